@@ -1,31 +1,535 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/SawitProRecruitment/UserService/generated"
 	"github.com/SawitProRecruitment/UserService/handler"
+	"github.com/SawitProRecruitment/UserService/handler/model/lockout"
+	"github.com/SawitProRecruitment/UserService/handler/model/user"
+	"github.com/SawitProRecruitment/UserService/handler/model/webauthn"
+	"github.com/SawitProRecruitment/UserService/keyring"
+	"github.com/SawitProRecruitment/UserService/pepper"
+	"github.com/SawitProRecruitment/UserService/pwned"
 	"github.com/SawitProRecruitment/UserService/repository"
+	"github.com/SawitProRecruitment/UserService/sms"
 
 	"github.com/labstack/echo/v4"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		migrate(os.Args[2:])
+		return
+	}
+
 	e := echo.New()
 
+	var trustedProxies []string
+	if v := os.Getenv("TRUSTED_PROXIES"); v != "" {
+		trustedProxies = strings.Split(v, ",")
+	}
+
+	ipExtractor, err := handler.ClientIPExtractor(trustedProxies)
+	if err != nil {
+		log.Fatalf("invalid TRUSTED_PROXIES: %v", err)
+	}
+
+	e.IPExtractor = ipExtractor
 	var server generated.ServerInterface = newServer()
 
+	requestTimeout := handler.DefaultRequestTimeout
+	if v := os.Getenv("REQUEST_TIMEOUT"); v != "" {
+		requestTimeout, err = time.ParseDuration(v)
+		if err != nil || requestTimeout <= 0 {
+			log.Fatalf("invalid REQUEST_TIMEOUT: %q", v)
+		}
+	}
+
+	e.Use(handler.RequestTimeout(requestTimeout))
 	generated.RegisterHandlers(e, server)
 	e.Logger.Fatal(e.Start(":1323"))
 }
 
 func newServer() *handler.Server {
-	dbDsn := os.Getenv("DATABASE_URL")
-	var repo repository.RepositoryInterface = repository.NewRepository(repository.NewRepositoryOptions{
-		Dsn: dbDsn,
-	})
+	repo := newStorage()
+
+	// The in-memory storage has no schema
+	if migrator, ok := repo.(repository.Migrator); ok {
+		migrateOnStart(migrator)
+	}
+
 	opts := handler.NewServerOptions{
-		Repository: repo,
+		Repository:                repo,
+		RefreshTokenRepository:    repo,
+		TokenRevocationRepository: repo,
+		OTPRepository:             repo,
+		LoginFailureRepository:    repo,
+		PasswordHistoryRepository: repo,
+		TOTPRepository:            repo,
+		MFAChallengeRepository:    repo,
+		PasskeyRepository:         repo,
+		WebAuthnSessionRepository: repo,
+		TOTPIssuer:                os.Getenv("TOTP_ISSUER"),
+		TokenIssuer:               os.Getenv("TOKEN_ISSUER"),
+		AdminKey:                  os.Getenv("ADMIN_KEY"),
+	}
+
+	opts.UserLockoutPolicy = lockoutPolicy("LOGIN_", lockout.DefaultUserPolicy())
+	opts.IPLockoutPolicy = lockoutPolicy("LOGIN_IP_", lockout.DefaultIPPolicy())
+
+	if err := user.SetHashParams(passwordHashParams()); err != nil {
+		log.Fatalf("invalid password hashing: %v", err)
+	}
+
+	var pepperProvider pepper.KeyProvider
+	if path := os.Getenv("PASSWORD_PEPPER_FILE"); path != "" {
+		fileProvider := &pepper.FileKeyProvider{Path: path}
+		reloadOnHangup(fileProvider)
+		pepperProvider = fileProvider
+	} else if os.Getenv("PASSWORD_PEPPER") != "" {
+		pepperProvider = &pepper.EnvKeyProvider{Name: "PASSWORD_PEPPER"}
+	}
+
+	if pepperProvider != nil {
+		if _, err := pepperProvider.CurrentKeyID(); err != nil {
+			log.Fatalf("invalid password pepper: %v", err)
+		}
+
+		user.SetPepperProvider(pepperProvider)
+	}
+
+	if err := user.SetPasswordPolicy(passwordPolicy()); err != nil {
+		log.Fatalf("invalid password policy: %v", err)
+	}
+
+	if rpID := os.Getenv("WEBAUTHN_RP_ID"); rpID != "" {
+		opts.RelyingParty = webauthn.RelyingParty{
+			ID:      rpID,
+			Name:    os.Getenv("WEBAUTHN_RP_NAME"),
+			Origins: strings.Split(os.Getenv("WEBAUTHN_ORIGINS"), ","),
+		}
+
+		if opts.RelyingParty.Name == "" {
+			opts.RelyingParty.Name = webauthn.DefaultRelyingParty().Name
+		}
+
+		if opts.RelyingParty.Origins[0] == "" {
+			opts.RelyingParty.Origins = []string{"https://" + rpID}
+		}
+	}
+
+	sender, err := smsSender()
+	if err != nil {
+		log.Fatalf("invalid SMS sender: %v", err)
+	}
+
+	opts.SMSSender = sender
+	keySource, err := tokenKeySource()
+	if err != nil {
+		log.Fatalf("invalid token signing key: %v", err)
+	}
+
+	opts.KeySource = keySource
+	if v := os.Getenv("TOKEN_SIGNING_ALGORITHM"); v != "" {
+		opts.SigningAlgorithm, err = keyring.ParseAlgorithm(v)
+		if err != nil {
+			log.Fatalf("invalid TOKEN_SIGNING_ALGORITHM: %v", err)
+		}
+	}
+
+	if v := os.Getenv("TOKEN_AUDIENCE"); v != "" {
+		opts.TokenAudience = strings.Split(v, ",")
+	}
+
+	if v := os.Getenv("TOKEN_LEEWAY"); v != "" {
+		opts.TokenLeeway, err = time.ParseDuration(v)
+		if err != nil {
+			log.Fatalf("invalid TOKEN_LEEWAY: %v", err)
+		}
+	}
+
+	opts.OAuthClients, err = oauthClients(os.Getenv("OAUTH_CLIENTS"))
+	if err != nil {
+		log.Fatalf("invalid OAUTH_CLIENTS: %v", err)
+	}
+
+	// Clients only introspect and revoke the tokens intended for them
+	audience := make(map[string]bool)
+	for _, aud := range opts.TokenAudience {
+		audience[aud] = true
+	}
+
+	for id := range opts.OAuthClients {
+		if !audience[id] {
+			log.Printf("OAuth client %s is not in TOKEN_AUDIENCE, it can not introspect or revoke any token", id)
+		}
+	}
+
+	server, err := handler.NewServer(opts)
+	if err != nil {
+		log.Fatalf("error creating server: %v", err)
+	}
+
+	return server
+}
+
+func newStorage() repository.Storage {
+	opts := repository.NewRepositoryOptions{
+		Dsn: os.Getenv("DATABASE_URL"),
+	}
+
+	envInt := func(name string, bitSize int) int64 {
+		v := os.Getenv(name)
+		if v == "" {
+			return 0
+		}
+
+		n, err := strconv.ParseInt(v, 10, bitSize)
+		if err != nil || n < 0 {
+			log.Fatalf("invalid %s: %q", name, v)
+		}
+
+		return n
+	}
+
+	envDuration := func(name string) time.Duration {
+		v := os.Getenv(name)
+		if v == "" {
+			return 0
+		}
+
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			log.Fatalf("invalid %s: %q", name, v)
+		}
+
+		return d
+	}
+
+	opts.MaxConns = int32(envInt("DATABASE_MAX_CONNS", 32))
+	opts.MinConns = int32(envInt("DATABASE_MIN_CONNS", 32))
+	opts.MaxConnLifetime = envDuration("DATABASE_MAX_CONN_LIFETIME")
+	opts.ConnectTimeout = envDuration("DATABASE_CONNECT_TIMEOUT")
+	if v := os.Getenv("DATABASE_STATEMENT_CACHE"); v != "" {
+		var err error
+		opts.StatementCacheCapacity, err = strconv.Atoi(v)
+		if err != nil {
+			log.Fatalf("invalid DATABASE_STATEMENT_CACHE: %v", err)
+		}
+
+		// Zero keeps the default capacity, disabling the cache takes -1
+		if opts.StatementCacheCapacity == 0 {
+			opts.StatementCacheCapacity = -1
+		}
+	}
+
+	repo, err := repository.Open(context.Background(), opts)
+	if err != nil {
+		log.Fatalf("error connecting to database: %v", err)
+	}
+
+	return repo
+}
+
+// migrateOnStart applies the pending migrations, unless MIGRATE_ON_START is
+// false, in which case the schema has to be up to date.
+func migrateOnStart(migrator repository.Migrator) {
+	apply := true
+	if v := os.Getenv("MIGRATE_ON_START"); v != "" {
+		var err error
+		apply, err = strconv.ParseBool(v)
+		if err != nil {
+			log.Fatalf("invalid MIGRATE_ON_START: %v", err)
+		}
+	}
+
+	if apply {
+		if err := migrator.MigrateUp(context.Background()); err != nil {
+			log.Fatalf("error migrating database: %v", err)
+		}
+	} else if err := migrator.CheckSchema(context.Background()); err != nil {
+		log.Fatalf("error checking database schema: %v", err)
+	}
+}
+
+// migrate runs the migrate command:
+//
+//	migrate [up]        applies the pending migrations
+//	migrate down        reverts the last migration
+//	migrate to VERSION  applies or reverts migrations up to the version
+//	migrate version     prints the version of the database schema
+func migrate(args []string) {
+	ctx := context.Background()
+	repo, ok := newStorage().(repository.Migrator)
+	if !ok {
+		log.Fatal("the storage of DATABASE_URL has no schema to migrate")
+	}
+
+	cmd := "up"
+	if len(args) > 0 {
+		cmd = args[0]
+	}
+
+	var err error
+	switch {
+	case cmd == "up" && len(args) <= 1:
+		err = repo.MigrateUp(ctx)
+	case cmd == "down" && len(args) == 1:
+		var version int
+		version, err = repo.SchemaVersion(ctx)
+		if err == nil && version == 0 {
+			err = errors.New("no migration to revert")
+		}
+
+		if err == nil {
+			err = repo.MigrateTo(ctx, version-1)
+		}
+	case cmd == "to" && len(args) == 2:
+		var version int
+		version, err = strconv.Atoi(args[1])
+		if err == nil {
+			err = repo.MigrateTo(ctx, version)
+		}
+	case cmd == "version" && len(args) == 1:
+		var version int
+		version, err = repo.SchemaVersion(ctx)
+		if err == nil {
+			fmt.Println(version)
+			return
+		}
+	default:
+		log.Fatalf("usage: %s migrate [up | down | to VERSION | version]", os.Args[0])
+	}
+
+	if err != nil {
+		log.Fatalf("error migrating database: %v", err)
+	}
+
+	version, err := repo.SchemaVersion(ctx)
+	if err != nil {
+		log.Fatalf("error reading schema version: %v", err)
+	}
+
+	log.Printf("database schema at version %d", version)
+}
+
+// tokenKeySource picks where the token signing keys are read from, a mounted
+// secret directory taking precedence over a file and a file over the
+// environment.
+func tokenKeySource() (keyring.Source, error) {
+	var passphrase []byte
+	if path := os.Getenv("TOKEN_SIGNING_KEY_PASSPHRASE_FILE"); path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		passphrase = []byte(strings.TrimRight(string(b), "\r\n"))
+	} else if v := os.Getenv("TOKEN_SIGNING_KEY_PASSPHRASE"); v != "" {
+		passphrase = []byte(v)
 	}
-	return handler.NewServer(opts)
+
+	if dir := os.Getenv("TOKEN_SIGNING_KEY_DIR"); dir != "" {
+		return &keyring.DirSource{Path: dir, Passphrase: passphrase}, nil
+	}
+
+	if path := os.Getenv("TOKEN_SIGNING_KEY_FILE"); path != "" {
+		return &keyring.FileSource{Path: path, Passphrase: passphrase}, nil
+	}
+
+	if os.Getenv("TOKEN_SIGNING_KEY") != "" {
+		return &keyring.EnvSource{Name: "TOKEN_SIGNING_KEY", Passphrase: passphrase}, nil
+	}
+
+	return nil, errors.New("none of TOKEN_SIGNING_KEY_DIR, TOKEN_SIGNING_KEY_FILE or TOKEN_SIGNING_KEY is set")
+}
+
+// smsSender picks how the one-time passwords are delivered. Only development
+// senders exist so far, each has to be chosen explicitly so no code ends up in
+// a production log by default.
+func smsSender() (sms.Sender, error) {
+	// Keep sent SMS in a local outbox file until a gateway is configured
+	if outbox := os.Getenv("SMS_OUTBOX_FILE"); outbox != "" {
+		return &sms.FileSender{Path: outbox}, nil
+	}
+
+	if v := os.Getenv("SMS_LOG_ONLY"); v != "" {
+		logOnly, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SMS_LOG_ONLY: %w", err)
+		}
+
+		if logOnly {
+			return &sms.LogSender{}, nil
+		}
+	}
+
+	return nil, errors.New("none of SMS_OUTBOX_FILE or SMS_LOG_ONLY is set")
+}
+
+// oauthClients parses comma separated "id:secret" entries.
+func oauthClients(v string) (map[string]string, error) {
+	if v == "" {
+		return nil, nil
+	}
+
+	clients := make(map[string]string)
+	for _, entry := range strings.Split(v, ",") {
+		id, secret, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok || id == "" || secret == "" {
+			return nil, fmt.Errorf("entry %q is not id:secret", entry)
+		}
+
+		if _, ok := clients[id]; ok {
+			return nil, fmt.Errorf("duplicate client %q", id)
+		}
+
+		clients[id] = secret
+	}
+
+	return clients, nil
+}
+
+// lockoutPolicy reads the MAX_FAILURES and LOCK_DURATION of the policy from
+// the variables with the prefix, keeping the defaults of what is not set.
+func lockoutPolicy(prefix string, p lockout.Policy) lockout.Policy {
+	if v := os.Getenv(prefix + "MAX_FAILURES"); v != "" {
+		maxFailures, err := strconv.Atoi(v)
+		if err != nil {
+			log.Fatalf("invalid %sMAX_FAILURES: %v", prefix, err)
+		}
+
+		p.MaxFailures = maxFailures
+	}
+
+	if v := os.Getenv(prefix + "LOCK_DURATION"); v != "" {
+		lockDuration, err := time.ParseDuration(v)
+		if err != nil {
+			log.Fatalf("invalid %sLOCK_DURATION: %v", prefix, err)
+		}
+
+		p.LockDuration = lockDuration
+	}
+
+	return p
+}
+
+// passwordHashParams reads the password hashing algorithm and cost, keeping
+// the defaults of what is not set.
+func passwordHashParams() user.HashParams {
+	hp := user.DefaultHashParams()
+	if v := os.Getenv("PASSWORD_HASH_ALGORITHM"); v != "" {
+		hp.Algorithm = user.HashAlgorithm(v)
+	}
+
+	envUint := func(name string, bitSize int) (uint64, bool) {
+		v := os.Getenv(name)
+		if v == "" {
+			return 0, false
+		}
+
+		n, err := strconv.ParseUint(v, 10, bitSize)
+		if err != nil {
+			log.Fatalf("invalid %s: %v", name, err)
+		}
+
+		return n, true
+	}
+
+	if n, ok := envUint("ARGON2_MEMORY", 32); ok {
+		hp.Argon2Memory = uint32(n)
+	}
+
+	if n, ok := envUint("ARGON2_TIME", 32); ok {
+		hp.Argon2Time = uint32(n)
+	}
+
+	if n, ok := envUint("ARGON2_THREADS", 8); ok {
+		hp.Argon2Threads = uint8(n)
+	}
+
+	if n, ok := envUint("BCRYPT_COST", 8); ok {
+		hp.BcryptCost = int(n)
+	}
+
+	if n, ok := envUint("PBKDF2_ITERATIONS", 31); ok {
+		hp.PBKDF2Iterations = int(n)
+	}
+
+	return hp
+}
+
+// passwordPolicy reads the rules new passwords have to meet, keeping the
+// defaults of what is not set.
+func passwordPolicy() user.PasswordPolicy {
+	pp := user.DefaultPasswordPolicy()
+	envInt := func(name string, value *int) {
+		if v := os.Getenv(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				log.Fatalf("invalid %s: %v", name, err)
+			}
+
+			*value = n
+		}
+	}
+
+	envBool := func(name string, value *bool) {
+		if v := os.Getenv(name); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				log.Fatalf("invalid %s: %v", name, err)
+			}
+
+			*value = b
+		}
+	}
+
+	envInt("PASSWORD_MIN_LENGTH", &pp.MinLength)
+	envInt("PASSWORD_MAX_LENGTH", &pp.MaxLength)
+	envBool("PASSWORD_REQUIRE_UPPERCASE", &pp.RequireUppercase)
+	envBool("PASSWORD_REQUIRE_LOWERCASE", &pp.RequireLowercase)
+	envBool("PASSWORD_REQUIRE_DIGIT", &pp.RequireDigit)
+	envBool("PASSWORD_REQUIRE_SPECIAL", &pp.RequireSpecial)
+	envBool("PASSWORD_REJECT_PERSONAL_INFO", &pp.RejectPersonalInfo)
+	envInt("PASSWORD_MIN_SCORE", &pp.MinScore)
+	envInt("PASSWORD_HISTORY_SIZE", &pp.HistorySize)
+
+	if path := os.Getenv("PASSWORD_BREACHED_FILE"); path != "" {
+		source, err := pwned.LoadFile(path)
+		if err != nil {
+			log.Fatalf("invalid PASSWORD_BREACHED_FILE: %v", err)
+		}
+
+		pp.BreachedSource = source
+	}
+
+	return pp
+}
+
+// reloadOnHangup reads the pepper keys again whenever the process receives
+// SIGHUP, keeping the previous keys when the file is invalid.
+func reloadOnHangup(fkp *pepper.FileKeyProvider) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	go func() {
+		for range hangup {
+			if err := fkp.Reload(); err != nil {
+				log.Printf("cannot reload PASSWORD_PEPPER_FILE: %v", err)
+				continue
+			}
+
+			log.Printf("reloaded PASSWORD_PEPPER_FILE")
+		}
+	}()
 }
@@ -1,37 +1,290 @@
 package app
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/SawitProRecruitment/UserService/handler/model/lockout"
+	"github.com/SawitProRecruitment/UserService/handler/model/otp"
 	"github.com/SawitProRecruitment/UserService/handler/model/user"
 	"github.com/SawitProRecruitment/UserService/repository"
 )
 
+const loginOTPMessage = "Your login code is %s. It expires in %d minutes."
+
 type AuthService struct {
-	userRepo repository.RepositoryInterface
+	userRepo         repository.RepositoryInterface
+	loginFailureRepo repository.LoginFailureRepositoryInterface
+	otpService       *OTPService
+
+	UserLockoutPolicy lockout.Policy
+	IPLockoutPolicy   lockout.Policy
+	// VerifyDummyPassword hashes the password of the logins with no password
+	// to check, user.VerifyDummyPassword unless replaced.
+	VerifyDummyPassword func(password string)
 }
 
-func NewAuthService(userRepo repository.RepositoryInterface) *AuthService {
+func NewAuthService(userRepo repository.RepositoryInterface, loginFailureRepo repository.LoginFailureRepositoryInterface, otpService *OTPService) *AuthService {
 	return &AuthService{
-		userRepo: userRepo,
+		userRepo:            userRepo,
+		loginFailureRepo:    loginFailureRepo,
+		otpService:          otpService,
+		UserLockoutPolicy:   lockout.DefaultUserPolicy(),
+		IPLockoutPolicy:     lockout.DefaultIPPolicy(),
+		VerifyDummyPassword: user.VerifyDummyPassword,
 	}
 }
 
-func (as *AuthService) Authenticate(phoneNumber, password string) (*user.User, error) {
-	usr, err := as.userRepo.GetByPhoneNumber(phoneNumber)
+// Authenticate verifies the credentials. Consecutive failures delay and
+// eventually lock further attempts for the user and the source IP. Every
+// rejection is reported as the same AuthenticationError, so a locked account
+// is indistinguishable from a wrong password. Unknown phone numbers go through
+// the same repository calls and password hashing as registered ones, so the
+// response time does not tell them apart either.
+func (as *AuthService) Authenticate(ctx context.Context, phoneNumber, password, sourceIP string) (*user.User, error) {
+	now := time.Now()
+	ipKey := lockout.IPKey(sourceIP)
+	ipCounter, err := as.loginFailureRepo.GetLoginFailures(ctx, ipKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if as.IPLockoutPolicy.Blocked(ipCounter, now) {
+		return nil, AuthenticationError("source ip locked")
+	}
+
+	usr, err := as.userRepo.GetByPhoneNumber(ctx, phoneNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	userKey := unknownUserKey(phoneNumber)
+	if usr != nil {
+		userKey = lockout.UserKey(usr.ID())
+	}
+
+	userCounter, err := as.loginFailureRepo.GetLoginFailures(ctx, userKey)
 	if err != nil {
 		return nil, err
 	}
 
+	if as.UserLockoutPolicy.Blocked(userCounter, now) {
+		as.VerifyDummyPassword(password)
+		return nil, AuthenticationError("user locked")
+	}
+
 	if usr == nil {
-		return nil, AuthenticationError("user not found")
+		as.VerifyDummyPassword(password)
 	}
 
-	if !usr.VerifyPassword(password) {
+	if usr == nil || !usr.VerifyPassword(password) {
+		err = as.recordFailure(ctx, userKey, as.UserLockoutPolicy, now)
+		if err != nil {
+			return nil, err
+		}
+
+		err = as.recordFailure(ctx, ipKey, as.IPLockoutPolicy, now)
+		if err != nil {
+			return nil, err
+		}
+
+		if usr == nil {
+			return nil, AuthenticationError("user not found")
+		}
+
 		return nil, AuthenticationError("invalid password")
 	}
 
+	as.upgradePasswordHash(ctx, usr, password)
+
 	return usr, nil
 }
 
+// RequestLoginOTP sends a login code to the phone number. Unknown phone
+// numbers and throttled requests are silently ignored, so the response never
+// tells whether the phone number is registered.
+func (as *AuthService) RequestLoginOTP(ctx context.Context, phoneNumber string) error {
+	usr, err := as.userRepo.GetByPhoneNumber(ctx, phoneNumber)
+	if err != nil {
+		return err
+	}
+
+	if usr == nil {
+		return nil
+	}
+
+	err = as.otpService.Send(ctx, otp.PurposeLogin, usr.PhoneNumber(), loginOTPMessage)
+	if errors.Is(err, ErrOTPResendTooSoon) || errors.Is(err, ErrOTPSendLimitReached) {
+		return nil
+	}
+
+	return err
+}
+
+// AuthenticateOTP verifies the login code sent to the phone number. Wrong
+// codes count as failed logins, the same lockout as Authenticate applies.
+func (as *AuthService) AuthenticateOTP(ctx context.Context, phoneNumber, code, sourceIP string) (*user.User, error) {
+	now := time.Now()
+	ipKey := lockout.IPKey(sourceIP)
+	ipCounter, err := as.loginFailureRepo.GetLoginFailures(ctx, ipKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if as.IPLockoutPolicy.Blocked(ipCounter, now) {
+		return nil, AuthenticationError("source ip locked")
+	}
+
+	usr, err := as.userRepo.GetByPhoneNumber(ctx, phoneNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	if usr == nil {
+		err = as.recordFailure(ctx, ipKey, as.IPLockoutPolicy, now)
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, AuthenticationError("user not found")
+	}
+
+	userKey := lockout.UserKey(usr.ID())
+	userCounter, err := as.loginFailureRepo.GetLoginFailures(ctx, userKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if as.UserLockoutPolicy.Blocked(userCounter, now) {
+		return nil, AuthenticationError("user locked")
+	}
+
+	err = as.otpService.Verify(ctx, otp.PurposeLogin, usr.PhoneNumber(), code)
+	if errors.Is(err, ErrInvalidOTP) || errors.Is(err, ErrOTPAttemptsExceeded) {
+		err = as.recordFailure(ctx, userKey, as.UserLockoutPolicy, now)
+		if err != nil {
+			return nil, err
+		}
+
+		err = as.recordFailure(ctx, ipKey, as.IPLockoutPolicy, now)
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, AuthenticationError("invalid one-time password")
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return usr, nil
+}
+
+// upgradePasswordHash rehashes the just verified password when its hash was
+// computed with an outdated algorithm or cost. Failing to do so does not fail
+// the login, the upgrade is retried on the next one.
+func (as *AuthService) upgradePasswordHash(ctx context.Context, usr *user.User, password string) {
+	if !usr.PasswordNeedsRehash() {
+		return
+	}
+
+	err := usr.RehashPassword(password)
+	if err == nil {
+		err = as.userRepo.Update(ctx, usr)
+	}
+
+	if err != nil {
+		log.Printf("error upgrading password hash: %v", err)
+	}
+}
+
+// LoginSucceeded clears the failed logins of the user once every factor is
+// verified. The first factor alone does not, so wrong second factors keep
+// counting across the logins answering them.
+func (as *AuthService) LoginSucceeded(ctx context.Context, userID string) error {
+	userKey := lockout.UserKey(userID)
+	counter, err := as.loginFailureRepo.GetLoginFailures(ctx, userKey)
+	if err != nil || counter == nil {
+		return err
+	}
+
+	return as.loginFailureRepo.ResetLoginFailures(ctx, userKey)
+}
+
+// verifyUserSecret checks a secret of the user outside of the password step
+// of a login, such as a second factor or the current password confirming a
+// change, under the lockout of the logins. A locked user is refused without
+// checking the secret, and a wrong one counts as a failed login.
+func (as *AuthService) verifyUserSecret(ctx context.Context, userID string, verify func() (bool, error)) (bool, error) {
+	now := time.Now()
+	userKey := lockout.UserKey(userID)
+	counter, err := as.loginFailureRepo.GetLoginFailures(ctx, userKey)
+	if err != nil {
+		return false, err
+	}
+
+	if as.UserLockoutPolicy.Blocked(counter, now) {
+		return false, nil
+	}
+
+	ok, err := verify()
+	if err != nil || ok {
+		return ok, err
+	}
+
+	return false, as.recordFailure(ctx, userKey, as.UserLockoutPolicy, now)
+}
+
+// throttle counts a request under the key like a failed login, and tells
+// whether the policy still allows it.
+func (as *AuthService) throttle(ctx context.Context, key string, policy lockout.Policy) (bool, error) {
+	now := time.Now()
+	counter, err := as.loginFailureRepo.GetLoginFailures(ctx, key)
+	if err != nil {
+		return false, err
+	}
+
+	if policy.Blocked(counter, now) {
+		return false, nil
+	}
+
+	return true, as.recordFailure(ctx, key, policy, now)
+}
+
+// LoginLockState returns the failed login counter of the user, nil when there
+// is no recent failure.
+func (as *AuthService) LoginLockState(ctx context.Context, userID string) (*lockout.Counter, error) {
+	return as.loginFailureRepo.GetLoginFailures(ctx, lockout.UserKey(userID))
+}
+
+// unknownUserKey identifies the failed logins of a phone number no user is
+// registered with. They are counted like the ones of a user, the number being
+// hashed to bound the length of the key.
+func unknownUserKey(phoneNumber string) string {
+	sum := sha256.Sum256([]byte(phoneNumber))
+	return lockout.UserKey("phone:" + base64.RawURLEncoding.EncodeToString(sum[:]))
+}
+
+// recordFailure counts a failed login for the key and locks it once the
+// policy limit is reached.
+func (as *AuthService) recordFailure(ctx context.Context, key string, policy lockout.Policy, now time.Time) error {
+	counter, err := as.loginFailureRepo.IncrementLoginFailures(ctx, key, now, policy.ResetBefore(now))
+	if err != nil {
+		return err
+	}
+
+	if policy.ShouldLock(counter) && !counter.Locked(now) {
+		return as.loginFailureRepo.LockLogin(ctx, key, now.Add(policy.LockDuration))
+	}
+
+	return nil
+}
+
 type AuthenticationError string
 
 func (ae AuthenticationError) Error() string {
@@ -0,0 +1,257 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/SawitProRecruitment/UserService/handler/model/mfa"
+	"github.com/SawitProRecruitment/UserService/handler/model/user"
+	"github.com/SawitProRecruitment/UserService/repository"
+)
+
+var (
+	ErrTOTPEnabled         = errors.New("totp already enabled")
+	ErrTOTPNotEnrolled     = errors.New("totp not enrolled")
+	ErrInvalidMFACode      = errors.New("invalid mfa code")
+	ErrInvalidMFAChallenge = errors.New("invalid mfa challenge")
+	ErrMFAAttemptsExceeded = errors.New("mfa attempts exceeded")
+)
+
+const DefaultTOTPIssuer = "UserService"
+
+// MFAService manages the TOTP second factor of the users and the login
+// challenges asking for it.
+type MFAService struct {
+	userRepo      repository.RepositoryInterface
+	totpRepo      repository.TOTPRepositoryInterface
+	challengeRepo repository.MFAChallengeRepositoryInterface
+	authService   *AuthService
+
+	// Issuer is the name shown by authenticator apps next to the account.
+	Issuer          string
+	ChallengeExpiry time.Duration
+	MaxAttempts     int
+}
+
+func NewMFAService(userRepo repository.RepositoryInterface, totpRepo repository.TOTPRepositoryInterface, challengeRepo repository.MFAChallengeRepositoryInterface, authService *AuthService) *MFAService {
+	return &MFAService{
+		userRepo:        userRepo,
+		totpRepo:        totpRepo,
+		challengeRepo:   challengeRepo,
+		authService:     authService,
+		Issuer:          DefaultTOTPIssuer,
+		ChallengeExpiry: mfa.DefaultChallengeExpiry,
+		MaxAttempts:     mfa.DefaultChallengeMaxAttempts,
+	}
+}
+
+// Enroll generates a new TOTP secret for the user and returns it with its
+// otpauth URI. The secret is not enforced until confirmed, enrolling again
+// replaces an unconfirmed one.
+func (ms *MFAService) Enroll(ctx context.Context, userID string) (*mfa.TOTP, string, error) {
+	usr, err := ms.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if usr == nil {
+		return nil, "", ErrUserNotFound
+	}
+
+	prev, err := ms.totpRepo.GetTOTP(ctx, userID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if prev != nil && prev.Confirmed() {
+		return nil, "", ErrTOTPEnabled
+	}
+
+	t, err := mfa.Enroll(userID, time.Now())
+	if err != nil {
+		return nil, "", err
+	}
+
+	err = ms.totpRepo.StoreTOTP(ctx, t)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return t, t.URI(ms.Issuer, usr.PhoneNumber()), nil
+}
+
+// Confirm enables the enrolled secret with a first code and returns the plain
+// recovery codes, which are shown once.
+func (ms *MFAService) Confirm(ctx context.Context, userID, code string) ([]string, error) {
+	now := time.Now()
+	t, err := ms.totpRepo.GetTOTP(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if t == nil {
+		return nil, ErrTOTPNotEnrolled
+	}
+
+	if t.Confirmed() {
+		return nil, ErrTOTPEnabled
+	}
+
+	step, ok := t.Verify(code, now)
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+
+	codes, hashes, err := mfa.GenerateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	ok, err = ms.totpRepo.ConfirmTOTP(ctx, userID, now, step, hashes)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, ErrTOTPEnabled
+	}
+
+	return codes, nil
+}
+
+// Disable removes the secret and the recovery codes after verifying the
+// password of the user. Wrong passwords count as failed logins.
+func (ms *MFAService) Disable(ctx context.Context, userID, password string) error {
+	usr, err := ms.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	if usr == nil {
+		return ErrUserNotFound
+	}
+
+	ok, err := ms.authService.verifyUserSecret(ctx, userID, func() (bool, error) {
+		return usr.VerifyPassword(password), nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return user.ErrIncorrectPassword
+	}
+
+	t, err := ms.totpRepo.GetTOTP(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	if t == nil {
+		return ErrTOTPNotEnrolled
+	}
+
+	return ms.totpRepo.DeleteTOTP(ctx, userID)
+}
+
+// Required reports whether the user has to answer a challenge to login.
+func (ms *MFAService) Required(ctx context.Context, userID string) (bool, error) {
+	t, err := ms.totpRepo.GetTOTP(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+
+	return t != nil && t.Confirmed(), nil
+}
+
+// Challenge issues the second step of the login of an authenticated user and
+// returns the plain token to answer it with.
+func (ms *MFAService) Challenge(ctx context.Context, usr *user.User) (*mfa.Challenge, string, error) {
+	c, value, err := mfa.IssueChallenge(usr.ID(), time.Now(), ms.ChallengeExpiry)
+	if err != nil {
+		return nil, "", err
+	}
+
+	err = ms.challengeRepo.StoreMFAChallenge(ctx, c)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return c, value, nil
+}
+
+// VerifyChallenge answers the challenge with either a TOTP code or a
+// recovery code and returns the user to be logged in. Both the challenge and
+// the code can be used once. Wrong codes count as failed logins of the user,
+// so they cannot be guessed across challenges.
+func (ms *MFAService) VerifyChallenge(ctx context.Context, value, code, recoveryCode string) (*user.User, error) {
+	now := time.Now()
+	c, err := ms.challengeRepo.GetMFAChallengeByHash(ctx, mfa.HashChallengeToken(value))
+	if err != nil {
+		return nil, err
+	}
+
+	if c == nil || !c.Usable(now) {
+		return nil, ErrInvalidMFAChallenge
+	}
+
+	attempts, err := ms.challengeRepo.IncrementMFAChallengeAttempts(ctx, c.ID())
+	if err != nil {
+		return nil, err
+	}
+
+	if attempts > ms.MaxAttempts {
+		return nil, ErrMFAAttemptsExceeded
+	}
+
+	// The second factor may have been disabled since the password was
+	// verified, the login has to start over.
+	t, err := ms.totpRepo.GetTOTP(ctx, c.UserID())
+	if err != nil {
+		return nil, err
+	}
+
+	if t == nil || !t.Confirmed() {
+		return nil, ErrInvalidMFAChallenge
+	}
+
+	ok, err := ms.authService.verifyUserSecret(ctx, c.UserID(), func() (bool, error) {
+		if recoveryCode != "" {
+			return ms.totpRepo.UseRecoveryCode(ctx, c.UserID(), mfa.HashRecoveryCode(recoveryCode), now)
+		}
+
+		if step, valid := t.Verify(code, now); valid {
+			return ms.totpRepo.UseTOTPStep(ctx, c.UserID(), step)
+		}
+
+		return false, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+
+	ok, err = ms.challengeRepo.ConsumeMFAChallenge(ctx, c.ID(), now)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, ErrInvalidMFAChallenge
+	}
+
+	usr, err := ms.userRepo.GetByID(ctx, c.UserID())
+	if err != nil {
+		return nil, err
+	}
+
+	if usr == nil {
+		return nil, ErrInvalidMFAChallenge
+	}
+
+	return usr, nil
+}
@@ -0,0 +1,112 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/SawitProRecruitment/UserService/handler/model/otp"
+	"github.com/SawitProRecruitment/UserService/repository"
+	"github.com/SawitProRecruitment/UserService/sms"
+)
+
+var (
+	ErrInvalidOTP          = errors.New("invalid one-time password")
+	ErrOTPAttemptsExceeded = errors.New("one-time password attempts exceeded")
+	ErrOTPResendTooSoon    = errors.New("one-time password resent too soon")
+	ErrOTPSendLimitReached = errors.New("one-time password send limit reached")
+)
+
+// OTPService issues one-time passwords over SMS and verifies them.
+type OTPService struct {
+	otpRepo   repository.OTPRepositoryInterface
+	smsSender sms.Sender
+
+	Expiry         time.Duration
+	MaxAttempts    int
+	ResendInterval time.Duration
+	SendLimit      otp.SendLimit
+}
+
+func NewOTPService(otpRepo repository.OTPRepositoryInterface, smsSender sms.Sender) *OTPService {
+	return &OTPService{
+		otpRepo:        otpRepo,
+		smsSender:      smsSender,
+		Expiry:         otp.DefaultExpiry,
+		MaxAttempts:    otp.DefaultMaxAttempts,
+		ResendInterval: otp.DefaultResendInterval,
+		SendLimit:      otp.DefaultSendLimit(),
+	}
+}
+
+// Send issues a new code and sends it to the phone number, unless a code was
+// sent too recently or too many were sent within the send limit window. The
+// message is a format string receiving the code and the expiry in minutes.
+func (otps *OTPService) Send(ctx context.Context, purpose otp.Purpose, phoneNumber, message string) error {
+	now := time.Now()
+	prev, err := otps.otpRepo.GetOTP(ctx, purpose, phoneNumber)
+	if err != nil {
+		return err
+	}
+
+	if prev != nil && !prev.ResendAllowed(now, otps.ResendInterval) {
+		return ErrOTPResendTooSoon
+	}
+
+	if otps.SendLimit.Reached(prev, now) {
+		return ErrOTPSendLimitReached
+	}
+
+	o, code, err := otp.Issue(purpose, phoneNumber, now, otps.Expiry)
+	if err != nil {
+		return err
+	}
+
+	o.ContinueSendWindow(prev, otps.SendLimit.Window)
+
+	err = otps.otpRepo.StoreOTP(ctx, o)
+	if err != nil {
+		return err
+	}
+
+	return otps.smsSender.Send(phoneNumber, fmt.Sprintf(message, code, int(otps.Expiry.Minutes())))
+}
+
+// Verify checks the code and consumes it on success, so every code can be
+// used once.
+func (otps *OTPService) Verify(ctx context.Context, purpose otp.Purpose, phoneNumber, code string) error {
+	now := time.Now()
+	o, err := otps.otpRepo.GetOTP(ctx, purpose, phoneNumber)
+	if err != nil {
+		return err
+	}
+
+	if o == nil || !o.Usable(now) {
+		return ErrInvalidOTP
+	}
+
+	attempts, err := otps.otpRepo.IncrementOTPAttempts(ctx, o.ID())
+	if err != nil {
+		return err
+	}
+
+	if attempts > otps.MaxAttempts {
+		return ErrOTPAttemptsExceeded
+	}
+
+	if !o.Matches(code) {
+		return ErrInvalidOTP
+	}
+
+	ok, err := otps.otpRepo.ConsumeOTP(ctx, o.ID(), now)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return ErrInvalidOTP
+	}
+
+	return nil
+}
@@ -0,0 +1,244 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/SawitProRecruitment/UserService/handler/model/lockout"
+	"github.com/SawitProRecruitment/UserService/handler/model/user"
+	"github.com/SawitProRecruitment/UserService/handler/model/webauthn"
+	"github.com/SawitProRecruitment/UserService/repository"
+)
+
+var (
+	ErrInvalidPasskey        = errors.New("invalid passkey")
+	ErrPasskeyRegistered     = errors.New("passkey already registered")
+	ErrPasskeyLoginThrottled = errors.New("too many passkey logins")
+)
+
+// PasskeyRegistrationOptions is what the client needs to create a passkey.
+type PasskeyRegistrationOptions struct {
+	RelyingParty       webauthn.RelyingParty
+	User               *user.User
+	Challenge          []byte
+	Timeout            time.Duration
+	ExcludeCredentials [][]byte
+}
+
+// PasskeyLoginOptions is what the client needs to sign in with a passkey.
+type PasskeyLoginOptions struct {
+	RelyingPartyID string
+	Challenge      []byte
+	Timeout        time.Duration
+}
+
+// PasskeyService registers WebAuthn credentials and logs users in with them,
+// without a password.
+type PasskeyService struct {
+	userRepo    repository.RepositoryInterface
+	passkeyRepo repository.PasskeyRepositoryInterface
+	sessionRepo repository.WebAuthnSessionRepositoryInterface
+	authService *AuthService
+
+	RelyingParty webauthn.RelyingParty
+	Timeout      time.Duration
+	// LoginPolicy throttles the passkey logins begun from an IP address.
+	LoginPolicy lockout.Policy
+}
+
+func NewPasskeyService(userRepo repository.RepositoryInterface, passkeyRepo repository.PasskeyRepositoryInterface, sessionRepo repository.WebAuthnSessionRepositoryInterface, authService *AuthService) *PasskeyService {
+	return &PasskeyService{
+		userRepo:     userRepo,
+		passkeyRepo:  passkeyRepo,
+		sessionRepo:  sessionRepo,
+		authService:  authService,
+		RelyingParty: webauthn.DefaultRelyingParty(),
+		Timeout:      webauthn.DefaultTimeout,
+		LoginPolicy:  lockout.DefaultPasskeyLoginPolicy(),
+	}
+}
+
+// BeginRegistration starts the registration of a passkey for the user. The
+// passkeys already registered are excluded so an authenticator is not
+// registered twice.
+func (ps *PasskeyService) BeginRegistration(ctx context.Context, userID string) (*PasskeyRegistrationOptions, error) {
+	usr, err := ps.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if usr == nil {
+		return nil, ErrUserNotFound
+	}
+
+	creds, err := ps.passkeyRepo.GetUserPasskeys(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := webauthn.BeginSession(webauthn.CeremonyRegistration, userID, time.Now(), ps.Timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ps.sessionRepo.StoreWebAuthnSession(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := &PasskeyRegistrationOptions{
+		RelyingParty: ps.RelyingParty,
+		User:         usr,
+		Challenge:    s.Challenge(),
+		Timeout:      ps.Timeout,
+	}
+
+	for _, c := range creds {
+		opts.ExcludeCredentials = append(opts.ExcludeCredentials, c.ID())
+	}
+
+	return opts, nil
+}
+
+// FinishRegistration verifies the attestation of the authenticator and saves
+// the new passkey of the user.
+func (ps *PasskeyService) FinishRegistration(ctx context.Context, userID string, clientDataJSON, attestationObject []byte) (*webauthn.Credential, error) {
+	now := time.Now()
+	s, err := ps.takeSession(ctx, clientDataJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.UserID() != userID {
+		return nil, ErrInvalidPasskey
+	}
+
+	cred, err := webauthn.VerifyRegistration(ps.RelyingParty, s, clientDataJSON, attestationObject, now)
+	if errors.Is(err, webauthn.ErrVerification) {
+		log.Printf("error verifying passkey registration: %v", err)
+		return nil, ErrInvalidPasskey
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = ps.passkeyRepo.StorePasskey(ctx, cred)
+	if errors.Is(err, repository.ErrUniqueViolation) {
+		return nil, ErrPasskeyRegistered
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return cred, nil
+}
+
+// BeginLogin starts a passwordless login. No credential is suggested, the
+// authenticator offers the passkeys it holds for the relying party. Anyone
+// can begin one, so they are throttled by source IP.
+func (ps *PasskeyService) BeginLogin(ctx context.Context, sourceIP string) (*PasskeyLoginOptions, error) {
+	allowed, err := ps.authService.throttle(ctx, lockout.PasskeyLoginKey(sourceIP), ps.LoginPolicy)
+	if err != nil {
+		return nil, err
+	}
+
+	if !allowed {
+		return nil, ErrPasskeyLoginThrottled
+	}
+
+	s, err := webauthn.BeginSession(webauthn.CeremonyLogin, "", time.Now(), ps.Timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ps.sessionRepo.StoreWebAuthnSession(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PasskeyLoginOptions{
+		RelyingPartyID: ps.RelyingParty.ID,
+		Challenge:      s.Challenge(),
+		Timeout:        ps.Timeout,
+	}, nil
+}
+
+// FinishLogin verifies the assertion signed with a passkey and returns its
+// user. A sign count lower than the last one seen means the authenticator was
+// cloned, the login is rejected.
+func (ps *PasskeyService) FinishLogin(ctx context.Context, credentialID, clientDataJSON, authenticatorData, signature, userHandle []byte) (*user.User, error) {
+	now := time.Now()
+	s, err := ps.takeSession(ctx, clientDataJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	cred, err := ps.passkeyRepo.GetPasskey(ctx, credentialID)
+	if err != nil {
+		return nil, err
+	}
+
+	if cred == nil {
+		return nil, ErrInvalidPasskey
+	}
+
+	signCount, err := webauthn.VerifyAssertion(ps.RelyingParty, s, cred, clientDataJSON, authenticatorData, signature, userHandle, now)
+	if errors.Is(err, webauthn.ErrVerification) || errors.Is(err, webauthn.ErrSignCountRegressed) {
+		log.Printf("error verifying passkey assertion: %v", err)
+		return nil, ErrInvalidPasskey
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	ok, err := ps.passkeyRepo.UpdatePasskeySignCount(ctx, cred.ID(), signCount, now)
+	if err != nil {
+		return nil, err
+	}
+
+	// Lost the race against another assertion with the same count
+	if !ok {
+		return nil, ErrInvalidPasskey
+	}
+
+	usr, err := ps.userRepo.GetByID(ctx, cred.UserID())
+	if err != nil {
+		return nil, err
+	}
+
+	if usr == nil {
+		return nil, ErrInvalidPasskey
+	}
+
+	return usr, nil
+}
+
+// takeSession consumes the session of the challenge signed in the client
+// data, so a response cannot be replayed.
+func (ps *PasskeyService) takeSession(ctx context.Context, clientDataJSON []byte) (*webauthn.Session, error) {
+	cd, err := webauthn.ParseClientData(clientDataJSON)
+	if err != nil {
+		return nil, ErrInvalidPasskey
+	}
+
+	challenge, err := cd.ChallengeBytes()
+	if err != nil {
+		return nil, ErrInvalidPasskey
+	}
+
+	s, err := ps.sessionRepo.TakeWebAuthnSession(ctx, challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	if s == nil {
+		return nil, ErrInvalidPasskey
+	}
+
+	return s, nil
+}
@@ -0,0 +1,33 @@
+package app
+
+import (
+	"context"
+
+	"github.com/SawitProRecruitment/UserService/handler/model/user"
+	"github.com/SawitProRecruitment/UserService/repository"
+)
+
+// The current password counts in the history size of the password policy,
+// only the ones before it are stored.
+
+// previousPasswords returns the stored history a new password of the user is
+// checked against.
+func previousPasswords(ctx context.Context, repo repository.PasswordHistoryRepositoryInterface, userID string) ([]*user.PasswordRecord, error) {
+	size := user.CurrentPasswordPolicy().HistorySize
+	if size <= 1 {
+		return nil, nil
+	}
+
+	return repo.GetPasswordHistory(ctx, userID, size-1)
+}
+
+// updatePassword saves the user with its new password, along with the
+// replaced one in the history when there is one to keep.
+func updatePassword(ctx context.Context, userRepo repository.RepositoryInterface, historyRepo repository.PasswordHistoryRepositoryInterface, usr *user.User, previous *user.PasswordRecord) error {
+	size := user.CurrentPasswordPolicy().HistorySize
+	if size <= 1 {
+		return userRepo.Update(ctx, usr)
+	}
+
+	return historyRepo.UpdatePassword(ctx, usr, previous, size-1)
+}
@@ -0,0 +1,100 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/SawitProRecruitment/UserService/handler/model/otp"
+	"github.com/SawitProRecruitment/UserService/handler/model/user"
+	"github.com/SawitProRecruitment/UserService/repository"
+)
+
+const passwordResetMessage = "Your password reset code is %s. It expires in %d minutes."
+
+type PasswordResetService struct {
+	userRepo            repository.RepositoryInterface
+	passwordHistoryRepo repository.PasswordHistoryRepositoryInterface
+	otpService          *OTPService
+}
+
+func NewPasswordResetService(userRepo repository.RepositoryInterface, passwordHistoryRepo repository.PasswordHistoryRepositoryInterface, otpService *OTPService) *PasswordResetService {
+	return &PasswordResetService{
+		userRepo:            userRepo,
+		passwordHistoryRepo: passwordHistoryRepo,
+		otpService:          otpService,
+	}
+}
+
+// RequestReset sends a reset code to the phone number. Unknown phone numbers
+// and throttled requests are silently ignored, so the response never tells
+// whether the phone number is registered.
+func (prs *PasswordResetService) RequestReset(ctx context.Context, phoneNumber string) error {
+	usr, err := prs.userRepo.GetByPhoneNumber(ctx, phoneNumber)
+	if err != nil {
+		return err
+	}
+
+	if usr == nil {
+		return nil
+	}
+
+	err = prs.otpService.Send(ctx, otp.PurposePasswordReset, usr.PhoneNumber(), passwordResetMessage)
+	if errors.Is(err, ErrOTPResendTooSoon) || errors.Is(err, ErrOTPSendLimitReached) {
+		return nil
+	}
+
+	return err
+}
+
+// Reset sets a new password once the code sent to the phone number is
+// verified. A reused password is only reported after the code is verified,
+// which consumes it, so the endpoint cannot be used to guess passwords.
+func (prs *PasswordResetService) Reset(ctx context.Context, phoneNumber, code, newPassword string) (*user.User, error) {
+	usr, err := prs.userRepo.GetByPhoneNumber(ctx, phoneNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	// Reject weak passwords before the code gets consumed
+	owner := user.PasswordOwner{PhoneNumber: phoneNumber}
+	if usr != nil {
+		owner = usr.PasswordOwner()
+	}
+
+	violations, err := user.CheckPassword(newPassword, owner)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(violations) > 0 {
+		return nil, &user.WeakPasswordError{Violations: violations}
+	}
+
+	err = prs.otpService.Verify(ctx, otp.PurposePasswordReset, phoneNumber, code)
+	if err != nil {
+		return nil, err
+	}
+
+	if usr == nil {
+		return nil, ErrInvalidOTP
+	}
+
+	history, err := previousPasswords(ctx, prs.passwordHistoryRepo, usr.ID())
+	if err != nil {
+		return nil, err
+	}
+
+	record := usr.PasswordRecord(time.Now())
+	err = usr.ResetPassword(newPassword, history)
+	if err != nil {
+		return nil, err
+	}
+
+	err = updatePassword(ctx, prs.userRepo, prs.passwordHistoryRepo, usr, record)
+	if err != nil {
+		return nil, err
+	}
+
+	return usr, nil
+}
@@ -0,0 +1,195 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/SawitProRecruitment/UserService/handler/model/token"
+	"github.com/SawitProRecruitment/UserService/handler/model/user"
+	"github.com/SawitProRecruitment/UserService/repository"
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reused")
+)
+
+type SessionService struct {
+	userRepo            repository.RepositoryInterface
+	refreshTokenRepo    repository.RefreshTokenRepositoryInterface
+	tokenRevocationRepo repository.TokenRevocationRepositoryInterface
+
+	RefreshTokenExpiry time.Duration
+}
+
+func NewSessionService(userRepo repository.RepositoryInterface, refreshTokenRepo repository.RefreshTokenRepositoryInterface, tokenRevocationRepo repository.TokenRevocationRepositoryInterface) *SessionService {
+	return &SessionService{
+		userRepo:            userRepo,
+		refreshTokenRepo:    refreshTokenRepo,
+		tokenRevocationRepo: tokenRevocationRepo,
+		RefreshTokenExpiry:  token.DefaultRefreshTokenExpiry,
+	}
+}
+
+// Session is a login of the user, kept alive by its refresh tokens.
+type Session struct {
+	// ID is the refresh token family, it stays the same across refreshes.
+	ID string
+	// RefreshToken is the plain value of the current refresh token.
+	RefreshToken string
+}
+
+// IssueRefreshToken starts a new refresh token family for the user.
+func (ss *SessionService) IssueRefreshToken(ctx context.Context, usr *user.User) (*Session, error) {
+	rt, value, err := token.Issue(usr.ID(), time.Now(), ss.RefreshTokenExpiry)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ss.refreshTokenRepo.StoreRefreshToken(ctx, rt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Session{ID: rt.FamilyID(), RefreshToken: value}, nil
+}
+
+// Refresh exchanges a refresh token for its successor. Presenting a token
+// that was already used revokes the whole family, since it means the token
+// has been leaked.
+func (ss *SessionService) Refresh(ctx context.Context, value string) (*user.User, *Session, error) {
+	now := time.Now()
+	rt, err := ss.refreshTokenRepo.GetRefreshTokenByHash(ctx, token.Hash(value))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if rt == nil || rt.Revoked() || rt.Expired(now) {
+		return nil, nil, ErrInvalidRefreshToken
+	}
+
+	if rt.Used() {
+		return nil, nil, ss.revokeFamily(ctx, rt, now)
+	}
+
+	ok, err := ss.refreshTokenRepo.MarkRefreshTokenUsed(ctx, rt.ID(), now)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Lost the race against another request using the same token
+	if !ok {
+		return nil, nil, ss.revokeFamily(ctx, rt, now)
+	}
+
+	usr, err := ss.userRepo.GetByID(ctx, rt.UserID())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if usr == nil {
+		return nil, nil, ErrInvalidRefreshToken
+	}
+
+	next, nextValue, err := rt.Rotate(now, ss.RefreshTokenExpiry)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = ss.refreshTokenRepo.StoreRefreshToken(ctx, next)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return usr, &Session{ID: next.FamilyID(), RefreshToken: nextValue}, nil
+}
+
+// AccessToken identifies an issued access token.
+type AccessToken struct {
+	ID        string
+	UserID    string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+// AccessTokenRevoked reports whether the access token was revoked, either
+// individually or by logging out of all devices.
+func (ss *SessionService) AccessTokenRevoked(ctx context.Context, at AccessToken) (bool, error) {
+	return ss.tokenRevocationRepo.AccessTokenRevoked(ctx, at.ID, at.UserID, at.IssuedAt)
+}
+
+// Logout revokes the access token and, when given, the refresh token family
+// of the same session.
+func (ss *SessionService) Logout(ctx context.Context, at AccessToken, refreshToken string) error {
+	err := ss.tokenRevocationRepo.RevokeAccessToken(ctx, at.ID, at.UserID, at.ExpiresAt)
+	if err != nil {
+		return err
+	}
+
+	if refreshToken == "" {
+		return nil
+	}
+
+	rt, err := ss.refreshTokenRepo.GetRefreshTokenByHash(ctx, token.Hash(refreshToken))
+	if err != nil {
+		return err
+	}
+
+	// Never let a user revoke somebody else's session
+	if rt == nil || rt.UserID() != at.UserID {
+		return nil
+	}
+
+	return ss.refreshTokenRepo.RevokeRefreshTokenFamily(ctx, rt.FamilyID(), time.Now())
+}
+
+// LogoutAll revokes every access and refresh token issued to the user so far,
+// including the one used for this call.
+func (ss *SessionService) LogoutAll(ctx context.Context, at AccessToken) error {
+	return ss.RevokeAll(ctx, at.UserID)
+}
+
+// RevokeAccessToken revokes a single access token.
+func (ss *SessionService) RevokeAccessToken(ctx context.Context, at AccessToken) error {
+	return ss.tokenRevocationRepo.RevokeAccessToken(ctx, at.ID, at.UserID, at.ExpiresAt)
+}
+
+// RevokeRefreshToken revokes every refresh token of the login the token
+// belongs to. Unknown tokens are ignored.
+func (ss *SessionService) RevokeRefreshToken(ctx context.Context, value string) error {
+	rt, err := ss.refreshTokenRepo.GetRefreshTokenByHash(ctx, token.Hash(value))
+	if err != nil {
+		return err
+	}
+
+	if rt == nil {
+		return nil
+	}
+
+	return ss.refreshTokenRepo.RevokeRefreshTokenFamily(ctx, rt.FamilyID(), time.Now())
+}
+
+// RevokeAll revokes every access and refresh token issued to the user so far.
+func (ss *SessionService) RevokeAll(ctx context.Context, userID string) error {
+	now := time.Now()
+
+	// Token issue times have second precision, so the tokens are revoked up
+	// to the next second to cover the current one, including a token issued
+	// right after this call rather than keeping one issued right before it.
+	err := ss.tokenRevocationRepo.RevokeUserAccessTokens(ctx, userID, now.Truncate(time.Second).Add(time.Second))
+	if err != nil {
+		return err
+	}
+
+	return ss.refreshTokenRepo.RevokeUserRefreshTokens(ctx, userID, now)
+}
+
+func (ss *SessionService) revokeFamily(ctx context.Context, rt *token.RefreshToken, now time.Time) error {
+	err := ss.refreshTokenRepo.RevokeRefreshTokenFamily(ctx, rt.FamilyID(), now)
+	if err != nil {
+		return err
+	}
+
+	return ErrRefreshTokenReused
+}
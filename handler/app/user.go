@@ -1,40 +1,58 @@
 package app
 
 import (
+	"context"
 	"errors"
+	"log"
+	"time"
 
+	"github.com/SawitProRecruitment/UserService/handler/model/otp"
 	"github.com/SawitProRecruitment/UserService/handler/model/user"
 	"github.com/SawitProRecruitment/UserService/repository"
 )
 
-var ErrPhoneNumberAlreadyTaken = errors.New("phone number already taken")
+var (
+	ErrPhoneNumberAlreadyTaken = errors.New("phone number already taken")
+	ErrUserNotFound            = errors.New("user not found")
+	ErrPhoneNumberVerified     = errors.New("phone number already verified")
+)
+
+const phoneVerificationMessage = "Your phone verification code is %s. It expires in %d minutes."
 
 type UserService struct {
-	userRepo repository.RepositoryInterface
+	userRepo            repository.RepositoryInterface
+	passwordHistoryRepo repository.PasswordHistoryRepositoryInterface
+	otpService          *OTPService
+	authService         *AuthService
 }
 
-func NewUserService(userRepo repository.RepositoryInterface) *UserService {
+func NewUserService(userRepo repository.RepositoryInterface, passwordHistoryRepo repository.PasswordHistoryRepositoryInterface, otpService *OTPService, authService *AuthService) *UserService {
 	return &UserService{
-		userRepo: userRepo,
+		userRepo:            userRepo,
+		passwordHistoryRepo: passwordHistoryRepo,
+		otpService:          otpService,
+		authService:         authService,
 	}
 }
 
-func (us *UserService) RegisterUser(phoneNumber, fullName, password string) (*user.User, error) {
+func (us *UserService) RegisterUser(ctx context.Context, phoneNumber, fullName, password string) (*user.User, error) {
 	usr, err := user.NewWithPassword(user.NextID(), phoneNumber, fullName, password)
 	if err != nil {
 		return nil, err
 	}
 
-	err = us.userRepo.Store(usr)
+	err = us.userRepo.Store(ctx, usr)
 	if err != nil {
 		return nil, err
 	}
 
+	us.sendPhoneVerification(ctx, usr)
+
 	return usr, nil
 }
 
-func (us *UserService) GetProfile(id string) (*user.User, error) {
-	usr, err := us.userRepo.GetByID(id)
+func (us *UserService) GetProfile(ctx context.Context, id string) (*user.User, error) {
+	usr, err := us.userRepo.GetByID(ctx, id)
 	if err != nil {
 		return nil, err
 	}
@@ -42,13 +60,13 @@ func (us *UserService) GetProfile(id string) (*user.User, error) {
 	return usr, nil
 }
 
-func (us *UserService) UpdateProfile(id string, fullName, phoneNumber *string) error {
-	usr, err := us.userRepo.GetByID(id)
+func (us *UserService) UpdateProfile(ctx context.Context, id string, fullName, phoneNumber *string) error {
+	usr, err := us.userRepo.GetByID(ctx, id)
 	if err != nil {
 		return err
 	}
 
-	var modified bool
+	var modified, phoneNumberRequested bool
 	if fullName != nil && *fullName != usr.FullName() {
 		err = usr.ChangeFullName(*fullName)
 		if err != nil {
@@ -58,8 +76,8 @@ func (us *UserService) UpdateProfile(id string, fullName, phoneNumber *string) e
 		modified = true
 	}
 
-	if phoneNumber != nil && *phoneNumber != usr.PhoneNumber() {
-		other, err := us.userRepo.GetByPhoneNumber(*phoneNumber)
+	if phoneNumber != nil && *phoneNumber != usr.PhoneNumber() && *phoneNumber != usr.PendingPhoneNumber() {
+		other, err := us.userRepo.GetByPhoneNumber(ctx, *phoneNumber)
 		if err != nil {
 			return err
 		}
@@ -69,7 +87,17 @@ func (us *UserService) UpdateProfile(id string, fullName, phoneNumber *string) e
 			return ErrPhoneNumberAlreadyTaken
 		}
 
-		err = usr.ChangePhoneNumber(*phoneNumber)
+		// The new phone number only replaces the current one once verified
+		err = usr.RequestPhoneNumberChange(*phoneNumber)
+		if err != nil {
+			return err
+		}
+
+		modified = true
+		phoneNumberRequested = true
+	} else if phoneNumber != nil && *phoneNumber == usr.PhoneNumber() && usr.PendingPhoneNumber() != "" {
+		// Going back to the current phone number cancels the pending one
+		err = usr.RequestPhoneNumberChange(*phoneNumber)
 		if err != nil {
 			return err
 		}
@@ -81,5 +109,132 @@ func (us *UserService) UpdateProfile(id string, fullName, phoneNumber *string) e
 		return nil
 	}
 
-	return us.userRepo.Update(usr)
+	err = us.userRepo.Update(ctx, usr)
+	if err != nil {
+		return err
+	}
+
+	if phoneNumberRequested {
+		us.sendPhoneVerification(ctx, usr)
+	}
+
+	return nil
+}
+
+func (us *UserService) ChangePassword(ctx context.Context, id, currentPassword, newPassword string) error {
+	usr, err := us.userRepo.GetByID(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if usr == nil {
+		return ErrUserNotFound
+	}
+
+	// Wrong current passwords count as failed logins, so a stolen session
+	// cannot be used to guess the password
+	ok, err := us.authService.verifyUserSecret(ctx, usr.ID(), func() (bool, error) {
+		return usr.VerifyPassword(currentPassword), nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return user.ErrIncorrectPassword
+	}
+
+	history, err := previousPasswords(ctx, us.passwordHistoryRepo, usr.ID())
+	if err != nil {
+		return err
+	}
+
+	record := usr.PasswordRecord(time.Now())
+	err = usr.ResetPassword(newPassword, history)
+	if err != nil {
+		return err
+	}
+
+	return updatePassword(ctx, us.userRepo, us.passwordHistoryRepo, usr, record)
+}
+
+// SendPhoneVerification sends a verification code to the phone number
+// waiting for verification, the pending one if any.
+func (us *UserService) SendPhoneVerification(ctx context.Context, id string) error {
+	usr, err := us.userRepo.GetByID(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if usr == nil {
+		return ErrUserNotFound
+	}
+
+	phoneNumber := usr.PhoneNumberToVerify()
+	if phoneNumber == "" {
+		return ErrPhoneNumberVerified
+	}
+
+	return us.otpService.Send(ctx, otp.PurposePhoneVerification, phoneNumber, phoneVerificationMessage)
+}
+
+// ConfirmPhoneNumber verifies the phone number with the code sent to it. A
+// pending phone number replaces the current one.
+func (us *UserService) ConfirmPhoneNumber(ctx context.Context, id, code string) error {
+	usr, err := us.userRepo.GetByID(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if usr == nil {
+		return ErrUserNotFound
+	}
+
+	phoneNumber := usr.PhoneNumberToVerify()
+	if phoneNumber == "" {
+		return ErrPhoneNumberVerified
+	}
+
+	err = us.otpService.Verify(ctx, otp.PurposePhoneVerification, phoneNumber, code)
+	if err != nil {
+		return err
+	}
+
+	// The pending phone number might be taken while waiting for verification
+	if phoneNumber != usr.PhoneNumber() {
+		other, err := us.userRepo.GetByPhoneNumber(ctx, phoneNumber)
+		if err != nil {
+			return err
+		}
+
+		if other != nil && other.ID() != usr.ID() {
+			return ErrPhoneNumberAlreadyTaken
+		}
+	}
+
+	err = usr.ConfirmPhoneNumber(time.Now())
+	if err != nil {
+		return err
+	}
+
+	err = us.userRepo.Update(ctx, usr)
+	if errors.Is(err, repository.ErrUniqueViolation) {
+		return ErrPhoneNumberAlreadyTaken
+	}
+
+	return err
+}
+
+// sendPhoneVerification sends the verification code on a best effort basis,
+// the user can always ask for it again.
+func (us *UserService) sendPhoneVerification(ctx context.Context, usr *user.User) {
+	phoneNumber := usr.PhoneNumberToVerify()
+	if phoneNumber == "" {
+		return
+	}
+
+	err := us.otpService.Send(ctx, otp.PurposePhoneVerification, phoneNumber, phoneVerificationMessage)
+	if err != nil {
+		log.Printf("error sending phone verification: %v", err)
+	}
 }
@@ -0,0 +1,39 @@
+package handler
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ClientIPExtractor returns how the IP address of the clients, which failed
+// logins are counted for, is found. It is the address of the connection,
+// unless the request came through one of the trusted proxies, given as CIDR
+// ranges, in which case it is the last address of X-Forwarded-For not
+// belonging to them. Headers sent by the clients themselves are never
+// trusted, so they can not pick the address their failures count for.
+func ClientIPExtractor(trustedProxies []string) (echo.IPExtractor, error) {
+	if len(trustedProxies) == 0 {
+		return echo.ExtractIPDirect(), nil
+	}
+
+	// Only the given ranges are trusted, not the private networks echo
+	// trusts by default
+	opts := []echo.TrustOption{
+		echo.TrustLoopback(false),
+		echo.TrustLinkLocal(false),
+		echo.TrustPrivateNet(false),
+	}
+
+	for _, cidr := range trustedProxies {
+		_, ipRange, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy range %q: %w", cidr, err)
+		}
+
+		opts = append(opts, echo.TrustIPRange(ipRange))
+	}
+
+	return echo.ExtractIPFromXFFHeader(opts...), nil
+}
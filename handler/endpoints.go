@@ -1,16 +1,20 @@
 package handler
 
 import (
-	"crypto/rsa"
+	"crypto/subtle"
+	"encoding/base64"
 	"errors"
 	"log"
 	"net/http"
+	"net/url"
 	"strings"
+	"time"
 
 	"github.com/SawitProRecruitment/UserService/generated"
 	"github.com/SawitProRecruitment/UserService/handler/app"
 
 	"github.com/SawitProRecruitment/UserService/handler/model/user"
+	"github.com/SawitProRecruitment/UserService/handler/model/webauthn"
 	"github.com/labstack/echo/v4"
 )
 
@@ -21,7 +25,7 @@ func (s *Server) Login(ctx echo.Context) error {
 		return err
 	}
 
-	usr, err := s.AuthService.Authenticate(cred.PhoneNumber, cred.Password)
+	usr, err := s.AuthService.Authenticate(ctx.Request().Context(), cred.PhoneNumber, cred.Password, ctx.RealIP())
 	var authErr app.AuthenticationError
 	if ok := errors.As(err, &authErr); ok {
 		return ctx.NoContent(http.StatusBadRequest)
@@ -32,64 +36,717 @@ func (s *Server) Login(ctx echo.Context) error {
 		return err
 	}
 
-	tc := &TokenCreator{
-		PrivateKey: _privateKey,
+	return s.firstFactorVerified(ctx, usr, []string{amrPassword})
+}
+
+// Request a login code
+// (POST /users/login/otp/request)
+func (s *Server) RequestLoginOTP(ctx echo.Context) error {
+	var form generated.OTPLoginRequestForm
+	if err := ctx.Bind(&form); err != nil {
+		return err
+	}
+
+	failures := make(map[string][]string)
+	validatePhoneNumber(failures, "phoneNumber", form.PhoneNumber)
+	if len(failures) > 0 {
+		return ctx.JSON(http.StatusBadRequest, fieldErrors(failures))
+	}
+
+	err := s.AuthService.RequestLoginOTP(ctx.Request().Context(), form.PhoneNumber)
+	if err != nil {
+		return err
+	}
+
+	return ctx.NoContent(http.StatusAccepted)
+}
+
+// Login with a code sent by SMS
+// (POST /users/login/otp/verify)
+func (s *Server) LoginWithOTP(ctx echo.Context) error {
+	var form generated.OTPLoginForm
+	if err := ctx.Bind(&form); err != nil {
+		return err
+	}
+
+	usr, err := s.AuthService.AuthenticateOTP(ctx.Request().Context(), form.PhoneNumber, form.Code, ctx.RealIP())
+	var authErr app.AuthenticationError
+	if ok := errors.As(err, &authErr); ok {
+		return ctx.NoContent(http.StatusBadRequest)
+	}
+
+	if err != nil {
+		log.Printf("error authenticating user: %v", err)
+		return err
+	}
+
+	return s.firstFactorVerified(ctx, usr, []string{amrOneTimePassword, amrSMS})
+}
+
+// firstFactorVerified logs the user in, or asks for the second factor when
+// TOTP is enabled. The authentication methods are those of the first factor.
+func (s *Server) firstFactorVerified(ctx echo.Context, usr *user.User, authMethods []string) error {
+	mfaRequired, err := s.MFAService.Required(ctx.Request().Context(), usr.ID())
+	if err != nil {
+		return err
+	}
+
+	if mfaRequired {
+		challenge, mfaToken, err := s.MFAService.Challenge(ctx.Request().Context(), usr)
+		if err != nil {
+			return err
+		}
+
+		return ctx.JSON(http.StatusAccepted, generated.MFAChallenge{
+			MfaToken:  mfaToken,
+			ExpiresAt: challenge.ExpiresAt(),
+		})
+	}
+
+	return s.loggedIn(ctx, usr, authMethods)
+}
+
+// Complete login with a second factor
+// (POST /users/login/mfa)
+func (s *Server) LoginMFA(ctx echo.Context) error {
+	var form generated.MFALoginForm
+	if err := ctx.Bind(&form); err != nil {
+		return err
+	}
+
+	var code, recoveryCode string
+	if form.Code != nil {
+		code = *form.Code
+	}
+
+	if form.RecoveryCode != nil {
+		recoveryCode = *form.RecoveryCode
+	}
+
+	if code == "" && recoveryCode == "" {
+		return ctx.JSON(http.StatusBadRequest, []generated.FieldError{
+			{Name: "code", Codes: []string{errCodeMFACodeInvalid}},
+		})
+	}
+
+	usr, err := s.MFAService.VerifyChallenge(ctx.Request().Context(), form.MfaToken, code, recoveryCode)
+	if errors.Is(err, app.ErrInvalidMFAChallenge) {
+		return ctx.JSON(http.StatusBadRequest, []generated.FieldError{
+			{Name: "mfaToken", Codes: []string{errCodeMFATokenInvalid}},
+		})
+	}
+
+	if errors.Is(err, app.ErrMFAAttemptsExceeded) {
+		return ctx.JSON(http.StatusBadRequest, []generated.FieldError{
+			{Name: "mfaToken", Codes: []string{errCodeMFAAttemptsExceeded}},
+		})
+	}
+
+	if errors.Is(err, app.ErrInvalidMFACode) {
+		field := "code"
+		if recoveryCode != "" {
+			field = "recoveryCode"
+		}
+
+		return ctx.JSON(http.StatusBadRequest, []generated.FieldError{
+			{Name: field, Codes: []string{errCodeMFACodeInvalid}},
+		})
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return s.loggedIn(ctx, usr, []string{amrMultiFactor, amrOneTimePassword})
+}
+
+// Begin passkey login
+// (POST /users/login/passkey-options)
+func (s *Server) BeginPasskeyLogin(ctx echo.Context) error {
+	opts, err := s.PasskeyService.BeginLogin(ctx.Request().Context(), ctx.RealIP())
+	if errors.Is(err, app.ErrPasskeyLoginThrottled) {
+		return ctx.NoContent(http.StatusTooManyRequests)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return ctx.JSON(http.StatusOK, generated.PasskeyLoginOptions{
+		Challenge:        base64.RawURLEncoding.EncodeToString(opts.Challenge),
+		RpId:             opts.RelyingPartyID,
+		Timeout:          int(opts.Timeout.Milliseconds()),
+		UserVerification: passkeyUserVerification,
+	})
+}
+
+// Login with a passkey
+// (POST /users/login/passkey)
+func (s *Server) LoginWithPasskey(ctx echo.Context) error {
+	var form generated.PasskeyLoginForm
+	if err := ctx.Bind(&form); err != nil {
+		return err
+	}
+
+	var userHandle string
+	if form.Response.UserHandle != nil {
+		userHandle = *form.Response.UserHandle
+	}
+
+	fields, err := decodeBase64URLs(form.Id, form.Response.ClientDataJSON, form.Response.AuthenticatorData, form.Response.Signature, userHandle)
+	if err != nil || form.Type != passkeyCredentialType {
+		return ctx.NoContent(http.StatusBadRequest)
+	}
+
+	usr, err := s.PasskeyService.FinishLogin(ctx.Request().Context(), fields[0], fields[1], fields[2], fields[3], fields[4])
+	if errors.Is(err, app.ErrInvalidPasskey) {
+		return ctx.NoContent(http.StatusBadRequest)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	// User verification is required, so the passkey is a second factor too
+	return s.loggedIn(ctx, usr, []string{amrProofOfPossession, amrMultiFactor})
+}
+
+// loggedIn clears the failed logins of the user and responds with the tokens
+// of a new session, the access token tells how the user authenticated.
+func (s *Server) loggedIn(ctx echo.Context, usr *user.User, authMethods []string) error {
+	err := s.AuthService.LoginSucceeded(ctx.Request().Context(), usr.ID())
+	if err != nil {
+		return err
+	}
+
+	session, err := s.SessionService.IssueRefreshToken(ctx.Request().Context(), usr)
+	if err != nil {
+		return err
+	}
+
+	tokenString, err := s.tokenCreator().CreateAccessTokenWithClaims(usr, CustomClaims{
+		SessionID:   session.ID,
+		AuthMethods: authMethods,
+	})
+	if err != nil {
+		return err
+	}
+
+	return ctx.JSON(http.StatusOK, generated.LoginResponse{
+		Id:           usr.ID(),
+		AccessToken:  tokenString,
+		RefreshToken: session.RefreshToken,
+	})
+}
+
+// Refresh access token
+// (POST /users/token/refresh)
+func (s *Server) RefreshToken(ctx echo.Context) error {
+	var form generated.RefreshTokenForm
+	if err := ctx.Bind(&form); err != nil {
+		return err
+	}
+
+	usr, session, err := s.SessionService.Refresh(ctx.Request().Context(), form.RefreshToken)
+	if errors.Is(err, app.ErrInvalidRefreshToken) || errors.Is(err, app.ErrRefreshTokenReused) {
+		return ctx.NoContent(http.StatusBadRequest)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	// The session goes on, but how the user authenticated is only vouched
+	// for by the tokens issued on login
+	tokenString, err := s.tokenCreator().CreateAccessTokenWithClaims(usr, CustomClaims{
+		SessionID: session.ID,
+	})
+	if err != nil {
+		return err
+	}
+
+	return ctx.JSON(http.StatusOK, generated.LoginResponse{
+		Id:           usr.ID(),
+		AccessToken:  tokenString,
+		RefreshToken: session.RefreshToken,
+	})
+}
+
+// Request password reset
+// (POST /users/password/reset-request)
+func (s *Server) RequestPasswordReset(ctx echo.Context) error {
+	var form generated.PasswordResetRequestForm
+	if err := ctx.Bind(&form); err != nil {
+		return err
+	}
+
+	failures := make(map[string][]string)
+	validatePhoneNumber(failures, "phoneNumber", form.PhoneNumber)
+	if len(failures) > 0 {
+		return ctx.JSON(http.StatusBadRequest, fieldErrors(failures))
+	}
+
+	err := s.PasswordResetService.RequestReset(ctx.Request().Context(), form.PhoneNumber)
+	if err != nil {
+		return err
+	}
+
+	return ctx.NoContent(http.StatusAccepted)
+}
+
+// Reset password
+// (POST /users/password/reset)
+func (s *Server) ResetPassword(ctx echo.Context) error {
+	var form generated.PasswordResetForm
+	if err := ctx.Bind(&form); err != nil {
+		return err
+	}
+
+	formErrs, err := validatePasswordResetForm(form)
+	if err != nil {
+		return err
+	}
+
+	if len(formErrs) > 0 {
+		return ctx.JSON(http.StatusBadRequest, formErrs)
+	}
+
+	usr, err := s.PasswordResetService.Reset(ctx.Request().Context(), form.PhoneNumber, form.Code, form.NewPassword)
+	var weakErr *user.WeakPasswordError
+	if errors.As(err, &weakErr) {
+		return ctx.JSON(http.StatusBadRequest, weakPasswordErrors("newPassword", weakErr))
+	}
+
+	if errors.Is(err, user.ErrPasswordReused) {
+		return ctx.JSON(http.StatusBadRequest, []generated.FieldError{
+			{Name: "newPassword", Codes: []string{errCodePasswordReused}},
+		})
+	}
+
+	if errors.Is(err, app.ErrInvalidOTP) {
+		return ctx.JSON(http.StatusBadRequest, []generated.FieldError{
+			{Name: "code", Codes: []string{errCodeOTPInvalid}},
+		})
+	}
+
+	if errors.Is(err, app.ErrOTPAttemptsExceeded) {
+		return ctx.JSON(http.StatusBadRequest, []generated.FieldError{
+			{Name: "code", Codes: []string{errCodeOTPAttemptsExceeded}},
+		})
+	}
+
+	if err != nil {
+		return err
+	}
+
+	err = s.SessionService.RevokeAll(ctx.Request().Context(), usr.ID())
+	if err != nil {
+		return err
+	}
+
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+// Logout
+// (POST /users/logout)
+func (s *Server) Logout(ctx echo.Context) error {
+	at, err := s.authenticatedToken(ctx)
+	if err != nil {
+		return ctx.NoContent(http.StatusForbidden)
+	}
+
+	var form generated.LogoutForm
+	if err := ctx.Bind(&form); err != nil {
+		return err
+	}
+
+	var refreshToken string
+	if form.RefreshToken != nil {
+		refreshToken = *form.RefreshToken
+	}
+
+	err = s.SessionService.Logout(ctx.Request().Context(), at, refreshToken)
+	if err != nil {
+		return err
+	}
+
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+// Logout from all devices
+// (POST /users/logout/all)
+func (s *Server) LogoutAll(ctx echo.Context) error {
+	at, err := s.authenticatedToken(ctx)
+	if err != nil {
+		return ctx.NoContent(http.StatusForbidden)
+	}
+
+	err = s.SessionService.LogoutAll(ctx.Request().Context(), at)
+	if err != nil {
+		return err
+	}
+
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+// Get my profile
+// (GET /users/me)
+func (s *Server) GetMyProfile(ctx echo.Context) error {
+	userID, err := s.authenticatedUserID(ctx)
+	if err != nil {
+		return ctx.NoContent(http.StatusForbidden)
+	}
+
+	usr, err := s.UserService.GetProfile(ctx.Request().Context(), userID)
+	if err != nil {
+		return err
+	}
+
+	if usr == nil {
+		return ctx.NoContent(http.StatusNotFound)
+	}
+
+	profile := generated.UserProfile{
+		Name:          usr.FullName(),
+		PhoneNumber:   usr.PhoneNumber(),
+		PhoneVerified: usr.PhoneVerified(),
+	}
+
+	if pending := usr.PendingPhoneNumber(); pending != "" {
+		profile.PendingPhoneNumber = &pending
+	}
+
+	return ctx.JSON(http.StatusOK, profile)
+}
+
+// Update my profile
+// (PUT /users/me)
+func (s *Server) UpdateMyProfile(ctx echo.Context) error {
+	userID, err := s.authenticatedUserID(ctx)
+	if err != nil {
+		return ctx.NoContent(http.StatusForbidden)
+	}
+
+	var profileForm generated.UserProfileForm
+	if err := ctx.Bind(&profileForm); err != nil {
+		return err
+	}
+
+	formErrs := validateUserProfileForm(profileForm)
+	if len(formErrs) > 0 {
+		return ctx.JSON(http.StatusBadRequest, formErrs)
+	}
+
+	err = s.UserService.UpdateProfile(ctx.Request().Context(), userID, profileForm.FullName, profileForm.PhoneNumber)
+	if errors.Is(err, app.ErrPhoneNumberAlreadyTaken) {
+		return ctx.NoContent(http.StatusConflict)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+// Send phone verification code
+// (POST /users/me/phone/verification)
+func (s *Server) SendPhoneVerification(ctx echo.Context) error {
+	userID, err := s.authenticatedUserID(ctx)
+	if err != nil {
+		return ctx.NoContent(http.StatusForbidden)
+	}
+
+	err = s.UserService.SendPhoneVerification(ctx.Request().Context(), userID)
+	if errors.Is(err, app.ErrUserNotFound) {
+		return ctx.NoContent(http.StatusForbidden)
+	}
+
+	if errors.Is(err, app.ErrPhoneNumberVerified) {
+		return ctx.NoContent(http.StatusConflict)
+	}
+
+	if errors.Is(err, app.ErrOTPResendTooSoon) || errors.Is(err, app.ErrOTPSendLimitReached) {
+		return ctx.NoContent(http.StatusTooManyRequests)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return ctx.NoContent(http.StatusAccepted)
+}
+
+// Confirm phone verification
+// (POST /users/me/phone/verification/confirm)
+func (s *Server) ConfirmPhoneVerification(ctx echo.Context) error {
+	userID, err := s.authenticatedUserID(ctx)
+	if err != nil {
+		return ctx.NoContent(http.StatusForbidden)
+	}
+
+	var form generated.PhoneVerificationForm
+	if err := ctx.Bind(&form); err != nil {
+		return err
+	}
+
+	err = s.UserService.ConfirmPhoneNumber(ctx.Request().Context(), userID, form.Code)
+	if errors.Is(err, app.ErrInvalidOTP) {
+		return ctx.JSON(http.StatusBadRequest, []generated.FieldError{
+			{Name: "code", Codes: []string{errCodeOTPInvalid}},
+		})
+	}
+
+	if errors.Is(err, app.ErrOTPAttemptsExceeded) {
+		return ctx.JSON(http.StatusBadRequest, []generated.FieldError{
+			{Name: "code", Codes: []string{errCodeOTPAttemptsExceeded}},
+		})
+	}
+
+	if errors.Is(err, app.ErrUserNotFound) {
+		return ctx.NoContent(http.StatusForbidden)
+	}
+
+	if errors.Is(err, app.ErrPhoneNumberVerified) || errors.Is(err, app.ErrPhoneNumberAlreadyTaken) {
+		return ctx.NoContent(http.StatusConflict)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+// Change my password
+// (PUT /users/me/password)
+func (s *Server) ChangeMyPassword(ctx echo.Context) error {
+	at, err := s.authenticatedToken(ctx)
+	if err != nil {
+		return ctx.NoContent(http.StatusForbidden)
+	}
+
+	var form generated.ChangePasswordForm
+	if err := ctx.Bind(&form); err != nil {
+		return err
+	}
+
+	formErrs, err := validateChangePasswordForm(form)
+	if err != nil {
+		return err
+	}
+
+	if len(formErrs) > 0 {
+		return ctx.JSON(http.StatusBadRequest, formErrs)
+	}
+
+	err = s.UserService.ChangePassword(ctx.Request().Context(), at.UserID, form.CurrentPassword, form.NewPassword)
+	if errors.Is(err, user.ErrIncorrectPassword) {
+		return ctx.JSON(http.StatusBadRequest, []generated.FieldError{
+			{Name: "currentPassword", Codes: []string{errCodePasswordIncorrect}},
+		})
+	}
+
+	var weakErr *user.WeakPasswordError
+	if errors.As(err, &weakErr) {
+		return ctx.JSON(http.StatusBadRequest, weakPasswordErrors("newPassword", weakErr))
+	}
+
+	if errors.Is(err, user.ErrPasswordReused) {
+		return ctx.JSON(http.StatusBadRequest, []generated.FieldError{
+			{Name: "newPassword", Codes: []string{errCodePasswordReused}},
+		})
+	}
+
+	if errors.Is(err, app.ErrUserNotFound) {
+		return ctx.NoContent(http.StatusForbidden)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	err = s.SessionService.LogoutAll(ctx.Request().Context(), at)
+	if err != nil {
+		return err
+	}
+
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+// Enroll TOTP
+// (POST /users/me/totp)
+func (s *Server) EnrollTOTP(ctx echo.Context) error {
+	userID, err := s.authenticatedUserID(ctx)
+	if err != nil {
+		return ctx.NoContent(http.StatusForbidden)
+	}
+
+	t, uri, err := s.MFAService.Enroll(ctx.Request().Context(), userID)
+	if errors.Is(err, app.ErrUserNotFound) {
+		return ctx.NoContent(http.StatusForbidden)
+	}
+
+	if errors.Is(err, app.ErrTOTPEnabled) {
+		return ctx.NoContent(http.StatusConflict)
 	}
 
-	tokenString, err := tc.CreateAccessToken(usr)
 	if err != nil {
 		return err
 	}
 
-	return ctx.JSON(http.StatusOK, generated.LoginResponse{
-		Id:          usr.ID(),
-		AccessToken: tokenString,
+	return ctx.JSON(http.StatusOK, generated.TOTPEnrollment{
+		Secret: t.EncodedSecret(),
+		Uri:    uri,
 	})
 }
 
-// Get my profile
-// (GET /users/me)
-func (s *Server) GetMyProfile(ctx echo.Context) error {
-	userID, err := authenticatedUserID(ctx, _publicKey)
+// Confirm TOTP
+// (POST /users/me/totp/confirm)
+func (s *Server) ConfirmTOTP(ctx echo.Context) error {
+	userID, err := s.authenticatedUserID(ctx)
 	if err != nil {
 		return ctx.NoContent(http.StatusForbidden)
 	}
 
-	usr, err := s.UserService.GetProfile(userID)
+	var form generated.TOTPConfirmationForm
+	if err := ctx.Bind(&form); err != nil {
+		return err
+	}
+
+	codes, err := s.MFAService.Confirm(ctx.Request().Context(), userID, form.Code)
+	if errors.Is(err, app.ErrInvalidMFACode) {
+		return ctx.JSON(http.StatusBadRequest, []generated.FieldError{
+			{Name: "code", Codes: []string{errCodeMFACodeInvalid}},
+		})
+	}
+
+	if errors.Is(err, app.ErrTOTPNotEnrolled) || errors.Is(err, app.ErrTOTPEnabled) {
+		return ctx.NoContent(http.StatusConflict)
+	}
+
 	if err != nil {
 		return err
 	}
 
-	if usr == nil {
+	return ctx.JSON(http.StatusOK, generated.RecoveryCodes{
+		RecoveryCodes: codes,
+	})
+}
+
+// Disable TOTP
+// (POST /users/me/totp/disable)
+func (s *Server) DisableTOTP(ctx echo.Context) error {
+	userID, err := s.authenticatedUserID(ctx)
+	if err != nil {
+		return ctx.NoContent(http.StatusForbidden)
+	}
+
+	var form generated.TOTPDisableForm
+	if err := ctx.Bind(&form); err != nil {
+		return err
+	}
+
+	err = s.MFAService.Disable(ctx.Request().Context(), userID, form.Password)
+	if errors.Is(err, user.ErrIncorrectPassword) {
+		return ctx.JSON(http.StatusBadRequest, []generated.FieldError{
+			{Name: "password", Codes: []string{errCodePasswordIncorrect}},
+		})
+	}
+
+	if errors.Is(err, app.ErrUserNotFound) {
+		return ctx.NoContent(http.StatusForbidden)
+	}
+
+	if errors.Is(err, app.ErrTOTPNotEnrolled) {
 		return ctx.NoContent(http.StatusNotFound)
 	}
 
-	return ctx.JSON(http.StatusOK, generated.UserProfile{
-		Name:        usr.FullName(),
-		PhoneNumber: usr.PhoneNumber(),
-	})
+	if err != nil {
+		return err
+	}
+
+	return ctx.NoContent(http.StatusNoContent)
 }
 
-// Update my profile
-// (PUT /users/me)
-func (s *Server) UpdateMyProfile(ctx echo.Context) error {
-	userID, err := authenticatedUserID(ctx, _publicKey)
+// Begin passkey registration
+// (POST /users/me/passkeys/registration-options)
+func (s *Server) BeginPasskeyRegistration(ctx echo.Context) error {
+	userID, err := s.authenticatedUserID(ctx)
 	if err != nil {
 		return ctx.NoContent(http.StatusForbidden)
 	}
 
-	var profileForm generated.UserProfileForm
-	if err := ctx.Bind(&profileForm); err != nil {
+	opts, err := s.PasskeyService.BeginRegistration(ctx.Request().Context(), userID)
+	if errors.Is(err, app.ErrUserNotFound) {
+		return ctx.NoContent(http.StatusForbidden)
+	}
+
+	if err != nil {
 		return err
 	}
 
-	formErrs := validateUserProfileForm(profileForm)
-	if len(formErrs) > 0 {
-		return ctx.JSON(http.StatusBadRequest, formErrs)
+	res := generated.PasskeyRegistrationOptions{
+		Challenge: base64.RawURLEncoding.EncodeToString(opts.Challenge),
+		Rp: generated.PasskeyRelyingParty{
+			Id:   opts.RelyingParty.ID,
+			Name: opts.RelyingParty.Name,
+		},
+		User: generated.PasskeyUser{
+			Id:          base64.RawURLEncoding.EncodeToString([]byte(opts.User.ID())),
+			Name:        opts.User.PhoneNumber(),
+			DisplayName: opts.User.FullName(),
+		},
+		Timeout:            int(opts.Timeout.Milliseconds()),
+		ExcludeCredentials: []generated.PasskeyCredentialDescriptor{},
+		AuthenticatorSelection: generated.PasskeyAuthenticatorSelection{
+			ResidentKey:      "required",
+			UserVerification: passkeyUserVerification,
+		},
+		Attestation: "none",
 	}
 
-	err = s.UserService.UpdateProfile(userID, profileForm.FullName, profileForm.PhoneNumber)
-	if errors.Is(err, app.ErrPhoneNumberAlreadyTaken) {
+	for _, alg := range webauthn.SupportedAlgorithms {
+		res.PubKeyCredParams = append(res.PubKeyCredParams, generated.PasskeyCredentialParameters{
+			Type: passkeyCredentialType,
+			Alg:  alg,
+		})
+	}
+
+	for _, id := range opts.ExcludeCredentials {
+		res.ExcludeCredentials = append(res.ExcludeCredentials, generated.PasskeyCredentialDescriptor{
+			Type: passkeyCredentialType,
+			Id:   base64.RawURLEncoding.EncodeToString(id),
+		})
+	}
+
+	return ctx.JSON(http.StatusOK, res)
+}
+
+// Register passkey
+// (POST /users/me/passkeys)
+func (s *Server) RegisterPasskey(ctx echo.Context) error {
+	userID, err := s.authenticatedUserID(ctx)
+	if err != nil {
+		return ctx.NoContent(http.StatusForbidden)
+	}
+
+	var form generated.PasskeyRegistrationForm
+	if err := ctx.Bind(&form); err != nil {
+		return err
+	}
+
+	fields, err := decodeBase64URLs(form.Response.ClientDataJSON, form.Response.AttestationObject)
+	if err != nil || form.Type != passkeyCredentialType {
+		return ctx.NoContent(http.StatusBadRequest)
+	}
+
+	cred, err := s.PasskeyService.FinishRegistration(ctx.Request().Context(), userID, fields[0], fields[1])
+	if errors.Is(err, app.ErrInvalidPasskey) {
+		return ctx.NoContent(http.StatusBadRequest)
+	}
+
+	if errors.Is(err, app.ErrPasskeyRegistered) {
 		return ctx.NoContent(http.StatusConflict)
 	}
 
@@ -97,7 +754,10 @@ func (s *Server) UpdateMyProfile(ctx echo.Context) error {
 		return err
 	}
 
-	return ctx.NoContent(http.StatusNoContent)
+	return ctx.JSON(http.StatusCreated, generated.Passkey{
+		Id:        base64.RawURLEncoding.EncodeToString(cred.ID()),
+		CreatedAt: cred.CreatedAt(),
+	})
 }
 
 // Register new user
@@ -108,12 +768,16 @@ func (s *Server) RegisterUser(ctx echo.Context) error {
 		return err
 	}
 
-	formErrs := validateRegistrationForm(regForm)
+	formErrs, err := validateRegistrationForm(regForm)
+	if err != nil {
+		return err
+	}
+
 	if len(formErrs) > 0 {
 		return ctx.JSON(http.StatusBadRequest, formErrs)
 	}
 
-	usr, err := s.UserService.RegisterUser(
+	usr, err := s.UserService.RegisterUser(ctx.Request().Context(),
 		regForm.PhoneNumber,
 		regForm.FullName,
 		regForm.Password)
@@ -127,18 +791,300 @@ func (s *Server) RegisterUser(ctx echo.Context) error {
 	})
 }
 
-func authenticatedUserID(ctx echo.Context, pubKey *rsa.PublicKey) (string, error) {
+// Get password policy
+// (GET /password-policy)
+func (s *Server) GetPasswordPolicy(ctx echo.Context) error {
+	pp := user.CurrentPasswordPolicy()
+	return ctx.JSON(http.StatusOK, generated.PasswordPolicy{
+		MinLength:               pp.MinLength,
+		MaxLength:               pp.MaxLength,
+		RequireUppercase:        pp.RequireUppercase,
+		RequireLowercase:        pp.RequireLowercase,
+		RequireDigit:            pp.RequireDigit,
+		RequireSpecialCharacter: pp.RequireSpecial,
+		RejectPersonalInfo:      pp.RejectPersonalInfo,
+		RejectBreached:          pp.BreachedSource != nil,
+		MinScore:                pp.MinScore,
+		HistorySize:             pp.HistorySize,
+	})
+}
+
+// Get token signing keys
+// (GET /.well-known/jwks.json)
+func (s *Server) GetJWKS(ctx echo.Context) error {
+	jwks := generated.JSONWebKeySet{
+		Keys: []generated.JSONWebKey{},
+	}
+
+	for _, k := range s.KeyRing.PublishedKeys(time.Now()) {
+		jwk := k.JWK()
+		jwks.Keys = append(jwks.Keys, generated.JSONWebKey{
+			Kty: jwk.Kty,
+			Use: "sig",
+			Alg: string(k.Algorithm),
+			Kid: k.ID,
+			Crv: optionalString(jwk.Crv),
+			N:   optionalString(jwk.N),
+			E:   optionalString(jwk.E),
+			X:   optionalString(jwk.X),
+			Y:   optionalString(jwk.Y),
+		})
+	}
+
+	// Verifiers may cache the keys, new ones are published ahead of use
+	ctx.Response().Header().Set("Cache-Control", "public, max-age=300")
+	return ctx.JSON(http.StatusOK, jwks)
+}
+
+// Introspect a token
+// (POST /oauth/introspect)
+func (s *Server) IntrospectToken(ctx echo.Context) error {
+	clientID, ok := s.authenticatedClient(ctx)
+	if !ok {
+		return oauthClientUnauthorized(ctx)
+	}
+
+	tokenString := ctx.FormValue("token")
+	if tokenString == "" {
+		return ctx.JSON(http.StatusBadRequest, generated.OAuthError{Error: oauthErrInvalidRequest})
+	}
+
+	ctx.Response().Header().Set("Cache-Control", "no-store")
+	inactive := generated.TokenIntrospection{Active: false}
+
+	// Refresh tokens are only meant for this service, so they are reported
+	// inactive like any token failing verification, and so are the tokens
+	// of other clients
+	claim, err := s.tokenVerifier().Verify(tokenString)
+	if err != nil || !claim.Audience.contains(clientID) {
+		return ctx.JSON(http.StatusOK, inactive)
+	}
+
+	revoked, err := s.SessionService.AccessTokenRevoked(ctx.Request().Context(), accessTokenOf(claim))
+	if err != nil {
+		log.Printf("error checking token revocation: %v", err)
+		return err
+	}
+
+	if revoked {
+		return ctx.JSON(http.StatusOK, inactive)
+	}
+
+	res := generated.TokenIntrospection{
+		Active:    true,
+		TokenType: optionalString("Bearer"),
+		Scope:     optionalString(claim.Scope),
+		Sub:       &claim.Subject,
+		Exp:       &claim.ExpiresAt,
+		Iat:       &claim.IssuedAt,
+		Nbf:       &claim.NotBefore,
+		Iss:       &claim.Issuer,
+		Jti:       &claim.ID,
+		Sid:       optionalString(claim.SessionID),
+	}
+
+	if len(claim.Audience) > 0 {
+		aud := []string(claim.Audience)
+		res.Aud = &aud
+	}
+
+	return ctx.JSON(http.StatusOK, res)
+}
+
+// Revoke a token
+// (POST /oauth/revoke)
+func (s *Server) RevokeToken(ctx echo.Context) error {
+	clientID, ok := s.authenticatedClient(ctx)
+	if !ok {
+		return oauthClientUnauthorized(ctx)
+	}
+
+	tokenString := ctx.FormValue("token")
+	if tokenString == "" {
+		return ctx.JSON(http.StatusBadRequest, generated.OAuthError{Error: oauthErrInvalidRequest})
+	}
+
+	// The hint is not needed, anything that is not a valid access token is
+	// looked up as a refresh token. A client only revokes the tokens issued
+	// to it, as RFC 7009 requires, the others are ignored like unknown ones.
+	// Refresh tokens are issued to the audience of the access tokens they
+	// are exchanged for.
+	var err error
+	if claim, verifyErr := s.tokenVerifier().Verify(tokenString); verifyErr == nil {
+		if claim.Audience.contains(clientID) {
+			err = s.SessionService.RevokeAccessToken(ctx.Request().Context(), accessTokenOf(claim))
+		}
+	} else if audience(s.TokenAudience).contains(clientID) {
+		err = s.SessionService.RevokeRefreshToken(ctx.Request().Context(), tokenString)
+	}
+
+	if err != nil {
+		log.Printf("error revoking token: %v", err)
+		return err
+	}
+
+	return ctx.NoContent(http.StatusOK)
+}
+
+// authenticatedClient returns the ID of the client authenticated by its
+// credentials. They are sent with HTTP Basic authentication, the ID and secret
+// being form encoded as in RFC 6749, or as the client_id and client_secret
+// form parameters, but not both.
+func (s *Server) authenticatedClient(ctx echo.Context) (string, bool) {
+	clientID, secret := ctx.FormValue("client_id"), ctx.FormValue("client_secret")
+	if rawID, rawSecret, ok := ctx.Request().BasicAuth(); ok {
+		if clientID != "" || secret != "" {
+			return "", false
+		}
+
+		var err error
+		clientID, err = url.QueryUnescape(rawID)
+		if err != nil {
+			return "", false
+		}
+
+		secret, err = url.QueryUnescape(rawSecret)
+		if err != nil {
+			return "", false
+		}
+	}
+
+	expected, ok := s.OAuthClients[clientID]
+	if !ok || expected == "" {
+		return "", false
+	}
+
+	if subtle.ConstantTimeCompare([]byte(secret), []byte(expected)) != 1 {
+		return "", false
+	}
+
+	return clientID, true
+}
+
+func oauthClientUnauthorized(ctx echo.Context) error {
+	ctx.Response().Header().Set(echo.HeaderWWWAuthenticate, `Basic realm="oauth"`)
+	return ctx.JSON(http.StatusUnauthorized, generated.OAuthError{Error: oauthErrInvalidClient})
+}
+
+// Get user login lock state
+// (GET /admin/users/{id}/login-lock)
+func (s *Server) GetUserLoginLock(ctx echo.Context, id string) error {
+	if !s.authorizedAdmin(ctx) {
+		return ctx.NoContent(http.StatusForbidden)
+	}
+
+	usr, err := s.UserService.GetProfile(ctx.Request().Context(), id)
+	if err != nil {
+		return err
+	}
+
+	if usr == nil {
+		return ctx.NoContent(http.StatusNotFound)
+	}
+
+	counter, err := s.AuthService.LoginLockState(ctx.Request().Context(), usr.ID())
+	if err != nil {
+		return err
+	}
+
+	state := generated.LoginLockState{
+		UserId: usr.ID(),
+	}
+
+	if counter != nil {
+		now := time.Now()
+		state.FailedAttempts = counter.Failures()
+		state.Locked = counter.Locked(now)
+		if state.Locked {
+			lockedUntil := counter.LockedUntil()
+			state.LockedUntil = &lockedUntil
+		}
+
+		if retryAt := s.AuthService.UserLockoutPolicy.RetryAt(counter); retryAt.After(now) {
+			state.RetryAfter = &retryAt
+		}
+	}
+
+	return ctx.JSON(http.StatusOK, state)
+}
+
+func (s *Server) authorizedAdmin(ctx echo.Context) bool {
+	if s.AdminKey == "" {
+		return false
+	}
+
+	key := ctx.Request().Header.Get("X-Admin-Key")
+	return subtle.ConstantTimeCompare([]byte(key), []byte(s.AdminKey)) == 1
+}
+
+func (s *Server) authenticatedUserID(ctx echo.Context) (string, error) {
+	at, err := s.authenticatedToken(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return at.UserID, nil
+}
+
+func (s *Server) authenticatedToken(ctx echo.Context) (app.AccessToken, error) {
 	authHeader := ctx.Request().Header.Get("Authorization")
 	bearerToken, err := parseBearerToken(authHeader)
 	if err != nil {
-		return "", err
+		return app.AccessToken{}, err
+	}
+
+	claim, err := s.tokenVerifier().Verify(bearerToken)
+	if err != nil {
+		return app.AccessToken{}, err
+	}
+
+	at := accessTokenOf(claim)
+	revoked, err := s.SessionService.AccessTokenRevoked(ctx.Request().Context(), at)
+	if err != nil {
+		log.Printf("error checking token revocation: %v", err)
+		return app.AccessToken{}, err
+	}
+
+	if revoked {
+		return app.AccessToken{}, errors.New("revoked token")
+	}
+
+	return at, nil
+}
+
+// optionalString returns nil for an empty string, leaving it out of the
+// response.
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
 	}
 
-	tv := &TokenVerifier{
-		PublicKey: pubKey,
+	return &s
+}
+
+// decodeBase64URLs decodes the binary values of WebAuthn responses, padded or
+// not.
+func decodeBase64URLs(values ...string) ([][]byte, error) {
+	decoded := make([][]byte, len(values))
+	for i, v := range values {
+		b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(v, "="))
+		if err != nil {
+			return nil, err
+		}
+
+		decoded[i] = b
 	}
 
-	return tv.VerifyIdentify(bearerToken)
+	return decoded, nil
+}
+
+func accessTokenOf(claim *AccessTokenClaims) app.AccessToken {
+	return app.AccessToken{
+		ID:        claim.ID,
+		UserID:    claim.Subject,
+		IssuedAt:  time.Unix(claim.IssuedAt, 0),
+		ExpiresAt: time.Unix(claim.ExpiresAt, 0),
+	}
 }
 
 func parseBearerToken(authHeader string) (string, error) {
@@ -154,7 +1100,7 @@ func parseBearerToken(authHeader string) (string, error) {
 	return parts[1], nil
 }
 
-func validateRegistrationForm(form generated.UserRegistrationForm) []generated.FieldError {
+func validateRegistrationForm(form generated.UserRegistrationForm) ([]generated.FieldError, error) {
 	failures := make(map[string][]string)
 	if !user.ValidPhoneNumberLength(form.PhoneNumber) {
 		failures["phoneNumber"] = append(failures["phoneNumber"], errCodePhoneNumberLength)
@@ -168,8 +1114,12 @@ func validateRegistrationForm(form generated.UserRegistrationForm) []generated.F
 		failures["fullName"] = append(failures["fullName"], errCodeFullNameLength)
 	}
 
-	if !user.ValidPasswordStrength(form.Password) {
-		failures["password"] = append(failures["password"], errCodePasswordStrength)
+	err := validatePassword(failures, "password", form.Password, user.PasswordOwner{
+		PhoneNumber: form.PhoneNumber,
+		FullName:    form.FullName,
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	var errors []generated.FieldError
@@ -180,7 +1130,7 @@ func validateRegistrationForm(form generated.UserRegistrationForm) []generated.F
 		})
 	}
 
-	return errors
+	return errors, nil
 }
 
 func validateUserProfileForm(form generated.UserProfileForm) []generated.FieldError {
@@ -213,9 +1163,125 @@ func validateUserProfileForm(form generated.UserProfileForm) []generated.FieldEr
 	return errors
 }
 
+func validatePasswordResetForm(form generated.PasswordResetForm) ([]generated.FieldError, error) {
+	failures := make(map[string][]string)
+	validatePhoneNumber(failures, "phoneNumber", form.PhoneNumber)
+
+	err := validatePassword(failures, "newPassword", form.NewPassword, user.PasswordOwner{
+		PhoneNumber: form.PhoneNumber,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return fieldErrors(failures), nil
+}
+
+func validatePhoneNumber(failures map[string][]string, field, phoneNumber string) {
+	if !user.ValidPhoneNumberLength(phoneNumber) {
+		failures[field] = append(failures[field], errCodePhoneNumberLength)
+	}
+
+	if !user.ValidPhoneNumberPrefix(phoneNumber) {
+		failures[field] = append(failures[field], errCodePhoneNumberFormat)
+	}
+}
+
+// validatePassword adds the password rules not met to the failures. The owner
+// may be partially known, the rules are checked again once the user is loaded.
+func validatePassword(failures map[string][]string, field, password string, owner user.PasswordOwner) error {
+	violations, err := user.CheckPassword(password, owner)
+	if err != nil {
+		return err
+	}
+
+	for _, v := range violations {
+		failures[field] = append(failures[field], passwordErrorCode(v))
+	}
+
+	return nil
+}
+
+func weakPasswordErrors(field string, wpe *user.WeakPasswordError) []generated.FieldError {
+	failures := make(map[string][]string)
+	for _, v := range wpe.Violations {
+		failures[field] = append(failures[field], passwordErrorCode(v))
+	}
+
+	return fieldErrors(failures)
+}
+
+func passwordErrorCode(v user.PasswordViolation) string {
+	switch v {
+	case user.PasswordBreached:
+		return errCodePasswordBreached
+	case user.PasswordContainsPersonalInfo:
+		return errCodePasswordPersonalInfo
+	case user.PasswordLowEntropy:
+		return errCodePasswordLowEntropy
+	}
+
+	return errCodePasswordStrength
+}
+
+func fieldErrors(failures map[string][]string) []generated.FieldError {
+	var errors []generated.FieldError
+	for field, codes := range failures {
+		errors = append(errors, generated.FieldError{
+			Name:  field,
+			Codes: codes,
+		})
+	}
+
+	return errors
+}
+
+func validateChangePasswordForm(form generated.ChangePasswordForm) ([]generated.FieldError, error) {
+	failures := make(map[string][]string)
+
+	err := validatePassword(failures, "newPassword", form.NewPassword, user.PasswordOwner{})
+	if err != nil {
+		return nil, err
+	}
+
+	var errors []generated.FieldError
+	for field, codes := range failures {
+		errors = append(errors, generated.FieldError{
+			Name:  field,
+			Codes: codes,
+		})
+	}
+
+	return errors, nil
+}
+
+const (
+	passkeyCredentialType   = "public-key"
+	passkeyUserVerification = "required"
+)
+
+// OAuth error codes of RFC 6749.
+const (
+	oauthErrInvalidRequest = "invalid_request"
+	oauthErrInvalidClient  = "invalid_client"
+)
+
 const (
 	errCodePhoneNumberLength = "PHONE_NUMBER_LENGTH"
 	errCodePhoneNumberFormat = "PHONE_NUMBER_FORMAT"
 	errCodeFullNameLength    = "FULL_NAME_LENGTH"
 	errCodePasswordStrength  = "PASSWORD_STRENGTH"
+	errCodePasswordIncorrect = "PASSWORD_INCORRECT"
+
+	errCodePasswordBreached     = "PASSWORD_BREACHED"
+	errCodePasswordPersonalInfo = "PASSWORD_CONTAINS_PERSONAL_INFO"
+	errCodePasswordLowEntropy   = "PASSWORD_LOW_ENTROPY"
+	errCodePasswordReused       = "PASSWORD_REUSED"
+
+	errCodeOTPInvalid          = "OTP_INVALID"
+	errCodeOTPAttemptsExceeded = "OTP_ATTEMPTS_EXCEEDED"
+
+	errCodeMFACodeInvalid      = "MFA_CODE_INVALID"
+	errCodeMFATokenInvalid     = "MFA_TOKEN_INVALID"
+	errCodeMFAAttemptsExceeded = "MFA_ATTEMPTS_EXCEEDED"
 )
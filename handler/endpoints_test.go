@@ -2,38 +2,152 @@ package handler
 
 import (
 	"bytes"
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/pem"
 	"errors"
 	"fmt"
+	"io"
+	"log"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
 	"slices"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/SawitProRecruitment/UserService/generated"
+	"github.com/SawitProRecruitment/UserService/handler/app"
+	"github.com/SawitProRecruitment/UserService/handler/model/lockout"
+	"github.com/SawitProRecruitment/UserService/handler/model/mfa"
+	"github.com/SawitProRecruitment/UserService/handler/model/otp"
+	"github.com/SawitProRecruitment/UserService/handler/model/token"
 	"github.com/SawitProRecruitment/UserService/handler/model/user"
+	"github.com/SawitProRecruitment/UserService/handler/model/webauthn"
+	"github.com/SawitProRecruitment/UserService/keyring"
+	"github.com/SawitProRecruitment/UserService/pepper"
+	"github.com/SawitProRecruitment/UserService/pwned"
 	"github.com/SawitProRecruitment/UserService/repository"
+	"github.com/SawitProRecruitment/UserService/sms"
+	"github.com/golang-jwt/jwt"
 	"github.com/golang/mock/gomock"
 	"github.com/labstack/echo/v4"
+	"github.com/rs/xid"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/pbkdf2"
 )
 
+const (
+	testAdminKey          = "admin-secret"
+	testOAuthClientID     = "billing"
+	testOAuthClientSecret = "billing-secret"
+)
+
+var (
+	testKeySource = keyring.StaticSource{{ID: "test", PrivateKey: generateRSAKey()}}
+	// testKeyRing signs tokens the server of setup accepts.
+	testKeyRing, _ = keyring.Load(testKeySource, "", time.Hour)
+)
+
+// testTokenRoles gives the users of the setup server a role derived from
+// their ID, so a test can tell whose roles a token carries.
+func testTokenRoles(usr *user.User) []string {
+	return []string{"member", "member-" + usr.ID()}
+}
+
+func generateRSAKey() *rsa.PrivateKey {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+
+	return privateKey
+}
+
 type fixture struct {
-	ctrl     *gomock.Controller
-	userRepo *repository.MockRepositoryInterface
-	svr      *Server
+	ctrl                *gomock.Controller
+	userRepo            *repository.MockRepositoryInterface
+	refreshTokenRepo    *repository.MockRefreshTokenRepositoryInterface
+	tokenRevocationRepo *repository.MockTokenRevocationRepositoryInterface
+	otpRepo             *repository.MockOTPRepositoryInterface
+	loginFailureRepo    *repository.MockLoginFailureRepositoryInterface
+	passwordHistoryRepo *repository.MockPasswordHistoryRepositoryInterface
+	totpRepo            *repository.MockTOTPRepositoryInterface
+	mfaChallengeRepo    *repository.MockMFAChallengeRepositoryInterface
+	passkeyRepo         *repository.MockPasskeyRepositoryInterface
+	webAuthnSessionRepo *repository.MockWebAuthnSessionRepositoryInterface
+	smsLog              *bytes.Buffer
+	svr                 *Server
 }
 
 func setup(t *testing.T) *fixture {
 	ctrl := gomock.NewController(t)
 	userRepo := repository.NewMockRepositoryInterface(ctrl)
-	svr := NewServer(NewServerOptions{
-		Repository: userRepo,
+	refreshTokenRepo := repository.NewMockRefreshTokenRepositoryInterface(ctrl)
+	tokenRevocationRepo := repository.NewMockTokenRevocationRepositoryInterface(ctrl)
+	otpRepo := repository.NewMockOTPRepositoryInterface(ctrl)
+	loginFailureRepo := repository.NewMockLoginFailureRepositoryInterface(ctrl)
+	passwordHistoryRepo := repository.NewMockPasswordHistoryRepositoryInterface(ctrl)
+	totpRepo := repository.NewMockTOTPRepositoryInterface(ctrl)
+	mfaChallengeRepo := repository.NewMockMFAChallengeRepositoryInterface(ctrl)
+	passkeyRepo := repository.NewMockPasskeyRepositoryInterface(ctrl)
+	webAuthnSessionRepo := repository.NewMockWebAuthnSessionRepositoryInterface(ctrl)
+	smsLog := new(bytes.Buffer)
+	svr, err := NewServer(NewServerOptions{
+		Repository:                userRepo,
+		RefreshTokenRepository:    refreshTokenRepo,
+		TokenRevocationRepository: tokenRevocationRepo,
+		OTPRepository:             otpRepo,
+		LoginFailureRepository:    loginFailureRepo,
+		PasswordHistoryRepository: passwordHistoryRepo,
+		TOTPRepository:            totpRepo,
+		MFAChallengeRepository:    mfaChallengeRepo,
+		PasskeyRepository:         passkeyRepo,
+		WebAuthnSessionRepository: webAuthnSessionRepo,
+		AdminKey:                  testAdminKey,
+		OAuthClients:              map[string]string{testOAuthClientID: testOAuthClientSecret},
+		SMSSender:                 &testSMSSender{logger: log.New(smsLog, "", 0)},
+		KeySource:                 testKeySource,
+		TokenRoles:                testTokenRoles,
 	})
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	return &fixture{
-		ctrl:     ctrl,
-		userRepo: userRepo,
-		svr:      svr,
+		ctrl:                ctrl,
+		userRepo:            userRepo,
+		refreshTokenRepo:    refreshTokenRepo,
+		tokenRevocationRepo: tokenRevocationRepo,
+		otpRepo:             otpRepo,
+		loginFailureRepo:    loginFailureRepo,
+		passwordHistoryRepo: passwordHistoryRepo,
+		totpRepo:            totpRepo,
+		mfaChallengeRepo:    mfaChallengeRepo,
+		passkeyRepo:         passkeyRepo,
+		webAuthnSessionRepo: webAuthnSessionRepo,
+		smsLog:              smsLog,
+		svr:                 svr,
 	}
 }
 
@@ -41,9 +155,57 @@ func (f *fixture) tearDown() {
 	f.ctrl.Finish()
 }
 
+// testSMSSender logs the messages with their text, unlike sms.LogSender.
+type testSMSSender struct {
+	logger *log.Logger
+}
+
+func (s *testSMSSender) Send(phoneNumber, message string) error {
+	s.logger.Printf("sms to=%s message=%q", phoneNumber, message)
+	return nil
+}
+
+var smsCodePattern = regexp.MustCompile(`sms to=(\S+) message=".*?(\d{6})`)
+
+// sentCode returns the last one-time password sent to the phone number.
+func (f *fixture) sentCode(phoneNumber string) string {
+	var code string
+	for _, m := range smsCodePattern.FindAllStringSubmatch(f.smsLog.String(), -1) {
+		if m[1] == phoneNumber {
+			code = m[2]
+		}
+	}
+
+	return code
+}
+
 func TestRegisterUser(t *testing.T) {
+	breachedPassword := "Password1!"
+	breachedHash := sha1.Sum([]byte(breachedPassword))
+	breachedFile := filepath.Join(t.TempDir(), "breached.txt")
+	err := os.WriteFile(breachedFile, []byte(strings.ToUpper(hex.EncodeToString(breachedHash[:]))+":52579\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	breachedSource, err := pwned.LoadFile(breachedFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	strictPolicy := user.DefaultPasswordPolicy()
+	strictPolicy.BreachedSource = breachedSource
+	strictPolicy.MinScore = 3
+
+	passphrasePolicy := user.PasswordPolicy{
+		MinLength:        12,
+		MaxLength:        128,
+		RequireLowercase: true,
+	}
+
 	testCases := map[string]struct {
 		regForm             generated.UserRegistrationForm
+		passwordPolicy      *user.PasswordPolicy
 		expectStatusCode    int
 		expectContainsError map[string][]string
 	}{
@@ -99,6 +261,82 @@ func TestRegisterUser(t *testing.T) {
 				"password": {"PASSWORD_STRENGTH"},
 			},
 		},
+		"password containing phone number": {
+			regForm: generated.UserRegistrationForm{
+				PhoneNumber: "+628174546647",
+				FullName:    "John Doe",
+				Password:    "Pw8174546647!",
+			},
+			expectStatusCode: http.StatusBadRequest,
+			expectContainsError: map[string][]string{
+				"password": {"PASSWORD_CONTAINS_PERSONAL_INFO"},
+			},
+		},
+		"password containing full name": {
+			regForm: generated.UserRegistrationForm{
+				PhoneNumber: "+628174546647",
+				FullName:    "John Doe",
+				Password:    "JohnDoe123!",
+			},
+			expectStatusCode: http.StatusBadRequest,
+			expectContainsError: map[string][]string{
+				"password": {"PASSWORD_CONTAINS_PERSONAL_INFO"},
+			},
+		},
+		"breached password": {
+			regForm: generated.UserRegistrationForm{
+				PhoneNumber: "+628174546647",
+				FullName:    "John Doe",
+				Password:    breachedPassword,
+			},
+			passwordPolicy:   &strictPolicy,
+			expectStatusCode: http.StatusBadRequest,
+			expectContainsError: map[string][]string{
+				"password": {"PASSWORD_BREACHED", "PASSWORD_LOW_ENTROPY"},
+			},
+		},
+		"low entropy password": {
+			regForm: generated.UserRegistrationForm{
+				PhoneNumber: "+628174546647",
+				FullName:    "John Doe",
+				Password:    "Qwerty123!",
+			},
+			passwordPolicy:   &strictPolicy,
+			expectStatusCode: http.StatusBadRequest,
+			expectContainsError: map[string][]string{
+				"password": {"PASSWORD_LOW_ENTROPY"},
+			},
+		},
+		"success with passphrase policy": {
+			regForm: generated.UserRegistrationForm{
+				PhoneNumber: "+628174546647",
+				FullName:    "John Doe",
+				Password:    "correct horse battery staple",
+			},
+			passwordPolicy:   &passphrasePolicy,
+			expectStatusCode: http.StatusOK,
+		},
+		"too short for passphrase policy": {
+			regForm: generated.UserRegistrationForm{
+				PhoneNumber: "+628174546647",
+				FullName:    "John Doe",
+				Password:    "Secret123!",
+			},
+			passwordPolicy:   &passphrasePolicy,
+			expectStatusCode: http.StatusBadRequest,
+			expectContainsError: map[string][]string{
+				"password": {"PASSWORD_STRENGTH"},
+			},
+		},
+		"success with strict rules": {
+			regForm: generated.UserRegistrationForm{
+				PhoneNumber: "+628174546647",
+				FullName:    "John Doe",
+				Password:    "Kv9#tQ2!mZ7p",
+			},
+			passwordPolicy:   &strictPolicy,
+			expectStatusCode: http.StatusOK,
+		},
 	}
 
 	for name, tc := range testCases {
@@ -107,6 +345,13 @@ func TestRegisterUser(t *testing.T) {
 			fix := setup(t)
 			defer fix.tearDown()
 
+			if tc.passwordPolicy != nil {
+				if err := user.SetPasswordPolicy(*tc.passwordPolicy); err != nil {
+					t.Fatal(err)
+				}
+				defer user.SetPasswordPolicy(user.DefaultPasswordPolicy())
+			}
+
 			// When
 			var buf bytes.Buffer
 			if err := json.NewEncoder(&buf).Encode(tc.regForm); err != nil {
@@ -119,7 +364,7 @@ func TestRegisterUser(t *testing.T) {
 
 			var newlyStoredUser *user.User
 			if tc.expectStatusCode == http.StatusOK {
-				fix.userRepo.EXPECT().Store(gomock.Any()).DoAndReturn(func(u *user.User) (*user.User, error) {
+				fix.userRepo.EXPECT().Store(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, u *user.User) (*user.User, error) {
 					if u.PhoneNumber() != tc.regForm.PhoneNumber {
 						return nil, errors.New("phoneNumber is not equal")
 					}
@@ -132,6 +377,8 @@ func TestRegisterUser(t *testing.T) {
 
 					return u, nil
 				})
+				fix.otpRepo.EXPECT().GetOTP(gomock.Any(), otp.PurposePhoneVerification, tc.regForm.PhoneNumber).Return(nil, nil)
+				fix.otpRepo.EXPECT().StoreOTP(gomock.Any(), gomock.Any()).Return(nil)
 			}
 
 			c := echo.New().NewContext(req, rec)
@@ -155,6 +402,14 @@ func TestRegisterUser(t *testing.T) {
 				if got, want := res.Id, newlyStoredUser.ID(); got != want {
 					t.Fatalf("id got %s, want %s", got, want)
 				}
+
+				if newlyStoredUser.PhoneVerified() {
+					t.Fatal("phone number verified, want unverified")
+				}
+
+				if fix.sentCode(tc.regForm.PhoneNumber) == "" {
+					t.Fatal("phone verification code not sent")
+				}
 			}
 
 			if rec.Code == http.StatusBadRequest {
@@ -190,9 +445,28 @@ func TestLogin(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	sourceIP := "192.0.2.1"
+	newCounter := func(key string, failures int, lastFailureAt, lockedUntil time.Time) *lockout.Counter {
+		c, err := lockout.New(key, failures, lastFailureAt, lockedUntil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return c
+	}
+
+	now := time.Now()
+	userKey := lockout.UserKey(user1.ID())
+	ipKey := lockout.IPKey(sourceIP)
+	maxFailures := lockout.DefaultUserPolicy().MaxFailures
+
 	testCases := map[string]struct {
 		creds            generated.UserCredentials
 		returnedUser     *user.User
+		ipCounter        *lockout.Counter
+		userCounter      *lockout.Counter
+		forwardedFor     string
+		expectUserLock   bool
 		expectStatusCode int
 	}{
 		"success": {
@@ -203,6 +477,15 @@ func TestLogin(t *testing.T) {
 			returnedUser:     user1,
 			expectStatusCode: http.StatusOK,
 		},
+		"success after failures": {
+			creds: generated.UserCredentials{
+				PhoneNumber: user1.PhoneNumber(),
+				Password:    usrPassword,
+			},
+			returnedUser:     user1,
+			userCounter:      newCounter(userKey, 2, now.Add(-time.Minute), time.Time{}),
+			expectStatusCode: http.StatusOK,
+		},
 		"phone number not found": {
 			creds: generated.UserCredentials{
 				PhoneNumber: "+628174546648",
@@ -219,6 +502,51 @@ func TestLogin(t *testing.T) {
 			returnedUser:     user1,
 			expectStatusCode: http.StatusBadRequest,
 		},
+		"invalid password reaching the limit": {
+			creds: generated.UserCredentials{
+				PhoneNumber: user1.PhoneNumber(),
+				Password:    usrPassword + "x",
+			},
+			returnedUser:     user1,
+			userCounter:      newCounter(userKey, maxFailures-1, now.Add(-time.Hour), time.Time{}),
+			expectUserLock:   true,
+			expectStatusCode: http.StatusBadRequest,
+		},
+		"locked user": {
+			creds: generated.UserCredentials{
+				PhoneNumber: user1.PhoneNumber(),
+				Password:    usrPassword,
+			},
+			returnedUser:     user1,
+			userCounter:      newCounter(userKey, maxFailures, now, now.Add(time.Minute)),
+			expectStatusCode: http.StatusBadRequest,
+		},
+		"user in back-off": {
+			creds: generated.UserCredentials{
+				PhoneNumber: user1.PhoneNumber(),
+				Password:    usrPassword,
+			},
+			returnedUser:     user1,
+			userCounter:      newCounter(userKey, 3, now, time.Time{}),
+			expectStatusCode: http.StatusBadRequest,
+		},
+		"locked source ip": {
+			creds: generated.UserCredentials{
+				PhoneNumber: user1.PhoneNumber(),
+				Password:    usrPassword,
+			},
+			ipCounter:        newCounter(ipKey, 100, now, now.Add(time.Minute)),
+			expectStatusCode: http.StatusBadRequest,
+		},
+		"locked source ip spoofing forwarded headers": {
+			creds: generated.UserCredentials{
+				PhoneNumber: user1.PhoneNumber(),
+				Password:    usrPassword,
+			},
+			ipCounter:        newCounter(ipKey, 100, now, now.Add(time.Minute)),
+			forwardedFor:     "198.51.100.7",
+			expectStatusCode: http.StatusBadRequest,
+		},
 	}
 
 	for name, tc := range testCases {
@@ -235,11 +563,72 @@ func TestLogin(t *testing.T) {
 
 			req := httptest.NewRequest(http.MethodPost, "/login", bytes.NewReader(buf.Bytes()))
 			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			req.RemoteAddr = sourceIP + ":54321"
+			if tc.forwardedFor != "" {
+				req.Header.Set(echo.HeaderXForwardedFor, tc.forwardedFor)
+				req.Header.Set(echo.HeaderXRealIP, tc.forwardedFor)
+			}
+
 			rec := httptest.NewRecorder()
 
-			fix.userRepo.EXPECT().GetByPhoneNumber(tc.creds.PhoneNumber).Return(tc.returnedUser, nil)
+			fix.loginFailureRepo.EXPECT().GetLoginFailures(gomock.Any(), ipKey).Return(tc.ipCounter, nil)
+			ipLocked := tc.ipCounter != nil
 
-			c := echo.New().NewContext(req, rec)
+			if !ipLocked {
+				fix.userRepo.EXPECT().GetByPhoneNumber(gomock.Any(), tc.creds.PhoneNumber).Return(tc.returnedUser, nil)
+			}
+
+			// Unknown phone numbers are counted under a key of their own
+			userKeyMatcher := gomock.Eq(userKey)
+			if tc.returnedUser == nil {
+				userKeyMatcher = gomock.All(gomock.Not(userKey), gomock.Not(ipKey))
+			}
+
+			if !ipLocked {
+				fix.loginFailureRepo.EXPECT().GetLoginFailures(gomock.Any(), userKeyMatcher).Return(tc.userCounter, nil)
+			}
+
+			userBlocked := tc.userCounter != nil && lockout.DefaultUserPolicy().Blocked(tc.userCounter, now)
+			passwordChecked := !ipLocked && !userBlocked
+			if passwordChecked && tc.expectStatusCode == http.StatusOK && tc.userCounter != nil {
+				fix.loginFailureRepo.EXPECT().ResetLoginFailures(gomock.Any(), userKey).Return(nil)
+			}
+
+			if passwordChecked && tc.expectStatusCode != http.StatusOK {
+				failures := 1
+				if tc.userCounter != nil {
+					failures = tc.userCounter.Failures() + 1
+				}
+
+				fix.loginFailureRepo.EXPECT().IncrementLoginFailures(gomock.Any(), userKeyMatcher, gomock.Any(), gomock.Any()).Return(newCounter(userKey, failures, now, time.Time{}), nil)
+			}
+
+			if passwordChecked && tc.expectStatusCode != http.StatusOK {
+				fix.loginFailureRepo.EXPECT().IncrementLoginFailures(gomock.Any(), ipKey, gomock.Any(), gomock.Any()).Return(newCounter(ipKey, 1, now, time.Time{}), nil)
+			}
+
+			if tc.expectUserLock {
+				fix.loginFailureRepo.EXPECT().LockLogin(gomock.Any(), userKey, gomock.Any()).Return(nil)
+			}
+
+			var storedRefreshToken *token.RefreshToken
+			if tc.expectStatusCode == http.StatusOK {
+				// Once more to clear the failures of the completed login
+				fix.loginFailureRepo.EXPECT().GetLoginFailures(gomock.Any(), userKey).Return(tc.userCounter, nil)
+				fix.totpRepo.EXPECT().GetTOTP(gomock.Any(), user1.ID()).Return(nil, nil)
+				fix.refreshTokenRepo.EXPECT().StoreRefreshToken(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, rt *token.RefreshToken) error {
+					storedRefreshToken = rt
+					return nil
+				})
+			}
+
+			e := echo.New()
+			e.IPExtractor, err = ClientIPExtractor(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			c := e.NewContext(req, rec)
 			err = fix.svr.Login(c)
 
 			// Then
@@ -267,39 +656,139 @@ func TestLogin(t *testing.T) {
 			if got := res.AccessToken; got == "" {
 				t.Fatalf("accessToken got %s, want not empty", got)
 			}
+
+			if got, want := storedRefreshToken.Hash(), token.Hash(res.RefreshToken); !bytes.Equal(got, want) {
+				t.Fatalf("refreshToken hash got %x, want %x", got, want)
+			}
+
+			if got, want := storedRefreshToken.UserID(), user1.ID(); got != want {
+				t.Fatalf("refreshToken userID got %s, want %s", got, want)
+			}
+
+			claims, err := (&TokenVerifier{KeyRing: testKeyRing}).Verify(res.AccessToken)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := claims.SessionID, storedRefreshToken.FamilyID(); got != want {
+				t.Fatalf("sid got %s, want %s", got, want)
+			}
+
+			if got, want := claims.AuthMethods, []string{"pwd"}; !slices.Equal(got, want) {
+				t.Fatalf("amr got %v, want %v", got, want)
+			}
+
+			if got, want := claims.Roles, testTokenRoles(user1); !slices.Equal(got, want) {
+				t.Fatalf("roles got %v, want %v", got, want)
+			}
 		})
 	}
 }
 
-func TestGetMyProfile(t *testing.T) {
-	user1, err := user.NewWithPassword("jdoe", "+628174546647", "John Doe", "Secret123!")
+func TestClientIPExtractor(t *testing.T) {
+	testCases := map[string]struct {
+		trustedProxies []string
+		remoteAddr     string
+		forwardedFor   string
+		expectErr      bool
+		expectIP       string
+	}{
+		"direct": {
+			remoteAddr: "192.0.2.1:54321",
+			expectIP:   "192.0.2.1",
+		},
+		"spoofed header without proxy": {
+			remoteAddr:   "192.0.2.1:54321",
+			forwardedFor: "198.51.100.7",
+			expectIP:     "192.0.2.1",
+		},
+		"trusted proxy": {
+			trustedProxies: []string{"10.0.0.0/8"},
+			remoteAddr:     "10.1.2.3:54321",
+			forwardedFor:   "198.51.100.7",
+			expectIP:       "198.51.100.7",
+		},
+		"spoofed header through trusted proxy": {
+			trustedProxies: []string{"10.0.0.0/8"},
+			remoteAddr:     "10.1.2.3:54321",
+			forwardedFor:   "203.0.113.9, 198.51.100.7",
+			expectIP:       "198.51.100.7",
+		},
+		"untrusted proxy": {
+			trustedProxies: []string{"10.0.0.0/8"},
+			remoteAddr:     "192.168.1.1:54321",
+			forwardedFor:   "198.51.100.7",
+			expectIP:       "192.168.1.1",
+		},
+		"invalid range": {
+			trustedProxies: []string{"10.0.0.0"},
+			expectErr:      true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			// Given
+			extractor, err := ClientIPExtractor(tc.trustedProxies)
+			if tc.expectErr {
+				if err == nil {
+					t.Fatal("err got nil, want not nil")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/login", nil)
+			req.RemoteAddr = tc.remoteAddr
+			if tc.forwardedFor != "" {
+				req.Header.Set(echo.HeaderXForwardedFor, tc.forwardedFor)
+				req.Header.Set(echo.HeaderXRealIP, "203.0.113.9")
+			}
+
+			// When
+			ip := extractor(req)
+
+			// Then
+			if ip != tc.expectIP {
+				t.Fatalf("ip got %s, want %s", ip, tc.expectIP)
+			}
+		})
+	}
+}
+
+func TestLoginUpgradesPasswordHash(t *testing.T) {
+	usrPassword := "Secret123!"
+	legacySalt := []byte("0123456789abcdef")
+	// The legacy key derived with this salt starts with '$' like PHC strings
+	dollarSalt := []byte("dollar-salt-0022")
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte(usrPassword), bcrypt.MinCost)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	testCases := map[string]struct {
-		user             *user.User
-		tokenFn          func(*user.User) (string, error)
-		invalidToken     bool
-		expectStatusCode int
+		passwordHash []byte
+		passwordSalt []byte
 	}{
-		"success": {
-			user: user1,
-			tokenFn: func(u *user.User) (string, error) {
-				tc := &TokenCreator{
-					PrivateKey: _privateKey,
-				}
-				return tc.CreateAccessToken(u)
-			},
-			expectStatusCode: http.StatusOK,
+		"legacy pbkdf2": {
+			passwordHash: pbkdf2.Key([]byte(usrPassword), legacySalt, 10000, 32, sha256.New),
+			passwordSalt: legacySalt,
 		},
-		"invalid token": {
-			user: user1,
-			tokenFn: func(u *user.User) (string, error) {
-				return "invalid token", nil
-			},
-			invalidToken:     true,
-			expectStatusCode: http.StatusForbidden,
+		"legacy pbkdf2 starting with $": {
+			passwordHash: pbkdf2.Key([]byte(usrPassword), dollarSalt, 10000, 32, sha256.New),
+			passwordSalt: dollarSalt,
+		},
+		"bcrypt": {
+			passwordHash: bcryptHash,
+		},
+		"pbkdf2 phc": {
+			passwordHash: []byte("$pbkdf2-sha256$i=1000,l=32$" +
+				base64.RawStdEncoding.EncodeToString(legacySalt) + "$" +
+				base64.RawStdEncoding.EncodeToString(pbkdf2.Key([]byte(usrPassword), legacySalt, 1000, 32, sha256.New))),
 		},
 	}
 
@@ -309,233 +798,5063 @@ func TestGetMyProfile(t *testing.T) {
 			fix := setup(t)
 			defer fix.tearDown()
 
-			// When
-			accessToken, err := tc.tokenFn(tc.user)
+			usr, err := user.New("jdoe", "+628174546647", "John Doe", tc.passwordHash, tc.passwordSalt, "", time.Time{}, "")
 			if err != nil {
 				t.Fatal(err)
 			}
 
-			req := httptest.NewRequest(http.MethodGet, "/users/me", nil)
-			req.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", accessToken))
+			// When
+			var buf bytes.Buffer
+			if err := json.NewEncoder(&buf).Encode(generated.UserCredentials{
+				PhoneNumber: usr.PhoneNumber(),
+				Password:    usrPassword,
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/login", bytes.NewReader(buf.Bytes()))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
 			rec := httptest.NewRecorder()
 
-			if !tc.invalidToken {
-				fix.userRepo.EXPECT().GetByID(tc.user.ID()).Return(tc.user, nil)
-			}
+			fix.loginFailureRepo.EXPECT().GetLoginFailures(gomock.Any(), gomock.Any()).Return(nil, nil).Times(3)
+			fix.userRepo.EXPECT().GetByPhoneNumber(gomock.Any(), usr.PhoneNumber()).Return(usr, nil)
+
+			var updatedHash, updatedSalt []byte
+			fix.userRepo.EXPECT().Update(gomock.Any(), usr).DoAndReturn(func(_ context.Context, u *user.User) error {
+				updatedHash, updatedSalt = u.Password()
+				return nil
+			})
+			fix.totpRepo.EXPECT().GetTOTP(gomock.Any(), usr.ID()).Return(nil, nil)
+			fix.refreshTokenRepo.EXPECT().StoreRefreshToken(gomock.Any(), gomock.Any()).Return(nil)
 
 			c := echo.New().NewContext(req, rec)
-			err = fix.svr.GetMyProfile(c)
+			err = fix.svr.Login(c)
 
 			// Then
 			if err != nil {
 				t.Fatal(err)
 			}
 
-			if got, want := rec.Code, tc.expectStatusCode; got != want {
+			if got, want := rec.Code, http.StatusOK; got != want {
 				t.Fatalf("statusCode got %d, want %d", got, want)
 			}
 
-			if rec.Code != http.StatusOK {
+			if got, want := string(updatedHash), "$argon2id$"; !strings.HasPrefix(got, want) {
+				t.Fatalf("passwordHash got %s, want prefix %s", got, want)
+			}
+
+			if got := updatedSalt; got != nil {
+				t.Fatalf("passwordSalt got %x, want nil", got)
+			}
+
+			if !usr.VerifyPassword(usrPassword) {
+				t.Fatal("upgraded password hash does not verify")
+			}
+
+			if usr.PasswordNeedsRehash() {
+				t.Fatal("upgraded password hash needs rehash")
+			}
+		})
+	}
+}
+
+func TestLoginRotatesPasswordPepper(t *testing.T) {
+	usrPassword := "Secret123!"
+	pepperEnv := "TEST_PASSWORD_PEPPER"
+	pepperV1 := "v1:" + base64.StdEncoding.EncodeToString([]byte("pepper one"))
+	pepperV2 := "v2:" + base64.StdEncoding.EncodeToString([]byte("pepper two"))
+
+	testCases := map[string]struct {
+		hashPepper     string
+		expectPepperID string
+		currentPeppers string
+	}{
+		"rotated pepper": {
+			hashPepper:     pepperV1,
+			currentPeppers: pepperV2 + "," + pepperV1,
+			expectPepperID: "v2",
+		},
+		"unpeppered hash": {
+			currentPeppers: pepperV1,
+			expectPepperID: "v1",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			// Given
+			fix := setup(t)
+			defer fix.tearDown()
+
+			if tc.hashPepper != "" {
+				t.Setenv(pepperEnv, tc.hashPepper)
+				user.SetPepperProvider(&pepper.EnvKeyProvider{Name: pepperEnv})
+			}
+			defer user.SetPepperProvider(nil)
+
+			usr, err := user.NewWithPassword("jdoe", "+628174546647", "John Doe", usrPassword)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			t.Setenv(pepperEnv, tc.currentPeppers)
+			user.SetPepperProvider(&pepper.EnvKeyProvider{Name: pepperEnv})
+
+			// When
+			var buf bytes.Buffer
+			if err := json.NewEncoder(&buf).Encode(generated.UserCredentials{
+				PhoneNumber: usr.PhoneNumber(),
+				Password:    usrPassword,
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/login", bytes.NewReader(buf.Bytes()))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+
+			fix.loginFailureRepo.EXPECT().GetLoginFailures(gomock.Any(), gomock.Any()).Return(nil, nil).Times(3)
+			fix.userRepo.EXPECT().GetByPhoneNumber(gomock.Any(), usr.PhoneNumber()).Return(usr, nil)
+			fix.userRepo.EXPECT().Update(gomock.Any(), usr).Return(nil)
+			fix.totpRepo.EXPECT().GetTOTP(gomock.Any(), usr.ID()).Return(nil, nil)
+			fix.refreshTokenRepo.EXPECT().StoreRefreshToken(gomock.Any(), gomock.Any()).Return(nil)
+
+			c := echo.New().NewContext(req, rec)
+			err = fix.svr.Login(c)
+
+			// Then
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := rec.Code, http.StatusOK; got != want {
+				t.Fatalf("statusCode got %d, want %d", got, want)
+			}
+
+			if got, want := usr.PasswordPepperID(), tc.expectPepperID; got != want {
+				t.Fatalf("passwordPepperID got %s, want %s", got, want)
+			}
+
+			if !usr.VerifyPassword(usrPassword) {
+				t.Fatal("repeppered password hash does not verify")
+			}
+		})
+	}
+}
+
+func TestLoginHashesDummyPassword(t *testing.T) {
+	usrPassword := "Secret123!"
+	user1, err := user.NewWithPassword("jdoe", "+628174546647", "John Doe", usrPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	userKey := lockout.UserKey(user1.ID())
+	maxFailures := lockout.DefaultUserPolicy().MaxFailures
+	lockedCounter, err := lockout.New(userKey, maxFailures, now, now.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := map[string]struct {
+		returnedUser *user.User
+		userCounter  *lockout.Counter
+		expectDummy  bool
+	}{
+		"unknown user": {
+			expectDummy: true,
+		},
+		"locked user": {
+			returnedUser: user1,
+			userCounter:  lockedCounter,
+			expectDummy:  true,
+		},
+		"invalid password": {
+			returnedUser: user1,
+			expectDummy:  false,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			// Given
+			fix := setup(t)
+			defer fix.tearDown()
+
+			var dummyPasswords []string
+			fix.svr.AuthService.VerifyDummyPassword = func(password string) {
+				dummyPasswords = append(dummyPasswords, password)
+			}
+
+			fix.loginFailureRepo.EXPECT().GetLoginFailures(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, key string) (*lockout.Counter, error) {
+				if key == userKey {
+					return tc.userCounter, nil
+				}
+
+				return nil, nil
+			}).AnyTimes()
+			fix.userRepo.EXPECT().GetByPhoneNumber(gomock.Any(), user1.PhoneNumber()).Return(tc.returnedUser, nil)
+			fix.loginFailureRepo.EXPECT().IncrementLoginFailures(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, key string, failedAt, resetBefore time.Time) (*lockout.Counter, error) {
+				return lockout.New(key, 1, failedAt, time.Time{})
+			}).AnyTimes()
+
+			// When
+			var buf bytes.Buffer
+			if err := json.NewEncoder(&buf).Encode(generated.UserCredentials{
+				PhoneNumber: user1.PhoneNumber(),
+				Password:    usrPassword + "x",
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/login", bytes.NewReader(buf.Bytes()))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+
+			c := echo.New().NewContext(req, rec)
+			err := fix.svr.Login(c)
+
+			// Then
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := rec.Code, http.StatusBadRequest; got != want {
+				t.Fatalf("statusCode got %d, want %d", got, want)
+			}
+
+			var expectDummyPasswords []string
+			if tc.expectDummy {
+				expectDummyPasswords = []string{usrPassword + "x"}
+			}
+
+			if got, want := dummyPasswords, expectDummyPasswords; !slices.Equal(got, want) {
+				t.Fatalf("dummy passwords got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+// newStorageServer returns a server keeping everything in the storage rather
+// than in mocks, for the tests going through several requests.
+func newStorageServer(t *testing.T, repo repository.Storage) *Server {
+	svr, err := NewServer(NewServerOptions{
+		Repository:                repo,
+		RefreshTokenRepository:    repo,
+		TokenRevocationRepository: repo,
+		OTPRepository:             repo,
+		LoginFailureRepository:    repo,
+		PasswordHistoryRepository: repo,
+		TOTPRepository:            repo,
+		MFAChallengeRepository:    repo,
+		PasskeyRepository:         repo,
+		WebAuthnSessionRepository: repo,
+		AdminKey:                  testAdminKey,
+		SMSSender:                 &testSMSSender{logger: log.New(io.Discard, "", 0)},
+		KeySource:                 testKeySource,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return svr
+}
+
+// slowRepository adds the latency of a database round trip to the user and
+// login failure queries of the memory repository.
+type slowRepository struct {
+	*repository.MemoryRepository
+	latency time.Duration
+}
+
+func (r *slowRepository) GetByPhoneNumber(ctx context.Context, phoneNumber string) (*user.User, error) {
+	time.Sleep(r.latency)
+	return r.MemoryRepository.GetByPhoneNumber(ctx, phoneNumber)
+}
+
+func (r *slowRepository) GetLoginFailures(ctx context.Context, key string) (*lockout.Counter, error) {
+	time.Sleep(r.latency)
+	return r.MemoryRepository.GetLoginFailures(ctx, key)
+}
+
+func (r *slowRepository) IncrementLoginFailures(ctx context.Context, key string, failedAt, resetBefore time.Time) (*lockout.Counter, error) {
+	time.Sleep(r.latency)
+	return r.MemoryRepository.IncrementLoginFailures(ctx, key, failedAt, resetBefore)
+}
+
+func (r *slowRepository) LockLogin(ctx context.Context, key string, until time.Time) error {
+	time.Sleep(r.latency)
+	return r.MemoryRepository.LockLogin(ctx, key, until)
+}
+
+func (r *slowRepository) ResetLoginFailures(ctx context.Context, key string) error {
+	time.Sleep(r.latency)
+	return r.MemoryRepository.ResetLoginFailures(ctx, key)
+}
+
+// TestLoginTiming checks the response time of a login does not tell whether
+// the phone number is registered, the repository answering as slowly as a
+// database. The median latencies of both cases are compared, interleaving the
+// samples so noise affects them equally.
+func TestLoginTiming(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping timing test in short mode")
+	}
+
+	// Given
+	ctx := context.Background()
+	repo := &slowRepository{MemoryRepository: repository.NewMemoryRepository(), latency: 20 * time.Millisecond}
+	svr := newStorageServer(t, repo)
+
+	// Back-off and lockouts would skip the password check of later samples
+	svr.AuthService.UserLockoutPolicy = lockout.Policy{}
+	svr.AuthService.IPLockoutPolicy = lockout.Policy{}
+
+	usrPassword := "Secret123!"
+	user1, err := user.NewWithPassword("jdoe", "+628174546647", "John Doe", usrPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.Store(ctx, user1); err != nil {
+		t.Fatal(err)
+	}
+
+	unknownPhoneNumber := "+628174546648"
+	login := func(phoneNumber string) time.Duration {
+		var buf bytes.Buffer
+		if err := json.NewEncoder(&buf).Encode(generated.UserCredentials{
+			PhoneNumber: phoneNumber,
+			Password:    usrPassword + "x",
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		req := httptest.NewRequest(http.MethodPost, "/login", bytes.NewReader(buf.Bytes()))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+
+		start := time.Now()
+		if err := svr.Login(c); err != nil {
+			t.Fatal(err)
+		}
+		elapsed := time.Since(start)
+
+		if got, want := rec.Code, http.StatusBadRequest; got != want {
+			t.Fatalf("statusCode got %d, want %d", got, want)
+		}
+
+		return elapsed
+	}
+
+	// When
+	const samples = 15
+	var existing, unknown []time.Duration
+	for i := 0; i < samples; i++ {
+		existing = append(existing, login(user1.PhoneNumber()))
+		unknown = append(unknown, login(unknownPhoneNumber))
+	}
+
+	// Then
+	median := func(ds []time.Duration) time.Duration {
+		slices.Sort(ds)
+		return ds[len(ds)/2]
+	}
+
+	existingMedian, unknownMedian := median(existing), median(unknown)
+	if ratio := float64(unknownMedian) / float64(existingMedian); ratio < 0.8 || ratio > 1.25 {
+		t.Fatalf("median latency got existing %v, unknown %v, want them indistinguishable", existingMedian, unknownMedian)
+	}
+}
+
+func TestRefreshToken(t *testing.T) {
+	user1, err := user.NewWithPassword(user.NextID(), "+628174546647", "John Doe", "Secret123!")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	refreshTokenValue := "refresh-token"
+	newRefreshToken := func(issuedAt, usedAt, revokedAt time.Time) *token.RefreshToken {
+		rt, err := token.New(token.NextID(), token.NextID(), user1.ID(), token.Hash(refreshTokenValue), issuedAt, issuedAt.Add(time.Hour), usedAt, revokedAt)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return rt
+	}
+
+	testCases := map[string]struct {
+		storedToken      *token.RefreshToken
+		expectMarkUsed   bool
+		alreadyUsed      bool
+		expectRevoke     bool
+		expectStatusCode int
+	}{
+		"success": {
+			storedToken:      newRefreshToken(now, time.Time{}, time.Time{}),
+			expectMarkUsed:   true,
+			expectStatusCode: http.StatusOK,
+		},
+		"not found": {
+			storedToken:      nil,
+			expectStatusCode: http.StatusBadRequest,
+		},
+		"expired": {
+			storedToken:      newRefreshToken(now.Add(-2*time.Hour), time.Time{}, time.Time{}),
+			expectStatusCode: http.StatusBadRequest,
+		},
+		"revoked": {
+			storedToken:      newRefreshToken(now, time.Time{}, now),
+			expectStatusCode: http.StatusBadRequest,
+		},
+		"reused": {
+			storedToken:      newRefreshToken(now, now, time.Time{}),
+			expectRevoke:     true,
+			expectStatusCode: http.StatusBadRequest,
+		},
+		"concurrently used": {
+			storedToken:      newRefreshToken(now, time.Time{}, time.Time{}),
+			expectMarkUsed:   true,
+			alreadyUsed:      true,
+			expectRevoke:     true,
+			expectStatusCode: http.StatusBadRequest,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			// Given
+			fix := setup(t)
+			defer fix.tearDown()
+
+			// When
+			var buf bytes.Buffer
+			if err := json.NewEncoder(&buf).Encode(generated.RefreshTokenForm{RefreshToken: refreshTokenValue}); err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/users/token/refresh", bytes.NewReader(buf.Bytes()))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+
+			fix.refreshTokenRepo.EXPECT().GetRefreshTokenByHash(gomock.Any(), token.Hash(refreshTokenValue)).Return(tc.storedToken, nil)
+
+			if tc.expectMarkUsed {
+				fix.refreshTokenRepo.EXPECT().MarkRefreshTokenUsed(gomock.Any(), tc.storedToken.ID(), gomock.Any()).Return(!tc.alreadyUsed, nil)
+			}
+
+			if tc.expectRevoke {
+				fix.refreshTokenRepo.EXPECT().RevokeRefreshTokenFamily(gomock.Any(), tc.storedToken.FamilyID(), gomock.Any()).Return(nil)
+			}
+
+			var rotatedToken *token.RefreshToken
+			if tc.expectStatusCode == http.StatusOK {
+				fix.userRepo.EXPECT().GetByID(gomock.Any(), user1.ID()).Return(user1, nil)
+				fix.refreshTokenRepo.EXPECT().StoreRefreshToken(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, rt *token.RefreshToken) error {
+					rotatedToken = rt
+					return nil
+				})
+			}
+
+			c := echo.New().NewContext(req, rec)
+			err = fix.svr.RefreshToken(c)
+
+			// Then
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := rec.Code, tc.expectStatusCode; got != want {
+				t.Fatalf("statusCode got %d, want %d", got, want)
+			}
+
+			if rec.Code != http.StatusOK {
+				return
+			}
+
+			var res generated.LoginResponse
+			if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := res.Id, user1.ID(); got != want {
+				t.Fatalf("id got %s, want %s", got, want)
+			}
+
+			if got := res.AccessToken; got == "" {
+				t.Fatalf("accessToken got %s, want not empty", got)
+			}
+
+			if got := res.RefreshToken; got == refreshTokenValue {
+				t.Fatalf("refreshToken got %s, want rotated", got)
+			}
+
+			if got, want := rotatedToken.FamilyID(), tc.storedToken.FamilyID(); got != want {
+				t.Fatalf("familyID got %s, want %s", got, want)
+			}
+
+			if got, want := rotatedToken.Hash(), token.Hash(res.RefreshToken); !bytes.Equal(got, want) {
+				t.Fatalf("refreshToken hash got %x, want %x", got, want)
+			}
+
+			claims, err := (&TokenVerifier{KeyRing: testKeyRing}).Verify(res.AccessToken)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := claims.SessionID, tc.storedToken.FamilyID(); got != want {
+				t.Fatalf("sid got %s, want %s", got, want)
+			}
+
+			if got := claims.AuthMethods; len(got) != 0 {
+				t.Fatalf("amr got %v, want none", got)
+			}
+
+			if got, want := claims.Roles, testTokenRoles(user1); !slices.Equal(got, want) {
+				t.Fatalf("roles got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestGetMyProfile(t *testing.T) {
+	user1, err := user.NewWithPassword("jdoe", "+628174546647", "John Doe", "Secret123!")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := map[string]struct {
+		user             *user.User
+		tokenFn          func(*user.User) (string, error)
+		invalidToken     bool
+		revokedToken     bool
+		expectStatusCode int
+	}{
+		"success": {
+			user: user1,
+			tokenFn: func(u *user.User) (string, error) {
+				tc := &TokenCreator{
+					KeyRing: testKeyRing,
+				}
+				return tc.CreateAccessToken(u)
+			},
+			expectStatusCode: http.StatusOK,
+		},
+		"revoked token": {
+			user: user1,
+			tokenFn: func(u *user.User) (string, error) {
+				tc := &TokenCreator{
+					KeyRing: testKeyRing,
+				}
+				return tc.CreateAccessToken(u)
+			},
+			revokedToken:     true,
+			expectStatusCode: http.StatusForbidden,
+		},
+		"invalid token": {
+			user: user1,
+			tokenFn: func(u *user.User) (string, error) {
+				return "invalid token", nil
+			},
+			invalidToken:     true,
+			expectStatusCode: http.StatusForbidden,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			// Given
+			fix := setup(t)
+			defer fix.tearDown()
+
+			// When
+			accessToken, err := tc.tokenFn(tc.user)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/users/me", nil)
+			req.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", accessToken))
+			rec := httptest.NewRecorder()
+
+			if !tc.invalidToken {
+				fix.tokenRevocationRepo.EXPECT().AccessTokenRevoked(gomock.Any(), gomock.Any(), tc.user.ID(), gomock.Any()).Return(tc.revokedToken, nil)
+			}
+
+			if !tc.invalidToken && !tc.revokedToken {
+				fix.userRepo.EXPECT().GetByID(gomock.Any(), tc.user.ID()).Return(tc.user, nil)
+			}
+
+			c := echo.New().NewContext(req, rec)
+			err = fix.svr.GetMyProfile(c)
+
+			// Then
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := rec.Code, tc.expectStatusCode; got != want {
+				t.Fatalf("statusCode got %d, want %d", got, want)
+			}
+
+			if rec.Code != http.StatusOK {
+				return
+			}
+
+			var res generated.UserProfile
+			if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := res.Name, tc.user.FullName(); got != want {
+				t.Fatalf("name got %s, want %s", got, want)
+			}
+
+			if got, want := res.PhoneNumber, tc.user.PhoneNumber(); got != want {
+				t.Fatalf("phoneNumber got %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+// rotatedKeys returns a retired key, whose tokens all expired, the key it was
+// replaced with, the current key and one scheduled next.
+func rotatedKeys(now time.Time) []*keyring.Key {
+	var keys []*keyring.Key
+	for i, activeFrom := range []time.Time{
+		now.Add(-3 * time.Hour),
+		now.Add(-2 * time.Hour),
+		now.Add(-30 * time.Minute),
+		now.Add(time.Hour),
+	} {
+		keys = append(keys, &keyring.Key{
+			ID:         fmt.Sprintf("key-%d", i+1),
+			PrivateKey: generateRSAKey(),
+			ActiveFrom: activeFrom,
+		})
+	}
+
+	return keys
+}
+
+// encryptPKCS8 encrypts the key as `openssl pkcs8 -topk8 -v2 aes-256-cbc
+// -v2prf hmacWithSHA256` does.
+func encryptPKCS8(t *testing.T, privateKey *rsa.PrivateKey, passphrase string) []byte {
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	salt := make([]byte, 16)
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(salt); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := rand.Read(iv); err != nil {
+		t.Fatal(err)
+	}
+
+	iterations := 2048
+	key := pbkdf2.Key([]byte(passphrase), salt, iterations, 32, sha256.New)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n := aes.BlockSize - len(der)%aes.BlockSize
+	plain := append(der, bytes.Repeat([]byte{byte(n)}, n)...)
+	encrypted := make([]byte, len(plain))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, plain)
+
+	type algorithm struct {
+		Algorithm  asn1.ObjectIdentifier
+		Parameters asn1.RawValue
+	}
+
+	mustMarshal := func(v interface{}) asn1.RawValue {
+		b, err := asn1.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return asn1.RawValue{FullBytes: b}
+	}
+
+	kdf := algorithm{
+		Algorithm: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12},
+		Parameters: mustMarshal(struct {
+			Salt       []byte
+			Iterations int
+			PRF        algorithm
+		}{salt, iterations, algorithm{asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}, asn1.NullRawValue}}),
+	}
+	scheme := algorithm{
+		Algorithm:  asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42},
+		Parameters: mustMarshal(iv),
+	}
+	info := struct {
+		Algorithm     algorithm
+		EncryptedData []byte
+	}{
+		Algorithm: algorithm{
+			Algorithm: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13},
+			Parameters: mustMarshal(struct {
+				KDF    algorithm
+				Scheme algorithm
+			}{kdf, scheme}),
+		},
+		EncryptedData: encrypted,
+	}
+
+	b, err := asn1.Marshal(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: b})
+}
+
+func TestNewServerSigningKeys(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	key1 := generateRSAKey()
+	key2 := generateRSAKey()
+	thumbprint1, err := keyring.Thumbprint(&key1.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecDER, err := x509.MarshalECPrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p384Key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pkcs8PEM := func(privateKey crypto.Signer, headers map[string]string) []byte {
+		der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Headers: headers, Bytes: der})
+	}
+
+	pkcs1PEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key1)})
+	smallKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeFile := func(dir, name string, data []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		return path
+	}
+
+	testCases := map[string]struct {
+		source           func(t *testing.T) keyring.Source
+		algorithm        keyring.Algorithm
+		expectErr        string
+		expectSigningKey string
+		expectAlgorithm  keyring.Algorithm
+		expectPublished  []string
+	}{
+		"PKCS#1 file": {
+			source: func(t *testing.T) keyring.Source {
+				return &keyring.FileSource{Path: writeFile(t.TempDir(), "key.pem", pkcs1PEM)}
+			},
+			expectSigningKey: thumbprint1,
+		},
+		"PKCS#8 environment variable": {
+			source: func(t *testing.T) keyring.Source {
+				t.Setenv("TEST_TOKEN_SIGNING_KEY", string(pkcs8PEM(key1, map[string]string{"Key-ID": "env-key"})))
+				return &keyring.EnvSource{Name: "TEST_TOKEN_SIGNING_KEY"}
+			},
+			expectSigningKey: "env-key",
+		},
+		"secret directory with rotation": {
+			source: func(t *testing.T) keyring.Source {
+				dir := t.TempDir()
+				writeFile(dir, "old.pem", pkcs8PEM(key1, map[string]string{"Active-From": now.Add(-2 * time.Hour).Format(time.RFC3339)}))
+				writeFile(dir, "current.pem", pkcs8PEM(key2, map[string]string{"Active-From": now.Add(-time.Minute).Format(time.RFC3339)}))
+				writeFile(dir, "README", []byte("not a key"))
+				return &keyring.DirSource{Path: dir}
+			},
+			expectSigningKey: "current",
+			expectPublished:  []string{"old", "current"},
+		},
+		"bundle with scheduled key": {
+			source: func(t *testing.T) keyring.Source {
+				bundle := append(pkcs8PEM(key1, map[string]string{"Key-ID": "a"}), pkcs8PEM(key2, map[string]string{"Key-ID": "b", "Active-From": now.Add(time.Hour).Format(time.RFC3339)})...)
+				return &keyring.FileSource{Path: writeFile(t.TempDir(), "keys.pem", bundle)}
+			},
+			expectSigningKey: "a",
+			expectPublished:  []string{"a", "b"},
+		},
+		"encrypted PKCS#8": {
+			source: func(t *testing.T) keyring.Source {
+				return &keyring.FileSource{Path: writeFile(t.TempDir(), "key.pem", encryptPKCS8(t, key1, "s3cret")), Passphrase: []byte("s3cret")}
+			},
+			expectSigningKey: thumbprint1,
+		},
+		"SEC 1 EC key": {
+			source: func(t *testing.T) keyring.Source {
+				return &keyring.FileSource{Path: writeFile(t.TempDir(), "key.pem", pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Headers: map[string]string{"Key-ID": "ec"}, Bytes: ecDER}))}
+			},
+			expectSigningKey: "ec",
+			expectAlgorithm:  keyring.ES256,
+		},
+		"Ed25519 key": {
+			source: func(t *testing.T) keyring.Source {
+				return &keyring.FileSource{Path: writeFile(t.TempDir(), "key.pem", pkcs8PEM(edKey, map[string]string{"Key-ID": "ed"}))}
+			},
+			expectSigningKey: "ed",
+			expectAlgorithm:  keyring.EdDSA,
+		},
+		"configured algorithm": {
+			source: func(t *testing.T) keyring.Source {
+				return &keyring.FileSource{Path: writeFile(t.TempDir(), "key.pem", pkcs8PEM(key1, map[string]string{"Key-ID": "rsa"}))}
+			},
+			algorithm:        keyring.PS256,
+			expectSigningKey: "rsa",
+			expectAlgorithm:  keyring.PS256,
+		},
+		"algorithm header": {
+			source: func(t *testing.T) keyring.Source {
+				return &keyring.FileSource{Path: writeFile(t.TempDir(), "key.pem", pkcs8PEM(key1, map[string]string{"Key-ID": "rsa", "Algorithm": "PS256"}))}
+			},
+			algorithm:        keyring.RS256,
+			expectSigningKey: "rsa",
+			expectAlgorithm:  keyring.PS256,
+		},
+		"algorithm not matching the key": {
+			source: func(t *testing.T) keyring.Source {
+				return &keyring.FileSource{Path: writeFile(t.TempDir(), "key.pem", pkcs8PEM(key1, nil))}
+			},
+			algorithm: keyring.ES256,
+			expectErr: "RSA key can not sign with ES256",
+		},
+		"unsupported algorithm header": {
+			source: func(t *testing.T) keyring.Source {
+				return &keyring.FileSource{Path: writeFile(t.TempDir(), "key.pem", pkcs8PEM(key1, map[string]string{"Algorithm": "HS256"}))}
+			},
+			expectErr: `unsupported algorithm "HS256"`,
+		},
+		"P-384 key": {
+			source: func(t *testing.T) keyring.Source {
+				return &keyring.FileSource{Path: writeFile(t.TempDir(), "key.pem", pkcs8PEM(p384Key, nil))}
+			},
+			expectErr: "ES256 needs P-256",
+		},
+		"encrypted PKCS#8 wrong passphrase": {
+			source: func(t *testing.T) keyring.Source {
+				return &keyring.FileSource{Path: writeFile(t.TempDir(), "key.pem", encryptPKCS8(t, key1, "s3cret")), Passphrase: []byte("guess")}
+			},
+			expectErr: "wrong passphrase",
+		},
+		"encrypted PKCS#8 without passphrase": {
+			source: func(t *testing.T) keyring.Source {
+				return &keyring.FileSource{Path: writeFile(t.TempDir(), "key.pem", encryptPKCS8(t, key1, "s3cret"))}
+			},
+			expectErr: "without passphrase",
+		},
+		"missing file": {
+			source: func(t *testing.T) keyring.Source {
+				return &keyring.FileSource{Path: filepath.Join(t.TempDir(), "key.pem")}
+			},
+			expectErr: "no such file",
+		},
+		"empty directory": {
+			source: func(t *testing.T) keyring.Source {
+				return &keyring.DirSource{Path: t.TempDir()}
+			},
+			expectErr: "no .pem file",
+		},
+		"public key": {
+			source: func(t *testing.T) keyring.Source {
+				der, err := x509.MarshalPKIXPublicKey(&key1.PublicKey)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				return &keyring.FileSource{Path: writeFile(t.TempDir(), "key.pem", pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))}
+			},
+			expectErr: "want a private key",
+		},
+		"small key": {
+			source: func(t *testing.T) keyring.Source {
+				return &keyring.FileSource{Path: writeFile(t.TempDir(), "key.pem", pkcs8PEM(smallKey, nil))}
+			},
+			expectErr: "want at least 2048",
+		},
+		"same activation time": {
+			source: func(t *testing.T) keyring.Source {
+				bundle := append(pkcs8PEM(key1, nil), pkcs8PEM(key2, nil)...)
+				return &keyring.FileSource{Path: writeFile(t.TempDir(), "keys.pem", bundle)}
+			},
+			expectErr: "same activation time",
+		},
+		"no source": {
+			source: func(t *testing.T) keyring.Source {
+				return nil
+			},
+			expectErr: "no token signing key source",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			// Given
+			opts := NewServerOptions{SMSSender: &sms.LogSender{}, SigningAlgorithm: tc.algorithm}
+			if src := tc.source(t); src != nil {
+				opts.KeySource = src
+			}
+
+			// When
+			svr, err := NewServer(opts)
+
+			// Then
+			if tc.expectErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.expectErr) {
+					t.Fatalf("err got %v, want containing %q", err, tc.expectErr)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			key, err := svr.KeyRing.SigningKey(time.Now())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := key.ID, tc.expectSigningKey; got != want {
+				t.Fatalf("signing key got %s, want %s", got, want)
+			}
+
+			expectAlgorithm := tc.expectAlgorithm
+			if expectAlgorithm == "" {
+				expectAlgorithm = keyring.RS256
+			}
+
+			if got, want := key.Algorithm, expectAlgorithm; got != want {
+				t.Fatalf("algorithm got %s, want %s", got, want)
+			}
+
+			if tc.expectPublished == nil {
+				return
+			}
+
+			var published []string
+			for _, k := range svr.KeyRing.PublishedKeys(time.Now()) {
+				published = append(published, k.ID)
+			}
+
+			if got, want := published, tc.expectPublished; !slices.Equal(got, want) {
+				t.Fatalf("published keys got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestNewServerWithoutSMSSender(t *testing.T) {
+	// When
+	_, err := NewServer(NewServerOptions{KeySource: testKeySource})
+
+	// Then
+	if err == nil || !strings.Contains(err.Error(), "no SMS sender") {
+		t.Fatalf("err got %v, want no SMS sender", err)
+	}
+}
+
+func TestGetJWKS(t *testing.T) {
+	// Given
+	fix := setup(t)
+	defer fix.tearDown()
+
+	keys := rotatedKeys(time.Now())
+	ring, err := keyring.New(keys, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fix.svr.KeyRing = ring
+
+	// When
+	req := httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil)
+	rec := httptest.NewRecorder()
+
+	c := echo.New().NewContext(req, rec)
+	err = fix.svr.GetJWKS(c)
+
+	// Then
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := rec.Code, http.StatusOK; got != want {
+		t.Fatalf("statusCode got %d, want %d", got, want)
+	}
+
+	var res generated.JSONWebKeySet
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+
+	var kids []string
+	for _, jwk := range res.Keys {
+		kids = append(kids, jwk.Kid)
+	}
+
+	if got, want := kids, []string{"key-2", "key-3", "key-4"}; !slices.Equal(got, want) {
+		t.Fatalf("kids got %v, want %v", got, want)
+	}
+
+	for i, jwk := range res.Keys {
+		pub := keys[i+1].PrivateKey.Public().(*rsa.PublicKey)
+		if jwk.Kty != "RSA" || jwk.Use != "sig" || jwk.Alg != "RS256" {
+			t.Fatalf("key %s got kty=%s use=%s alg=%s, want RSA sig RS256", jwk.Kid, jwk.Kty, jwk.Use, jwk.Alg)
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(*jwk.N)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		e, err := base64.RawURLEncoding.DecodeString(*jwk.E)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(n, pub.N.Bytes()) || new(big.Int).SetBytes(e).Int64() != int64(pub.E) {
+			t.Fatalf("key %s does not match its public key", jwk.Kid)
+		}
+	}
+}
+
+func TestAccessTokenKeyRotation(t *testing.T) {
+	now := time.Now()
+	keys := rotatedKeys(now)
+	user1, err := user.NewWithPassword(user.NextID(), "+628174546647", "John Doe", "Secret123!")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// signedWith creates a token as the ring had only the key
+	signedWith := func(key *keyring.Key) func() (string, error) {
+		return func() (string, error) {
+			ring, err := keyring.New([]*keyring.Key{{ID: key.ID, PrivateKey: key.PrivateKey}}, time.Hour)
+			if err != nil {
+				return "", err
+			}
+
+			tc := &TokenCreator{
+				KeyRing: ring,
+			}
+			return tc.CreateAccessToken(user1)
+		}
+	}
+
+	testCases := map[string]struct {
+		tokenFn          func() (string, error)
+		expectStatusCode int
+	}{
+		"current key": {
+			tokenFn:          signedWith(keys[2]),
+			expectStatusCode: http.StatusOK,
+		},
+		"replaced key with unexpired tokens": {
+			tokenFn:          signedWith(keys[1]),
+			expectStatusCode: http.StatusOK,
+		},
+		"scheduled key": {
+			tokenFn:          signedWith(keys[3]),
+			expectStatusCode: http.StatusOK,
+		},
+		"retired key": {
+			tokenFn:          signedWith(keys[0]),
+			expectStatusCode: http.StatusForbidden,
+		},
+		"unknown key": {
+			tokenFn:          signedWith(&keyring.Key{ID: keys[2].ID, PrivateKey: generateRSAKey()}),
+			expectStatusCode: http.StatusForbidden,
+		},
+		"missing kid": {
+			tokenFn: func() (string, error) {
+				token := jwt.NewWithClaims(jwt.SigningMethodRS256, &AccessTokenClaims{
+					ID:        xid.New().String(),
+					Subject:   user1.ID(),
+					Issuer:    DefaultTokenIssuer,
+					IssuedAt:  now.Unix(),
+					ExpiresAt: now.Add(time.Hour).Unix(),
+				})
+				return token.SignedString(keys[2].PrivateKey)
+			},
+			expectStatusCode: http.StatusForbidden,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			// Given
+			fix := setup(t)
+			defer fix.tearDown()
+
+			ring, err := keyring.New(keys, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			fix.svr.KeyRing = ring
+
+			// When
+			accessToken, err := tc.tokenFn()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/users/me", nil)
+			req.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", accessToken))
+			rec := httptest.NewRecorder()
+
+			if tc.expectStatusCode == http.StatusOK {
+				fix.tokenRevocationRepo.EXPECT().AccessTokenRevoked(gomock.Any(), gomock.Any(), user1.ID(), gomock.Any()).Return(false, nil)
+				fix.userRepo.EXPECT().GetByID(gomock.Any(), user1.ID()).Return(user1, nil)
+			}
+
+			c := echo.New().NewContext(req, rec)
+			err = fix.svr.GetMyProfile(c)
+
+			// Then
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := rec.Code, tc.expectStatusCode; got != want {
+				t.Fatalf("statusCode got %d, want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestAccessTokenAlgorithms(t *testing.T) {
+	rsaKey := generateRSAKey()
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user1, err := user.NewWithPassword(user.NextID(), "+628174546647", "John Doe", "Secret123!")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := map[string]struct {
+		privateKey crypto.Signer
+		algorithm  keyring.Algorithm
+		expectKty  string
+		expectCrv  string
+	}{
+		"RS256": {
+			privateKey: rsaKey,
+			algorithm:  keyring.RS256,
+			expectKty:  "RSA",
+		},
+		"PS256": {
+			privateKey: rsaKey,
+			algorithm:  keyring.PS256,
+			expectKty:  "RSA",
+		},
+		"ES256": {
+			privateKey: ecKey,
+			algorithm:  keyring.ES256,
+			expectKty:  "EC",
+			expectCrv:  "P-256",
+		},
+		"EdDSA": {
+			privateKey: edKey,
+			algorithm:  keyring.EdDSA,
+			expectKty:  "OKP",
+			expectCrv:  "Ed25519",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			// Given
+			fix := setup(t)
+			defer fix.tearDown()
+
+			ring, err := keyring.Load(keyring.StaticSource{{ID: "key", PrivateKey: tc.privateKey}}, tc.algorithm, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			fix.svr.KeyRing = ring
+
+			// When
+			tokenCreator := &TokenCreator{
+				KeyRing: ring,
+			}
+			accessToken, err := tokenCreator.CreateAccessToken(user1)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/users/me", nil)
+			req.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", accessToken))
+			rec := httptest.NewRecorder()
+
+			fix.tokenRevocationRepo.EXPECT().AccessTokenRevoked(gomock.Any(), gomock.Any(), user1.ID(), gomock.Any()).Return(false, nil)
+			fix.userRepo.EXPECT().GetByID(gomock.Any(), user1.ID()).Return(user1, nil)
+
+			c := echo.New().NewContext(req, rec)
+			err = fix.svr.GetMyProfile(c)
+
+			jwksReq := httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil)
+			jwksRec := httptest.NewRecorder()
+			jwksErr := fix.svr.GetJWKS(echo.New().NewContext(jwksReq, jwksRec))
+
+			// Then
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if jwksErr != nil {
+				t.Fatal(jwksErr)
+			}
+
+			if got, want := rec.Code, http.StatusOK; got != want {
+				t.Fatalf("statusCode got %d, want %d", got, want)
+			}
+
+			var header struct {
+				Alg string `json:"alg"`
+				Kid string `json:"kid"`
+			}
+
+			rawHeader, err := jwt.DecodeSegment(strings.Split(accessToken, ".")[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := json.Unmarshal(rawHeader, &header); err != nil {
+				t.Fatal(err)
+			}
+
+			if header.Alg != string(tc.algorithm) || header.Kid != "key" {
+				t.Fatalf("token header got alg=%s kid=%s, want alg=%s kid=key", header.Alg, header.Kid, tc.algorithm)
+			}
+
+			var jwks generated.JSONWebKeySet
+			if err := json.NewDecoder(jwksRec.Body).Decode(&jwks); err != nil {
+				t.Fatal(err)
+			}
+
+			jwk := jwks.Keys[0]
+			var crv string
+			if jwk.Crv != nil {
+				crv = *jwk.Crv
+			}
+
+			if jwk.Alg != string(tc.algorithm) || jwk.Kty != tc.expectKty || crv != tc.expectCrv {
+				t.Fatalf("jwk got alg=%s kty=%s crv=%s, want alg=%s kty=%s crv=%s", jwk.Alg, jwk.Kty, crv, tc.algorithm, tc.expectKty, tc.expectCrv)
+			}
+		})
+	}
+}
+
+func TestAccessTokenAlgorithmPinning(t *testing.T) {
+	now := time.Now()
+	rsaKey := generateRSAKey()
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user1, err := user.NewWithPassword(user.NextID(), "+628174546647", "John Doe", "Secret123!")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publicKeyDER, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publicKeyPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKeyDER})
+
+	signed := func(method jwt.SigningMethod, kid string, key interface{}) func() (string, error) {
+		return func() (string, error) {
+			token := jwt.NewWithClaims(method, &AccessTokenClaims{
+				ID:        xid.New().String(),
+				Subject:   user1.ID(),
+				Issuer:    DefaultTokenIssuer,
+				IssuedAt:  now.Unix(),
+				ExpiresAt: now.Add(time.Hour).Unix(),
+			})
+			token.Header["kid"] = kid
+
+			return token.SignedString(key)
+		}
+	}
+
+	testCases := map[string]struct {
+		tokenFn          func() (string, error)
+		expectStatusCode int
+	}{
+		"RS256 with the RSA key": {
+			tokenFn:          signed(jwt.SigningMethodRS256, "rsa", rsaKey),
+			expectStatusCode: http.StatusOK,
+		},
+		"ES256 with the EC key": {
+			tokenFn:          signed(jwt.SigningMethodES256, "ec", ecKey),
+			expectStatusCode: http.StatusOK,
+		},
+		"PS256 with the RS256 key": {
+			tokenFn:          signed(jwt.SigningMethodPS256, "rsa", rsaKey),
+			expectStatusCode: http.StatusForbidden,
+		},
+		"ES256 named as the RSA key": {
+			tokenFn:          signed(jwt.SigningMethodES256, "rsa", ecKey),
+			expectStatusCode: http.StatusForbidden,
+		},
+		"HS256 keyed with the public key": {
+			tokenFn:          signed(jwt.SigningMethodHS256, "rsa", publicKeyPEM),
+			expectStatusCode: http.StatusForbidden,
+		},
+		"none": {
+			tokenFn:          signed(jwt.SigningMethodNone, "rsa", jwt.UnsafeAllowNoneSignatureType),
+			expectStatusCode: http.StatusForbidden,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			// Given
+			fix := setup(t)
+			defer fix.tearDown()
+
+			ring, err := keyring.New([]*keyring.Key{
+				{ID: "ec", PrivateKey: ecKey, ActiveFrom: now.Add(-2 * time.Hour)},
+				{ID: "rsa", PrivateKey: rsaKey, ActiveFrom: now.Add(-time.Minute)},
+			}, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			fix.svr.KeyRing = ring
+
+			// When
+			accessToken, err := tc.tokenFn()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/users/me", nil)
+			req.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", accessToken))
+			rec := httptest.NewRecorder()
+
+			if tc.expectStatusCode == http.StatusOK {
+				fix.tokenRevocationRepo.EXPECT().AccessTokenRevoked(gomock.Any(), gomock.Any(), user1.ID(), gomock.Any()).Return(false, nil)
+				fix.userRepo.EXPECT().GetByID(gomock.Any(), user1.ID()).Return(user1, nil)
+			}
+
+			c := echo.New().NewContext(req, rec)
+			err = fix.svr.GetMyProfile(c)
+
+			// Then
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := rec.Code, tc.expectStatusCode; got != want {
+				t.Fatalf("statusCode got %d, want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestAccessTokenClaims(t *testing.T) {
+	now := time.Now()
+	user1, err := user.NewWithPassword(user.NextID(), "+628174546647", "John Doe", "Secret123!")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed := func(claims *AccessTokenClaims) func() (string, error) {
+		return func() (string, error) {
+			key, err := testKeyRing.SigningKey(now)
+			if err != nil {
+				return "", err
+			}
+
+			token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+			token.Header["kid"] = key.ID
+
+			return token.SignedString(key.PrivateKey)
+		}
+	}
+
+	claims := func(modify func(*AccessTokenClaims)) *AccessTokenClaims {
+		c := &AccessTokenClaims{
+			ID:        xid.New().String(),
+			Subject:   user1.ID(),
+			Issuer:    "https://auth.example.com",
+			Audience:  audience{"profile"},
+			IssuedAt:  now.Unix(),
+			NotBefore: now.Unix(),
+			ExpiresAt: now.Add(time.Hour).Unix(),
+		}
+		modify(c)
+
+		return c
+	}
+
+	testCases := map[string]struct {
+		tokenFn          func() (string, error)
+		expectStatusCode int
+	}{
+		"created": {
+			tokenFn: func() (string, error) {
+				tc := &TokenCreator{KeyRing: testKeyRing, Issuer: "https://auth.example.com", Audience: []string{"profile"}}
+				return tc.CreateAccessTokenWithClaims(user1, CustomClaims{Roles: []string{"admin"}, SessionID: "session", AuthMethods: []string{"pwd"}})
+			},
+			expectStatusCode: http.StatusOK,
+		},
+		"one of the audiences": {
+			tokenFn:          signed(claims(func(c *AccessTokenClaims) { c.Audience = audience{"billing", "profile"} })),
+			expectStatusCode: http.StatusOK,
+		},
+		"default issuer": {
+			tokenFn:          signed(claims(func(c *AccessTokenClaims) { c.Issuer = DefaultTokenIssuer })),
+			expectStatusCode: http.StatusForbidden,
+		},
+		"another issuer": {
+			tokenFn:          signed(claims(func(c *AccessTokenClaims) { c.Issuer = "https://evil.example.com" })),
+			expectStatusCode: http.StatusForbidden,
+		},
+		"another audience": {
+			tokenFn:          signed(claims(func(c *AccessTokenClaims) { c.Audience = audience{"billing"} })),
+			expectStatusCode: http.StatusForbidden,
+		},
+		"no audience": {
+			tokenFn:          signed(claims(func(c *AccessTokenClaims) { c.Audience = nil })),
+			expectStatusCode: http.StatusForbidden,
+		},
+		"not valid yet within the leeway": {
+			tokenFn:          signed(claims(func(c *AccessTokenClaims) { c.NotBefore = now.Add(20 * time.Second).Unix() })),
+			expectStatusCode: http.StatusOK,
+		},
+		"not valid yet": {
+			tokenFn:          signed(claims(func(c *AccessTokenClaims) { c.NotBefore = now.Add(time.Minute).Unix() })),
+			expectStatusCode: http.StatusForbidden,
+		},
+		"issued in the future": {
+			tokenFn:          signed(claims(func(c *AccessTokenClaims) { c.IssuedAt = now.Add(time.Minute).Unix() })),
+			expectStatusCode: http.StatusForbidden,
+		},
+		"expired within the leeway": {
+			tokenFn:          signed(claims(func(c *AccessTokenClaims) { c.ExpiresAt = now.Add(-20 * time.Second).Unix() })),
+			expectStatusCode: http.StatusOK,
+		},
+		"expired": {
+			tokenFn:          signed(claims(func(c *AccessTokenClaims) { c.ExpiresAt = now.Add(-time.Minute).Unix() })),
+			expectStatusCode: http.StatusForbidden,
+		},
+		"missing expiry": {
+			tokenFn:          signed(claims(func(c *AccessTokenClaims) { c.ExpiresAt = 0 })),
+			expectStatusCode: http.StatusForbidden,
+		},
+		"missing token id": {
+			tokenFn:          signed(claims(func(c *AccessTokenClaims) { c.ID = "" })),
+			expectStatusCode: http.StatusForbidden,
+		},
+		"missing subject": {
+			tokenFn:          signed(claims(func(c *AccessTokenClaims) { c.Subject = "" })),
+			expectStatusCode: http.StatusForbidden,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			// Given
+			fix := setup(t)
+			defer fix.tearDown()
+
+			fix.svr.TokenIssuer = "https://auth.example.com"
+			fix.svr.TokenAudience = []string{"profile", "admin"}
+			fix.svr.TokenLeeway = 30 * time.Second
+
+			// When
+			accessToken, err := tc.tokenFn()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/users/me", nil)
+			req.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", accessToken))
+			rec := httptest.NewRecorder()
+
+			if tc.expectStatusCode == http.StatusOK {
+				fix.tokenRevocationRepo.EXPECT().AccessTokenRevoked(gomock.Any(), gomock.Any(), user1.ID(), gomock.Any()).Return(false, nil)
+				fix.userRepo.EXPECT().GetByID(gomock.Any(), user1.ID()).Return(user1, nil)
+			}
+
+			c := echo.New().NewContext(req, rec)
+			err = fix.svr.GetMyProfile(c)
+
+			// Then
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := rec.Code, tc.expectStatusCode; got != want {
+				t.Fatalf("statusCode got %d, want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestAccessTokenAudienceEncoding(t *testing.T) {
+	testCases := map[string]struct {
+		audience     []string
+		expectClaim  string
+		expectAbsent bool
+	}{
+		"none": {
+			expectAbsent: true,
+		},
+		"single": {
+			audience:    []string{"profile"},
+			expectClaim: `"profile"`,
+		},
+		"several": {
+			audience:    []string{"profile", "billing"},
+			expectClaim: `["profile","billing"]`,
+		},
+	}
+
+	user1, err := user.NewWithPassword(user.NextID(), "+628174546647", "John Doe", "Secret123!")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			// Given
+			creator := &TokenCreator{KeyRing: testKeyRing, Audience: tc.audience}
+
+			// When
+			accessToken, err := creator.CreateAccessToken(user1)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Then
+			rawClaims, err := jwt.DecodeSegment(strings.Split(accessToken, ".")[1])
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var claims map[string]json.RawMessage
+			if err := json.Unmarshal(rawClaims, &claims); err != nil {
+				t.Fatal(err)
+			}
+
+			got, ok := claims["aud"]
+			if tc.expectAbsent {
+				if ok {
+					t.Fatalf("aud got %s, want none", got)
+				}
+
+				return
+			}
+
+			if want := tc.expectClaim; string(got) != want {
+				t.Fatalf("aud got %s, want %s", got, want)
+			}
+
+			if got, want := string(claims["iss"]), `"UserService"`; got != want {
+				t.Fatalf("iss got %s, want %s", got, want)
+			}
+
+			tv := &TokenVerifier{KeyRing: testKeyRing, Audience: tc.audience[len(tc.audience)-1:]}
+			if _, err := tv.Verify(accessToken); err != nil {
+				t.Fatalf("verify got %v, want nil", err)
+			}
+		})
+	}
+}
+
+func TestIntrospectToken(t *testing.T) {
+	user1, err := user.NewWithPassword(user.NextID(), "+628174546647", "John Doe", "Secret123!")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	createTokenFor := func(aud ...string) func() (string, error) {
+		return func() (string, error) {
+			tc := &TokenCreator{KeyRing: testKeyRing, Audience: aud}
+			return tc.CreateAccessTokenWithClaims(user1, CustomClaims{SessionID: "session"})
+		}
+	}
+	createToken := createTokenFor(testOAuthClientID, "shipping")
+
+	testCases := map[string]struct {
+		clientID         string
+		clientSecret     string
+		clientAuth       string
+		tokenFn          func() (string, error)
+		revoked          bool
+		expectStatusCode int
+		expectError      string
+		expectActive     bool
+	}{
+		"active": {
+			clientID:         testOAuthClientID,
+			clientSecret:     testOAuthClientSecret,
+			tokenFn:          createToken,
+			expectStatusCode: http.StatusOK,
+			expectActive:     true,
+		},
+		"client secret post": {
+			clientID:         testOAuthClientID,
+			clientSecret:     testOAuthClientSecret,
+			clientAuth:       "post",
+			tokenFn:          createToken,
+			expectStatusCode: http.StatusOK,
+			expectActive:     true,
+		},
+		"revoked": {
+			clientID:         testOAuthClientID,
+			clientSecret:     testOAuthClientSecret,
+			tokenFn:          createToken,
+			revoked:          true,
+			expectStatusCode: http.StatusOK,
+		},
+		"token of another client": {
+			clientID:         testOAuthClientID,
+			clientSecret:     testOAuthClientSecret,
+			tokenFn:          createTokenFor("shipping"),
+			expectStatusCode: http.StatusOK,
+		},
+		"token without audience": {
+			clientID:         testOAuthClientID,
+			clientSecret:     testOAuthClientSecret,
+			tokenFn:          createTokenFor(),
+			expectStatusCode: http.StatusOK,
+		},
+		"expired": {
+			clientID:     testOAuthClientID,
+			clientSecret: testOAuthClientSecret,
+			tokenFn: func() (string, error) {
+				now := time.Now()
+				key, err := testKeyRing.SigningKey(now)
+				if err != nil {
+					return "", err
+				}
+
+				expired := jwt.NewWithClaims(jwt.SigningMethodRS256, &AccessTokenClaims{
+					ID:        xid.New().String(),
+					Subject:   user1.ID(),
+					Issuer:    DefaultTokenIssuer,
+					IssuedAt:  now.Add(-2 * time.Hour).Unix(),
+					ExpiresAt: now.Add(-time.Hour).Unix(),
+				})
+				expired.Header["kid"] = key.ID
+
+				return expired.SignedString(key.PrivateKey)
+			},
+			expectStatusCode: http.StatusOK,
+		},
+		"refresh token": {
+			clientID:     testOAuthClientID,
+			clientSecret: testOAuthClientSecret,
+			tokenFn: func() (string, error) {
+				_, value, err := token.Issue(user1.ID(), time.Now(), time.Hour)
+				return value, err
+			},
+			expectStatusCode: http.StatusOK,
+		},
+		"missing token": {
+			clientID:         testOAuthClientID,
+			clientSecret:     testOAuthClientSecret,
+			tokenFn:          func() (string, error) { return "", nil },
+			expectStatusCode: http.StatusBadRequest,
+			expectError:      "invalid_request",
+		},
+		"wrong client secret": {
+			clientID:         testOAuthClientID,
+			clientSecret:     "wrong",
+			tokenFn:          createToken,
+			expectStatusCode: http.StatusUnauthorized,
+			expectError:      "invalid_client",
+		},
+		"wrong client secret post": {
+			clientID:         testOAuthClientID,
+			clientSecret:     "wrong",
+			clientAuth:       "post",
+			tokenFn:          createToken,
+			expectStatusCode: http.StatusUnauthorized,
+			expectError:      "invalid_client",
+		},
+		"client credentials sent both ways": {
+			clientID:         testOAuthClientID,
+			clientSecret:     testOAuthClientSecret,
+			clientAuth:       "both",
+			tokenFn:          createToken,
+			expectStatusCode: http.StatusUnauthorized,
+			expectError:      "invalid_client",
+		},
+		"unknown client": {
+			clientID:         "unknown",
+			clientSecret:     testOAuthClientSecret,
+			tokenFn:          createToken,
+			expectStatusCode: http.StatusUnauthorized,
+			expectError:      "invalid_client",
+		},
+		"missing client credentials": {
+			tokenFn:          createToken,
+			expectStatusCode: http.StatusUnauthorized,
+			expectError:      "invalid_client",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			// Given
+			fix := setup(t)
+			defer fix.tearDown()
+
+			accessToken, err := tc.tokenFn()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// When
+			form := url.Values{"token": {accessToken}, "token_type_hint": {"access_token"}}
+			if tc.clientAuth == "post" || tc.clientAuth == "both" {
+				form.Set("client_id", tc.clientID)
+				form.Set("client_secret", tc.clientSecret)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/oauth/introspect", strings.NewReader(form.Encode()))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+			if tc.clientID != "" && tc.clientAuth != "post" {
+				req.SetBasicAuth(tc.clientID, tc.clientSecret)
+			}
+
+			rec := httptest.NewRecorder()
+
+			if tc.expectActive || tc.revoked {
+				fix.tokenRevocationRepo.EXPECT().AccessTokenRevoked(gomock.Any(), gomock.Any(), user1.ID(), gomock.Any()).Return(tc.revoked, nil)
+			}
+
+			c := echo.New().NewContext(req, rec)
+			err = fix.svr.IntrospectToken(c)
+
+			// Then
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := rec.Code, tc.expectStatusCode; got != want {
+				t.Fatalf("statusCode got %d, want %d", got, want)
+			}
+
+			if tc.expectError != "" {
+				var res generated.OAuthError
+				if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+					t.Fatal(err)
+				}
+
+				if got, want := res.Error, tc.expectError; got != want {
+					t.Fatalf("error got %s, want %s", got, want)
+				}
+
+				return
+			}
+
+			var res map[string]interface{}
+			if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := res["active"], tc.expectActive; got != want {
+				t.Fatalf("active got %v, want %v", got, want)
+			}
+
+			if !tc.expectActive {
+				if got, want := len(res), 1; got != want {
+					t.Fatalf("members got %v, want only active", res)
+				}
+
+				return
+			}
+
+			if got, want := res["sub"], user1.ID(); got != want {
+				t.Fatalf("sub got %v, want %s", got, want)
+			}
+
+			if got, want := res["scope"], DefaultTokenScope; got != want {
+				t.Fatalf("scope got %v, want %s", got, want)
+			}
+
+			if got, want := res["sid"], "session"; got != want {
+				t.Fatalf("sid got %v, want %s", got, want)
+			}
+
+			if exp, ok := res["exp"].(float64); !ok || time.Unix(int64(exp), 0).Before(time.Now()) {
+				t.Fatalf("exp got %v, want in the future", res["exp"])
+			}
+		})
+	}
+}
+
+func TestRevokeToken(t *testing.T) {
+	user1, err := user.NewWithPassword(user.NextID(), "+628174546647", "John Doe", "Secret123!")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refreshToken, refreshTokenValue, err := token.Issue(user1.ID(), time.Now(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	createTokenFor := func(aud ...string) func() (string, error) {
+		return func() (string, error) {
+			tc := &TokenCreator{KeyRing: testKeyRing, Audience: aud}
+			return tc.CreateAccessToken(user1)
+		}
+	}
+
+	testCases := map[string]struct {
+		tokenFn             func() (string, error)
+		clientSecret        string
+		clientAuth          string
+		tokenAudience       []string
+		storedRefreshToken  *token.RefreshToken
+		expectAccessRevoke  bool
+		expectRefreshLookup bool
+		expectStatusCode    int
+	}{
+		"access token": {
+			tokenFn:            createTokenFor(testOAuthClientID),
+			expectAccessRevoke: true,
+			expectStatusCode:   http.StatusOK,
+		},
+		"client secret post": {
+			tokenFn:            createTokenFor(testOAuthClientID),
+			clientAuth:         "post",
+			expectAccessRevoke: true,
+			expectStatusCode:   http.StatusOK,
+		},
+		"access token of another client": {
+			tokenFn:          createTokenFor("shipping"),
+			expectStatusCode: http.StatusOK,
+		},
+		"refresh token": {
+			tokenFn:             func() (string, error) { return refreshTokenValue, nil },
+			storedRefreshToken:  refreshToken,
+			expectRefreshLookup: true,
+			expectStatusCode:    http.StatusOK,
+		},
+		"refresh token of another client": {
+			tokenFn:          func() (string, error) { return refreshTokenValue, nil },
+			tokenAudience:    []string{"shipping"},
+			expectStatusCode: http.StatusOK,
+		},
+		"unknown token": {
+			tokenFn:             func() (string, error) { return "unknown", nil },
+			expectRefreshLookup: true,
+			expectStatusCode:    http.StatusOK,
+		},
+		"missing token": {
+			tokenFn:          func() (string, error) { return "", nil },
+			expectStatusCode: http.StatusBadRequest,
+		},
+		"wrong client secret": {
+			tokenFn:          func() (string, error) { return refreshTokenValue, nil },
+			clientSecret:     "wrong",
+			expectStatusCode: http.StatusUnauthorized,
+		},
+		"wrong client secret post": {
+			tokenFn:          func() (string, error) { return refreshTokenValue, nil },
+			clientSecret:     "wrong",
+			clientAuth:       "post",
+			expectStatusCode: http.StatusUnauthorized,
+		},
+		"client credentials sent both ways": {
+			tokenFn:          createTokenFor(testOAuthClientID),
+			clientAuth:       "both",
+			expectStatusCode: http.StatusUnauthorized,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			// Given
+			fix := setup(t)
+			defer fix.tearDown()
+
+			fix.svr.TokenAudience = []string{testOAuthClientID, "shipping"}
+			if tc.tokenAudience != nil {
+				fix.svr.TokenAudience = tc.tokenAudience
+			}
+
+			tokenString, err := tc.tokenFn()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			clientSecret := testOAuthClientSecret
+			if tc.clientSecret != "" {
+				clientSecret = tc.clientSecret
+			}
+
+			// When
+			form := url.Values{"token": {tokenString}}
+			if tc.clientAuth == "post" || tc.clientAuth == "both" {
+				form.Set("client_id", testOAuthClientID)
+				form.Set("client_secret", clientSecret)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/oauth/revoke", strings.NewReader(form.Encode()))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+			if tc.clientAuth != "post" {
+				req.SetBasicAuth(testOAuthClientID, clientSecret)
+			}
+			rec := httptest.NewRecorder()
+
+			if tc.expectAccessRevoke {
+				fix.tokenRevocationRepo.EXPECT().RevokeAccessToken(gomock.Any(), gomock.Any(), user1.ID(), gomock.Any()).Return(nil)
+			}
+
+			if tc.expectRefreshLookup {
+				fix.refreshTokenRepo.EXPECT().GetRefreshTokenByHash(gomock.Any(), token.Hash(tokenString)).Return(tc.storedRefreshToken, nil)
+			}
+
+			if tc.storedRefreshToken != nil {
+				fix.refreshTokenRepo.EXPECT().RevokeRefreshTokenFamily(gomock.Any(), tc.storedRefreshToken.FamilyID(), gomock.Any()).Return(nil)
+			}
+
+			c := echo.New().NewContext(req, rec)
+			err = fix.svr.RevokeToken(c)
+
+			// Then
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := rec.Code, tc.expectStatusCode; got != want {
+				t.Fatalf("statusCode got %d, want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestUpdateMyProfile(t *testing.T) {
+	testCases := map[string]struct {
+		phoneNumber         string
+		fullName            string
+		profileForm         generated.UserProfileForm
+		tokenFn             func(*user.User) (string, error)
+		noUpdate            bool
+		invalidToken        bool
+		expectStatusCode    int
+		expectContainsError map[string][]string
+
+		skip bool
+	}{
+		"update both": {
+			phoneNumber: "+628174546647",
+			fullName:    "John Doe",
+			profileForm: generated.UserProfileForm{
+				PhoneNumber: strPtr("+628174546648"),
+				FullName:    strPtr("John Wick"),
+			},
+			tokenFn: func(u *user.User) (string, error) {
+				tc := &TokenCreator{
+					KeyRing: testKeyRing,
+				}
+				return tc.CreateAccessToken(u)
+			},
+			expectStatusCode: http.StatusNoContent,
+		},
+		"update phone only": {
+			phoneNumber: "+628174546647",
+			fullName:    "John Doe",
+			profileForm: generated.UserProfileForm{
+				PhoneNumber: strPtr("+628174546648"),
+			},
+			tokenFn: func(u *user.User) (string, error) {
+				tc := &TokenCreator{
+					KeyRing: testKeyRing,
+				}
+				return tc.CreateAccessToken(u)
+			},
+			expectStatusCode: http.StatusNoContent,
+		},
+		"update name only": {
+			phoneNumber: "+628174546647",
+			fullName:    "John Doe",
+			profileForm: generated.UserProfileForm{
+				FullName: strPtr("John Wick"),
+			},
+			tokenFn: func(u *user.User) (string, error) {
+				tc := &TokenCreator{
+					KeyRing: testKeyRing,
+				}
+				return tc.CreateAccessToken(u)
+			},
+			expectStatusCode: http.StatusNoContent,
+		},
+		"no details sent": {
+			phoneNumber: "+628174546647",
+			fullName:    "John Doe",
+			profileForm: generated.UserProfileForm{},
+			tokenFn: func(u *user.User) (string, error) {
+				tc := &TokenCreator{
+					KeyRing: testKeyRing,
+				}
+				return tc.CreateAccessToken(u)
+			},
+			noUpdate:         true,
+			expectStatusCode: http.StatusNoContent,
+		},
+		"no changes": {
+			phoneNumber: "+628174546647",
+			fullName:    "John Doe",
+			profileForm: generated.UserProfileForm{
+				PhoneNumber: strPtr("+628174546647"),
+				FullName:    strPtr("John Doe"),
+			},
+			tokenFn: func(u *user.User) (string, error) {
+				tc := &TokenCreator{
+					KeyRing: testKeyRing,
+				}
+				return tc.CreateAccessToken(u)
+			},
+			noUpdate:         true,
+			expectStatusCode: http.StatusNoContent,
+		},
+		"invalid name length": {
+			phoneNumber: "+628174546647",
+			fullName:    "John Doe",
+			profileForm: generated.UserProfileForm{
+				PhoneNumber: strPtr("+628174546648"),
+				FullName:    strPtr("Jo"),
+			},
+			tokenFn: func(u *user.User) (string, error) {
+				tc := &TokenCreator{
+					KeyRing: testKeyRing,
+				}
+				return tc.CreateAccessToken(u)
+			},
+			expectStatusCode: http.StatusBadRequest,
+			expectContainsError: map[string][]string{
+				"fullName": {"FULL_NAME_LENGTH"},
+			},
+		},
+		"invalid phone format": {
+			phoneNumber: "+628174546647",
+			fullName:    "John Doe",
+			profileForm: generated.UserProfileForm{
+				PhoneNumber: strPtr("+618174546648"),
+				FullName:    strPtr("John Wick"),
+			},
+			tokenFn: func(u *user.User) (string, error) {
+				tc := &TokenCreator{
+					KeyRing: testKeyRing,
+				}
+				return tc.CreateAccessToken(u)
+			},
+			expectStatusCode: http.StatusBadRequest,
+			expectContainsError: map[string][]string{
+				"phoneNumber": {"PHONE_NUMBER_FORMAT"},
+			},
+		},
+		"invalid token": {
+			phoneNumber: "+628174546647",
+			fullName:    "John Doe",
+			profileForm: generated.UserProfileForm{
+				PhoneNumber: strPtr("+628174546648"),
+				FullName:    strPtr("John Wick"),
+			},
+			tokenFn: func(u *user.User) (string, error) {
+				return "invalid token", nil
+			},
+			invalidToken:     true,
+			expectStatusCode: http.StatusForbidden,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if tc.skip {
+				t.Skip()
+			}
+
+			// Given
+			fix := setup(t)
+			defer fix.tearDown()
+
+			storedUser, err := user.NewWithPassword("jdoe", tc.phoneNumber, tc.fullName, "Secret123!")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// When
+			var buf bytes.Buffer
+			if err := json.NewEncoder(&buf).Encode(tc.profileForm); err != nil {
+				t.Fatal(err)
+			}
+
+			accessToken, err := tc.tokenFn(storedUser)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodPut, "/users/me", bytes.NewReader(buf.Bytes()))
+			req.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", accessToken))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+
+			if !tc.invalidToken {
+				fix.tokenRevocationRepo.EXPECT().AccessTokenRevoked(gomock.Any(), gomock.Any(), storedUser.ID(), gomock.Any()).Return(false, nil)
+			}
+
+			if !tc.invalidToken && len(tc.expectContainsError) == 0 {
+				fix.userRepo.EXPECT().GetByID(gomock.Any(), storedUser.ID()).Return(storedUser, nil)
+
+				if tc.profileForm.PhoneNumber != nil && *tc.profileForm.PhoneNumber != tc.phoneNumber {
+					fix.userRepo.EXPECT().GetByPhoneNumber(gomock.Any(), *tc.profileForm.PhoneNumber).Return(nil, nil)
+				}
+
+				if !tc.noUpdate {
+					fix.userRepo.EXPECT().Update(gomock.Any(), storedUser).Return(nil)
+				}
+
+				if tc.profileForm.PhoneNumber != nil && *tc.profileForm.PhoneNumber != tc.phoneNumber {
+					fix.otpRepo.EXPECT().GetOTP(gomock.Any(), otp.PurposePhoneVerification, *tc.profileForm.PhoneNumber).Return(nil, nil)
+					fix.otpRepo.EXPECT().StoreOTP(gomock.Any(), gomock.Any()).Return(nil)
+				}
+			}
+
+			c := echo.New().NewContext(req, rec)
+			err = fix.svr.UpdateMyProfile(c)
+
+			// Then
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := rec.Code, tc.expectStatusCode; got != want {
+				t.Fatalf("statusCode got %d, want %d", got, want)
+			}
+
+			if rec.Code == http.StatusNoContent && tc.profileForm.PhoneNumber != nil && *tc.profileForm.PhoneNumber != tc.phoneNumber {
+				if got, want := storedUser.PhoneNumber(), tc.phoneNumber; got != want {
+					t.Fatalf("phoneNumber got %s, want %s until verified", got, want)
+				}
+
+				if got, want := storedUser.PendingPhoneNumber(), *tc.profileForm.PhoneNumber; got != want {
+					t.Fatalf("pendingPhoneNumber got %s, want %s", got, want)
+				}
+
+				if fix.sentCode(*tc.profileForm.PhoneNumber) == "" {
+					t.Fatal("phone verification code not sent")
+				}
+			}
+
+			if rec.Code == http.StatusBadRequest {
+				var resErrs []generated.FieldError
+				if err := json.NewDecoder(rec.Body).Decode(&resErrs); err != nil {
+					t.Fatal(err)
+				}
+
+				for fieldName, errCodes := range tc.expectContainsError {
+					for _, code := range errCodes {
+						found := false
+						for _, e := range resErrs {
+							if e.Name == fieldName && slices.Contains(e.Codes, code) {
+								found = true
+								break
+							}
+						}
+
+						if !found {
+							t.Fatalf("FieldError not found: want fieldName=%s code=%s, got errors=%+v", fieldName, code, resErrs)
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestLogout(t *testing.T) {
+	user1, err := user.NewWithPassword(user.NextID(), "+628174546647", "John Doe", "Secret123!")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	otherUserID := user.NextID()
+
+	refreshTokenValue := "refresh-token"
+	newRefreshToken := func(userID string) *token.RefreshToken {
+		now := time.Now()
+		rt, err := token.New(token.NextID(), token.NextID(), userID, token.Hash(refreshTokenValue), now, now.Add(time.Hour), time.Time{}, time.Time{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return rt
+	}
+
+	testCases := map[string]struct {
+		logoutForm         *generated.LogoutForm
+		storedRefreshToken *token.RefreshToken
+		expectRevokeFamily bool
+		invalidToken       bool
+		expectStatusCode   int
+	}{
+		"access token only": {
+			expectStatusCode: http.StatusNoContent,
+		},
+		"with refresh token": {
+			logoutForm: &generated.LogoutForm{
+				RefreshToken: strPtr(refreshTokenValue),
+			},
+			storedRefreshToken: newRefreshToken(user1.ID()),
+			expectRevokeFamily: true,
+			expectStatusCode:   http.StatusNoContent,
+		},
+		"with refresh token of other user": {
+			logoutForm: &generated.LogoutForm{
+				RefreshToken: strPtr(refreshTokenValue),
+			},
+			storedRefreshToken: newRefreshToken(otherUserID),
+			expectStatusCode:   http.StatusNoContent,
+		},
+		"invalid token": {
+			invalidToken:     true,
+			expectStatusCode: http.StatusForbidden,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			// Given
+			fix := setup(t)
+			defer fix.tearDown()
+
+			accessToken := "invalid token"
+			if !tc.invalidToken {
+				tokenCreator := &TokenCreator{
+					KeyRing: testKeyRing,
+				}
+
+				accessToken, err = tokenCreator.CreateAccessToken(user1)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// When
+			var body io.Reader
+			if tc.logoutForm != nil {
+				var buf bytes.Buffer
+				if err := json.NewEncoder(&buf).Encode(tc.logoutForm); err != nil {
+					t.Fatal(err)
+				}
+
+				body = &buf
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/users/logout", body)
+			req.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", accessToken))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+
+			if !tc.invalidToken {
+				fix.tokenRevocationRepo.EXPECT().AccessTokenRevoked(gomock.Any(), gomock.Any(), user1.ID(), gomock.Any()).Return(false, nil)
+				fix.tokenRevocationRepo.EXPECT().RevokeAccessToken(gomock.Any(), gomock.Not(""), user1.ID(), gomock.Any()).Return(nil)
+			}
+
+			if tc.storedRefreshToken != nil {
+				fix.refreshTokenRepo.EXPECT().GetRefreshTokenByHash(gomock.Any(), token.Hash(refreshTokenValue)).Return(tc.storedRefreshToken, nil)
+			}
+
+			if tc.expectRevokeFamily {
+				fix.refreshTokenRepo.EXPECT().RevokeRefreshTokenFamily(gomock.Any(), tc.storedRefreshToken.FamilyID(), gomock.Any()).Return(nil)
+			}
+
+			c := echo.New().NewContext(req, rec)
+			err = fix.svr.Logout(c)
+
+			// Then
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := rec.Code, tc.expectStatusCode; got != want {
+				t.Fatalf("statusCode got %d, want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestLogoutAll(t *testing.T) {
+	user1, err := user.NewWithPassword(user.NextID(), "+628174546647", "John Doe", "Secret123!")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Given
+	fix := setup(t)
+	defer fix.tearDown()
+
+	tokenCreator := &TokenCreator{
+		KeyRing: testKeyRing,
+	}
+
+	accessToken, err := tokenCreator.CreateAccessToken(user1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// When
+	req := httptest.NewRequest(http.MethodPost, "/users/logout/all", nil)
+	req.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", accessToken))
+	rec := httptest.NewRecorder()
+
+	before := time.Now()
+	fix.tokenRevocationRepo.EXPECT().AccessTokenRevoked(gomock.Any(), gomock.Any(), user1.ID(), gomock.Any()).Return(false, nil)
+	fix.tokenRevocationRepo.EXPECT().RevokeUserAccessTokens(gomock.Any(), user1.ID(), gomock.Any()).DoAndReturn(func(_ context.Context, userID string, issuedBefore time.Time) error {
+		if !issuedBefore.Equal(issuedBefore.Truncate(time.Second)) || !issuedBefore.After(before) || issuedBefore.After(time.Now().Add(time.Second)) {
+			return fmt.Errorf("unexpected issuedBefore %s", issuedBefore)
+		}
+
+		return nil
+	})
+	fix.refreshTokenRepo.EXPECT().RevokeUserRefreshTokens(gomock.Any(), user1.ID(), gomock.Any()).Return(nil)
+
+	c := echo.New().NewContext(req, rec)
+	err = fix.svr.LogoutAll(c)
+
+	// Then
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := rec.Code, http.StatusNoContent; got != want {
+		t.Fatalf("statusCode got %d, want %d", got, want)
+	}
+}
+
+func TestRevokeAllWithinTheSecondOfIssue(t *testing.T) {
+	user1, err := user.NewWithPassword(user.NextID(), "+628174546647", "John Doe", "Secret123!")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Given
+	fix := setup(t)
+	defer fix.tearDown()
+
+	fix.svr.SessionService = app.NewSessionService(fix.userRepo, fix.refreshTokenRepo, repository.NewMemoryRepository())
+	fix.refreshTokenRepo.EXPECT().RevokeUserRefreshTokens(gomock.Any(), user1.ID(), gomock.Any()).Return(nil)
+
+	tokenCreator := &TokenCreator{
+		KeyRing: testKeyRing,
+	}
+
+	// Leave room to issue and revoke within the same second
+	if now := time.Now(); now.Add(100*time.Millisecond).Unix() != now.Unix() {
+		time.Sleep(now.Truncate(time.Second).Add(time.Second).Sub(now))
+	}
+
+	accessToken, err := tokenCreator.CreateAccessToken(user1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// When
+	err = fix.svr.SessionService.RevokeAll(context.Background(), user1.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/users/me", nil)
+	req.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", accessToken))
+	rec := httptest.NewRecorder()
+
+	c := echo.New().NewContext(req, rec)
+	err = fix.svr.GetMyProfile(c)
+
+	// Then
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := rec.Code, http.StatusForbidden; got != want {
+		t.Fatalf("statusCode got %d, want %d", got, want)
+	}
+}
+
+func TestChangeMyPassword(t *testing.T) {
+	currentPassword := "Secret123!"
+	newPassword := "NewSecret456!"
+	previousPassword := "OldSecret789!"
+
+	previousUser, err := user.NewWithPassword(user.NextID(), "+628174546647", "John Doe", previousPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	history := []*user.PasswordRecord{previousUser.PasswordRecord(time.Now().Add(-time.Hour))}
+
+	testCases := map[string]struct {
+		form                generated.ChangePasswordForm
+		invalidToken        bool
+		userLocked          bool
+		expectStatusCode    int
+		expectContainsError map[string][]string
+	}{
+		"success": {
+			form: generated.ChangePasswordForm{
+				CurrentPassword: currentPassword,
+				NewPassword:     newPassword,
+			},
+			expectStatusCode: http.StatusNoContent,
+		},
+		"incorrect current password": {
+			form: generated.ChangePasswordForm{
+				CurrentPassword: currentPassword + "x",
+				NewPassword:     newPassword,
+			},
+			expectStatusCode: http.StatusBadRequest,
+			expectContainsError: map[string][]string{
+				"currentPassword": {"PASSWORD_INCORRECT"},
+			},
+		},
+		"locked user": {
+			form: generated.ChangePasswordForm{
+				CurrentPassword: currentPassword,
+				NewPassword:     newPassword,
+			},
+			userLocked:       true,
+			expectStatusCode: http.StatusBadRequest,
+			expectContainsError: map[string][]string{
+				"currentPassword": {"PASSWORD_INCORRECT"},
+			},
+		},
+		"weak new password": {
+			form: generated.ChangePasswordForm{
+				CurrentPassword: currentPassword,
+				NewPassword:     "weak",
+			},
+			expectStatusCode: http.StatusBadRequest,
+			expectContainsError: map[string][]string{
+				"newPassword": {"PASSWORD_STRENGTH"},
+			},
+		},
+		"reused current password": {
+			form: generated.ChangePasswordForm{
+				CurrentPassword: currentPassword,
+				NewPassword:     currentPassword,
+			},
+			expectStatusCode: http.StatusBadRequest,
+			expectContainsError: map[string][]string{
+				"newPassword": {"PASSWORD_REUSED"},
+			},
+		},
+		"reused previous password": {
+			form: generated.ChangePasswordForm{
+				CurrentPassword: currentPassword,
+				NewPassword:     previousPassword,
+			},
+			expectStatusCode: http.StatusBadRequest,
+			expectContainsError: map[string][]string{
+				"newPassword": {"PASSWORD_REUSED"},
+			},
+		},
+		"invalid token": {
+			form: generated.ChangePasswordForm{
+				CurrentPassword: currentPassword,
+				NewPassword:     newPassword,
+			},
+			invalidToken:     true,
+			expectStatusCode: http.StatusForbidden,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			// Given
+			fix := setup(t)
+			defer fix.tearDown()
+
+			storedUser, err := user.NewWithPassword(user.NextID(), "+628174546647", "John Doe", currentPassword)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			accessToken := "invalid token"
+			if !tc.invalidToken {
+				tokenCreator := &TokenCreator{
+					KeyRing: testKeyRing,
+				}
+
+				accessToken, err = tokenCreator.CreateAccessToken(storedUser)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// When
+			var buf bytes.Buffer
+			if err := json.NewEncoder(&buf).Encode(tc.form); err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodPut, "/users/me/password", bytes.NewReader(buf.Bytes()))
+			req.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", accessToken))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+
+			if !tc.invalidToken {
+				fix.tokenRevocationRepo.EXPECT().AccessTokenRevoked(gomock.Any(), gomock.Any(), storedUser.ID(), gomock.Any()).Return(false, nil)
+			}
+
+			if !tc.invalidToken && user.ValidPasswordStrength(tc.form.NewPassword) {
+				fix.userRepo.EXPECT().GetByID(gomock.Any(), storedUser.ID()).Return(storedUser, nil)
+
+				// Wrong current passwords count toward the lockout of the logins
+				userKey := lockout.UserKey(storedUser.ID())
+				var userCounter *lockout.Counter
+				if tc.userLocked {
+					userCounter, err = lockout.New(userKey, lockout.DefaultUserPolicy().MaxFailures, time.Now(), time.Now().Add(time.Minute))
+					if err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				fix.loginFailureRepo.EXPECT().GetLoginFailures(gomock.Any(), userKey).Return(userCounter, nil)
+				if tc.form.CurrentPassword != currentPassword {
+					fix.loginFailureRepo.EXPECT().IncrementLoginFailures(gomock.Any(), userKey, gomock.Any(), gomock.Any()).Return(lockout.New(userKey, 1, time.Now(), time.Time{}))
+				}
+
+				if tc.form.CurrentPassword == currentPassword && !tc.userLocked {
+					fix.passwordHistoryRepo.EXPECT().GetPasswordHistory(gomock.Any(), storedUser.ID(), 4).Return(history, nil)
+				}
+			}
+
+			oldHash, _ := storedUser.Password()
+			if tc.expectStatusCode == http.StatusNoContent {
+				fix.passwordHistoryRepo.EXPECT().UpdatePassword(gomock.Any(), storedUser, gomock.Any(), 4).DoAndReturn(func(_ context.Context, u *user.User, previous *user.PasswordRecord, keep int) error {
+					if !bytes.Equal(previous.Hash(), oldHash) {
+						t.Errorf("history hash got %s, want %s", previous.Hash(), oldHash)
+					}
+
+					return nil
+				})
+				fix.tokenRevocationRepo.EXPECT().RevokeUserAccessTokens(gomock.Any(), storedUser.ID(), gomock.Any()).Return(nil)
+				fix.refreshTokenRepo.EXPECT().RevokeUserRefreshTokens(gomock.Any(), storedUser.ID(), gomock.Any()).Return(nil)
+			}
+
+			c := echo.New().NewContext(req, rec)
+			err = fix.svr.ChangeMyPassword(c)
+
+			// Then
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := rec.Code, tc.expectStatusCode; got != want {
+				t.Fatalf("statusCode got %d, want %d", got, want)
+			}
+
+			if rec.Code == http.StatusNoContent {
+				if !storedUser.VerifyPassword(newPassword) {
+					t.Fatal("new password is not applied")
+				}
+
+				if storedUser.VerifyPassword(currentPassword) {
+					t.Fatal("old password still valid")
+				}
+			}
+
+			if rec.Code == http.StatusBadRequest {
+				var resErrs []generated.FieldError
+				if err := json.NewDecoder(rec.Body).Decode(&resErrs); err != nil {
+					t.Fatal(err)
+				}
+
+				for fieldName, errCodes := range tc.expectContainsError {
+					for _, code := range errCodes {
+						found := false
+						for _, e := range resErrs {
+							if e.Name == fieldName && slices.Contains(e.Codes, code) {
+								found = true
+								break
+							}
+						}
+
+						if !found {
+							t.Fatalf("FieldError not found: want fieldName=%s code=%s, got errors=%+v", fieldName, code, resErrs)
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestRequestPasswordReset(t *testing.T) {
+	user1, err := user.NewWithPassword(user.NextID(), "+628174546647", "John Doe", "Secret123!")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recentOTP, _, err := otp.Issue(otp.PurposePasswordReset, user1.PhoneNumber(), time.Now(), otp.DefaultExpiry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := map[string]struct {
+		phoneNumber         string
+		registeredUser      *user.User
+		previousOTP         *otp.OTP
+		expectSent          bool
+		expectStatusCode    int
+		expectContainsError map[string][]string
+	}{
+		"registered": {
+			phoneNumber:      user1.PhoneNumber(),
+			registeredUser:   user1,
+			expectSent:       true,
+			expectStatusCode: http.StatusAccepted,
+		},
+		"not registered": {
+			phoneNumber:      "+628174546648",
+			expectStatusCode: http.StatusAccepted,
+		},
+		"resent too soon": {
+			phoneNumber:      user1.PhoneNumber(),
+			registeredUser:   user1,
+			previousOTP:      recentOTP,
+			expectStatusCode: http.StatusAccepted,
+		},
+		"invalid phone number": {
+			phoneNumber:      "+618174546647",
+			expectStatusCode: http.StatusBadRequest,
+			expectContainsError: map[string][]string{
+				"phoneNumber": {"PHONE_NUMBER_FORMAT"},
+			},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			// Given
+			fix := setup(t)
+			defer fix.tearDown()
+
+			// When
+			var buf bytes.Buffer
+			if err := json.NewEncoder(&buf).Encode(generated.PasswordResetRequestForm{PhoneNumber: tc.phoneNumber}); err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/users/password/reset-request", bytes.NewReader(buf.Bytes()))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+
+			if len(tc.expectContainsError) == 0 {
+				fix.userRepo.EXPECT().GetByPhoneNumber(gomock.Any(), tc.phoneNumber).Return(tc.registeredUser, nil)
+			}
+
+			if tc.registeredUser != nil {
+				fix.otpRepo.EXPECT().GetOTP(gomock.Any(), otp.PurposePasswordReset, tc.phoneNumber).Return(tc.previousOTP, nil)
+			}
+
+			var storedOTP *otp.OTP
+			if tc.expectSent {
+				fix.otpRepo.EXPECT().StoreOTP(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, o *otp.OTP) error {
+					storedOTP = o
+					return nil
+				})
+			}
+
+			c := echo.New().NewContext(req, rec)
+			err = fix.svr.RequestPasswordReset(c)
+
+			// Then
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := rec.Code, tc.expectStatusCode; got != want {
+				t.Fatalf("statusCode got %d, want %d", got, want)
+			}
+
+			code := fix.sentCode(tc.phoneNumber)
+			if !tc.expectSent {
+				if code != "" {
+					t.Fatalf("code sent %s, want none", code)
+				}
+
+				return
+			}
+
+			if got, want := storedOTP.PhoneNumber(), tc.phoneNumber; got != want {
+				t.Fatalf("phoneNumber got %s, want %s", got, want)
+			}
+
+			if !storedOTP.Matches(code) {
+				t.Fatalf("sent code %q does not match the stored one", code)
+			}
+		})
+	}
+}
+
+func TestResetPassword(t *testing.T) {
+	phoneNumber := "+628174546647"
+	newPassword := "NewSecret456!"
+
+	testCases := map[string]struct {
+		form                func(code string) generated.PasswordResetForm
+		attempts            int
+		expectLookup        bool
+		expectVerify        bool
+		expectConsume       bool
+		expectStatusCode    int
+		expectContainsError map[string][]string
+	}{
+		"success": {
+			form: func(code string) generated.PasswordResetForm {
+				return generated.PasswordResetForm{PhoneNumber: phoneNumber, Code: code, NewPassword: newPassword}
+			},
+			attempts:         1,
+			expectVerify:     true,
+			expectConsume:    true,
+			expectStatusCode: http.StatusNoContent,
+		},
+		"reused password": {
+			form: func(code string) generated.PasswordResetForm {
+				return generated.PasswordResetForm{PhoneNumber: phoneNumber, Code: code, NewPassword: "Secret123!"}
+			},
+			attempts:         1,
+			expectVerify:     true,
+			expectConsume:    true,
+			expectStatusCode: http.StatusBadRequest,
+			expectContainsError: map[string][]string{
+				"newPassword": {"PASSWORD_REUSED"},
+			},
+		},
+		"wrong code": {
+			form: func(code string) generated.PasswordResetForm {
+				return generated.PasswordResetForm{PhoneNumber: phoneNumber, Code: "x" + code, NewPassword: newPassword}
+			},
+			attempts:         1,
+			expectVerify:     true,
+			expectStatusCode: http.StatusBadRequest,
+			expectContainsError: map[string][]string{
+				"code": {"OTP_INVALID"},
+			},
+		},
+		"attempts exceeded": {
+			form: func(code string) generated.PasswordResetForm {
+				return generated.PasswordResetForm{PhoneNumber: phoneNumber, Code: code, NewPassword: newPassword}
+			},
+			attempts:         otp.DefaultMaxAttempts + 1,
+			expectVerify:     true,
+			expectStatusCode: http.StatusBadRequest,
+			expectContainsError: map[string][]string{
+				"code": {"OTP_ATTEMPTS_EXCEEDED"},
+			},
+		},
+		"weak password": {
+			form: func(code string) generated.PasswordResetForm {
+				return generated.PasswordResetForm{PhoneNumber: phoneNumber, Code: code, NewPassword: "weak"}
+			},
+			expectStatusCode: http.StatusBadRequest,
+			expectContainsError: map[string][]string{
+				"newPassword": {"PASSWORD_STRENGTH"},
+			},
+		},
+		"password containing full name": {
+			form: func(code string) generated.PasswordResetForm {
+				return generated.PasswordResetForm{PhoneNumber: phoneNumber, Code: code, NewPassword: "JohnSecret456!"}
+			},
+			expectLookup:     true,
+			expectStatusCode: http.StatusBadRequest,
+			expectContainsError: map[string][]string{
+				"newPassword": {"PASSWORD_CONTAINS_PERSONAL_INFO"},
+			},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			// Given
+			fix := setup(t)
+			defer fix.tearDown()
+
+			storedUser, err := user.NewWithPassword(user.NextID(), phoneNumber, "John Doe", "Secret123!")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			storedOTP, code, err := otp.Issue(otp.PurposePasswordReset, phoneNumber, time.Now(), otp.DefaultExpiry)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// When
+			var buf bytes.Buffer
+			if err := json.NewEncoder(&buf).Encode(tc.form(code)); err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/users/password/reset", bytes.NewReader(buf.Bytes()))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+
+			if tc.expectLookup || tc.expectVerify {
+				fix.userRepo.EXPECT().GetByPhoneNumber(gomock.Any(), phoneNumber).Return(storedUser, nil)
+			}
+
+			if tc.expectVerify {
+				fix.otpRepo.EXPECT().GetOTP(gomock.Any(), otp.PurposePasswordReset, phoneNumber).Return(storedOTP, nil)
+				fix.otpRepo.EXPECT().IncrementOTPAttempts(gomock.Any(), storedOTP.ID()).Return(tc.attempts, nil)
+			}
+
+			if tc.expectConsume {
+				fix.otpRepo.EXPECT().ConsumeOTP(gomock.Any(), storedOTP.ID(), gomock.Any()).Return(true, nil)
+				fix.passwordHistoryRepo.EXPECT().GetPasswordHistory(gomock.Any(), storedUser.ID(), 4).Return(nil, nil)
+			}
+
+			if tc.expectStatusCode == http.StatusNoContent {
+				fix.passwordHistoryRepo.EXPECT().UpdatePassword(gomock.Any(), storedUser, gomock.Any(), 4).Return(nil)
+				fix.tokenRevocationRepo.EXPECT().RevokeUserAccessTokens(gomock.Any(), storedUser.ID(), gomock.Any()).Return(nil)
+				fix.refreshTokenRepo.EXPECT().RevokeUserRefreshTokens(gomock.Any(), storedUser.ID(), gomock.Any()).Return(nil)
+			}
+
+			c := echo.New().NewContext(req, rec)
+			err = fix.svr.ResetPassword(c)
+
+			// Then
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := rec.Code, tc.expectStatusCode; got != want {
+				t.Fatalf("statusCode got %d, want %d", got, want)
+			}
+
+			if rec.Code == http.StatusNoContent && !storedUser.VerifyPassword(newPassword) {
+				t.Fatal("new password is not applied")
+			}
+
+			if rec.Code == http.StatusBadRequest {
+				var resErrs []generated.FieldError
+				if err := json.NewDecoder(rec.Body).Decode(&resErrs); err != nil {
+					t.Fatal(err)
+				}
+
+				for fieldName, errCodes := range tc.expectContainsError {
+					for _, code := range errCodes {
+						found := false
+						for _, e := range resErrs {
+							if e.Name == fieldName && slices.Contains(e.Codes, code) {
+								found = true
+								break
+							}
+						}
+
+						if !found {
+							t.Fatalf("FieldError not found: want fieldName=%s code=%s, got errors=%+v", fieldName, code, resErrs)
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestRequestLoginOTP(t *testing.T) {
+	now := time.Now()
+	user1, err := user.NewWithPassword(user.NextID(), "+628174546647", "John Doe", "Secret123!")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sentOTP := func(issuedAt time.Time, sendCount int, sendWindowStart time.Time) *otp.OTP {
+		o, _, err := otp.Issue(otp.PurposeLogin, user1.PhoneNumber(), issuedAt, otp.DefaultExpiry)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		o, err = otp.New(o.ID(), o.Purpose(), o.PhoneNumber(), o.CodeHash(), o.IssuedAt(), o.ExpiresAt(), 0, time.Time{}, sendCount, sendWindowStart)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return o
+	}
+
+	testCases := map[string]struct {
+		phoneNumber           string
+		registeredUser        *user.User
+		previousOTP           *otp.OTP
+		expectSent            bool
+		expectSendCount       int
+		expectSendWindowStart time.Time
+		expectStatusCode      int
+		expectContainsError   map[string][]string
+	}{
+		"registered": {
+			phoneNumber:      user1.PhoneNumber(),
+			registeredUser:   user1,
+			expectSent:       true,
+			expectSendCount:  1,
+			expectStatusCode: http.StatusAccepted,
+		},
+		"within send window": {
+			phoneNumber:           user1.PhoneNumber(),
+			registeredUser:        user1,
+			previousOTP:           sentOTP(now.Add(-2*time.Minute), 2, now.Add(-10*time.Minute)),
+			expectSent:            true,
+			expectSendCount:       3,
+			expectSendWindowStart: now.Add(-10 * time.Minute),
+			expectStatusCode:      http.StatusAccepted,
+		},
+		"send window over": {
+			phoneNumber:      user1.PhoneNumber(),
+			registeredUser:   user1,
+			previousOTP:      sentOTP(now.Add(-2*time.Minute), otp.DefaultMaxSends, now.Add(-otp.DefaultSendWindow)),
+			expectSent:       true,
+			expectSendCount:  1,
+			expectStatusCode: http.StatusAccepted,
+		},
+		"send limit reached": {
+			phoneNumber:      user1.PhoneNumber(),
+			registeredUser:   user1,
+			previousOTP:      sentOTP(now.Add(-2*time.Minute), otp.DefaultMaxSends, now.Add(-10*time.Minute)),
+			expectStatusCode: http.StatusAccepted,
+		},
+		"resent too soon": {
+			phoneNumber:      user1.PhoneNumber(),
+			registeredUser:   user1,
+			previousOTP:      sentOTP(now, 1, now),
+			expectStatusCode: http.StatusAccepted,
+		},
+		"not registered": {
+			phoneNumber:      "+628174546648",
+			expectStatusCode: http.StatusAccepted,
+		},
+		"invalid phone number": {
+			phoneNumber:      "+618174546647",
+			expectStatusCode: http.StatusBadRequest,
+			expectContainsError: map[string][]string{
+				"phoneNumber": {"PHONE_NUMBER_FORMAT"},
+			},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			// Given
+			fix := setup(t)
+			defer fix.tearDown()
+
+			// When
+			var buf bytes.Buffer
+			if err := json.NewEncoder(&buf).Encode(generated.OTPLoginRequestForm{PhoneNumber: tc.phoneNumber}); err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/users/login/otp/request", bytes.NewReader(buf.Bytes()))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+
+			if len(tc.expectContainsError) == 0 {
+				fix.userRepo.EXPECT().GetByPhoneNumber(gomock.Any(), tc.phoneNumber).Return(tc.registeredUser, nil)
+			}
+
+			if tc.registeredUser != nil {
+				fix.otpRepo.EXPECT().GetOTP(gomock.Any(), otp.PurposeLogin, tc.phoneNumber).Return(tc.previousOTP, nil)
+			}
+
+			var storedOTP *otp.OTP
+			if tc.expectSent {
+				fix.otpRepo.EXPECT().StoreOTP(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, o *otp.OTP) error {
+					storedOTP = o
+					return nil
+				})
+			}
+
+			c := echo.New().NewContext(req, rec)
+			err = fix.svr.RequestLoginOTP(c)
+
+			// Then
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := rec.Code, tc.expectStatusCode; got != want {
+				t.Fatalf("statusCode got %d, want %d", got, want)
+			}
+
+			code := fix.sentCode(tc.phoneNumber)
+			if !tc.expectSent {
+				if code != "" {
+					t.Fatalf("code sent %s, want none", code)
+				}
+
+				return
+			}
+
+			if got, want := storedOTP.Purpose(), otp.PurposeLogin; got != want {
+				t.Fatalf("purpose got %s, want %s", got, want)
+			}
+
+			if !storedOTP.Matches(code) {
+				t.Fatalf("sent code %q does not match the stored one", code)
+			}
+
+			if got, want := storedOTP.SendCount(), tc.expectSendCount; got != want {
+				t.Fatalf("sendCount got %d, want %d", got, want)
+			}
+
+			expectSendWindowStart := tc.expectSendWindowStart
+			if expectSendWindowStart.IsZero() {
+				expectSendWindowStart = storedOTP.IssuedAt()
+			}
+
+			if got, want := storedOTP.SendWindowStart(), expectSendWindowStart; !got.Equal(want) {
+				t.Fatalf("sendWindowStart got %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestLoginWithOTP(t *testing.T) {
+	now := time.Now()
+	sourceIP := "192.0.2.1"
+	phoneNumber := "+628174546647"
+	maxFailures := lockout.DefaultUserPolicy().MaxFailures
+
+	newCounter := func(key string, failures int, lastFailureAt, lockedUntil time.Time) *lockout.Counter {
+		c, err := lockout.New(key, failures, lastFailureAt, lockedUntil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return c
+	}
+
+	testCases := map[string]struct {
+		registered       bool
+		wrongCode        bool
+		attempts         int
+		userFailures     int
+		userLocked       bool
+		mfaEnabled       bool
+		expectStatusCode int
+	}{
+		"success": {
+			registered:       true,
+			attempts:         1,
+			expectStatusCode: http.StatusOK,
+		},
+		"success resets failures": {
+			registered:       true,
+			attempts:         1,
+			userFailures:     2,
+			expectStatusCode: http.StatusOK,
+		},
+		"mfa required": {
+			registered:       true,
+			attempts:         1,
+			mfaEnabled:       true,
+			expectStatusCode: http.StatusAccepted,
+		},
+		"wrong code": {
+			registered:       true,
+			wrongCode:        true,
+			attempts:         1,
+			expectStatusCode: http.StatusBadRequest,
+		},
+		"attempts exceeded": {
+			registered:       true,
+			attempts:         otp.DefaultMaxAttempts + 1,
+			expectStatusCode: http.StatusBadRequest,
+		},
+		"wrong code locks user": {
+			registered:       true,
+			wrongCode:        true,
+			attempts:         1,
+			userFailures:     maxFailures - 1,
+			expectStatusCode: http.StatusBadRequest,
+		},
+		"user locked": {
+			registered:       true,
+			userFailures:     maxFailures,
+			userLocked:       true,
+			expectStatusCode: http.StatusBadRequest,
+		},
+		"not registered": {
+			expectStatusCode: http.StatusBadRequest,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			// Given
+			fix := setup(t)
+			defer fix.tearDown()
+
+			usr, err := user.NewWithPassword(user.NextID(), phoneNumber, "John Doe", "Secret123!")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			storedOTP, code, err := otp.Issue(otp.PurposeLogin, phoneNumber, now, otp.DefaultExpiry)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tc.wrongCode {
+				code = "x" + code
+			}
+
+			userKey := lockout.UserKey(usr.ID())
+			ipKey := lockout.IPKey(sourceIP)
+			var userCounter *lockout.Counter
+			if tc.userFailures > 0 {
+				var lockedUntil time.Time
+				if tc.userLocked {
+					lockedUntil = now.Add(time.Minute)
+				}
+
+				userCounter = newCounter(userKey, tc.userFailures, now.Add(-time.Minute), lockedUntil)
+			}
+
+			// When
+			var buf bytes.Buffer
+			if err := json.NewEncoder(&buf).Encode(generated.OTPLoginForm{PhoneNumber: phoneNumber, Code: code}); err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/users/login/otp/verify", bytes.NewReader(buf.Bytes()))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			req.RemoteAddr = sourceIP + ":54321"
+			rec := httptest.NewRecorder()
+
+			fix.loginFailureRepo.EXPECT().GetLoginFailures(gomock.Any(), ipKey).Return(nil, nil)
+			if !tc.registered {
+				fix.userRepo.EXPECT().GetByPhoneNumber(gomock.Any(), phoneNumber).Return(nil, nil)
+				fix.loginFailureRepo.EXPECT().IncrementLoginFailures(gomock.Any(), ipKey, gomock.Any(), gomock.Any()).Return(newCounter(ipKey, 1, now, time.Time{}), nil)
+			} else {
+				fix.userRepo.EXPECT().GetByPhoneNumber(gomock.Any(), phoneNumber).Return(usr, nil)
+				fix.loginFailureRepo.EXPECT().GetLoginFailures(gomock.Any(), userKey).Return(userCounter, nil)
+			}
+
+			verified := tc.registered && !tc.userLocked
+			if verified {
+				fix.otpRepo.EXPECT().GetOTP(gomock.Any(), otp.PurposeLogin, phoneNumber).Return(storedOTP, nil)
+				fix.otpRepo.EXPECT().IncrementOTPAttempts(gomock.Any(), storedOTP.ID()).Return(tc.attempts, nil)
+			}
+
+			if verified && !tc.wrongCode && tc.attempts <= otp.DefaultMaxAttempts {
+				fix.otpRepo.EXPECT().ConsumeOTP(gomock.Any(), storedOTP.ID(), gomock.Any()).Return(true, nil)
+			}
+
+			if verified && tc.expectStatusCode == http.StatusBadRequest {
+				failures := tc.userFailures + 1
+				fix.loginFailureRepo.EXPECT().IncrementLoginFailures(gomock.Any(), userKey, gomock.Any(), gomock.Any()).Return(newCounter(userKey, failures, now, time.Time{}), nil)
+				fix.loginFailureRepo.EXPECT().IncrementLoginFailures(gomock.Any(), ipKey, gomock.Any(), gomock.Any()).Return(newCounter(ipKey, 1, now, time.Time{}), nil)
+				if failures >= maxFailures {
+					fix.loginFailureRepo.EXPECT().LockLogin(gomock.Any(), userKey, gomock.Any()).Return(nil)
+				}
+			}
+
+			// The failures are only cleared once the login completes
+			if tc.expectStatusCode == http.StatusOK && userCounter != nil {
+				fix.loginFailureRepo.EXPECT().ResetLoginFailures(gomock.Any(), userKey).Return(nil)
+			}
+
+			var storedRefreshToken *token.RefreshToken
+			if tc.expectStatusCode == http.StatusOK {
+				fix.loginFailureRepo.EXPECT().GetLoginFailures(gomock.Any(), userKey).Return(userCounter, nil)
+				fix.totpRepo.EXPECT().GetTOTP(gomock.Any(), usr.ID()).Return(nil, nil)
+				fix.refreshTokenRepo.EXPECT().StoreRefreshToken(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, rt *token.RefreshToken) error {
+					storedRefreshToken = rt
+					return nil
+				})
+			}
+
+			if tc.mfaEnabled {
+				totp, err := mfa.Enroll(usr.ID(), now.Add(-time.Hour))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				totp, err = mfa.NewTOTP(usr.ID(), totp.Secret(), totp.CreatedAt(), now.Add(-time.Hour), 0)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				fix.totpRepo.EXPECT().GetTOTP(gomock.Any(), usr.ID()).Return(totp, nil)
+				fix.mfaChallengeRepo.EXPECT().StoreMFAChallenge(gomock.Any(), gomock.Any()).Return(nil)
+			}
+
+			c := echo.New().NewContext(req, rec)
+			err = fix.svr.LoginWithOTP(c)
+
+			// Then
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := rec.Code, tc.expectStatusCode; got != want {
+				t.Fatalf("statusCode got %d, want %d", got, want)
+			}
+
+			if tc.expectStatusCode != http.StatusOK {
+				return
+			}
+
+			var res generated.LoginResponse
+			if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := res.Id, usr.ID(); got != want {
+				t.Fatalf("id got %s, want %s", got, want)
+			}
+
+			if got := res.AccessToken; got == "" {
+				t.Fatalf("accessToken got %s, want not empty", got)
+			}
+
+			if got, want := storedRefreshToken.Hash(), token.Hash(res.RefreshToken); !bytes.Equal(got, want) {
+				t.Fatalf("refreshToken hash got %x, want %x", got, want)
+			}
+		})
+	}
+}
+
+func TestSendPhoneVerification(t *testing.T) {
+	testCases := map[string]struct {
+		phoneVerifiedAt    time.Time
+		pendingPhoneNumber string
+		expectSentTo       string
+		expectStatusCode   int
+	}{
+		"unverified phone number": {
+			expectSentTo:     "+628174546647",
+			expectStatusCode: http.StatusAccepted,
+		},
+		"pending phone number": {
+			phoneVerifiedAt:    time.Now(),
+			pendingPhoneNumber: "+628174546648",
+			expectSentTo:       "+628174546648",
+			expectStatusCode:   http.StatusAccepted,
+		},
+		"already verified": {
+			phoneVerifiedAt:  time.Now(),
+			expectStatusCode: http.StatusConflict,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			// Given
+			fix := setup(t)
+			defer fix.tearDown()
+
+			registered, err := user.NewWithPassword(user.NextID(), "+628174546647", "John Doe", "Secret123!")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			pwdHash, pwdSalt := registered.Password()
+			storedUser, err := user.New(registered.ID(), registered.PhoneNumber(), registered.FullName(), pwdHash, pwdSalt, registered.PasswordPepperID(), tc.phoneVerifiedAt, tc.pendingPhoneNumber)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tokenCreator := &TokenCreator{
+				KeyRing: testKeyRing,
+			}
+
+			accessToken, err := tokenCreator.CreateAccessToken(storedUser)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// When
+			req := httptest.NewRequest(http.MethodPost, "/users/me/phone/verification", nil)
+			req.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", accessToken))
+			rec := httptest.NewRecorder()
+
+			fix.tokenRevocationRepo.EXPECT().AccessTokenRevoked(gomock.Any(), gomock.Any(), storedUser.ID(), gomock.Any()).Return(false, nil)
+			fix.userRepo.EXPECT().GetByID(gomock.Any(), storedUser.ID()).Return(storedUser, nil)
+
+			var storedOTP *otp.OTP
+			if tc.expectSentTo != "" {
+				fix.otpRepo.EXPECT().GetOTP(gomock.Any(), otp.PurposePhoneVerification, tc.expectSentTo).Return(nil, nil)
+				fix.otpRepo.EXPECT().StoreOTP(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, o *otp.OTP) error {
+					storedOTP = o
+					return nil
+				})
+			}
+
+			c := echo.New().NewContext(req, rec)
+			err = fix.svr.SendPhoneVerification(c)
+
+			// Then
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := rec.Code, tc.expectStatusCode; got != want {
+				t.Fatalf("statusCode got %d, want %d", got, want)
+			}
+
+			if tc.expectSentTo == "" {
+				return
+			}
+
+			if code := fix.sentCode(tc.expectSentTo); !storedOTP.Matches(code) {
+				t.Fatalf("sent code %q does not match the stored one", code)
+			}
+		})
+	}
+}
+
+func TestConfirmPhoneVerification(t *testing.T) {
+	testCases := map[string]struct {
+		phoneVerifiedAt    time.Time
+		pendingPhoneNumber string
+		wrongCode          bool
+		takenBy            *user.User
+		expectPhoneNumber  string
+		expectStatusCode   int
+	}{
+		"unverified phone number": {
+			expectPhoneNumber: "+628174546647",
+			expectStatusCode:  http.StatusNoContent,
+		},
+		"pending phone number": {
+			phoneVerifiedAt:    time.Now(),
+			pendingPhoneNumber: "+628174546648",
+			expectPhoneNumber:  "+628174546648",
+			expectStatusCode:   http.StatusNoContent,
+		},
+		"pending phone number taken meanwhile": {
+			phoneVerifiedAt:    time.Now(),
+			pendingPhoneNumber: "+628174546648",
+			takenBy: func() *user.User {
+				u, err := user.NewWithPassword(user.NextID(), "+628174546648", "Jane Doe", "Secret123!")
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				return u
+			}(),
+			expectPhoneNumber: "+628174546647",
+			expectStatusCode:  http.StatusConflict,
+		},
+		"wrong code": {
+			wrongCode:         true,
+			expectPhoneNumber: "+628174546647",
+			expectStatusCode:  http.StatusBadRequest,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			// Given
+			fix := setup(t)
+			defer fix.tearDown()
+
+			registered, err := user.NewWithPassword(user.NextID(), "+628174546647", "John Doe", "Secret123!")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			pwdHash, pwdSalt := registered.Password()
+			storedUser, err := user.New(registered.ID(), registered.PhoneNumber(), registered.FullName(), pwdHash, pwdSalt, registered.PasswordPepperID(), tc.phoneVerifiedAt, tc.pendingPhoneNumber)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			phoneNumberToVerify := storedUser.PhoneNumberToVerify()
+			storedOTP, code, err := otp.Issue(otp.PurposePhoneVerification, phoneNumberToVerify, time.Now(), otp.DefaultExpiry)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tc.wrongCode {
+				code = "x" + code
+			}
+
+			tokenCreator := &TokenCreator{
+				KeyRing: testKeyRing,
+			}
+
+			accessToken, err := tokenCreator.CreateAccessToken(storedUser)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// When
+			var buf bytes.Buffer
+			if err := json.NewEncoder(&buf).Encode(generated.PhoneVerificationForm{Code: code}); err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/users/me/phone/verification/confirm", bytes.NewReader(buf.Bytes()))
+			req.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", accessToken))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+
+			fix.tokenRevocationRepo.EXPECT().AccessTokenRevoked(gomock.Any(), gomock.Any(), storedUser.ID(), gomock.Any()).Return(false, nil)
+			fix.userRepo.EXPECT().GetByID(gomock.Any(), storedUser.ID()).Return(storedUser, nil)
+			fix.otpRepo.EXPECT().GetOTP(gomock.Any(), otp.PurposePhoneVerification, phoneNumberToVerify).Return(storedOTP, nil)
+			fix.otpRepo.EXPECT().IncrementOTPAttempts(gomock.Any(), storedOTP.ID()).Return(1, nil)
+
+			if !tc.wrongCode {
+				fix.otpRepo.EXPECT().ConsumeOTP(gomock.Any(), storedOTP.ID(), gomock.Any()).Return(true, nil)
+			}
+
+			if !tc.wrongCode && tc.pendingPhoneNumber != "" {
+				fix.userRepo.EXPECT().GetByPhoneNumber(gomock.Any(), tc.pendingPhoneNumber).Return(tc.takenBy, nil)
+			}
+
+			if tc.expectStatusCode == http.StatusNoContent {
+				fix.userRepo.EXPECT().Update(gomock.Any(), storedUser).Return(nil)
+			}
+
+			c := echo.New().NewContext(req, rec)
+			err = fix.svr.ConfirmPhoneVerification(c)
+
+			// Then
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := rec.Code, tc.expectStatusCode; got != want {
+				t.Fatalf("statusCode got %d, want %d", got, want)
+			}
+
+			if got, want := storedUser.PhoneNumber(), tc.expectPhoneNumber; got != want {
+				t.Fatalf("phoneNumber got %s, want %s", got, want)
+			}
+
+			if rec.Code != http.StatusNoContent {
+				return
+			}
+
+			if !storedUser.PhoneVerified() {
+				t.Fatal("phone number unverified, want verified")
+			}
+
+			if got := storedUser.PendingPhoneNumber(); got != "" {
+				t.Fatalf("pendingPhoneNumber got %s, want empty", got)
+			}
+		})
+	}
+}
+
+func TestGetUserLoginLock(t *testing.T) {
+	user1, err := user.NewWithPassword(user.NextID(), "+628174546647", "John Doe", "Secret123!")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	lockedCounter, err := lockout.New(lockout.UserKey(user1.ID()), 5, now, now.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := map[string]struct {
+		adminKey         string
+		returnedUser     *user.User
+		counter          *lockout.Counter
+		expectLocked     bool
+		expectFailures   int
+		expectStatusCode int
+	}{
+		"locked": {
+			adminKey:         testAdminKey,
+			returnedUser:     user1,
+			counter:          lockedCounter,
+			expectLocked:     true,
+			expectFailures:   5,
+			expectStatusCode: http.StatusOK,
+		},
+		"no failures": {
+			adminKey:         testAdminKey,
+			returnedUser:     user1,
+			expectStatusCode: http.StatusOK,
+		},
+		"user not found": {
+			adminKey:         testAdminKey,
+			expectStatusCode: http.StatusNotFound,
+		},
+		"invalid admin key": {
+			adminKey:         "guess",
+			expectStatusCode: http.StatusForbidden,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			// Given
+			fix := setup(t)
+			defer fix.tearDown()
+
+			// When
+			req := httptest.NewRequest(http.MethodGet, "/admin/users/"+user1.ID()+"/login-lock", nil)
+			req.Header.Set("X-Admin-Key", tc.adminKey)
+			rec := httptest.NewRecorder()
+
+			if tc.adminKey == testAdminKey {
+				fix.userRepo.EXPECT().GetByID(gomock.Any(), user1.ID()).Return(tc.returnedUser, nil)
+			}
+
+			if tc.returnedUser != nil {
+				fix.loginFailureRepo.EXPECT().GetLoginFailures(gomock.Any(), lockout.UserKey(user1.ID())).Return(tc.counter, nil)
+			}
+
+			c := echo.New().NewContext(req, rec)
+			err = fix.svr.GetUserLoginLock(c, user1.ID())
+
+			// Then
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := rec.Code, tc.expectStatusCode; got != want {
+				t.Fatalf("statusCode got %d, want %d", got, want)
+			}
+
+			if rec.Code != http.StatusOK {
+				return
+			}
+
+			var res generated.LoginLockState
+			if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := res.Locked, tc.expectLocked; got != want {
+				t.Fatalf("locked got %t, want %t", got, want)
+			}
+
+			if got, want := res.FailedAttempts, tc.expectFailures; got != want {
+				t.Fatalf("failedAttempts got %d, want %d", got, want)
+			}
+
+			if got, want := res.LockedUntil != nil, tc.expectLocked; got != want {
+				t.Fatalf("lockedUntil set got %t, want %t", got, want)
+			}
+		})
+	}
+}
+
+func strPtr(s string) *string {
+	return &s
+}
+
+func TestGetPasswordPolicy(t *testing.T) {
+	testCases := map[string]struct {
+		policy       user.PasswordPolicy
+		expectPolicy generated.PasswordPolicy
+	}{
+		"default": {
+			policy: user.DefaultPasswordPolicy(),
+			expectPolicy: generated.PasswordPolicy{
+				MinLength:               6,
+				MaxLength:               64,
+				RequireUppercase:        true,
+				RequireDigit:            true,
+				RequireSpecialCharacter: true,
+				RejectPersonalInfo:      true,
+				HistorySize:             5,
+			},
+		},
+		"passphrase": {
+			policy: user.PasswordPolicy{
+				MinLength:        12,
+				MaxLength:        128,
+				RequireLowercase: true,
+				MinScore:         3,
+			},
+			expectPolicy: generated.PasswordPolicy{
+				MinLength:        12,
+				MaxLength:        128,
+				RequireLowercase: true,
+				MinScore:         3,
+			},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			// Given
+			fix := setup(t)
+			defer fix.tearDown()
+
+			if err := user.SetPasswordPolicy(tc.policy); err != nil {
+				t.Fatal(err)
+			}
+			defer user.SetPasswordPolicy(user.DefaultPasswordPolicy())
+
+			// When
+			req := httptest.NewRequest(http.MethodGet, "/password-policy", nil)
+			rec := httptest.NewRecorder()
+
+			c := echo.New().NewContext(req, rec)
+			err := fix.svr.GetPasswordPolicy(c)
+
+			// Then
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := rec.Code, http.StatusOK; got != want {
+				t.Fatalf("statusCode got %d, want %d", got, want)
+			}
+
+			var res generated.PasswordPolicy
+			if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := res, tc.expectPolicy; got != want {
+				t.Fatalf("policy got %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestLoginRequiresMFA(t *testing.T) {
+	// Given
+	fix := setup(t)
+	defer fix.tearDown()
+
+	usrPassword := "Secret123!"
+	usr, err := user.NewWithPassword(user.NextID(), "+628174546647", "John Doe", usrPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	totp, err := mfa.Enroll(usr.ID(), time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	totp, err = mfa.NewTOTP(usr.ID(), totp.Secret(), totp.CreatedAt(), time.Now().Add(-time.Hour), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// When
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(generated.UserCredentials{PhoneNumber: usr.PhoneNumber(), Password: usrPassword}); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/users/login", bytes.NewReader(buf.Bytes()))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+
+	fix.loginFailureRepo.EXPECT().GetLoginFailures(gomock.Any(), gomock.Any()).Return(nil, nil).Times(2)
+	fix.userRepo.EXPECT().GetByPhoneNumber(gomock.Any(), usr.PhoneNumber()).Return(usr, nil)
+	fix.totpRepo.EXPECT().GetTOTP(gomock.Any(), usr.ID()).Return(totp, nil)
+
+	var storedChallenge *mfa.Challenge
+	fix.mfaChallengeRepo.EXPECT().StoreMFAChallenge(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, c *mfa.Challenge) error {
+		storedChallenge = c
+		return nil
+	})
+
+	c := echo.New().NewContext(req, rec)
+	err = fix.svr.Login(c)
+
+	// Then
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := rec.Code, http.StatusAccepted; got != want {
+		t.Fatalf("statusCode got %d, want %d", got, want)
+	}
+
+	var res generated.MFAChallenge
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := storedChallenge.TokenHash(), mfa.HashChallengeToken(res.MfaToken); !bytes.Equal(got, want) {
+		t.Fatalf("mfaToken hash got %x, want %x", got, want)
+	}
+
+	if got, want := storedChallenge.UserID(), usr.ID(); got != want {
+		t.Fatalf("challenge userID got %s, want %s", got, want)
+	}
+
+	if got, want := res.ExpiresAt.Unix(), storedChallenge.ExpiresAt().Unix(); got != want {
+		t.Fatalf("expiresAt got %d, want %d", got, want)
+	}
+}
+
+func TestLoginMFA(t *testing.T) {
+	// The codes are computed once, leave room to check them all within the
+	// period they were computed in
+	if now := time.Now(); mfa.Step(now.Add(time.Second)) != mfa.Step(now) {
+		time.Sleep(time.Second)
+	}
+
+	now := time.Now()
+	secret := []byte("12345678901234567890")
+	recoveryCode := "abcde-fghjk"
+
+	testCases := map[string]struct {
+		code              string
+		recoveryCode      string
+		lastUsedStep      int64
+		challengeExpired  bool
+		challengeAttempts int
+		totpDisabled      bool
+		recoveryCodeUsed  bool
+		userLocked        bool
+		expectField       string
+		expectErrCode     string
+		expectStatusCode  int
+	}{
+		"valid code": {
+			code:             mfa.Code(secret, mfa.Step(now)),
+			expectStatusCode: http.StatusOK,
+		},
+		"code of the previous period": {
+			code:             mfa.Code(secret, mfa.Step(now)-1),
+			expectStatusCode: http.StatusOK,
+		},
+		"code too old": {
+			code:             mfa.Code(secret, mfa.Step(now)-3),
+			expectField:      "code",
+			expectErrCode:    errCodeMFACodeInvalid,
+			expectStatusCode: http.StatusBadRequest,
+		},
+		"code already used": {
+			code:             mfa.Code(secret, mfa.Step(now)),
+			lastUsedStep:     mfa.Step(now) + 1,
+			expectField:      "code",
+			expectErrCode:    errCodeMFACodeInvalid,
+			expectStatusCode: http.StatusBadRequest,
+		},
+		"valid recovery code": {
+			recoveryCode:     "ABCDE FGHJK",
+			expectStatusCode: http.StatusOK,
+		},
+		"recovery code already used": {
+			recoveryCode:     recoveryCode,
+			recoveryCodeUsed: true,
+			expectField:      "recoveryCode",
+			expectErrCode:    errCodeMFACodeInvalid,
+			expectStatusCode: http.StatusBadRequest,
+		},
+		"missing code": {
+			expectField:      "code",
+			expectErrCode:    errCodeMFACodeInvalid,
+			expectStatusCode: http.StatusBadRequest,
+		},
+		"expired challenge": {
+			code:             mfa.Code(secret, mfa.Step(now)),
+			challengeExpired: true,
+			expectField:      "mfaToken",
+			expectErrCode:    errCodeMFATokenInvalid,
+			expectStatusCode: http.StatusBadRequest,
+		},
+		"attempts exceeded": {
+			code:              mfa.Code(secret, mfa.Step(now)),
+			challengeAttempts: mfa.DefaultChallengeMaxAttempts,
+			expectField:       "mfaToken",
+			expectErrCode:     errCodeMFAAttemptsExceeded,
+			expectStatusCode:  http.StatusBadRequest,
+		},
+		"totp disabled meanwhile": {
+			code:             mfa.Code(secret, mfa.Step(now)),
+			totpDisabled:     true,
+			expectField:      "mfaToken",
+			expectErrCode:    errCodeMFATokenInvalid,
+			expectStatusCode: http.StatusBadRequest,
+		},
+		"locked user": {
+			code:             mfa.Code(secret, mfa.Step(now)),
+			userLocked:       true,
+			expectField:      "code",
+			expectErrCode:    errCodeMFACodeInvalid,
+			expectStatusCode: http.StatusBadRequest,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			// Given
+			fix := setup(t)
+			defer fix.tearDown()
+
+			usr, err := user.NewWithPassword(user.NextID(), "+628174546647", "John Doe", "Secret123!")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			totp, err := mfa.NewTOTP(usr.ID(), secret, now.Add(-time.Hour), now.Add(-time.Hour), tc.lastUsedStep)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			challenge, mfaToken, err := mfa.IssueChallenge(usr.ID(), now.Add(-time.Minute), mfa.DefaultChallengeExpiry)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tc.challengeExpired {
+				challenge, mfaToken, err = mfa.IssueChallenge(usr.ID(), now.Add(-time.Hour), mfa.DefaultChallengeExpiry)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// When
+			form := generated.MFALoginForm{MfaToken: mfaToken}
+			if tc.code != "" {
+				form.Code = &tc.code
+			}
+
+			if tc.recoveryCode != "" {
+				form.RecoveryCode = &tc.recoveryCode
+			}
+
+			var buf bytes.Buffer
+			if err := json.NewEncoder(&buf).Encode(form); err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/users/login/mfa", bytes.NewReader(buf.Bytes()))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+
+			answered := tc.code != "" || tc.recoveryCode != ""
+			if answered {
+				fix.mfaChallengeRepo.EXPECT().GetMFAChallengeByHash(gomock.Any(), mfa.HashChallengeToken(mfaToken)).Return(challenge, nil)
+			}
+
+			if answered && !tc.challengeExpired {
+				fix.mfaChallengeRepo.EXPECT().IncrementMFAChallengeAttempts(gomock.Any(), challenge.ID()).Return(tc.challengeAttempts+1, nil)
+			}
+
+			attemptsLeft := tc.challengeAttempts < mfa.DefaultChallengeMaxAttempts
+			if answered && !tc.challengeExpired && attemptsLeft {
+				if tc.totpDisabled {
+					fix.totpRepo.EXPECT().GetTOTP(gomock.Any(), usr.ID()).Return(nil, nil)
+				} else {
+					fix.totpRepo.EXPECT().GetTOTP(gomock.Any(), usr.ID()).Return(totp, nil)
+				}
+			}
+
+			userKey := lockout.UserKey(usr.ID())
+			var userCounter *lockout.Counter
+			if tc.userLocked {
+				userCounter, err = lockout.New(userKey, lockout.DefaultUserPolicy().MaxFailures, now, now.Add(time.Minute))
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			codeChecked := answered && !tc.challengeExpired && attemptsLeft && !tc.totpDisabled
+			if codeChecked {
+				fix.loginFailureRepo.EXPECT().GetLoginFailures(gomock.Any(), userKey).Return(userCounter, nil)
+			}
+
+			if codeChecked && !tc.userLocked && tc.recoveryCode != "" {
+				fix.totpRepo.EXPECT().UseRecoveryCode(gomock.Any(), usr.ID(), mfa.HashRecoveryCode(recoveryCode), gomock.Any()).Return(!tc.recoveryCodeUsed, nil)
+			}
+
+			if tc.code != "" && tc.expectStatusCode == http.StatusOK {
+				fix.totpRepo.EXPECT().UseTOTPStep(gomock.Any(), usr.ID(), gomock.Any()).Return(true, nil)
+			}
+
+			if codeChecked && !tc.userLocked && tc.expectStatusCode != http.StatusOK {
+				fix.loginFailureRepo.EXPECT().IncrementLoginFailures(gomock.Any(), userKey, gomock.Any(), gomock.Any()).Return(lockout.New(userKey, 1, now, time.Time{}))
+			}
+
+			if tc.expectStatusCode == http.StatusOK {
+				fix.mfaChallengeRepo.EXPECT().ConsumeMFAChallenge(gomock.Any(), challenge.ID(), gomock.Any()).Return(true, nil)
+				fix.loginFailureRepo.EXPECT().GetLoginFailures(gomock.Any(), userKey).Return(nil, nil)
+				fix.userRepo.EXPECT().GetByID(gomock.Any(), usr.ID()).Return(usr, nil)
+				fix.refreshTokenRepo.EXPECT().StoreRefreshToken(gomock.Any(), gomock.Any()).Return(nil)
+			}
+
+			c := echo.New().NewContext(req, rec)
+			err = fix.svr.LoginMFA(c)
+
+			// Then
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := rec.Code, tc.expectStatusCode; got != want {
+				t.Fatalf("statusCode got %d, want %d", got, want)
+			}
+
+			if tc.expectStatusCode == http.StatusOK {
+				var res generated.LoginResponse
+				if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+					t.Fatal(err)
+				}
+
+				if got, want := res.Id, usr.ID(); got != want {
+					t.Fatalf("id got %s, want %s", got, want)
+				}
+
+				return
+			}
+
+			var fieldErrs []generated.FieldError
+			if err := json.NewDecoder(rec.Body).Decode(&fieldErrs); err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := fieldErrs, []generated.FieldError{{Name: tc.expectField, Codes: []string{tc.expectErrCode}}}; !slices.EqualFunc(got, want, func(a, b generated.FieldError) bool {
+				return a.Name == b.Name && slices.Equal(a.Codes, b.Codes)
+			}) {
+				t.Fatalf("errors got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestLoginMFALocksUserAcrossChallenges(t *testing.T) {
+	// Given
+	ctx := context.Background()
+	repo := repository.NewMemoryRepository()
+	svr := newStorageServer(t, repo)
+	policy := lockout.Policy{MaxFailures: 3, LockDuration: time.Hour}
+	svr.AuthService.UserLockoutPolicy = policy
+
+	usrPassword := "Secret123!"
+	usr, err := user.NewWithPassword(user.NextID(), "+628174546647", "John Doe", usrPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.Store(ctx, usr); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	totp, err := mfa.Enroll(usr.ID(), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.StoreTOTP(ctx, totp); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := repo.ConfirmTOTP(ctx, usr.ID(), now, mfa.Step(now)-2, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	login := func(password string) *httptest.ResponseRecorder {
+		var buf bytes.Buffer
+		if err := json.NewEncoder(&buf).Encode(generated.UserCredentials{PhoneNumber: usr.PhoneNumber(), Password: password}); err != nil {
+			t.Fatal(err)
+		}
+
+		req := httptest.NewRequest(http.MethodPost, "/users/login", bytes.NewReader(buf.Bytes()))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		if err := svr.Login(echo.New().NewContext(req, rec)); err != nil {
+			t.Fatal(err)
+		}
+
+		return rec
+	}
+
+	challenge := func() string {
+		rec := login(usrPassword)
+		if got, want := rec.Code, http.StatusAccepted; got != want {
+			t.Fatalf("login statusCode got %d, want %d", got, want)
+		}
+
+		var res generated.MFAChallenge
+		if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+			t.Fatal(err)
+		}
+
+		return res.MfaToken
+	}
+
+	answer := func(mfaToken, code string) int {
+		var buf bytes.Buffer
+		if err := json.NewEncoder(&buf).Encode(generated.MFALoginForm{MfaToken: mfaToken, Code: &code}); err != nil {
+			t.Fatal(err)
+		}
+
+		req := httptest.NewRequest(http.MethodPost, "/users/login/mfa", bytes.NewReader(buf.Bytes()))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		if err := svr.LoginMFA(echo.New().NewContext(req, rec)); err != nil {
+			t.Fatal(err)
+		}
+
+		return rec.Code
+	}
+
+	pending := challenge()
+
+	// When a wrong code is tried on as many fresh challenges as allowed
+	for i := 0; i < policy.MaxFailures; i++ {
+		if got, want := answer(challenge(), "000000"), http.StatusBadRequest; got != want {
+			t.Fatalf("wrong code statusCode got %d, want %d", got, want)
+		}
+	}
+
+	// Then
+	if got, want := login(usrPassword).Code, http.StatusBadRequest; got != want {
+		t.Fatalf("login statusCode got %d, want %d", got, want)
+	}
+
+	if got, want := answer(pending, mfa.Code(totp.Secret(), mfa.Step(time.Now()))), http.StatusBadRequest; got != want {
+		t.Fatalf("valid code statusCode got %d, want %d", got, want)
+	}
+}
+
+func TestEnrollTOTP(t *testing.T) {
+	testCases := map[string]struct {
+		enrolled         bool
+		confirmed        bool
+		expectStatusCode int
+	}{
+		"not enrolled": {
+			expectStatusCode: http.StatusOK,
+		},
+		"enrolled but not confirmed": {
+			enrolled:         true,
+			expectStatusCode: http.StatusOK,
+		},
+		"already enabled": {
+			enrolled:         true,
+			confirmed:        true,
+			expectStatusCode: http.StatusConflict,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			// Given
+			fix := setup(t)
+			defer fix.tearDown()
+
+			usr, err := user.NewWithPassword(user.NextID(), "+628174546647", "John Doe", "Secret123!")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var prev *mfa.TOTP
+			if tc.enrolled {
+				var confirmedAt time.Time
+				if tc.confirmed {
+					confirmedAt = time.Now()
+				}
+
+				prev, err = mfa.NewTOTP(usr.ID(), []byte("12345678901234567890"), time.Now(), confirmedAt, 0)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			tokenCreator := &TokenCreator{
+				KeyRing: testKeyRing,
+			}
+
+			accessToken, err := tokenCreator.CreateAccessToken(usr)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// When
+			req := httptest.NewRequest(http.MethodPost, "/users/me/totp", nil)
+			req.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", accessToken))
+			rec := httptest.NewRecorder()
+
+			fix.tokenRevocationRepo.EXPECT().AccessTokenRevoked(gomock.Any(), gomock.Any(), usr.ID(), gomock.Any()).Return(false, nil)
+			fix.userRepo.EXPECT().GetByID(gomock.Any(), usr.ID()).Return(usr, nil)
+			fix.totpRepo.EXPECT().GetTOTP(gomock.Any(), usr.ID()).Return(prev, nil)
+
+			var stored *mfa.TOTP
+			if tc.expectStatusCode == http.StatusOK {
+				fix.totpRepo.EXPECT().StoreTOTP(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, t *mfa.TOTP) error {
+					stored = t
+					return nil
+				})
+			}
+
+			c := echo.New().NewContext(req, rec)
+			err = fix.svr.EnrollTOTP(c)
+
+			// Then
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := rec.Code, tc.expectStatusCode; got != want {
+				t.Fatalf("statusCode got %d, want %d", got, want)
+			}
+
+			if rec.Code != http.StatusOK {
+				return
+			}
+
+			var res generated.TOTPEnrollment
+			if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+				t.Fatal(err)
+			}
+
+			if stored.Confirmed() {
+				t.Fatal("stored totp confirmed, want unconfirmed")
+			}
+
+			if got, want := res.Secret, stored.EncodedSecret(); got != want {
+				t.Fatalf("secret got %s, want %s", got, want)
+			}
+
+			if prev != nil && bytes.Equal(stored.Secret(), prev.Secret()) {
+				t.Fatal("secret not replaced")
+			}
+
+			wantURI := "otpauth://totp/UserService%3A%2B628174546647?algorithm=SHA1&digits=6&issuer=UserService&period=30&secret=" + res.Secret
+			if got := res.Uri; got != wantURI {
+				t.Fatalf("uri got %s, want %s", got, wantURI)
+			}
+		})
+	}
+}
+
+func TestConfirmTOTP(t *testing.T) {
+	now := time.Now()
+	secret := []byte("12345678901234567890")
+
+	testCases := map[string]struct {
+		enrolled         bool
+		confirmed        bool
+		code             string
+		expectStatusCode int
+	}{
+		"valid code": {
+			enrolled:         true,
+			code:             mfa.Code(secret, mfa.Step(now)),
+			expectStatusCode: http.StatusOK,
+		},
+		"wrong code": {
+			enrolled:         true,
+			code:             "x" + mfa.Code(secret, mfa.Step(now)),
+			expectStatusCode: http.StatusBadRequest,
+		},
+		"not enrolled": {
+			code:             mfa.Code(secret, mfa.Step(now)),
+			expectStatusCode: http.StatusConflict,
+		},
+		"already enabled": {
+			enrolled:         true,
+			confirmed:        true,
+			code:             mfa.Code(secret, mfa.Step(now)),
+			expectStatusCode: http.StatusConflict,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			// Given
+			fix := setup(t)
+			defer fix.tearDown()
+
+			usr, err := user.NewWithPassword(user.NextID(), "+628174546647", "John Doe", "Secret123!")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var stored *mfa.TOTP
+			if tc.enrolled {
+				var confirmedAt time.Time
+				if tc.confirmed {
+					confirmedAt = now
+				}
+
+				stored, err = mfa.NewTOTP(usr.ID(), secret, now, confirmedAt, 0)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			tokenCreator := &TokenCreator{
+				KeyRing: testKeyRing,
+			}
+
+			accessToken, err := tokenCreator.CreateAccessToken(usr)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// When
+			var buf bytes.Buffer
+			if err := json.NewEncoder(&buf).Encode(generated.TOTPConfirmationForm{Code: tc.code}); err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/users/me/totp/confirm", bytes.NewReader(buf.Bytes()))
+			req.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", accessToken))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+
+			fix.tokenRevocationRepo.EXPECT().AccessTokenRevoked(gomock.Any(), gomock.Any(), usr.ID(), gomock.Any()).Return(false, nil)
+			fix.totpRepo.EXPECT().GetTOTP(gomock.Any(), usr.ID()).Return(stored, nil)
+
+			var storedCodeHashes [][]byte
+			if tc.expectStatusCode == http.StatusOK {
+				fix.totpRepo.EXPECT().ConfirmTOTP(gomock.Any(), usr.ID(), gomock.Any(), mfa.Step(now), gomock.Any()).DoAndReturn(func(_ context.Context, userID string, confirmedAt time.Time, step int64, hashes [][]byte) (bool, error) {
+					storedCodeHashes = hashes
+					return true, nil
+				})
+			}
+
+			c := echo.New().NewContext(req, rec)
+			err = fix.svr.ConfirmTOTP(c)
+
+			// Then
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := rec.Code, tc.expectStatusCode; got != want {
+				t.Fatalf("statusCode got %d, want %d", got, want)
+			}
+
+			if rec.Code != http.StatusOK {
+				return
+			}
+
+			var res generated.RecoveryCodes
+			if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := len(res.RecoveryCodes), mfa.RecoveryCodeCount; got != want {
+				t.Fatalf("recoveryCodes got %d, want %d", got, want)
+			}
+
+			for i, code := range res.RecoveryCodes {
+				if got, want := storedCodeHashes[i], mfa.HashRecoveryCode(code); !bytes.Equal(got, want) {
+					t.Fatalf("recoveryCode %d hash got %x, want %x", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestDisableTOTP(t *testing.T) {
+	usrPassword := "Secret123!"
+
+	testCases := map[string]struct {
+		password         string
+		enrolled         bool
+		userLocked       bool
+		expectStatusCode int
+	}{
+		"success": {
+			password:         usrPassword,
+			enrolled:         true,
+			expectStatusCode: http.StatusNoContent,
+		},
+		"wrong password": {
+			password:         usrPassword + "x",
+			enrolled:         true,
+			expectStatusCode: http.StatusBadRequest,
+		},
+		"not enrolled": {
+			password:         usrPassword,
+			expectStatusCode: http.StatusNotFound,
+		},
+		"locked user": {
+			password:         usrPassword,
+			enrolled:         true,
+			userLocked:       true,
+			expectStatusCode: http.StatusBadRequest,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			// Given
+			fix := setup(t)
+			defer fix.tearDown()
+
+			usr, err := user.NewWithPassword(user.NextID(), "+628174546647", "John Doe", usrPassword)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var stored *mfa.TOTP
+			if tc.enrolled {
+				stored, err = mfa.NewTOTP(usr.ID(), []byte("12345678901234567890"), time.Now(), time.Now(), 0)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			tokenCreator := &TokenCreator{
+				KeyRing: testKeyRing,
+			}
+
+			accessToken, err := tokenCreator.CreateAccessToken(usr)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// When
+			var buf bytes.Buffer
+			if err := json.NewEncoder(&buf).Encode(generated.TOTPDisableForm{Password: tc.password}); err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/users/me/totp/disable", bytes.NewReader(buf.Bytes()))
+			req.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", accessToken))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+
+			fix.tokenRevocationRepo.EXPECT().AccessTokenRevoked(gomock.Any(), gomock.Any(), usr.ID(), gomock.Any()).Return(false, nil)
+			fix.userRepo.EXPECT().GetByID(gomock.Any(), usr.ID()).Return(usr, nil)
+
+			// Wrong passwords count toward the lockout of the logins
+			userKey := lockout.UserKey(usr.ID())
+			var userCounter *lockout.Counter
+			if tc.userLocked {
+				userCounter, err = lockout.New(userKey, lockout.DefaultUserPolicy().MaxFailures, time.Now(), time.Now().Add(time.Minute))
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			fix.loginFailureRepo.EXPECT().GetLoginFailures(gomock.Any(), userKey).Return(userCounter, nil)
+			if tc.password != usrPassword {
+				fix.loginFailureRepo.EXPECT().IncrementLoginFailures(gomock.Any(), userKey, gomock.Any(), gomock.Any()).Return(lockout.New(userKey, 1, time.Now(), time.Time{}))
+			}
+
+			if tc.password == usrPassword && !tc.userLocked {
+				fix.totpRepo.EXPECT().GetTOTP(gomock.Any(), usr.ID()).Return(stored, nil)
+			}
+
+			if tc.expectStatusCode == http.StatusNoContent {
+				fix.totpRepo.EXPECT().DeleteTOTP(gomock.Any(), usr.ID()).Return(nil)
+			}
+
+			c := echo.New().NewContext(req, rec)
+			err = fix.svr.DisableTOTP(c)
+
+			// Then
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := rec.Code, tc.expectStatusCode; got != want {
+				t.Fatalf("statusCode got %d, want %d", got, want)
+			}
+		})
+	}
+}
+
+// softAuthenticator is an in-memory passkey authenticator, so the WebAuthn
+// ceremonies run without a browser or a security key.
+type softAuthenticator struct {
+	rpID         string
+	origin       string
+	credentialID []byte
+	userHandle   []byte
+	signCount    uint32
+	// flags of the authenticator data, user present and verified by default
+	flags    byte
+	ecKey    *ecdsa.PrivateKey
+	edKey    ed25519.PrivateKey
+	useEdDSA bool
+}
+
+func newSoftAuthenticator(t *testing.T, useEdDSA bool) *softAuthenticator {
+	rp := webauthn.DefaultRelyingParty()
+	a := &softAuthenticator{
+		rpID:         rp.ID,
+		origin:       rp.Origins[0],
+		credentialID: make([]byte, 16),
+		flags:        0x01 | 0x04,
+		useEdDSA:     useEdDSA,
+	}
+
+	if _, err := rand.Read(a.credentialID); err != nil {
+		t.Fatal(err)
+	}
+
+	var err error
+	if useEdDSA {
+		_, a.edKey, err = ed25519.GenerateKey(rand.Reader)
+	} else {
+		a.ecKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return a
+}
+
+// publicKey returns the COSE encoded public key.
+func (a *softAuthenticator) publicKey() []byte {
+	if a.useEdDSA {
+		return cborEncode(cborMap{{1, 1}, {3, -8}, {-1, 6}, {-2, []byte(a.edKey.Public().(ed25519.PublicKey))}})
+	}
+
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	a.ecKey.X.FillBytes(x)
+	a.ecKey.Y.FillBytes(y)
+	return cborEncode(cborMap{{1, 2}, {3, -7}, {-1, 1}, {-2, x}, {-3, y}})
+}
+
+func (a *softAuthenticator) clientData(typ string, challenge []byte) []byte {
+	b, _ := json.Marshal(map[string]interface{}{
+		"type":        typ,
+		"challenge":   base64.RawURLEncoding.EncodeToString(challenge),
+		"origin":      a.origin,
+		"crossOrigin": false,
+	})
+
+	return b
+}
+
+func (a *softAuthenticator) authenticatorData(attested bool) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.rpID))
+	data := append([]byte{}, rpIDHash[:]...)
+	flags := a.flags
+	if attested {
+		flags |= 0x40
+	}
+
+	data = append(data, flags)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+	if attested {
+		data = append(data, make([]byte, 16)...)
+		data = binary.BigEndian.AppendUint16(data, uint16(len(a.credentialID)))
+		data = append(data, a.credentialID...)
+		data = append(data, a.publicKey()...)
+	}
+
+	return data
+}
+
+// create answers navigator.credentials.create() for the user.
+func (a *softAuthenticator) create(challenge, userHandle []byte) (clientDataJSON, attestationObject []byte) {
+	a.userHandle = userHandle
+	clientDataJSON = a.clientData("webauthn.create", challenge)
+	attestationObject = cborEncode(cborMap{
+		{"fmt", "none"},
+		{"attStmt", cborMap{}},
+		{"authData", a.authenticatorData(true)},
+	})
+
+	return clientDataJSON, attestationObject
+}
+
+// get answers navigator.credentials.get(), incrementing the sign count of
+// authenticators having one.
+func (a *softAuthenticator) get(t *testing.T, challenge []byte) (clientDataJSON, authenticatorData, signature []byte) {
+	if a.signCount > 0 {
+		a.signCount++
+	}
+
+	clientDataJSON = a.clientData("webauthn.get", challenge)
+	authenticatorData = a.authenticatorData(false)
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte{}, authenticatorData...), clientDataHash[:]...)
+	if a.useEdDSA {
+		return clientDataJSON, authenticatorData, ed25519.Sign(a.edKey, signed)
+	}
+
+	digest := sha256.Sum256(signed)
+	signature, err := ecdsa.SignASN1(rand.Reader, a.ecKey, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return clientDataJSON, authenticatorData, signature
+}
+
+type cborMap []struct {
+	key   interface{}
+	value interface{}
+}
+
+// cborEncode encodes the subset of CBOR used by authenticators, map entries
+// keep their order.
+func cborEncode(v interface{}) []byte {
+	head := func(major byte, n uint64) []byte {
+		switch {
+		case n < 24:
+			return []byte{major<<5 | byte(n)}
+		case n <= 0xff:
+			return []byte{major<<5 | 24, byte(n)}
+		case n <= 0xffff:
+			return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(n))
+		default:
+			return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(n))
+		}
+	}
+
+	switch v := v.(type) {
+	case int:
+		if v < 0 {
+			return head(1, uint64(-1-v))
+		}
+
+		return head(0, uint64(v))
+	case []byte:
+		return append(head(2, uint64(len(v))), v...)
+	case string:
+		return append(head(3, uint64(len(v))), v...)
+	case cborMap:
+		b := head(5, uint64(len(v)))
+		for _, e := range v {
+			b = append(b, cborEncode(e.key)...)
+			b = append(b, cborEncode(e.value)...)
+		}
+
+		return b
+	default:
+		panic(fmt.Sprintf("cbor: unsupported type %T", v))
+	}
+}
+
+func TestBeginPasskeyRegistration(t *testing.T) {
+	// Given
+	fix := setup(t)
+	defer fix.tearDown()
+
+	usr, err := user.NewWithPassword(user.NextID(), "+628174546647", "John Doe", "Secret123!")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	existing, err := webauthn.NewCredential([]byte("existing-credential"), usr.ID(), []byte{0xa0}, 0, time.Now(), time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokenCreator := &TokenCreator{
+		KeyRing: testKeyRing,
+	}
+
+	accessToken, err := tokenCreator.CreateAccessToken(usr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// When
+	req := httptest.NewRequest(http.MethodPost, "/users/me/passkeys/registration-options", nil)
+	req.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", accessToken))
+	rec := httptest.NewRecorder()
+
+	fix.tokenRevocationRepo.EXPECT().AccessTokenRevoked(gomock.Any(), gomock.Any(), usr.ID(), gomock.Any()).Return(false, nil)
+	fix.userRepo.EXPECT().GetByID(gomock.Any(), usr.ID()).Return(usr, nil)
+	fix.passkeyRepo.EXPECT().GetUserPasskeys(gomock.Any(), usr.ID()).Return([]*webauthn.Credential{existing}, nil)
+
+	var storedSession *webauthn.Session
+	fix.webAuthnSessionRepo.EXPECT().StoreWebAuthnSession(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, s *webauthn.Session) error {
+		storedSession = s
+		return nil
+	})
+
+	c := echo.New().NewContext(req, rec)
+	err = fix.svr.BeginPasskeyRegistration(c)
+
+	// Then
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := rec.Code, http.StatusOK; got != want {
+		t.Fatalf("statusCode got %d, want %d", got, want)
+	}
+
+	var res generated.PasskeyRegistrationOptions
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := res.Challenge, base64.RawURLEncoding.EncodeToString(storedSession.Challenge()); got != want {
+		t.Fatalf("challenge got %s, want %s", got, want)
+	}
+
+	if got, want := storedSession.Ceremony(), webauthn.CeremonyRegistration; got != want {
+		t.Fatalf("session ceremony got %s, want %s", got, want)
+	}
+
+	if got, want := storedSession.UserID(), usr.ID(); got != want {
+		t.Fatalf("session userID got %s, want %s", got, want)
+	}
+
+	if got, want := res.User.Id, base64.RawURLEncoding.EncodeToString([]byte(usr.ID())); got != want {
+		t.Fatalf("user id got %s, want %s", got, want)
+	}
+
+	if got, want := res.Rp.Id, "localhost"; got != want {
+		t.Fatalf("rp id got %s, want %s", got, want)
+	}
+
+	wantExcluded := []generated.PasskeyCredentialDescriptor{{Type: "public-key", Id: base64.RawURLEncoding.EncodeToString(existing.ID())}}
+	if got := res.ExcludeCredentials; !slices.Equal(got, wantExcluded) {
+		t.Fatalf("excludeCredentials got %v, want %v", got, wantExcluded)
+	}
+
+	if got, want := res.AuthenticatorSelection.UserVerification, "required"; got != want {
+		t.Fatalf("userVerification got %s, want %s", got, want)
+	}
+}
+
+func TestRegisterPasskey(t *testing.T) {
+	testCases := map[string]struct {
+		useEdDSA         bool
+		origin           string
+		rpID             string
+		flags            byte
+		unknownChallenge bool
+		otherUserSession bool
+		expiredSession   bool
+		alreadyStored    bool
+		expectStatusCode int
+	}{
+		"es256 authenticator": {
+			expectStatusCode: http.StatusCreated,
+		},
+		"eddsa authenticator": {
+			useEdDSA:         true,
+			expectStatusCode: http.StatusCreated,
+		},
+		"wrong origin": {
+			origin:           "https://evil.example",
+			expectStatusCode: http.StatusBadRequest,
+		},
+		"wrong relying party": {
+			rpID:             "evil.example",
+			expectStatusCode: http.StatusBadRequest,
+		},
+		"user not verified": {
+			flags:            0x01,
+			expectStatusCode: http.StatusBadRequest,
+		},
+		"unknown challenge": {
+			unknownChallenge: true,
+			expectStatusCode: http.StatusBadRequest,
+		},
+		"session of another user": {
+			otherUserSession: true,
+			expectStatusCode: http.StatusBadRequest,
+		},
+		"expired session": {
+			expiredSession:   true,
+			expectStatusCode: http.StatusBadRequest,
+		},
+		"already registered": {
+			alreadyStored:    true,
+			expectStatusCode: http.StatusConflict,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			// Given
+			fix := setup(t)
+			defer fix.tearDown()
+
+			usr, err := user.NewWithPassword(user.NextID(), "+628174546647", "John Doe", "Secret123!")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			sessionUserID := usr.ID()
+			if tc.otherUserSession {
+				sessionUserID = user.NextID()
+			}
+
+			issuedAt := time.Now()
+			if tc.expiredSession {
+				issuedAt = issuedAt.Add(-time.Hour)
+			}
+
+			session, err := webauthn.BeginSession(webauthn.CeremonyRegistration, sessionUserID, issuedAt, webauthn.DefaultTimeout)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			auth := newSoftAuthenticator(t, tc.useEdDSA)
+			if tc.origin != "" {
+				auth.origin = tc.origin
+			}
+
+			if tc.rpID != "" {
+				auth.rpID = tc.rpID
+			}
+
+			if tc.flags != 0 {
+				auth.flags = tc.flags
+			}
+
+			clientDataJSON, attestationObject := auth.create(session.Challenge(), []byte(usr.ID()))
+
+			tokenCreator := &TokenCreator{
+				KeyRing: testKeyRing,
+			}
+
+			accessToken, err := tokenCreator.CreateAccessToken(usr)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// When
+			var buf bytes.Buffer
+			if err := json.NewEncoder(&buf).Encode(generated.PasskeyRegistrationForm{
+				Id:   base64.RawURLEncoding.EncodeToString(auth.credentialID),
+				Type: "public-key",
+				Response: generated.PasskeyAttestationResponse{
+					ClientDataJSON:    base64.RawURLEncoding.EncodeToString(clientDataJSON),
+					AttestationObject: base64.RawURLEncoding.EncodeToString(attestationObject),
+				},
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/users/me/passkeys", bytes.NewReader(buf.Bytes()))
+			req.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", accessToken))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+
+			fix.tokenRevocationRepo.EXPECT().AccessTokenRevoked(gomock.Any(), gomock.Any(), usr.ID(), gomock.Any()).Return(false, nil)
+			if tc.unknownChallenge {
+				fix.webAuthnSessionRepo.EXPECT().TakeWebAuthnSession(gomock.Any(), session.Challenge()).Return(nil, nil)
+			} else {
+				fix.webAuthnSessionRepo.EXPECT().TakeWebAuthnSession(gomock.Any(), session.Challenge()).Return(session, nil)
+			}
+
+			var storedCred *webauthn.Credential
+			if tc.expectStatusCode != http.StatusBadRequest {
+				fix.passkeyRepo.EXPECT().StorePasskey(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, c *webauthn.Credential) error {
+					if tc.alreadyStored {
+						return repository.ErrUniqueViolation
+					}
+
+					storedCred = c
+					return nil
+				})
+			}
+
+			c := echo.New().NewContext(req, rec)
+			err = fix.svr.RegisterPasskey(c)
+
+			// Then
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := rec.Code, tc.expectStatusCode; got != want {
+				t.Fatalf("statusCode got %d, want %d", got, want)
+			}
+
+			if rec.Code != http.StatusCreated {
 				return
 			}
 
-			var res generated.UserProfile
-			if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
-				t.Fatal(err)
-			}
+			if got, want := storedCred.ID(), auth.credentialID; !bytes.Equal(got, want) {
+				t.Fatalf("credential id got %x, want %x", got, want)
+			}
+
+			if got, want := storedCred.UserID(), usr.ID(); got != want {
+				t.Fatalf("credential userID got %s, want %s", got, want)
+			}
+
+			if got, want := storedCred.PublicKey(), auth.publicKey(); !bytes.Equal(got, want) {
+				t.Fatalf("credential public key got %x, want %x", got, want)
+			}
+		})
+	}
+}
+
+func TestBeginPasskeyLogin(t *testing.T) {
+	// Given
+	fix := setup(t)
+	defer fix.tearDown()
+
+	// When
+	req := httptest.NewRequest(http.MethodPost, "/users/login/passkey-options", nil)
+	req.RemoteAddr = "192.0.2.1:54321"
+	rec := httptest.NewRecorder()
+
+	key := lockout.PasskeyLoginKey("192.0.2.1")
+	fix.loginFailureRepo.EXPECT().GetLoginFailures(gomock.Any(), key).Return(nil, nil)
+	fix.loginFailureRepo.EXPECT().IncrementLoginFailures(gomock.Any(), key, gomock.Any(), gomock.Any()).Return(lockout.New(key, 1, time.Now(), time.Time{}))
+
+	var storedSession *webauthn.Session
+	fix.webAuthnSessionRepo.EXPECT().StoreWebAuthnSession(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, s *webauthn.Session) error {
+		storedSession = s
+		return nil
+	})
+
+	c := echo.New().NewContext(req, rec)
+	err := fix.svr.BeginPasskeyLogin(c)
+
+	// Then
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := rec.Code, http.StatusOK; got != want {
+		t.Fatalf("statusCode got %d, want %d", got, want)
+	}
+
+	var res generated.PasskeyLoginOptions
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := res.Challenge, base64.RawURLEncoding.EncodeToString(storedSession.Challenge()); got != want {
+		t.Fatalf("challenge got %s, want %s", got, want)
+	}
+
+	if got, want := storedSession.Ceremony(), webauthn.CeremonyLogin; got != want {
+		t.Fatalf("session ceremony got %s, want %s", got, want)
+	}
+
+	if got, want := res.RpId, "localhost"; got != want {
+		t.Fatalf("rpId got %s, want %s", got, want)
+	}
+}
+
+func TestBeginPasskeyLoginThrottled(t *testing.T) {
+	// Given
+	svr := newStorageServer(t, repository.NewMemoryRepository())
+	svr.PasskeyService.LoginPolicy = lockout.Policy{MaxFailures: 3, LockDuration: time.Minute}
 
-			if got, want := res.Name, tc.user.FullName(); got != want {
-				t.Fatalf("name got %s, want %s", got, want)
-			}
+	begin := func(sourceIP string) int {
+		req := httptest.NewRequest(http.MethodPost, "/users/login/passkey-options", nil)
+		req.RemoteAddr = sourceIP + ":54321"
+		rec := httptest.NewRecorder()
+		if err := svr.BeginPasskeyLogin(echo.New().NewContext(req, rec)); err != nil {
+			t.Fatal(err)
+		}
 
-			if got, want := res.PhoneNumber, tc.user.PhoneNumber(); got != want {
-				t.Fatalf("phoneNumber got %s, want %s", got, want)
-			}
-		})
+		return rec.Code
+	}
+
+	for i := 0; i < 3; i++ {
+		if got, want := begin("192.0.2.1"), http.StatusOK; got != want {
+			t.Fatalf("statusCode got %d, want %d", got, want)
+		}
+	}
+
+	// When
+	throttled := begin("192.0.2.1")
+	other := begin("192.0.2.2")
+
+	// Then
+	if got, want := throttled, http.StatusTooManyRequests; got != want {
+		t.Fatalf("statusCode got %d, want %d", got, want)
+	}
+
+	if got, want := other, http.StatusOK; got != want {
+		t.Fatalf("statusCode of another IP got %d, want %d", got, want)
 	}
 }
 
-func TestUpdateMyProfile(t *testing.T) {
+func TestLoginWithPasskey(t *testing.T) {
 	testCases := map[string]struct {
-		phoneNumber         string
-		fullName            string
-		profileForm         generated.UserProfileForm
-		tokenFn             func(*user.User) (string, error)
-		noUpdate            bool
-		invalidToken        bool
-		expectStatusCode    int
-		expectContainsError map[string][]string
-
-		skip bool
+		useEdDSA         bool
+		signCount        uint32
+		storedSignCount  uint32
+		otherKey         bool
+		unknownChallenge bool
+		unknownPasskey   bool
+		otherUserHandle  bool
+		registration     bool
+		expectStatusCode int
 	}{
-		"update both": {
-			phoneNumber: "+628174546647",
-			fullName:    "John Doe",
-			profileForm: generated.UserProfileForm{
-				PhoneNumber: strPtr("+628174546648"),
-				FullName:    strPtr("John Wick"),
-			},
-			tokenFn: func(u *user.User) (string, error) {
-				tc := &TokenCreator{
-					PrivateKey: _privateKey,
-				}
-				return tc.CreateAccessToken(u)
-			},
-			expectStatusCode: http.StatusNoContent,
+		"es256 authenticator": {
+			expectStatusCode: http.StatusOK,
 		},
-		"update phone only": {
-			phoneNumber: "+628174546647",
-			fullName:    "John Doe",
-			profileForm: generated.UserProfileForm{
-				PhoneNumber: strPtr("+628174546648"),
-			},
-			tokenFn: func(u *user.User) (string, error) {
-				tc := &TokenCreator{
-					PrivateKey: _privateKey,
-				}
-				return tc.CreateAccessToken(u)
-			},
-			expectStatusCode: http.StatusNoContent,
+		"eddsa authenticator": {
+			useEdDSA:         true,
+			expectStatusCode: http.StatusOK,
 		},
-		"update name only": {
-			phoneNumber: "+628174546647",
-			fullName:    "John Doe",
-			profileForm: generated.UserProfileForm{
-				FullName: strPtr("John Wick"),
-			},
-			tokenFn: func(u *user.User) (string, error) {
-				tc := &TokenCreator{
-					PrivateKey: _privateKey,
-				}
-				return tc.CreateAccessToken(u)
-			},
-			expectStatusCode: http.StatusNoContent,
+		"sign count increased": {
+			signCount:        10,
+			storedSignCount:  10,
+			expectStatusCode: http.StatusOK,
 		},
-		"no details sent": {
-			phoneNumber: "+628174546647",
-			fullName:    "John Doe",
-			profileForm: generated.UserProfileForm{},
-			tokenFn: func(u *user.User) (string, error) {
-				tc := &TokenCreator{
-					PrivateKey: _privateKey,
-				}
-				return tc.CreateAccessToken(u)
-			},
-			noUpdate:         true,
-			expectStatusCode: http.StatusNoContent,
+		"sign count regressed": {
+			signCount:        10,
+			storedSignCount:  42,
+			expectStatusCode: http.StatusBadRequest,
 		},
-		"no changes": {
-			phoneNumber: "+628174546647",
-			fullName:    "John Doe",
-			profileForm: generated.UserProfileForm{
-				PhoneNumber: strPtr("+628174546647"),
-				FullName:    strPtr("John Doe"),
-			},
-			tokenFn: func(u *user.User) (string, error) {
-				tc := &TokenCreator{
-					PrivateKey: _privateKey,
-				}
-				return tc.CreateAccessToken(u)
-			},
-			noUpdate:         true,
-			expectStatusCode: http.StatusNoContent,
+		"signed with another key": {
+			otherKey:         true,
+			expectStatusCode: http.StatusBadRequest,
 		},
-		"invalid name length": {
-			phoneNumber: "+628174546647",
-			fullName:    "John Doe",
-			profileForm: generated.UserProfileForm{
-				PhoneNumber: strPtr("+628174546648"),
-				FullName:    strPtr("Jo"),
-			},
-			tokenFn: func(u *user.User) (string, error) {
-				tc := &TokenCreator{
-					PrivateKey: _privateKey,
-				}
-				return tc.CreateAccessToken(u)
-			},
+		"unknown challenge": {
+			unknownChallenge: true,
 			expectStatusCode: http.StatusBadRequest,
-			expectContainsError: map[string][]string{
-				"fullName": {"FULL_NAME_LENGTH"},
-			},
 		},
-		"invalid phone format": {
-			phoneNumber: "+628174546647",
-			fullName:    "John Doe",
-			profileForm: generated.UserProfileForm{
-				PhoneNumber: strPtr("+618174546648"),
-				FullName:    strPtr("John Wick"),
-			},
-			tokenFn: func(u *user.User) (string, error) {
-				tc := &TokenCreator{
-					PrivateKey: _privateKey,
-				}
-				return tc.CreateAccessToken(u)
-			},
+		"unknown passkey": {
+			unknownPasskey:   true,
 			expectStatusCode: http.StatusBadRequest,
-			expectContainsError: map[string][]string{
-				"phoneNumber": {"PHONE_NUMBER_FORMAT"},
-			},
 		},
-		"invalid token": {
-			phoneNumber: "+628174546647",
-			fullName:    "John Doe",
-			profileForm: generated.UserProfileForm{
-				PhoneNumber: strPtr("+628174546648"),
-				FullName:    strPtr("John Wick"),
-			},
-			tokenFn: func(u *user.User) (string, error) {
-				return "invalid token", nil
-			},
-			invalidToken:     true,
-			expectStatusCode: http.StatusForbidden,
+		"user handle of another user": {
+			otherUserHandle:  true,
+			expectStatusCode: http.StatusBadRequest,
+		},
+		"registration challenge": {
+			registration:     true,
+			expectStatusCode: http.StatusBadRequest,
 		},
 	}
 
 	for name, tc := range testCases {
 		t.Run(name, func(t *testing.T) {
-			if tc.skip {
-				t.Skip()
-			}
-
 			// Given
 			fix := setup(t)
 			defer fix.tearDown()
 
-			storedUser, err := user.NewWithPassword("jdoe", tc.phoneNumber, tc.fullName, "Secret123!")
+			usr, err := user.NewWithPassword(user.NextID(), "+628174546647", "John Doe", "Secret123!")
 			if err != nil {
 				t.Fatal(err)
 			}
 
-			// When
-			var buf bytes.Buffer
-			if err := json.NewEncoder(&buf).Encode(tc.profileForm); err != nil {
+			auth := newSoftAuthenticator(t, tc.useEdDSA)
+			auth.signCount = tc.signCount
+			cred, err := webauthn.NewCredential(auth.credentialID, usr.ID(), auth.publicKey(), tc.storedSignCount, time.Now(), time.Time{})
+			if err != nil {
 				t.Fatal(err)
 			}
 
-			accessToken, err := tc.tokenFn(storedUser)
+			if tc.otherKey {
+				auth = newSoftAuthenticator(t, tc.useEdDSA)
+				auth.credentialID = cred.ID()
+			}
+
+			ceremony, sessionUserID := webauthn.CeremonyLogin, ""
+			if tc.registration {
+				ceremony, sessionUserID = webauthn.CeremonyRegistration, usr.ID()
+			}
+
+			session, err := webauthn.BeginSession(ceremony, sessionUserID, time.Now(), webauthn.DefaultTimeout)
 			if err != nil {
 				t.Fatal(err)
 			}
 
-			req := httptest.NewRequest(http.MethodPut, "/users/me", bytes.NewReader(buf.Bytes()))
-			req.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", accessToken))
+			clientDataJSON, authenticatorData, signature := auth.get(t, session.Challenge())
+			userHandle := usr.ID()
+			if tc.otherUserHandle {
+				userHandle = user.NextID()
+			}
+
+			// When
+			encodedUserHandle := base64.RawURLEncoding.EncodeToString([]byte(userHandle))
+			var buf bytes.Buffer
+			if err := json.NewEncoder(&buf).Encode(generated.PasskeyLoginForm{
+				Id:   base64.RawURLEncoding.EncodeToString(cred.ID()),
+				Type: "public-key",
+				Response: generated.PasskeyAssertionResponse{
+					ClientDataJSON:    base64.RawURLEncoding.EncodeToString(clientDataJSON),
+					AuthenticatorData: base64.RawURLEncoding.EncodeToString(authenticatorData),
+					Signature:         base64.RawURLEncoding.EncodeToString(signature),
+					UserHandle:        &encodedUserHandle,
+				},
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/users/login/passkey", bytes.NewReader(buf.Bytes()))
 			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
 			rec := httptest.NewRecorder()
 
-			if !tc.invalidToken && len(tc.expectContainsError) == 0 {
-				fix.userRepo.EXPECT().GetByID(storedUser.ID()).Return(storedUser, nil)
+			if tc.unknownChallenge {
+				fix.webAuthnSessionRepo.EXPECT().TakeWebAuthnSession(gomock.Any(), session.Challenge()).Return(nil, nil)
+			} else {
+				fix.webAuthnSessionRepo.EXPECT().TakeWebAuthnSession(gomock.Any(), session.Challenge()).Return(session, nil)
+			}
 
-				if tc.profileForm.PhoneNumber != nil && *tc.profileForm.PhoneNumber != tc.phoneNumber {
-					fix.userRepo.EXPECT().GetByPhoneNumber(*tc.profileForm.PhoneNumber).Return(nil, nil)
-				}
+			if !tc.unknownChallenge && tc.unknownPasskey {
+				fix.passkeyRepo.EXPECT().GetPasskey(gomock.Any(), cred.ID()).Return(nil, nil)
+			} else if !tc.unknownChallenge {
+				fix.passkeyRepo.EXPECT().GetPasskey(gomock.Any(), cred.ID()).Return(cred, nil)
+			}
 
-				if !tc.noUpdate {
-					fix.userRepo.EXPECT().Update(storedUser).Return(nil)
-				}
+			if tc.expectStatusCode == http.StatusOK {
+				fix.passkeyRepo.EXPECT().UpdatePasskeySignCount(gomock.Any(), cred.ID(), auth.signCount, gomock.Any()).Return(true, nil)
+				fix.userRepo.EXPECT().GetByID(gomock.Any(), usr.ID()).Return(usr, nil)
+				fix.loginFailureRepo.EXPECT().GetLoginFailures(gomock.Any(), lockout.UserKey(usr.ID())).Return(nil, nil)
+				fix.refreshTokenRepo.EXPECT().StoreRefreshToken(gomock.Any(), gomock.Any()).Return(nil)
 			}
 
 			c := echo.New().NewContext(req, rec)
-			err = fix.svr.UpdateMyProfile(c)
+			err = fix.svr.LoginWithPasskey(c)
 
 			// Then
 			if err != nil {
@@ -546,32 +5865,106 @@ func TestUpdateMyProfile(t *testing.T) {
 				t.Fatalf("statusCode got %d, want %d", got, want)
 			}
 
-			if rec.Code == http.StatusBadRequest {
-				var resErrs []generated.FieldError
-				if err := json.NewDecoder(rec.Body).Decode(&resErrs); err != nil {
-					t.Fatal(err)
-				}
+			if rec.Code != http.StatusOK {
+				return
+			}
 
-				for fieldName, errCodes := range tc.expectContainsError {
-					for _, code := range errCodes {
-						found := false
-						for _, e := range resErrs {
-							if e.Name == fieldName && slices.Contains(e.Codes, code) {
-								found = true
-								break
-							}
-						}
+			var res generated.LoginResponse
+			if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+				t.Fatal(err)
+			}
 
-						if !found {
-							t.Fatalf("FieldError not found: want fieldName=%s code=%s, got errors=%+v", fieldName, code, resErrs)
-						}
-					}
-				}
+			if got, want := res.Id, usr.ID(); got != want {
+				t.Fatalf("id got %s, want %s", got, want)
 			}
 		})
 	}
 }
 
-func strPtr(s string) *string {
-	return &s
+func TestRequestTimeout(t *testing.T) {
+	user1, err := user.NewWithPassword(user.NextID(), "+628174546647", "John Doe", "Secret123!")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	timeout := 50 * time.Millisecond
+	testCases := map[string]struct {
+		// getByID stands for a query, returning what the driver would
+		getByID          func(ctx context.Context, cancel context.CancelFunc) (*user.User, error)
+		expectErr        error
+		expectStatusCode int
+	}{
+		"in time": {
+			getByID: func(ctx context.Context, cancel context.CancelFunc) (*user.User, error) {
+				return user1, nil
+			},
+			expectStatusCode: http.StatusOK,
+		},
+		"deadline passed": {
+			getByID: func(ctx context.Context, cancel context.CancelFunc) (*user.User, error) {
+				<-ctx.Done()
+				return nil, ctx.Err()
+			},
+			expectStatusCode: http.StatusServiceUnavailable,
+		},
+		"client disconnected": {
+			getByID: func(ctx context.Context, cancel context.CancelFunc) (*user.User, error) {
+				cancel()
+				<-ctx.Done()
+				return nil, ctx.Err()
+			},
+			expectErr: context.Canceled,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			// Given
+			fix := setup(t)
+			defer fix.tearDown()
+
+			accessToken, err := (&TokenCreator{KeyRing: testKeyRing}).CreateAccessToken(user1)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			reqCtx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			// When
+			req := httptest.NewRequest(http.MethodGet, "/users/me", nil).WithContext(reqCtx)
+			req.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", accessToken))
+			rec := httptest.NewRecorder()
+
+			fix.tokenRevocationRepo.EXPECT().AccessTokenRevoked(gomock.Any(), gomock.Any(), user1.ID(), gomock.Any()).Return(false, nil)
+			fix.userRepo.EXPECT().GetByID(gomock.Any(), user1.ID()).DoAndReturn(func(ctx context.Context, id string) (*user.User, error) {
+				deadline, ok := ctx.Deadline()
+				if !ok || time.Until(deadline) > timeout {
+					t.Errorf("deadline got %v, want within %v", deadline, timeout)
+				}
+
+				return tc.getByID(ctx, cancel)
+			})
+
+			c := echo.New().NewContext(req, rec)
+			err = RequestTimeout(timeout)(fix.svr.GetMyProfile)(c)
+
+			// Then
+			if tc.expectErr != nil {
+				if !errors.Is(err, tc.expectErr) {
+					t.Fatalf("err got %v, want %v", err, tc.expectErr)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := rec.Code, tc.expectStatusCode; got != want {
+				t.Fatalf("statusCode got %d, want %d", got, want)
+			}
+		})
+	}
 }
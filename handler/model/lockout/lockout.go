@@ -0,0 +1,167 @@
+package lockout
+
+import (
+	"errors"
+	"math"
+	"time"
+)
+
+// Counter tracks consecutive failed logins for a key, which is either a user
+// or a source IP address.
+type Counter struct {
+	key           string
+	failures      int
+	lastFailureAt time.Time
+	lockedUntil   time.Time
+}
+
+func New(key string, failures int, lastFailureAt, lockedUntil time.Time) (*Counter, error) {
+	if key == "" {
+		return nil, errors.New("empty key")
+	}
+
+	if failures < 0 {
+		return nil, errors.New("negative failures")
+	}
+
+	return &Counter{
+		key:           key,
+		failures:      failures,
+		lastFailureAt: lastFailureAt,
+		lockedUntil:   lockedUntil,
+	}, nil
+}
+
+func UserKey(userID string) string {
+	return "user:" + userID
+}
+
+func IPKey(ip string) string {
+	return "ip:" + ip
+}
+
+// PasskeyLoginKey counts the passkey logins begun from a source IP address,
+// which are throttled like failed logins.
+func PasskeyLoginKey(ip string) string {
+	return "passkey:" + IPKey(ip)
+}
+
+func (c *Counter) Key() string {
+	return c.key
+}
+
+func (c *Counter) Failures() int {
+	return c.failures
+}
+
+func (c *Counter) LastFailureAt() time.Time {
+	return c.lastFailureAt
+}
+
+func (c *Counter) LockedUntil() time.Time {
+	return c.lockedUntil
+}
+
+func (c *Counter) Locked(now time.Time) bool {
+	return now.Before(c.lockedUntil)
+}
+
+// Policy decides when failed logins lock a key and how long the next attempt
+// has to wait.
+type Policy struct {
+	// MaxFailures is the number of consecutive failures locking the key.
+	MaxFailures int
+	// LockDuration is how long the key stays locked. Failures older than this
+	// are forgotten.
+	LockDuration time.Duration
+	// BackoffBase is the delay after the first failure, doubled on every
+	// following one up to BackoffMax.
+	BackoffBase time.Duration
+	BackoffMax  time.Duration
+}
+
+func DefaultUserPolicy() Policy {
+	return Policy{
+		MaxFailures:  5,
+		LockDuration: 15 * time.Minute,
+		BackoffBase:  time.Second,
+		BackoffMax:   30 * time.Second,
+	}
+}
+
+func DefaultIPPolicy() Policy {
+	return Policy{
+		MaxFailures:  50,
+		LockDuration: 15 * time.Minute,
+		BackoffBase:  0,
+		BackoffMax:   0,
+	}
+}
+
+// DefaultPasskeyLoginPolicy locks an IP address for 15 minutes once it began
+// 60 passkey logins, each storing a ceremony until it expires.
+func DefaultPasskeyLoginPolicy() Policy {
+	return Policy{
+		MaxFailures:  60,
+		LockDuration: 15 * time.Minute,
+	}
+}
+
+// Backoff returns the delay to wait after the given number of consecutive
+// failures. Without BackoffMax the delay keeps doubling until it saturates,
+// rather than overflowing to no delay at all.
+func (p Policy) Backoff(failures int) time.Duration {
+	if failures <= 0 || p.BackoffBase <= 0 {
+		return 0
+	}
+
+	maxDelay := p.BackoffMax
+	if maxDelay <= 0 {
+		maxDelay = math.MaxInt64
+	}
+
+	delay := p.BackoffBase
+	for i := 1; i < failures && delay < maxDelay; i++ {
+		if delay > maxDelay/2 {
+			return maxDelay
+		}
+
+		delay *= 2
+	}
+
+	if delay > maxDelay {
+		return maxDelay
+	}
+
+	return delay
+}
+
+// RetryAt returns when the next attempt is allowed, which is zero if the key
+// is not restricted.
+func (p Policy) RetryAt(c *Counter) time.Time {
+	if c == nil {
+		return time.Time{}
+	}
+
+	retryAt := c.lockedUntil
+	if backoffUntil := c.lastFailureAt.Add(p.Backoff(c.failures)); c.failures > 0 && backoffUntil.After(retryAt) {
+		retryAt = backoffUntil
+	}
+
+	return retryAt
+}
+
+// Blocked reports whether an attempt at the given time has to be rejected.
+func (p Policy) Blocked(c *Counter, now time.Time) bool {
+	return now.Before(p.RetryAt(c))
+}
+
+// ShouldLock reports whether the counter reached the failure limit.
+func (p Policy) ShouldLock(c *Counter) bool {
+	return p.MaxFailures > 0 && c.failures >= p.MaxFailures
+}
+
+// ResetBefore returns the time before which failures are forgotten.
+func (p Policy) ResetBefore(now time.Time) time.Time {
+	return now.Add(-p.LockDuration)
+}
@@ -0,0 +1,86 @@
+package lockout
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestPolicyBackoff(t *testing.T) {
+	testCases := map[string]struct {
+		policy   Policy
+		failures int
+		expect   time.Duration
+	}{
+		"no failure": {
+			policy:   DefaultUserPolicy(),
+			failures: 0,
+			expect:   0,
+		},
+		"first failure": {
+			policy:   DefaultUserPolicy(),
+			failures: 1,
+			expect:   time.Second,
+		},
+		"doubled": {
+			policy:   DefaultUserPolicy(),
+			failures: 3,
+			expect:   4 * time.Second,
+		},
+		"capped": {
+			policy:   DefaultUserPolicy(),
+			failures: 10,
+			expect:   30 * time.Second,
+		},
+		"capped after many failures": {
+			policy:   DefaultUserPolicy(),
+			failures: 1000,
+			expect:   30 * time.Second,
+		},
+		"no backoff": {
+			policy:   DefaultIPPolicy(),
+			failures: 1000,
+			expect:   0,
+		},
+		"uncapped": {
+			policy:   Policy{BackoffBase: time.Second},
+			failures: 5,
+			expect:   16 * time.Second,
+		},
+		"uncapped saturates instead of overflowing": {
+			policy:   Policy{BackoffBase: time.Second},
+			failures: 1000,
+			expect:   math.MaxInt64,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			// When
+			got := tc.policy.Backoff(tc.failures)
+
+			// Then
+			if got != tc.expect {
+				t.Fatalf("backoff got %v, want %v", got, tc.expect)
+			}
+		})
+	}
+}
+
+func TestPolicyBlockedAfterManyFailures(t *testing.T) {
+	// Given
+	now := time.Now()
+	policy := Policy{BackoffBase: time.Second}
+	c, err := New(IPKey("192.0.2.1"), 1000, now, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// When
+	blocked := policy.Blocked(c, now.Add(24*time.Hour))
+
+	// Then
+	if !blocked {
+		t.Fatal("blocked got false, want true")
+	}
+}
@@ -0,0 +1,126 @@
+package mfa
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"time"
+
+	"github.com/rs/xid"
+)
+
+const (
+	DefaultChallengeExpiry      = 5 * time.Minute
+	DefaultChallengeMaxAttempts = 5
+)
+
+// Challenge is the pending second step of a login, handed to the client once
+// the password is verified. Only the hash of the token is kept.
+type Challenge struct {
+	id         string
+	userID     string
+	tokenHash  []byte
+	issuedAt   time.Time
+	expiresAt  time.Time
+	attempts   int
+	consumedAt time.Time
+}
+
+func NewChallenge(id, userID string, tokenHash []byte, issuedAt, expiresAt time.Time, attempts int, consumedAt time.Time) (*Challenge, error) {
+	if id == "" {
+		return nil, errors.New("empty id")
+	}
+
+	if userID == "" {
+		return nil, errors.New("empty user id")
+	}
+
+	if len(tokenHash) == 0 {
+		return nil, errors.New("empty token hash")
+	}
+
+	if !expiresAt.After(issuedAt) {
+		return nil, errors.New("expiry should be after issued time")
+	}
+
+	if attempts < 0 {
+		return nil, errors.New("negative attempts")
+	}
+
+	return &Challenge{
+		id:         id,
+		userID:     userID,
+		tokenHash:  tokenHash,
+		issuedAt:   issuedAt,
+		expiresAt:  expiresAt,
+		attempts:   attempts,
+		consumedAt: consumedAt,
+	}, nil
+}
+
+// IssueChallenge creates a challenge for the user. The returned string is the
+// plain token handed to the client, it is never persisted.
+func IssueChallenge(userID string, now time.Time, expiry time.Duration) (*Challenge, string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return nil, "", err
+	}
+
+	value := base64.RawURLEncoding.EncodeToString(b)
+	c, err := NewChallenge(xid.New().String(), userID, HashChallengeToken(value), now, now.Add(expiry), 0, time.Time{})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return c, value, nil
+}
+
+func (c *Challenge) ID() string {
+	return c.id
+}
+
+func (c *Challenge) UserID() string {
+	return c.userID
+}
+
+func (c *Challenge) TokenHash() []byte {
+	return c.tokenHash
+}
+
+func (c *Challenge) IssuedAt() time.Time {
+	return c.issuedAt
+}
+
+func (c *Challenge) ExpiresAt() time.Time {
+	return c.expiresAt
+}
+
+func (c *Challenge) Attempts() int {
+	return c.attempts
+}
+
+func (c *Challenge) ConsumedAt() time.Time {
+	return c.consumedAt
+}
+
+func (c *Challenge) Consumed() bool {
+	return !c.consumedAt.IsZero()
+}
+
+func (c *Challenge) Expired(now time.Time) bool {
+	return !now.Before(c.expiresAt)
+}
+
+// Usable reports whether the challenge can still be answered.
+func (c *Challenge) Usable(now time.Time) bool {
+	return !c.Consumed() && !c.Expired(now)
+}
+
+// HashChallengeToken returns the digest of a plain challenge token, which is
+// what gets stored and looked up.
+func HashChallengeToken(value string) []byte {
+	sum := sha256.Sum256([]byte(value))
+	return sum[:]
+}
@@ -0,0 +1,70 @@
+package mfa
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"strings"
+)
+
+const (
+	RecoveryCodeCount = 10
+
+	// Recovery codes are formatted as two groups of five characters from an
+	// alphabet without look-alike characters.
+	recoveryCodeAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+	recoveryCodeGroup    = 5
+)
+
+// GenerateRecoveryCodes returns the plain one-time recovery codes, to be shown
+// once to the user, and their hashes, which are what gets stored.
+func GenerateRecoveryCodes() (codes []string, hashes [][]byte, err error) {
+	for i := 0; i < RecoveryCodeCount; i++ {
+		code, err := genRecoveryCode()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		codes = append(codes, code)
+		hashes = append(hashes, HashRecoveryCode(code))
+	}
+
+	return codes, hashes, nil
+}
+
+// HashRecoveryCode returns the digest of a recovery code, ignoring case,
+// spaces and dashes so that codes can be typed leniently.
+func HashRecoveryCode(code string) []byte {
+	sum := sha256.Sum256([]byte(normalizeRecoveryCode(code)))
+	return sum[:]
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+
+		return r
+	}, strings.ToLower(code))
+}
+
+func genRecoveryCode() (string, error) {
+	b := make([]byte, 2*recoveryCodeGroup)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	// The alphabet is small enough for the modulo bias to be irrelevant
+	// against the 50 bits of the code.
+	var sb strings.Builder
+	for i, c := range b {
+		if i == recoveryCodeGroup {
+			sb.WriteByte('-')
+		}
+
+		sb.WriteByte(recoveryCodeAlphabet[int(c)%len(recoveryCodeAlphabet)])
+	}
+
+	return sb.String(), nil
+}
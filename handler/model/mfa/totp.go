@@ -0,0 +1,159 @@
+package mfa
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// RFC 6238 parameters, the defaults every authenticator app supports.
+const (
+	Period = 30 * time.Second
+	Digits = 6
+
+	secretLength = 20
+	// skew is the number of periods a code is accepted before and after the
+	// current one, tolerating clock drift.
+	skew = 1
+)
+
+var b32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TOTP is the time-based one-time password secret of a user. It only protects
+// logins once confirmed with a first code.
+type TOTP struct {
+	userID      string
+	secret      []byte
+	createdAt   time.Time
+	confirmedAt time.Time
+	// lastUsedStep prevents a code from being used twice.
+	lastUsedStep int64
+}
+
+func NewTOTP(userID string, secret []byte, createdAt, confirmedAt time.Time, lastUsedStep int64) (*TOTP, error) {
+	if userID == "" {
+		return nil, errors.New("empty user id")
+	}
+
+	if len(secret) == 0 {
+		return nil, errors.New("empty secret")
+	}
+
+	if lastUsedStep < 0 {
+		return nil, errors.New("negative last used step")
+	}
+
+	return &TOTP{
+		userID:       userID,
+		secret:       secret,
+		createdAt:    createdAt,
+		confirmedAt:  confirmedAt,
+		lastUsedStep: lastUsedStep,
+	}, nil
+}
+
+// Enroll generates a new unconfirmed secret for the user.
+func Enroll(userID string, now time.Time) (*TOTP, error) {
+	secret := make([]byte, secretLength)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewTOTP(userID, secret, now, time.Time{}, 0)
+}
+
+func (t *TOTP) UserID() string {
+	return t.userID
+}
+
+func (t *TOTP) Secret() []byte {
+	return t.secret
+}
+
+// EncodedSecret returns the secret in base32, as typed into authenticator
+// apps.
+func (t *TOTP) EncodedSecret() string {
+	return b32.EncodeToString(t.secret)
+}
+
+func (t *TOTP) CreatedAt() time.Time {
+	return t.createdAt
+}
+
+func (t *TOTP) ConfirmedAt() time.Time {
+	return t.confirmedAt
+}
+
+func (t *TOTP) Confirmed() bool {
+	return !t.confirmedAt.IsZero()
+}
+
+func (t *TOTP) LastUsedStep() int64 {
+	return t.lastUsedStep
+}
+
+// URI returns the otpauth URI rendered as a QR code by authenticator apps.
+func (t *TOTP) URI(issuer, accountName string) string {
+	v := url.Values{}
+	v.Set("secret", t.EncodedSecret())
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(Digits))
+	v.Set("period", fmt.Sprint(int(Period.Seconds())))
+
+	// Some apps decode "+" in the label as a space, as phone numbers start
+	// with one it is always percent-encoded.
+	label := strings.ReplaceAll(url.QueryEscape(issuer+":"+accountName), "+", "%20")
+	return "otpauth://totp/" + label + "?" + v.Encode()
+}
+
+// Verify checks the code against the periods around now. It returns the
+// matching time step, which has to be recorded so the code cannot be replayed.
+func (t *TOTP) Verify(code string, now time.Time) (int64, bool) {
+	current := Step(now)
+	for step := current - skew; step <= current+skew; step++ {
+		if step <= t.lastUsedStep {
+			continue
+		}
+
+		if subtle.ConstantTimeCompare([]byte(Code(t.secret, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
+
+// Step returns the RFC 6238 time step of the given time.
+func Step(at time.Time) int64 {
+	return at.Unix() / int64(Period.Seconds())
+}
+
+// Code computes the RFC 4226 HOTP value of the secret for the time step.
+func Code(secret []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < Digits; i++ {
+		mod *= 10
+	}
+
+	return fmt.Sprintf("%0*d", Digits, value%mod)
+}
@@ -0,0 +1,231 @@
+package otp
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/rs/xid"
+)
+
+// Purpose tells what a one-time password is issued for. A code issued for one
+// purpose can never be used for another.
+type Purpose string
+
+const (
+	PurposePasswordReset     Purpose = "password_reset"
+	PurposePhoneVerification Purpose = "phone_verification"
+	PurposeLogin             Purpose = "login"
+)
+
+const (
+	DefaultExpiry         = 5 * time.Minute
+	DefaultMaxAttempts    = 5
+	DefaultResendInterval = time.Minute
+	DefaultMaxSends       = 5
+	DefaultSendWindow     = time.Hour
+
+	codeLength = 6
+)
+
+// OTP is a short numeric code sent to a phone number. Only the hash of the
+// code is kept.
+type OTP struct {
+	id          string
+	purpose     Purpose
+	phoneNumber string
+	codeHash    []byte
+	issuedAt    time.Time
+	expiresAt   time.Time
+	attempts    int
+	consumedAt  time.Time
+	// sendCount is the number of codes sent for the same purpose and phone
+	// number since sendWindowStart, this one included.
+	sendCount       int
+	sendWindowStart time.Time
+}
+
+func New(id string, purpose Purpose, phoneNumber string, codeHash []byte, issuedAt, expiresAt time.Time, attempts int, consumedAt time.Time, sendCount int, sendWindowStart time.Time) (*OTP, error) {
+	if id == "" {
+		return nil, errors.New("empty id")
+	}
+
+	if purpose == "" {
+		return nil, errors.New("empty purpose")
+	}
+
+	if phoneNumber == "" {
+		return nil, errors.New("empty phone number")
+	}
+
+	if len(codeHash) == 0 {
+		return nil, errors.New("empty code hash")
+	}
+
+	if !expiresAt.After(issuedAt) {
+		return nil, errors.New("expiry should be after issued time")
+	}
+
+	if attempts < 0 {
+		return nil, errors.New("negative attempts")
+	}
+
+	if sendCount < 1 {
+		return nil, errors.New("send count should be positive")
+	}
+
+	if sendWindowStart.After(issuedAt) {
+		return nil, errors.New("send window should start before issued time")
+	}
+
+	return &OTP{
+		id:              id,
+		purpose:         purpose,
+		phoneNumber:     phoneNumber,
+		codeHash:        codeHash,
+		issuedAt:        issuedAt,
+		expiresAt:       expiresAt,
+		attempts:        attempts,
+		consumedAt:      consumedAt,
+		sendCount:       sendCount,
+		sendWindowStart: sendWindowStart,
+	}, nil
+}
+
+// Issue generates a new code for the phone number. The returned string is the
+// plain code to be sent, it is never persisted.
+func Issue(purpose Purpose, phoneNumber string, now time.Time, expiry time.Duration) (*OTP, string, error) {
+	code, err := genCode(codeLength)
+	if err != nil {
+		return nil, "", err
+	}
+
+	id := xid.New().String()
+	o, err := New(id, purpose, phoneNumber, hashCode(id, code), now, now.Add(expiry), 0, time.Time{}, 1, now)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return o, code, nil
+}
+
+func (o *OTP) ID() string {
+	return o.id
+}
+
+func (o *OTP) Purpose() Purpose {
+	return o.purpose
+}
+
+func (o *OTP) PhoneNumber() string {
+	return o.phoneNumber
+}
+
+func (o *OTP) CodeHash() []byte {
+	return o.codeHash
+}
+
+func (o *OTP) IssuedAt() time.Time {
+	return o.issuedAt
+}
+
+func (o *OTP) ExpiresAt() time.Time {
+	return o.expiresAt
+}
+
+func (o *OTP) Attempts() int {
+	return o.attempts
+}
+
+func (o *OTP) ConsumedAt() time.Time {
+	return o.consumedAt
+}
+
+func (o *OTP) Consumed() bool {
+	return !o.consumedAt.IsZero()
+}
+
+func (o *OTP) Expired(now time.Time) bool {
+	return !now.Before(o.expiresAt)
+}
+
+// Usable reports whether the code can still be verified.
+func (o *OTP) Usable(now time.Time) bool {
+	return !o.Consumed() && !o.Expired(now)
+}
+
+func (o *OTP) SendCount() int {
+	return o.sendCount
+}
+
+func (o *OTP) SendWindowStart() time.Time {
+	return o.sendWindowStart
+}
+
+// ContinueSendWindow counts this code among the ones sent in the window of
+// the previous code, unless that window is over.
+func (o *OTP) ContinueSendWindow(prev *OTP, window time.Duration) {
+	if prev == nil || !o.issuedAt.Before(prev.sendWindowStart.Add(window)) {
+		return
+	}
+
+	o.sendCount = prev.sendCount + 1
+	o.sendWindowStart = prev.sendWindowStart
+}
+
+// ResendAllowed reports whether a new code may replace this one.
+func (o *OTP) ResendAllowed(now time.Time, interval time.Duration) bool {
+	return !now.Before(o.issuedAt.Add(interval))
+}
+
+// SendLimit caps the codes sent for the same purpose to a phone number within
+// a window, on top of the interval between two codes.
+type SendLimit struct {
+	MaxSends int
+	Window   time.Duration
+}
+
+func DefaultSendLimit() SendLimit {
+	return SendLimit{
+		MaxSends: DefaultMaxSends,
+		Window:   DefaultSendWindow,
+	}
+}
+
+// Reached reports whether no more code may be sent after prev until its
+// window is over.
+func (sl SendLimit) Reached(prev *OTP, now time.Time) bool {
+	if prev == nil || sl.MaxSends <= 0 {
+		return false
+	}
+
+	return now.Before(prev.sendWindowStart.Add(sl.Window)) && prev.sendCount >= sl.MaxSends
+}
+
+// Matches compares the code in constant time.
+func (o *OTP) Matches(code string) bool {
+	return subtle.ConstantTimeCompare(hashCode(o.id, code), o.codeHash) == 1
+}
+
+func hashCode(id, code string) []byte {
+	sum := sha256.Sum256([]byte(id + ":" + code))
+	return sum[:]
+}
+
+func genCode(length int) (string, error) {
+	max := big.NewInt(1)
+	for i := 0; i < length; i++ {
+		max.Mul(max, big.NewInt(10))
+	}
+
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%0*d", length, n), nil
+}
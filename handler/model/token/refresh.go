@@ -0,0 +1,154 @@
+package token
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"time"
+
+	"github.com/rs/xid"
+)
+
+// DefaultRefreshTokenExpiry is the lifetime of a refresh token when no
+// other expiry is configured.
+const DefaultRefreshTokenExpiry = 30 * 24 * time.Hour
+
+// RefreshToken is a long-lived, server-persisted token used to obtain new
+// access tokens. Tokens are rotated on every use; all tokens derived from the
+// same login share a family so that the whole chain can be revoked once a
+// reuse is detected.
+type RefreshToken struct {
+	id        string
+	familyID  string
+	userID    string
+	hash      []byte
+	issuedAt  time.Time
+	expiresAt time.Time
+	usedAt    time.Time
+	revokedAt time.Time
+}
+
+func New(id, familyID, userID string, hash []byte, issuedAt, expiresAt, usedAt, revokedAt time.Time) (*RefreshToken, error) {
+	if id == "" {
+		return nil, errors.New("empty id")
+	}
+
+	if familyID == "" {
+		return nil, errors.New("empty family id")
+	}
+
+	if userID == "" {
+		return nil, errors.New("empty user id")
+	}
+
+	if len(hash) == 0 {
+		return nil, errors.New("empty hash")
+	}
+
+	if !expiresAt.After(issuedAt) {
+		return nil, errors.New("expiry should be after issued time")
+	}
+
+	return &RefreshToken{
+		id:        id,
+		familyID:  familyID,
+		userID:    userID,
+		hash:      hash,
+		issuedAt:  issuedAt,
+		expiresAt: expiresAt,
+		usedAt:    usedAt,
+		revokedAt: revokedAt,
+	}, nil
+}
+
+// Issue creates the first refresh token of a new family for the given user.
+// The returned string is the plain token value which is handed to the client
+// and never persisted.
+func Issue(userID string, now time.Time, expiry time.Duration) (*RefreshToken, string, error) {
+	return issue(NextID(), userID, now, expiry)
+}
+
+// Rotate creates the successor of rt within the same family.
+func (rt *RefreshToken) Rotate(now time.Time, expiry time.Duration) (*RefreshToken, string, error) {
+	return issue(rt.familyID, rt.userID, now, expiry)
+}
+
+func issue(familyID, userID string, now time.Time, expiry time.Duration) (*RefreshToken, string, error) {
+	value, err := genValue(32)
+	if err != nil {
+		return nil, "", err
+	}
+
+	rt, err := New(NextID(), familyID, userID, Hash(value), now, now.Add(expiry), time.Time{}, time.Time{})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return rt, value, nil
+}
+
+func (rt *RefreshToken) ID() string {
+	return rt.id
+}
+
+func (rt *RefreshToken) FamilyID() string {
+	return rt.familyID
+}
+
+func (rt *RefreshToken) UserID() string {
+	return rt.userID
+}
+
+func (rt *RefreshToken) Hash() []byte {
+	return rt.hash
+}
+
+func (rt *RefreshToken) IssuedAt() time.Time {
+	return rt.issuedAt
+}
+
+func (rt *RefreshToken) ExpiresAt() time.Time {
+	return rt.expiresAt
+}
+
+func (rt *RefreshToken) UsedAt() time.Time {
+	return rt.usedAt
+}
+
+func (rt *RefreshToken) RevokedAt() time.Time {
+	return rt.revokedAt
+}
+
+func (rt *RefreshToken) Used() bool {
+	return !rt.usedAt.IsZero()
+}
+
+func (rt *RefreshToken) Revoked() bool {
+	return !rt.revokedAt.IsZero()
+}
+
+func (rt *RefreshToken) Expired(now time.Time) bool {
+	return !now.Before(rt.expiresAt)
+}
+
+// Hash returns the digest of a plain refresh token value, which is what gets
+// stored and looked up.
+func Hash(value string) []byte {
+	sum := sha256.Sum256([]byte(value))
+	return sum[:]
+}
+
+func NextID() string {
+	return xid.New().String()
+}
+
+func genValue(length int) (string, error) {
+	b := make([]byte, length)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
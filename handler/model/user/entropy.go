@@ -0,0 +1,279 @@
+package user
+
+import (
+	"math"
+	"strings"
+	"unicode"
+)
+
+// PasswordScore estimates how hard the password is to guess, the way zxcvbn
+// does in a simplified form: the password is split into common words,
+// repeated characters, sequences and keyboard runs, and the guesses needed for
+// every part are multiplied. The score goes from 0, too guessable, to 4, very
+// unguessable.
+func PasswordScore(password string, owner PasswordOwner) int {
+	guesses := estimateGuesses(password, ownerWords(owner))
+	switch {
+	case guesses < 1e3:
+		return 0
+	case guesses < 1e6:
+		return 1
+	case guesses < 1e8:
+		return 2
+	case guesses < 1e10:
+		return 3
+	}
+
+	return 4
+}
+
+const (
+	bruteforceCardinality = 10
+	minSubmatchGuesses    = 50
+	minMatchLength        = 3
+)
+
+func estimateGuesses(password string, userWords []string) float64 {
+	runes := []rune(password)
+	guesses := 1.0
+	matches := 0
+	bruteforce := 0
+	flushBruteforce := func() {
+		if bruteforce > 0 {
+			guesses *= math.Pow(bruteforceCardinality, float64(bruteforce))
+			matches++
+			bruteforce = 0
+		}
+	}
+
+	for i := 0; i < len(runes); {
+		length, g := bestMatch(runes[i:], userWords)
+		if length == 0 {
+			bruteforce++
+			i++
+			continue
+		}
+
+		flushBruteforce()
+		guesses *= g
+		matches++
+		i += length
+	}
+	flushBruteforce()
+
+	// The order of the parts has to be guessed as well
+	for k := 2; k <= matches; k++ {
+		guesses *= float64(k)
+	}
+
+	return guesses
+}
+
+// bestMatch returns the length and guesses of the longest pattern at the start
+// of s, zero length if there is none.
+func bestMatch(s []rune, userWords []string) (int, float64) {
+	bestLength, bestGuesses := 0, 0.0
+	consider := func(length int, guesses float64) {
+		if length < minMatchLength {
+			return
+		}
+
+		guesses = math.Max(guesses, minSubmatchGuesses)
+		if length > bestLength || (length == bestLength && guesses < bestGuesses) {
+			bestLength, bestGuesses = length, guesses
+		}
+	}
+
+	consider(dictionaryMatch(s, userWords))
+	consider(repeatMatch(s))
+	consider(sequenceMatch(s))
+	consider(keyboardMatch(s))
+	return bestLength, bestGuesses
+}
+
+func dictionaryMatch(s []rune, userWords []string) (int, float64) {
+	lower := strings.ToLower(string(s))
+	unleeted := strings.Map(unleet, lower)
+
+	bestLength, bestGuesses := 0, 0.0
+	match := func(word string, rank int) {
+		n := len([]rune(word))
+		if n <= bestLength {
+			return
+		}
+
+		var leeted bool
+		switch {
+		case strings.HasPrefix(lower, word):
+		case strings.HasPrefix(unleeted, word):
+			leeted = true
+		default:
+			return
+		}
+
+		guesses := float64(rank) * uppercaseVariations(s[:n])
+		if leeted {
+			guesses *= 2
+		}
+
+		bestLength, bestGuesses = n, guesses
+	}
+
+	for _, word := range userWords {
+		match(word, 1)
+	}
+
+	for i, word := range commonWords {
+		match(word, i+1)
+	}
+
+	return bestLength, bestGuesses
+}
+
+func uppercaseVariations(s []rune) float64 {
+	var upper int
+	for _, r := range s {
+		if unicode.IsUpper(r) {
+			upper++
+		}
+	}
+
+	switch {
+	case upper == 0:
+		return 1
+	case upper == len(s) || (upper == 1 && unicode.IsUpper(s[0])):
+		return 2
+	}
+
+	return math.Pow(2, float64(upper))
+}
+
+func unleet(r rune) rune {
+	switch r {
+	case '4', '@':
+		return 'a'
+	case '3':
+		return 'e'
+	case '1', '!':
+		return 'i'
+	case '0':
+		return 'o'
+	case '$', '5':
+		return 's'
+	case '7':
+		return 't'
+	}
+
+	return r
+}
+
+func repeatMatch(s []rune) (int, float64) {
+	n := 1
+	for n < len(s) && s[n] == s[0] {
+		n++
+	}
+
+	return n, cardinality(s[0]) * float64(n)
+}
+
+func sequenceMatch(s []rune) (int, float64) {
+	if len(s) < 2 {
+		return 0, 0
+	}
+
+	delta := s[1] - s[0]
+	if delta != 1 && delta != -1 {
+		return 0, 0
+	}
+
+	n := 2
+	for n < len(s) && s[n]-s[n-1] == delta {
+		n++
+	}
+
+	base := cardinality(s[0])
+	if strings.ContainsRune("aAzZ019", s[0]) {
+		base = 4
+	}
+
+	if delta < 0 {
+		base *= 2
+	}
+
+	return n, base * float64(n)
+}
+
+var keyboardRows = []string{
+	"`1234567890-=",
+	"qwertyuiop[]\\",
+	"asdfghjkl;'",
+	"zxcvbnm,./",
+}
+
+func keyboardMatch(s []rune) (int, float64) {
+	lower := []rune(strings.ToLower(string(s)))
+	for _, row := range keyboardRows {
+		pos := strings.IndexRune(row, lower[0])
+		if pos < 0 {
+			continue
+		}
+
+		n := 1
+		for n < len(lower) {
+			next := strings.IndexRune(row, lower[n])
+			if next < 0 || (next != pos+1 && next != pos-1) {
+				break
+			}
+
+			pos = next
+			n++
+		}
+
+		return n, 40 * float64(n)
+	}
+
+	return 0, 0
+}
+
+func cardinality(r rune) float64 {
+	switch {
+	case unicode.IsDigit(r):
+		return 10
+	case unicode.IsLower(r), unicode.IsUpper(r):
+		return 26
+	}
+
+	return 33
+}
+
+func ownerWords(owner PasswordOwner) []string {
+	var words []string
+	for _, part := range strings.Fields(strings.ToLower(owner.FullName)) {
+		if len(part) >= minMatchLength {
+			words = append(words, part)
+		}
+	}
+
+	if phone := strings.TrimPrefix(owner.PhoneNumber, "+"); phone != "" {
+		words = append(words, phone)
+	}
+
+	return words
+}
+
+// commonWords are frequently used passwords and words, most common first.
+var commonWords = []string{
+	"password", "qwerty", "123456", "iloveyou", "admin", "welcome", "letmein",
+	"secret", "monkey", "dragon", "sunshine", "princess", "football", "baseball",
+	"master", "shadow", "superman", "batman", "trustno1", "starwars", "whatever",
+	"freedom", "hello", "charlie", "login", "michael", "jessica", "ashley",
+	"hunter", "ranger", "buster", "soccer", "hockey", "killer", "pepper",
+	"summer", "winter", "spring", "autumn", "flower", "cookie", "cheese",
+	"orange", "banana", "purple", "silver", "golden", "tiger", "lovely",
+	"access", "mustang", "computer", "internet", "samsung", "google", "jakarta",
+	"indonesia", "rahasia", "sayang", "cinta", "bismillah", "ganteng", "cantik",
+	"kucing", "anjing", "merdeka", "garuda", "changeme", "default", "guest",
+	"test", "user", "pass", "love", "angel", "blessed", "family", "money",
+	"lucky", "happy", "smile", "friend", "forever", "heaven", "matrix", "ninja",
+	"pokemon", "naruto", "qazwsx", "asdfgh", "zxcvbn", "abc", "abcd",
+}
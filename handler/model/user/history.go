@@ -0,0 +1,86 @@
+package user
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrPasswordReused = errors.New("password reused")
+
+// PasswordRecord is a password hash the user had before, kept to prevent
+// cycling back to it.
+type PasswordRecord struct {
+	hash      []byte
+	salt      []byte
+	pepperID  string
+	createdAt time.Time
+}
+
+func NewPasswordRecord(hash, salt []byte, pepperID string, createdAt time.Time) (*PasswordRecord, error) {
+	if len(hash) == 0 {
+		return nil, errors.New("empty password hash")
+	}
+
+	if len(salt) == 0 && hash[0] != '$' {
+		return nil, errors.New("empty password salt")
+	}
+
+	if createdAt.IsZero() {
+		return nil, errors.New("empty created time")
+	}
+
+	return &PasswordRecord{
+		hash:      hash,
+		salt:      salt,
+		pepperID:  pepperID,
+		createdAt: createdAt,
+	}, nil
+}
+
+func (pr *PasswordRecord) Hash() []byte {
+	return pr.hash
+}
+
+func (pr *PasswordRecord) Salt() []byte {
+	return pr.salt
+}
+
+func (pr *PasswordRecord) PepperID() string {
+	return pr.pepperID
+}
+
+// CreatedAt returns when the password was replaced.
+func (pr *PasswordRecord) CreatedAt() time.Time {
+	return pr.createdAt
+}
+
+func (pr *PasswordRecord) Matches(plain string) bool {
+	return verifyPassword(plain, pr.hash, pr.salt, pr.pepperID)
+}
+
+// PasswordRecord returns the current password to be kept in the history once
+// it is replaced.
+func (u *User) PasswordRecord(now time.Time) *PasswordRecord {
+	return &PasswordRecord{
+		hash:      u.passwordHash,
+		salt:      u.passwordSalt,
+		pepperID:  u.passwordPepperID,
+		createdAt: now,
+	}
+}
+
+// passwordReused reports whether the password is the current one or one of
+// the history.
+func (u *User) passwordReused(plain string, history []*PasswordRecord) bool {
+	if u.VerifyPassword(plain) {
+		return true
+	}
+
+	for _, pr := range history {
+		if pr.Matches(plain) {
+			return true
+		}
+	}
+
+	return false
+}
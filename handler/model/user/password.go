@@ -1,13 +1,149 @@
 package user
 
 import (
-	"bytes"
+	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
 
+	"github.com/SawitProRecruitment/UserService/pepper"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
 	"golang.org/x/crypto/pbkdf2"
 )
 
+// Password hashes are stored as self-describing PHC strings, e.g.
+//
+//	$argon2id$v=19$m=19456,t=2,p=1$<salt>$<hash>
+//	$pbkdf2-sha256$i=10000,l=32$<salt>$<hash>
+//
+// bcrypt keeps its own modular crypt format ($2a$10$...). Hashes created
+// before the PHC format are raw PBKDF2-SHA256 keys with a separate salt.
+//
+// When a pepper is configured the password is first keyed with HMAC-SHA256
+// using the pepper, whose key ID is stored along with the hash.
+
+type HashAlgorithm string
+
+const (
+	Argon2id     HashAlgorithm = "argon2id"
+	Bcrypt       HashAlgorithm = "bcrypt"
+	PBKDF2SHA256 HashAlgorithm = "pbkdf2-sha256"
+)
+
+// HashParams configures how new password hashes are computed.
+type HashParams struct {
+	Algorithm HashAlgorithm
+
+	// Argon2Memory is in KiB.
+	Argon2Memory  uint32
+	Argon2Time    uint32
+	Argon2Threads uint8
+
+	BcryptCost int
+
+	PBKDF2Iterations int
+}
+
+// DefaultHashParams follows the OWASP recommendation for argon2id.
+func DefaultHashParams() HashParams {
+	return HashParams{
+		Algorithm:        Argon2id,
+		Argon2Memory:     19 * 1024,
+		Argon2Time:       2,
+		Argon2Threads:    1,
+		BcryptCost:       12,
+		PBKDF2Iterations: 600000,
+	}
+}
+
+func (hp HashParams) validate() error {
+	switch hp.Algorithm {
+	case Argon2id:
+		if hp.Argon2Memory == 0 || hp.Argon2Time == 0 || hp.Argon2Threads == 0 {
+			return errors.New("argon2id memory, time and threads should be positive")
+		}
+	case Bcrypt:
+		if hp.BcryptCost < bcrypt.MinCost || hp.BcryptCost > bcrypt.MaxCost {
+			return fmt.Errorf("bcrypt cost should be within %d-%d", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+	case PBKDF2SHA256:
+		if hp.PBKDF2Iterations <= 0 {
+			return errors.New("pbkdf2 iterations should be positive")
+		}
+	default:
+		return fmt.Errorf("unknown hash algorithm %q", hp.Algorithm)
+	}
+
+	return nil
+}
+
+var hashParams = DefaultHashParams()
+
+// SetHashParams changes how new password hashes are computed. Existing hashes
+// computed differently are upgraded on the next successful login. It is meant
+// to be called once on startup.
+func SetHashParams(hp HashParams) error {
+	if err := hp.validate(); err != nil {
+		return err
+	}
+
+	hashParams = hp
+	return nil
+}
+
+var pepperProvider pepper.KeyProvider
+
+// SetPepperProvider enables peppering of new password hashes. Hashes peppered
+// with another key than the current one are upgraded on the next successful
+// login. It is meant to be called once on startup.
+func SetPepperProvider(kp pepper.KeyProvider) {
+	pepperProvider = kp
+}
+
+func currentPepperID() (string, error) {
+	if pepperProvider == nil {
+		return "", nil
+	}
+
+	return pepperProvider.CurrentKeyID()
+}
+
+// pepperPassword keys the password with the pepper, the password is returned
+// as is when pepperID is empty.
+func pepperPassword(password, pepperID string) (string, error) {
+	if pepperID == "" {
+		return password, nil
+	}
+
+	if pepperProvider == nil {
+		return "", errors.New("no pepper provider")
+	}
+
+	key, err := pepperProvider.Key(pepperID)
+	if err != nil {
+		return "", err
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(password))
+	return b64.EncodeToString(mac.Sum(nil)), nil
+}
+
+const (
+	saltLength = 16
+	keyLength  = 32
+
+	legacyIterations = 10000
+)
+
+var b64 = base64.RawStdEncoding
+
 func genSalt(length int) ([]byte, error) {
 	salt := make([]byte, length)
 	_, err := rand.Read(salt)
@@ -18,13 +154,226 @@ func genSalt(length int) ([]byte, error) {
 	return salt, nil
 }
 
-func hashPassword(password string, salt []byte) []byte {
-	iterations := 10000
-	keyLength := 32
+// hashPassword returns the encoded hash of the password using the configured
+// parameters and the ID of the pepper applied, if any.
+func hashPassword(password string, hp HashParams) (hash []byte, pepperID string, err error) {
+	pepperID, err = currentPepperID()
+	if err != nil {
+		return nil, "", err
+	}
+
+	password, err = pepperPassword(password, pepperID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	hash, err = encodeHash(password, hp)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return hash, pepperID, nil
+}
+
+func encodeHash(password string, hp HashParams) ([]byte, error) {
+	if hp.Algorithm == Bcrypt {
+		return bcrypt.GenerateFromPassword([]byte(password), hp.BcryptCost)
+	}
+
+	salt, err := genSalt(saltLength)
+	if err != nil {
+		return nil, err
+	}
+
+	switch hp.Algorithm {
+	case Argon2id:
+		key := argon2.IDKey([]byte(password), salt, hp.Argon2Time, hp.Argon2Memory, hp.Argon2Threads, keyLength)
+		return []byte(fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+			argon2.Version, hp.Argon2Memory, hp.Argon2Time, hp.Argon2Threads, b64.EncodeToString(salt), b64.EncodeToString(key))), nil
+	case PBKDF2SHA256:
+		key := pbkdf2.Key([]byte(password), salt, hp.PBKDF2Iterations, keyLength, sha256.New)
+		return []byte(fmt.Sprintf("$pbkdf2-sha256$i=%d,l=%d$%s$%s",
+			hp.PBKDF2Iterations, keyLength, b64.EncodeToString(salt), b64.EncodeToString(key))), nil
+	}
+
+	return nil, fmt.Errorf("unknown hash algorithm %q", hp.Algorithm)
+}
+
+// verifyPassword checks the password against an encoded hash. legacySalt is
+// only used by hashes predating the PHC format.
+func verifyPassword(password string, encoded, legacySalt []byte, pepperID string) bool {
+	ph, err := parseHash(encoded, legacySalt)
+	if err != nil {
+		return false
+	}
+
+	password, err = pepperPassword(password, pepperID)
+	if err != nil {
+		return false
+	}
+
+	if ph.params.Algorithm == Bcrypt {
+		return bcrypt.CompareHashAndPassword(encoded, []byte(password)) == nil
+	}
+
+	return subtle.ConstantTimeCompare(ph.derive(password), ph.key) == 1
+}
+
+// needsRehash reports whether the hash was computed differently than the
+// configured parameters and pepper.
+func needsRehash(encoded, legacySalt []byte, pepperID string, hp HashParams) bool {
+	ph, err := parseHash(encoded, legacySalt)
+	if err != nil || ph.legacy || ph.params.Algorithm != hp.Algorithm {
+		return true
+	}
+
+	// An unavailable provider should not trigger rehashing on every login
+	if currentID, err := currentPepperID(); err == nil && currentID != pepperID {
+		return true
+	}
+
+	switch hp.Algorithm {
+	case Argon2id:
+		return ph.params.Argon2Memory != hp.Argon2Memory ||
+			ph.params.Argon2Time != hp.Argon2Time ||
+			ph.params.Argon2Threads != hp.Argon2Threads
+	case Bcrypt:
+		return ph.params.BcryptCost != hp.BcryptCost
+	case PBKDF2SHA256:
+		return ph.params.PBKDF2Iterations != hp.PBKDF2Iterations
+	}
+
+	return true
+}
+
+var dummy struct {
+	mu       sync.Mutex
+	hash     []byte
+	pepperID string
+}
+
+// VerifyDummyPassword takes as long as verifying a real password but never
+// succeeds. It is used when there is no user to check against, so response
+// time does not tell whether the user exists.
+func VerifyDummyPassword(plain string) {
+	hash, pepperID := dummyHash()
+	verifyPassword(plain, hash, nil, pepperID)
+}
+
+func dummyHash() ([]byte, string) {
+	dummy.mu.Lock()
+	defer dummy.mu.Unlock()
+
+	if dummy.hash == nil || needsRehash(dummy.hash, nil, dummy.pepperID, hashParams) {
+		hash, pepperID, err := hashPassword("dummy password", hashParams)
+		if err != nil {
+			return nil, ""
+		}
+
+		dummy.hash, dummy.pepperID = hash, pepperID
+	}
+
+	return dummy.hash, dummy.pepperID
+}
 
-	return pbkdf2.Key([]byte(password), salt, iterations, keyLength, sha256.New)
+type parsedHash struct {
+	params HashParams
+	salt   []byte
+	key    []byte
+	legacy bool
 }
 
-func verifyPassword(password string, salt []byte, hashedPassword []byte) bool {
-	return bytes.Equal(hashPassword(password, salt), hashedPassword)
+func (ph parsedHash) derive(password string) []byte {
+	switch ph.params.Algorithm {
+	case Argon2id:
+		return argon2.IDKey([]byte(password), ph.salt, ph.params.Argon2Time, ph.params.Argon2Memory, ph.params.Argon2Threads, uint32(len(ph.key)))
+	case PBKDF2SHA256:
+		return pbkdf2.Key([]byte(password), ph.salt, ph.params.PBKDF2Iterations, len(ph.key), sha256.New)
+	}
+
+	return nil
+}
+
+func parseHash(encoded, legacySalt []byte) (parsedHash, error) {
+	if len(encoded) == 0 {
+		return parsedHash{}, errors.New("empty hash")
+	}
+
+	// Legacy keys are raw bytes, which may start with '$' too, stored along
+	// with their salt. PHC strings carry their own.
+	if len(legacySalt) > 0 {
+		return parsedHash{
+			params: HashParams{Algorithm: PBKDF2SHA256, PBKDF2Iterations: legacyIterations},
+			salt:   legacySalt,
+			key:    encoded,
+			legacy: true,
+		}, nil
+	}
+
+	if encoded[0] != '$' {
+		return parsedHash{}, errors.New("missing legacy salt")
+	}
+
+	if strings.HasPrefix(string(encoded), "$2") {
+		cost, err := bcrypt.Cost(encoded)
+		if err != nil {
+			return parsedHash{}, err
+		}
+
+		return parsedHash{
+			params: HashParams{Algorithm: Bcrypt, BcryptCost: cost},
+		}, nil
+	}
+
+	parts := strings.Split(string(encoded), "$")
+	switch {
+	case len(parts) == 6 && parts[1] == string(Argon2id):
+		var version int
+		if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+			return parsedHash{}, err
+		}
+
+		if version != argon2.Version {
+			return parsedHash{}, fmt.Errorf("unsupported argon2 version %d", version)
+		}
+
+		hp := HashParams{Algorithm: Argon2id}
+		if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &hp.Argon2Memory, &hp.Argon2Time, &hp.Argon2Threads); err != nil {
+			return parsedHash{}, err
+		}
+
+		return decodeSaltAndKey(hp, parts[4], parts[5])
+	case len(parts) == 5 && parts[1] == string(PBKDF2SHA256):
+		var length int
+		hp := HashParams{Algorithm: PBKDF2SHA256}
+		if _, err := fmt.Sscanf(parts[2], "i=%d,l=%d", &hp.PBKDF2Iterations, &length); err != nil {
+			return parsedHash{}, err
+		}
+
+		return decodeSaltAndKey(hp, parts[3], parts[4])
+	}
+
+	return parsedHash{}, errors.New("unknown hash format")
+}
+
+func decodeSaltAndKey(hp HashParams, encodedSalt, encodedKey string) (parsedHash, error) {
+	salt, err := b64.DecodeString(encodedSalt)
+	if err != nil {
+		return parsedHash{}, err
+	}
+
+	key, err := b64.DecodeString(encodedKey)
+	if err != nil {
+		return parsedHash{}, err
+	}
+
+	if len(salt) == 0 || len(key) == 0 {
+		return parsedHash{}, errors.New("empty salt or key")
+	}
+
+	return parsedHash{
+		params: hp,
+		salt:   salt,
+		key:    key,
+	}, nil
 }
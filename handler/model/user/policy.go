@@ -0,0 +1,296 @@
+package user
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// PasswordViolation identifies a password rule that is not met.
+type PasswordViolation string
+
+const (
+	PasswordTooWeak              PasswordViolation = "weak"
+	PasswordBreached             PasswordViolation = "breached"
+	PasswordContainsPersonalInfo PasswordViolation = "personal_info"
+	PasswordLowEntropy           PasswordViolation = "low_entropy"
+)
+
+// WeakPasswordError lists the rules a password does not meet. It matches
+// ErrWeakPassword with errors.Is.
+type WeakPasswordError struct {
+	Violations []PasswordViolation
+}
+
+func (wpe *WeakPasswordError) Error() string {
+	return ErrWeakPassword.Error()
+}
+
+func (wpe *WeakPasswordError) Is(target error) bool {
+	return target == ErrWeakPassword
+}
+
+// PasswordOwner holds what a password should not contain. Fields may be empty
+// when they are not known yet.
+type PasswordOwner struct {
+	PhoneNumber string
+	FullName    string
+}
+
+// PasswordRule checks a password, returning empty violation when the rule is
+// met.
+type PasswordRule interface {
+	Check(password string, owner PasswordOwner) (PasswordViolation, error)
+}
+
+// PasswordPolicy is the set of rules new passwords have to meet.
+type PasswordPolicy struct {
+	MinLength        int
+	MaxLength        int
+	RequireUppercase bool
+	RequireLowercase bool
+	RequireDigit     bool
+	// RequireSpecial requires a non-alphanumeric character.
+	RequireSpecial bool
+
+	RejectPersonalInfo bool
+	// MinScore is the minimum PasswordScore, zero accepts any score.
+	MinScore int
+	// BreachedSource rejects breached passwords when set.
+	BreachedSource BreachedSource
+	// ExtraRules are checked after the ones above.
+	ExtraRules []PasswordRule
+
+	// HistorySize is the number of most recent passwords, the current one
+	// included, that cannot be reused. Zero allows any reuse.
+	HistorySize int
+}
+
+func DefaultPasswordPolicy() PasswordPolicy {
+	return PasswordPolicy{
+		MinLength:          6,
+		MaxLength:          64,
+		RequireUppercase:   true,
+		RequireDigit:       true,
+		RequireSpecial:     true,
+		RejectPersonalInfo: true,
+		HistorySize:        5,
+	}
+}
+
+const (
+	maxPasswordLength  = 256
+	maxPasswordScore   = 4
+	maxPasswordHistory = 24
+)
+
+func (pp PasswordPolicy) validate() error {
+	if pp.MinLength < 1 {
+		return errors.New("min length should be positive")
+	}
+
+	if pp.MaxLength < pp.MinLength || pp.MaxLength > maxPasswordLength {
+		return fmt.Errorf("max length should be within min length-%d", maxPasswordLength)
+	}
+
+	if pp.MinScore < 0 || pp.MinScore > maxPasswordScore {
+		return fmt.Errorf("min score should be within 0-%d", maxPasswordScore)
+	}
+
+	if pp.HistorySize < 0 || pp.HistorySize > maxPasswordHistory {
+		return fmt.Errorf("history size should be within 0-%d", maxPasswordHistory)
+	}
+
+	return nil
+}
+
+// ValidStrength checks the length and character classes of the password.
+func (pp PasswordPolicy) ValidStrength(password string) bool {
+	if len(password) < pp.MinLength || len(password) > pp.MaxLength {
+		return false
+	}
+
+	if pp.RequireUppercase && !containsUppercase(password) {
+		return false
+	}
+
+	if pp.RequireLowercase && !containsLowercase(password) {
+		return false
+	}
+
+	if pp.RequireDigit && !containsNumber(password) {
+		return false
+	}
+
+	if pp.RequireSpecial && !containsSpecialCharacter(password) {
+		return false
+	}
+
+	return true
+}
+
+// Check returns the rules the password does not meet.
+func (pp PasswordPolicy) Check(password string, owner PasswordOwner) ([]PasswordViolation, error) {
+	var violations []PasswordViolation
+	for _, rule := range pp.rules() {
+		v, err := rule.Check(password, owner)
+		if err != nil {
+			return nil, err
+		}
+
+		if v != "" {
+			violations = append(violations, v)
+		}
+	}
+
+	return violations, nil
+}
+
+func (pp PasswordPolicy) rules() []PasswordRule {
+	rules := []PasswordRule{strengthRule{policy: pp}}
+	if pp.RejectPersonalInfo {
+		rules = append(rules, PersonalInfoRule{})
+	}
+
+	if pp.BreachedSource != nil {
+		rules = append(rules, BreachedRule{Source: pp.BreachedSource})
+	}
+
+	if pp.MinScore > 0 {
+		rules = append(rules, EntropyRule{MinScore: pp.MinScore})
+	}
+
+	return append(rules, pp.ExtraRules...)
+}
+
+var passwordPolicy = DefaultPasswordPolicy()
+
+// SetPasswordPolicy changes the rules new passwords have to meet. It is meant
+// to be called once on startup.
+func SetPasswordPolicy(pp PasswordPolicy) error {
+	if err := pp.validate(); err != nil {
+		return err
+	}
+
+	passwordPolicy = pp
+	return nil
+}
+
+func CurrentPasswordPolicy() PasswordPolicy {
+	return passwordPolicy
+}
+
+// CheckPassword returns the rules of the current policy the password does not
+// meet.
+func CheckPassword(password string, owner PasswordOwner) ([]PasswordViolation, error) {
+	return passwordPolicy.Check(password, owner)
+}
+
+func checkPassword(password string, owner PasswordOwner) error {
+	violations, err := CheckPassword(password, owner)
+	if err != nil {
+		return err
+	}
+
+	if len(violations) > 0 {
+		return &WeakPasswordError{Violations: violations}
+	}
+
+	return nil
+}
+
+type strengthRule struct {
+	policy PasswordPolicy
+}
+
+func (sr strengthRule) Check(password string, _ PasswordOwner) (PasswordViolation, error) {
+	if !sr.policy.ValidStrength(password) {
+		return PasswordTooWeak, nil
+	}
+
+	return "", nil
+}
+
+// PersonalInfoRule rejects passwords containing a part of the full name or
+// the phone number.
+type PersonalInfoRule struct{}
+
+const (
+	minNamePartLength   = 3
+	minPhoneDigitsShown = 6
+)
+
+func (PersonalInfoRule) Check(password string, owner PasswordOwner) (PasswordViolation, error) {
+	lower := strings.ToLower(password)
+	for _, part := range strings.Fields(strings.ToLower(owner.FullName)) {
+		if len(part) >= minNamePartLength && strings.Contains(lower, part) {
+			return PasswordContainsPersonalInfo, nil
+		}
+	}
+
+	digits := strings.Map(func(r rune) rune {
+		if unicode.IsDigit(r) {
+			return r
+		}
+
+		return -1
+	}, owner.PhoneNumber)
+	for i := 0; i+minPhoneDigitsShown <= len(digits); i++ {
+		if strings.Contains(password, digits[i:i+minPhoneDigitsShown]) {
+			return PasswordContainsPersonalInfo, nil
+		}
+	}
+
+	return "", nil
+}
+
+// BreachedSource returns the breached password hashes sharing a SHA-1 prefix
+// of 5 hex characters, following the k-anonymity range API of Pwned
+// Passwords. Each entry is the uppercase hash suffix optionally followed by
+// ":COUNT".
+type BreachedSource interface {
+	Range(prefix string) ([]string, error)
+}
+
+// BreachedRule rejects passwords found in a breach. Only the hash prefix is
+// given to the source.
+type BreachedRule struct {
+	Source BreachedSource
+}
+
+func (br BreachedRule) Check(password string, _ PasswordOwner) (PasswordViolation, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	entries, err := br.Source.Range(prefix)
+	if err != nil {
+		return "", err
+	}
+
+	for _, entry := range entries {
+		entrySuffix, _, _ := strings.Cut(entry, ":")
+		if strings.EqualFold(entrySuffix, suffix) {
+			return PasswordBreached, nil
+		}
+	}
+
+	return "", nil
+}
+
+// EntropyRule rejects passwords scored below MinScore, on the 0-4 scale of
+// PasswordScore.
+type EntropyRule struct {
+	MinScore int
+}
+
+func (er EntropyRule) Check(password string, owner PasswordOwner) (PasswordViolation, error) {
+	if PasswordScore(password, owner) < er.MinScore {
+		return PasswordLowEntropy, nil
+	}
+
+	return "", nil
+}
@@ -3,19 +3,34 @@ package user
 import (
 	"errors"
 	"strings"
+	"time"
 
 	"github.com/rs/xid"
 )
 
+var (
+	ErrIncorrectPassword = errors.New("incorrect password")
+	ErrWeakPassword      = errors.New("weak password")
+)
+
 type User struct {
 	id           string
 	phoneNumber  string
 	fullName     string
 	passwordHash []byte
 	passwordSalt []byte
+	// passwordPepperID is the ID of the pepper key applied before hashing, or
+	// empty string if the hash is not peppered.
+	passwordPepperID string
+
+	// The phone number is unverified while phoneVerifiedAt is zero. A changed
+	// phone number stays pending until it is verified, meanwhile the current
+	// one keeps working.
+	phoneVerifiedAt    time.Time
+	pendingPhoneNumber string
 }
 
-func New(id, phoneNumber, fullName string, passwordHash []byte, passwordSalt []byte) (*User, error) {
+func New(id, phoneNumber, fullName string, passwordHash []byte, passwordSalt []byte, passwordPepperID string, phoneVerifiedAt time.Time, pendingPhoneNumber string) (*User, error) {
 	if id == "" {
 		return nil, errors.New("empty id")
 	}
@@ -36,32 +51,40 @@ func New(id, phoneNumber, fullName string, passwordHash []byte, passwordSalt []b
 		return nil, errors.New("empty password hash")
 	}
 
-	if len(passwordSalt) == 0 {
+	if len(passwordSalt) == 0 && passwordHash[0] != '$' {
 		return nil, errors.New("empty password salt")
 	}
 
+	if pendingPhoneNumber != "" && (!ValidPhoneNumberLength(pendingPhoneNumber) || !ValidPhoneNumberPrefix(pendingPhoneNumber)) {
+		return nil, errors.New("invalid pending phone number")
+	}
+
 	return &User{
-		id:           id,
-		phoneNumber:  phoneNumber,
-		fullName:     fullName,
-		passwordHash: passwordHash,
-		passwordSalt: passwordSalt,
+		id:                 id,
+		phoneNumber:        phoneNumber,
+		fullName:           fullName,
+		passwordHash:       passwordHash,
+		passwordSalt:       passwordSalt,
+		passwordPepperID:   passwordPepperID,
+		phoneVerifiedAt:    phoneVerifiedAt,
+		pendingPhoneNumber: pendingPhoneNumber,
 	}, nil
 }
 
+// NewWithPassword creates a user with a password meeting the configured rules,
+// a *WeakPasswordError is returned otherwise.
 func NewWithPassword(id, phoneNumber, fullName, password string) (*User, error) {
-	if !ValidPasswordStrength(password) {
-		return nil, errors.New("invalid password")
+	err := checkPassword(password, PasswordOwner{PhoneNumber: phoneNumber, FullName: fullName})
+	if err != nil {
+		return nil, err
 	}
 
-	salt, err := genSalt(16)
+	hash, pepperID, err := hashPassword(password, hashParams)
 	if err != nil {
 		return nil, err
 	}
 
-	hash := hashPassword(password, salt)
-
-	return New(id, phoneNumber, fullName, hash, salt)
+	return New(id, phoneNumber, fullName, hash, nil, pepperID, time.Time{}, "")
 }
 
 func (u *User) ID() string {
@@ -81,10 +104,80 @@ func (u *User) ChangePhoneNumber(phoneNumber string) error {
 		return errors.New("invalid phone number prefix")
 	}
 
+	if phoneNumber != u.phoneNumber {
+		u.phoneVerifiedAt = time.Time{}
+	}
+
 	u.phoneNumber = phoneNumber
 	return nil
 }
 
+func (u *User) PhoneVerified() bool {
+	return !u.phoneVerifiedAt.IsZero()
+}
+
+func (u *User) PhoneVerifiedAt() time.Time {
+	return u.phoneVerifiedAt
+}
+
+// PendingPhoneNumber returns the phone number waiting for verification before
+// it replaces the current one, or empty string if there is none.
+func (u *User) PendingPhoneNumber() string {
+	return u.pendingPhoneNumber
+}
+
+// RequestPhoneNumberChange keeps the phone number pending until it is
+// verified with ConfirmPhoneNumber.
+func (u *User) RequestPhoneNumberChange(phoneNumber string) error {
+	if !ValidPhoneNumberLength(phoneNumber) {
+		return errors.New("invalid phone number length")
+	}
+
+	if !ValidPhoneNumberPrefix(phoneNumber) {
+		return errors.New("invalid phone number prefix")
+	}
+
+	if phoneNumber == u.phoneNumber {
+		u.pendingPhoneNumber = ""
+		return nil
+	}
+
+	u.pendingPhoneNumber = phoneNumber
+	return nil
+}
+
+// PhoneNumberToVerify returns the phone number waiting for verification, the
+// pending one takes precedence over an unverified current one.
+func (u *User) PhoneNumberToVerify() string {
+	if u.pendingPhoneNumber != "" {
+		return u.pendingPhoneNumber
+	}
+
+	if !u.PhoneVerified() {
+		return u.phoneNumber
+	}
+
+	return ""
+}
+
+// ConfirmPhoneNumber marks the phone number returned by PhoneNumberToVerify
+// as verified, replacing the current one if it was pending.
+func (u *User) ConfirmPhoneNumber(at time.Time) error {
+	if u.pendingPhoneNumber != "" {
+		u.phoneNumber = u.pendingPhoneNumber
+		u.pendingPhoneNumber = ""
+		u.phoneVerifiedAt = at
+		return nil
+	}
+
+	if u.PhoneVerified() {
+		return errors.New("phone number already verified")
+	}
+
+	u.phoneVerifiedAt = at
+	return nil
+}
+
 func (u *User) FullName() string {
 	return u.fullName
 }
@@ -98,48 +191,91 @@ func (u *User) ChangeFullName(fullName string) error {
 	return nil
 }
 
+// Password returns the encoded password hash. The salt is only set for hashes
+// predating the PHC format, newer hashes embed their own salt.
 func (u *User) Password() (hash, salt []byte) {
 	return u.passwordHash, u.passwordSalt
 }
 
-func (u *User) VerifyPassword(plain string) bool {
-	return verifyPassword(plain, u.passwordSalt, u.passwordHash)
+// PasswordPepperID returns the ID of the pepper key applied to the password
+// hash, or empty string if the hash is not peppered.
+func (u *User) PasswordPepperID() string {
+	return u.passwordPepperID
 }
 
-func ValidPhoneNumberLength(phoneNumber string) bool {
-	return len(phoneNumber) >= 10 && len(phoneNumber) <= 13
+// PasswordOwner returns what the password of the user should not contain.
+func (u *User) PasswordOwner() PasswordOwner {
+	return PasswordOwner{
+		PhoneNumber: u.phoneNumber,
+		FullName:    u.fullName,
+	}
 }
 
-func ValidPhoneNumberPrefix(phoneNumber string) bool {
-	return strings.HasPrefix(phoneNumber, "+62")
+func (u *User) VerifyPassword(plain string) bool {
+	return verifyPassword(plain, u.passwordHash, u.passwordSalt, u.passwordPepperID)
 }
 
-func ValidFullNameLength(fullName string) bool {
-	return len(fullName) >= 3 && len(fullName) <= 60
+// PasswordNeedsRehash reports whether the password hash was computed with an
+// outdated algorithm, cost or pepper.
+func (u *User) PasswordNeedsRehash() bool {
+	return needsRehash(u.passwordHash, u.passwordSalt, u.passwordPepperID, hashParams)
 }
 
-func ValidPasswordStrength(password string) bool {
-	// password must be at min 6 and max 64 characters
-	if len(password) < 6 || len(password) > 64 {
-		return false
+// RehashPassword hashes the password again with the current parameters. It
+// should only be called with the plain password just verified.
+func (u *User) RehashPassword(plain string) error {
+	if !u.VerifyPassword(plain) {
+		return ErrIncorrectPassword
 	}
 
-	// password contains at least one uppercase letter
-	if !containsUppercase(password) {
-		return false
+	return u.setPassword(plain)
+}
+
+// ResetPassword replaces the password without knowing the current one. The
+// caller is responsible of proving the user identity some other way, such as
+// verifying the current password. The history holds the previous passwords,
+// which cannot be reused along with the current one.
+func (u *User) ResetPassword(next string, history []*PasswordRecord) error {
+	err := checkPassword(next, u.PasswordOwner())
+	if err != nil {
+		return err
 	}
 
-	// password contains at least one number
-	if !containsNumber(password) {
-		return false
+	if passwordPolicy.HistorySize > 0 && u.passwordReused(next, history) {
+		return ErrPasswordReused
 	}
 
-	// password contains at least one special character (non-alphanumeric)
-	if !containsSpecialCharacter(password) {
-		return false
+	return u.setPassword(next)
+}
+
+func (u *User) setPassword(plain string) error {
+	hash, pepperID, err := hashPassword(plain, hashParams)
+	if err != nil {
+		return err
 	}
 
-	return true
+	u.passwordHash = hash
+	u.passwordSalt = nil
+	u.passwordPepperID = pepperID
+	return nil
+}
+
+func ValidPhoneNumberLength(phoneNumber string) bool {
+	return len(phoneNumber) >= 10 && len(phoneNumber) <= 13
+}
+
+func ValidPhoneNumberPrefix(phoneNumber string) bool {
+	return strings.HasPrefix(phoneNumber, "+62")
+}
+
+func ValidFullNameLength(fullName string) bool {
+	return len(fullName) >= 3 && len(fullName) <= 60
+}
+
+// ValidPasswordStrength checks the length and character classes required by
+// the current password policy.
+func ValidPasswordStrength(password string) bool {
+	return passwordPolicy.ValidStrength(password)
 }
 
 func NextID() string {
@@ -155,6 +291,15 @@ func containsUppercase(s string) bool {
 	return false
 }
 
+func containsLowercase(s string) bool {
+	for _, c := range s {
+		if 'a' <= c && c <= 'z' {
+			return true
+		}
+	}
+	return false
+}
+
 func containsNumber(s string) bool {
 	for _, c := range s {
 		if '0' <= c && c <= '9' {
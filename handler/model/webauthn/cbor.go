@@ -0,0 +1,164 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// maxCBORDepth bounds the nesting of decoded items, authenticator data never
+// goes deeper than a few levels.
+const maxCBORDepth = 16
+
+var errCBORTruncated = errors.New("cbor: truncated data")
+
+// decodeCBOR decodes the first CBOR item of data and returns the remaining
+// bytes. Only the subset used by authenticators is supported: integers, byte
+// and text strings, arrays, maps and the simple values false, true and null,
+// all with definite lengths. Integers are returned as int64, maps as
+// map[interface{}]interface{} keyed by int64 or string.
+func decodeCBOR(data []byte) (interface{}, []byte, error) {
+	return decodeCBORItem(data, 0)
+}
+
+func decodeCBORItem(data []byte, depth int) (interface{}, []byte, error) {
+	if depth > maxCBORDepth {
+		return nil, nil, errors.New("cbor: nesting too deep")
+	}
+
+	if len(data) == 0 {
+		return nil, nil, errCBORTruncated
+	}
+
+	major := data[0] >> 5
+	info := data[0] & 0x1f
+	if major == 7 {
+		switch info {
+		case 20:
+			return false, data[1:], nil
+		case 21:
+			return true, data[1:], nil
+		case 22:
+			return nil, data[1:], nil
+		default:
+			return nil, nil, fmt.Errorf("cbor: unsupported simple value %d", info)
+		}
+	}
+
+	arg, rest, err := decodeCBORArgument(info, data[1:])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch major {
+	case 0:
+		if arg > 1<<63-1 {
+			return nil, nil, errors.New("cbor: integer overflow")
+		}
+
+		return int64(arg), rest, nil
+	case 1:
+		if arg > 1<<63-1 {
+			return nil, nil, errors.New("cbor: integer overflow")
+		}
+
+		return -1 - int64(arg), rest, nil
+	case 2, 3:
+		if arg > uint64(len(rest)) {
+			return nil, nil, errCBORTruncated
+		}
+
+		if major == 3 {
+			return string(rest[:arg]), rest[arg:], nil
+		}
+
+		b := make([]byte, arg)
+		copy(b, rest)
+		return b, rest[arg:], nil
+	case 4:
+		// Every item takes at least a byte
+		if arg > uint64(len(rest)) {
+			return nil, nil, errCBORTruncated
+		}
+
+		arr := make([]interface{}, 0, arg)
+		for i := uint64(0); i < arg; i++ {
+			var item interface{}
+			item, rest, err = decodeCBORItem(rest, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			arr = append(arr, item)
+		}
+
+		return arr, rest, nil
+	case 5:
+		if arg > uint64(len(rest))/2 {
+			return nil, nil, errCBORTruncated
+		}
+
+		m := make(map[interface{}]interface{}, arg)
+		for i := uint64(0); i < arg; i++ {
+			var key, value interface{}
+			key, rest, err = decodeCBORItem(rest, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, nil, errors.New("cbor: unsupported map key")
+			}
+
+			if _, ok := m[key]; ok {
+				return nil, nil, errors.New("cbor: duplicate map key")
+			}
+
+			value, rest, err = decodeCBORItem(rest, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			m[key] = value
+		}
+
+		return m, rest, nil
+	default:
+		return nil, nil, fmt.Errorf("cbor: unsupported major type %d", major)
+	}
+}
+
+func decodeCBORArgument(info byte, data []byte) (uint64, []byte, error) {
+	switch {
+	case info < 24:
+		return uint64(info), data, nil
+	case info == 24:
+		if len(data) < 1 {
+			return 0, nil, errCBORTruncated
+		}
+
+		return uint64(data[0]), data[1:], nil
+	case info == 25:
+		if len(data) < 2 {
+			return 0, nil, errCBORTruncated
+		}
+
+		return uint64(binary.BigEndian.Uint16(data)), data[2:], nil
+	case info == 26:
+		if len(data) < 4 {
+			return 0, nil, errCBORTruncated
+		}
+
+		return uint64(binary.BigEndian.Uint32(data)), data[4:], nil
+	case info == 27:
+		if len(data) < 8 {
+			return 0, nil, errCBORTruncated
+		}
+
+		return binary.BigEndian.Uint64(data), data[8:], nil
+	default:
+		return 0, nil, errors.New("cbor: indefinite length not supported")
+	}
+}
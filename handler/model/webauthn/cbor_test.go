@@ -0,0 +1,196 @@
+package webauthn
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecodeCBOR(t *testing.T) {
+	testCases := map[string]struct {
+		data       []byte
+		expect     interface{}
+		expectRest []byte
+		expectErr  string
+	}{
+		"small integer": {
+			data:   []byte{0x17},
+			expect: int64(23),
+		},
+		"one byte integer": {
+			data:   []byte{0x18, 0x64},
+			expect: int64(100),
+		},
+		"two bytes integer": {
+			data:   []byte{0x19, 0x03, 0xe8},
+			expect: int64(1000),
+		},
+		"largest integer": {
+			data:   []byte{0x1b, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+			expect: int64(1<<63 - 1),
+		},
+		"negative integer": {
+			data:   []byte{0x38, 0x63},
+			expect: int64(-100),
+		},
+		"smallest negative integer": {
+			data:   []byte{0x3b, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+			expect: int64(-1 << 63),
+		},
+		"byte string": {
+			data:   []byte{0x43, 0x01, 0x02, 0x03},
+			expect: []byte{0x01, 0x02, 0x03},
+		},
+		"text string": {
+			data:   []byte{0x63, 'a', 'b', 'c'},
+			expect: "abc",
+		},
+		"array": {
+			data:   []byte{0x82, 0x01, 0x20},
+			expect: []interface{}{int64(1), int64(-1)},
+		},
+		"map": {
+			data:   []byte{0xa2, 0x01, 0x02, 0x61, 'k', 0xf5},
+			expect: map[interface{}]interface{}{int64(1): int64(2), "k": true},
+		},
+		"simple values": {
+			data:   []byte{0x83, 0xf4, 0xf5, 0xf6},
+			expect: []interface{}{false, true, nil},
+		},
+		"trailing bytes": {
+			data:       []byte{0x01, 0x02, 0x03},
+			expect:     int64(1),
+			expectRest: []byte{0x02, 0x03},
+		},
+		"deepest nesting": {
+			data:   append(bytes.Repeat([]byte{0x81}, maxCBORDepth), 0x00),
+			expect: nested(maxCBORDepth, int64(0)),
+		},
+		"empty": {
+			data:      nil,
+			expectErr: "truncated",
+		},
+		"truncated argument": {
+			data:      []byte{0x19, 0x03},
+			expectErr: "truncated",
+		},
+		"truncated byte string": {
+			data:      []byte{0x43, 0x01, 0x02},
+			expectErr: "truncated",
+		},
+		"truncated text string": {
+			data:      []byte{0x63, 'a'},
+			expectErr: "truncated",
+		},
+		"truncated array": {
+			data:      []byte{0x82, 0x01},
+			expectErr: "truncated",
+		},
+		"truncated map": {
+			data:      []byte{0xa1, 0x01},
+			expectErr: "truncated",
+		},
+		"oversized byte string": {
+			data:      []byte{0x5b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x00},
+			expectErr: "truncated",
+		},
+		"oversized array": {
+			data:      []byte{0x9b, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00},
+			expectErr: "truncated",
+		},
+		"oversized map": {
+			data:      []byte{0xbb, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x01, 0x02},
+			expectErr: "truncated",
+		},
+		"integer overflow": {
+			data:      []byte{0x1b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+			expectErr: "integer overflow",
+		},
+		"negative integer overflow": {
+			data:      []byte{0x3b, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+			expectErr: "integer overflow",
+		},
+		"nesting too deep": {
+			data:      append(bytes.Repeat([]byte{0x81}, maxCBORDepth+1), 0x00),
+			expectErr: "nesting too deep",
+		},
+		"nesting too deep in a map": {
+			data:      append(bytes.Repeat([]byte{0xa1, 0x01}, maxCBORDepth+1), 0x00),
+			expectErr: "nesting too deep",
+		},
+		"indefinite length byte string": {
+			data:      []byte{0x5f, 0x41, 0x01, 0xff},
+			expectErr: "indefinite length",
+		},
+		"indefinite length array": {
+			data:      []byte{0x9f, 0x01, 0xff},
+			expectErr: "indefinite length",
+		},
+		"indefinite length map": {
+			data:      []byte{0xbf, 0x01, 0x02, 0xff},
+			expectErr: "indefinite length",
+		},
+		"reserved argument": {
+			data:      []byte{0x1c},
+			expectErr: "indefinite length",
+		},
+		"tag": {
+			data:      []byte{0xc0, 0x61, 'a'},
+			expectErr: "unsupported major type 6",
+		},
+		"float": {
+			data:      []byte{0xf9, 0x3c, 0x00},
+			expectErr: "unsupported simple value",
+		},
+		"undefined": {
+			data:      []byte{0xf7},
+			expectErr: "unsupported simple value",
+		},
+		"byte string map key": {
+			data:      []byte{0xa1, 0x41, 0x01, 0x02},
+			expectErr: "unsupported map key",
+		},
+		"duplicate map key": {
+			data:      []byte{0xa2, 0x01, 0x02, 0x01, 0x03},
+			expectErr: "duplicate map key",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			// When
+			v, rest, err := decodeCBOR(tc.data)
+
+			// Then
+			if tc.expectErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.expectErr) {
+					t.Fatalf("err got %v, want containing %q", err, tc.expectErr)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(v, tc.expect) {
+				t.Fatalf("value got %#v, want %#v", v, tc.expect)
+			}
+
+			if !bytes.Equal(rest, tc.expectRest) {
+				t.Fatalf("rest got %x, want %x", rest, tc.expectRest)
+			}
+		})
+	}
+}
+
+// nested wraps the item in depth arrays of one item.
+func nested(depth int, item interface{}) interface{} {
+	for i := 0; i < depth; i++ {
+		item = []interface{}{item}
+	}
+
+	return item
+}
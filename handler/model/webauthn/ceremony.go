@@ -0,0 +1,303 @@
+// Package webauthn verifies the WebAuthn registration and assertion
+// ceremonies of passkeys. Only the "none" attestation is accepted, the
+// authenticator model is not checked.
+package webauthn
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrVerification = errors.New("webauthn verification failed")
+
+const (
+	clientDataTypeCreate = "webauthn.create"
+	clientDataTypeGet    = "webauthn.get"
+
+	flagUserPresent       = 0x01
+	flagUserVerified      = 0x04
+	flagAttestedCredData  = 0x40
+	flagExtensionDataIncl = 0x80
+
+	aaguidLength = 16
+)
+
+// RelyingParty identifies the service passkeys are registered to.
+type RelyingParty struct {
+	// ID is the domain passkeys are scoped to, e.g. "example.com".
+	ID   string
+	Name string
+	// Origins are the web origins allowed to run the ceremonies, e.g.
+	// "https://example.com".
+	Origins []string
+}
+
+func DefaultRelyingParty() RelyingParty {
+	return RelyingParty{
+		ID:      "localhost",
+		Name:    "UserService",
+		Origins: []string{"http://localhost:8080"},
+	}
+}
+
+func (rp RelyingParty) allowedOrigin(origin string) bool {
+	for _, o := range rp.Origins {
+		if o == origin {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ClientData is the JSON the browser builds for the authenticator to sign.
+type ClientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+func ParseClientData(raw []byte) (*ClientData, error) {
+	var cd ClientData
+	err := json.Unmarshal(raw, &cd)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid client data: %v", ErrVerification, err)
+	}
+
+	return &cd, nil
+}
+
+// ChallengeBytes returns the decoded challenge, which identifies the session.
+func (cd *ClientData) ChallengeBytes() ([]byte, error) {
+	challenge, err := base64.RawURLEncoding.DecodeString(cd.Challenge)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid challenge encoding", ErrVerification)
+	}
+
+	return challenge, nil
+}
+
+func (cd *ClientData) check(rp RelyingParty, typ string, s *Session) error {
+	if cd.Type != typ {
+		return fmt.Errorf("%w: unexpected client data type %q", ErrVerification, cd.Type)
+	}
+
+	challenge, err := cd.ChallengeBytes()
+	if err != nil {
+		return err
+	}
+
+	if subtle.ConstantTimeCompare(challenge, s.challenge) != 1 {
+		return fmt.Errorf("%w: challenge mismatch", ErrVerification)
+	}
+
+	if !rp.allowedOrigin(cd.Origin) {
+		return fmt.Errorf("%w: origin %q not allowed", ErrVerification, cd.Origin)
+	}
+
+	return nil
+}
+
+type authenticatorData struct {
+	rpIDHash     []byte
+	flags        byte
+	signCount    uint32
+	credentialID []byte
+	publicKey    []byte
+}
+
+func parseAuthenticatorData(data []byte) (*authenticatorData, error) {
+	if len(data) < 37 {
+		return nil, errors.New("authenticator data too short")
+	}
+
+	ad := &authenticatorData{
+		rpIDHash:  data[:32],
+		flags:     data[32],
+		signCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+
+	rest := data[37:]
+	if ad.flags&flagAttestedCredData != 0 {
+		if len(rest) < aaguidLength+2 {
+			return nil, errors.New("attested credential data too short")
+		}
+
+		idLen := int(binary.BigEndian.Uint16(rest[aaguidLength:]))
+		rest = rest[aaguidLength+2:]
+		if idLen > maxCredentialIDLength || len(rest) < idLen {
+			return nil, errors.New("invalid credential id length")
+		}
+
+		ad.credentialID = rest[:idLen]
+		rest = rest[idLen:]
+
+		_, after, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, err
+		}
+
+		ad.publicKey = rest[:len(rest)-len(after)]
+		rest = after
+	}
+
+	if ad.flags&flagExtensionDataIncl != 0 {
+		v, after, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, ok := v.(map[interface{}]interface{}); !ok {
+			return nil, errors.New("extensions are not a map")
+		}
+
+		rest = after
+	}
+
+	if len(rest) > 0 {
+		return nil, errors.New("trailing authenticator data")
+	}
+
+	return ad, nil
+}
+
+// check verifies the data was produced for the relying party with the user
+// present and verified, since passkeys replace the password altogether.
+func (ad *authenticatorData) check(rp RelyingParty) error {
+	rpIDHash := sha256.Sum256([]byte(rp.ID))
+	if !bytes.Equal(ad.rpIDHash, rpIDHash[:]) {
+		return fmt.Errorf("%w: relying party id mismatch", ErrVerification)
+	}
+
+	if ad.flags&flagUserPresent == 0 {
+		return fmt.Errorf("%w: user not present", ErrVerification)
+	}
+
+	if ad.flags&flagUserVerified == 0 {
+		return fmt.Errorf("%w: user not verified", ErrVerification)
+	}
+
+	return nil
+}
+
+// VerifyRegistration checks the response of navigator.credentials.create()
+// and returns the new credential of the session user.
+func VerifyRegistration(rp RelyingParty, s *Session, clientDataJSON, attestationObject []byte, now time.Time) (*Credential, error) {
+	if s.ceremony != CeremonyRegistration || s.Expired(now) {
+		return nil, fmt.Errorf("%w: invalid session", ErrVerification)
+	}
+
+	cd, err := ParseClientData(clientDataJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	err = cd.check(rp, clientDataTypeCreate, s)
+	if err != nil {
+		return nil, err
+	}
+
+	v, rest, err := decodeCBOR(attestationObject)
+	if err != nil || len(rest) > 0 {
+		return nil, fmt.Errorf("%w: invalid attestation object", ErrVerification)
+	}
+
+	att, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%w: invalid attestation object", ErrVerification)
+	}
+
+	format, _ := att["fmt"].(string)
+	stmt, _ := att["attStmt"].(map[interface{}]interface{})
+	if format != "none" || stmt == nil || len(stmt) > 0 {
+		return nil, fmt.Errorf("%w: unsupported attestation format %q", ErrVerification, format)
+	}
+
+	rawAuthData, _ := att["authData"].([]byte)
+	ad, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrVerification, err)
+	}
+
+	err = ad.check(rp)
+	if err != nil {
+		return nil, err
+	}
+
+	if ad.credentialID == nil {
+		return nil, fmt.Errorf("%w: missing attested credential data", ErrVerification)
+	}
+
+	_, err = parsePublicKey(ad.publicKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrVerification, err)
+	}
+
+	cred, err := NewCredential(ad.credentialID, s.userID, ad.publicKey, ad.signCount, now, time.Time{})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrVerification, err)
+	}
+
+	return cred, nil
+}
+
+// VerifyAssertion checks the response of navigator.credentials.get() signed
+// with the credential and returns the new sign count of the authenticator.
+func VerifyAssertion(rp RelyingParty, s *Session, cred *Credential, clientDataJSON, authenticatorData, signature, userHandle []byte, now time.Time) (uint32, error) {
+	if s.ceremony != CeremonyLogin || s.Expired(now) {
+		return 0, fmt.Errorf("%w: invalid session", ErrVerification)
+	}
+
+	if s.userID != "" && s.userID != cred.userID {
+		return 0, fmt.Errorf("%w: credential of another user", ErrVerification)
+	}
+
+	if len(userHandle) > 0 && string(userHandle) != cred.userID {
+		return 0, fmt.Errorf("%w: user handle mismatch", ErrVerification)
+	}
+
+	cd, err := ParseClientData(clientDataJSON)
+	if err != nil {
+		return 0, err
+	}
+
+	err = cd.check(rp, clientDataTypeGet, s)
+	if err != nil {
+		return 0, err
+	}
+
+	ad, err := parseAuthenticatorData(authenticatorData)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrVerification, err)
+	}
+
+	err = ad.check(rp)
+	if err != nil {
+		return 0, err
+	}
+
+	pk, err := parsePublicKey(cred.publicKey)
+	if err != nil {
+		return 0, err
+	}
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte{}, authenticatorData...), clientDataHash[:]...)
+	if !pk.verify(signed, signature) {
+		return 0, fmt.Errorf("%w: invalid signature", ErrVerification)
+	}
+
+	err = cred.CheckSignCount(ad.signCount)
+	if err != nil {
+		return 0, err
+	}
+
+	return ad.signCount, nil
+}
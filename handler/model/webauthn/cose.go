@@ -0,0 +1,133 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// COSE algorithm identifiers of the supported public keys.
+const (
+	AlgES256 = -7
+	AlgEdDSA = -8
+	AlgRS256 = -257
+)
+
+// SupportedAlgorithms lists the algorithms offered to authenticators, in order
+// of preference.
+var SupportedAlgorithms = []int{AlgES256, AlgEdDSA, AlgRS256}
+
+// COSE key parameters, see RFC 9053.
+const (
+	coseKeyType      = 1
+	coseKeyAlgorithm = 3
+	coseKeyCurve     = -1
+	coseKeyX         = -2
+	coseKeyY         = -3
+	coseKeyN         = -1
+	coseKeyE         = -2
+
+	coseKeyTypeOKP = 1
+	coseKeyTypeEC2 = 2
+	coseKeyTypeRSA = 3
+
+	coseCurveP256    = 1
+	coseCurveEd25519 = 6
+
+	minRSAKeyBits = 2048
+)
+
+// publicKey is a credential public key able to check assertion signatures.
+type publicKey struct {
+	alg int
+	key crypto.PublicKey
+}
+
+// parsePublicKey decodes a COSE_Key, only accepting the supported algorithms.
+func parsePublicKey(data []byte) (*publicKey, error) {
+	v, rest, err := decodeCBOR(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rest) > 0 {
+		return nil, errors.New("trailing data after public key")
+	}
+
+	m, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("public key is not a map")
+	}
+
+	kty, _ := m[int64(coseKeyType)].(int64)
+	alg, _ := m[int64(coseKeyAlgorithm)].(int64)
+	switch {
+	case kty == coseKeyTypeEC2 && alg == AlgES256:
+		crv, _ := m[int64(coseKeyCurve)].(int64)
+		x, _ := m[int64(coseKeyX)].([]byte)
+		y, _ := m[int64(coseKeyY)].([]byte)
+		if crv != coseCurveP256 || len(x) != 32 || len(y) != 32 {
+			return nil, errors.New("invalid ES256 public key")
+		}
+
+		pub := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, errors.New("ES256 public key not on curve")
+		}
+
+		return &publicKey{alg: AlgES256, key: pub}, nil
+	case kty == coseKeyTypeOKP && alg == AlgEdDSA:
+		crv, _ := m[int64(coseKeyCurve)].(int64)
+		x, _ := m[int64(coseKeyX)].([]byte)
+		if crv != coseCurveEd25519 || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid EdDSA public key")
+		}
+
+		return &publicKey{alg: AlgEdDSA, key: ed25519.PublicKey(x)}, nil
+	case kty == coseKeyTypeRSA && alg == AlgRS256:
+		n, _ := m[int64(coseKeyN)].([]byte)
+		e, _ := m[int64(coseKeyE)].([]byte)
+		if len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("invalid RS256 public key")
+		}
+
+		pub := &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+
+		if pub.N.BitLen() < minRSAKeyBits || pub.E < 3 || pub.E%2 == 0 {
+			return nil, errors.New("invalid RS256 public key")
+		}
+
+		return &publicKey{alg: AlgRS256, key: pub}, nil
+	default:
+		return nil, fmt.Errorf("unsupported public key type %d with algorithm %d", kty, alg)
+	}
+}
+
+func (pk *publicKey) verify(message, sig []byte) bool {
+	switch key := pk.key.(type) {
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(message)
+		return ecdsa.VerifyASN1(key, digest[:], sig)
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, message, sig)
+	case *rsa.PublicKey:
+		digest := sha256.Sum256(message)
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig) == nil
+	default:
+		return false
+	}
+}
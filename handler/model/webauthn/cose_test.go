@@ -0,0 +1,307 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"math/big"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// encodeCOSEKey encodes the parameters of a COSE_Key, integers and byte
+// strings only.
+func encodeCOSEKey(params map[int64]interface{}) []byte {
+	var keys []int64
+	for k := range params {
+		keys = append(keys, k)
+	}
+
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+	data := cborHead(5, uint64(len(params)))
+	for _, k := range keys {
+		data = append(data, cborInt(k)...)
+		switch v := params[k].(type) {
+		case int64:
+			data = append(data, cborInt(v)...)
+		case []byte:
+			data = append(append(data, cborHead(2, uint64(len(v)))...), v...)
+		default:
+			panic("unsupported COSE key parameter")
+		}
+	}
+
+	return data
+}
+
+func cborInt(n int64) []byte {
+	if n < 0 {
+		return cborHead(1, uint64(-1-n))
+	}
+
+	return cborHead(0, uint64(n))
+}
+
+func cborHead(major byte, arg uint64) []byte {
+	switch {
+	case arg < 24:
+		return []byte{major<<5 | byte(arg)}
+	case arg <= 0xff:
+		return []byte{major<<5 | 24, byte(arg)}
+	case arg <= 0xffff:
+		return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(arg))
+	default:
+		return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(arg))
+	}
+}
+
+func ecKeyParams(pub *ecdsa.PublicKey) map[int64]interface{} {
+	return map[int64]interface{}{
+		coseKeyType:      int64(coseKeyTypeEC2),
+		coseKeyAlgorithm: int64(AlgES256),
+		coseKeyCurve:     int64(coseCurveP256),
+		coseKeyX:         pub.X.FillBytes(make([]byte, 32)),
+		coseKeyY:         pub.Y.FillBytes(make([]byte, 32)),
+	}
+}
+
+func rsaKeyParams(pub *rsa.PublicKey) map[int64]interface{} {
+	return map[int64]interface{}{
+		coseKeyType:      int64(coseKeyTypeRSA),
+		coseKeyAlgorithm: int64(AlgRS256),
+		coseKeyN:         pub.N.Bytes(),
+		coseKeyE:         big.NewInt(int64(pub.E)).Bytes(),
+	}
+}
+
+func with(params map[int64]interface{}, key int64, value interface{}) map[int64]interface{} {
+	copied := make(map[int64]interface{}, len(params))
+	for k, v := range params {
+		copied[k] = v
+	}
+
+	if value == nil {
+		delete(copied, key)
+	} else {
+		copied[key] = value
+	}
+
+	return copied
+}
+
+func TestParsePublicKey(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p384Key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	edPub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	weakRSAKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecParams := ecKeyParams(&ecKey.PublicKey)
+	edParams := map[int64]interface{}{
+		coseKeyType:      int64(coseKeyTypeOKP),
+		coseKeyAlgorithm: int64(AlgEdDSA),
+		coseKeyCurve:     int64(coseCurveEd25519),
+		coseKeyX:         []byte(edPub),
+	}
+	rsaParams := rsaKeyParams(&rsaKey.PublicKey)
+
+	offCurve := make([]byte, 32)
+	offCurve[31] = 1
+
+	testCases := map[string]struct {
+		data      []byte
+		expectAlg int
+		expectErr string
+	}{
+		"ES256": {
+			data:      encodeCOSEKey(ecParams),
+			expectAlg: AlgES256,
+		},
+		"EdDSA": {
+			data:      encodeCOSEKey(edParams),
+			expectAlg: AlgEdDSA,
+		},
+		"RS256": {
+			data:      encodeCOSEKey(rsaParams),
+			expectAlg: AlgRS256,
+		},
+		"EC point off the curve": {
+			data:      encodeCOSEKey(with(with(ecParams, coseKeyX, offCurve), coseKeyY, offCurve)),
+			expectErr: "not on curve",
+		},
+		"EC point of another curve": {
+			data: encodeCOSEKey(with(with(ecParams,
+				coseKeyX, p384Key.X.FillBytes(make([]byte, 48))[:32]),
+				coseKeyY, p384Key.Y.FillBytes(make([]byte, 48))[:32])),
+			expectErr: "not on curve",
+		},
+		"EC key of the wrong curve": {
+			data:      encodeCOSEKey(with(ecParams, coseKeyCurve, int64(2))),
+			expectErr: "invalid ES256 public key",
+		},
+		"EC coordinate too short": {
+			data:      encodeCOSEKey(with(ecParams, coseKeyX, make([]byte, 31))),
+			expectErr: "invalid ES256 public key",
+		},
+		"EC coordinate missing": {
+			data:      encodeCOSEKey(with(ecParams, coseKeyY, nil)),
+			expectErr: "invalid ES256 public key",
+		},
+		"EdDSA key of the wrong curve": {
+			data:      encodeCOSEKey(with(edParams, coseKeyCurve, int64(coseCurveP256))),
+			expectErr: "invalid EdDSA public key",
+		},
+		"EdDSA key too short": {
+			data:      encodeCOSEKey(with(edParams, coseKeyX, []byte(edPub)[:31])),
+			expectErr: "invalid EdDSA public key",
+		},
+		"RSA key under 2048 bits": {
+			data:      encodeCOSEKey(rsaKeyParams(&weakRSAKey.PublicKey)),
+			expectErr: "invalid RS256 public key",
+		},
+		"RSA even exponent": {
+			data:      encodeCOSEKey(with(rsaParams, coseKeyE, []byte{0x01, 0x00, 0x00})),
+			expectErr: "invalid RS256 public key",
+		},
+		"RSA exponent of 1": {
+			data:      encodeCOSEKey(with(rsaParams, coseKeyE, []byte{0x01})),
+			expectErr: "invalid RS256 public key",
+		},
+		"RSA exponent too long": {
+			data:      encodeCOSEKey(with(rsaParams, coseKeyE, []byte{0x01, 0x00, 0x00, 0x00, 0x01})),
+			expectErr: "invalid RS256 public key",
+		},
+		"RSA exponent missing": {
+			data:      encodeCOSEKey(with(rsaParams, coseKeyE, nil)),
+			expectErr: "invalid RS256 public key",
+		},
+		"algorithm of another key type": {
+			data:      encodeCOSEKey(with(ecParams, coseKeyAlgorithm, int64(AlgRS256))),
+			expectErr: "unsupported public key type",
+		},
+		"unsupported algorithm": {
+			data:      encodeCOSEKey(with(ecParams, coseKeyAlgorithm, int64(-35))),
+			expectErr: "unsupported public key type",
+		},
+		"key type of the wrong type": {
+			data:      encodeCOSEKey(with(ecParams, coseKeyType, []byte{coseKeyTypeEC2})),
+			expectErr: "unsupported public key type",
+		},
+		"trailing bytes": {
+			data:      append(encodeCOSEKey(ecParams), 0x00),
+			expectErr: "trailing data",
+		},
+		"truncated": {
+			data:      encodeCOSEKey(ecParams)[:40],
+			expectErr: "truncated",
+		},
+		"not a map": {
+			data:      []byte{0x80},
+			expectErr: "not a map",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			// When
+			pk, err := parsePublicKey(tc.data)
+
+			// Then
+			if tc.expectErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.expectErr) {
+					t.Fatalf("err got %v, want containing %q", err, tc.expectErr)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if pk.alg != tc.expectAlg {
+				t.Fatalf("alg got %d, want %d", pk.alg, tc.expectAlg)
+			}
+		})
+	}
+}
+
+func TestPublicKeyVerify(t *testing.T) {
+	message := []byte("authenticator data and client data hash")
+	digest := sha256.Sum256(message)
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecSig, err := ecdsa.SignASN1(rand.Reader, ecKey, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	edPub, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rsaSig, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := map[string]struct {
+		key crypto.PublicKey
+		sig []byte
+	}{
+		"ES256": {key: &ecKey.PublicKey, sig: ecSig},
+		"EdDSA": {key: edPub, sig: ed25519.Sign(edKey, message)},
+		"RS256": {key: &rsaKey.PublicKey, sig: rsaSig},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			pk := &publicKey{key: tc.key}
+
+			// When / Then
+			if !pk.verify(message, tc.sig) {
+				t.Fatal("verify got false, want true")
+			}
+
+			if pk.verify(append(message, '!'), tc.sig) {
+				t.Fatal("verify of another message got true, want false")
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package webauthn
+
+import (
+	"errors"
+	"time"
+)
+
+// maxCredentialIDLength is the limit set by the WebAuthn specification.
+const maxCredentialIDLength = 1023
+
+var ErrSignCountRegressed = errors.New("sign count regressed")
+
+// Credential is a passkey registered by a user, identified by the id chosen
+// by the authenticator.
+type Credential struct {
+	id         []byte
+	userID     string
+	publicKey  []byte
+	signCount  uint32
+	createdAt  time.Time
+	lastUsedAt time.Time
+}
+
+func NewCredential(id []byte, userID string, publicKey []byte, signCount uint32, createdAt, lastUsedAt time.Time) (*Credential, error) {
+	if len(id) == 0 {
+		return nil, errors.New("empty id")
+	}
+
+	if len(id) > maxCredentialIDLength {
+		return nil, errors.New("id too long")
+	}
+
+	if userID == "" {
+		return nil, errors.New("empty user id")
+	}
+
+	if len(publicKey) == 0 {
+		return nil, errors.New("empty public key")
+	}
+
+	if createdAt.IsZero() {
+		return nil, errors.New("empty created time")
+	}
+
+	return &Credential{
+		id:         id,
+		userID:     userID,
+		publicKey:  publicKey,
+		signCount:  signCount,
+		createdAt:  createdAt,
+		lastUsedAt: lastUsedAt,
+	}, nil
+}
+
+func (c *Credential) ID() []byte {
+	return c.id
+}
+
+func (c *Credential) UserID() string {
+	return c.userID
+}
+
+// PublicKey returns the COSE encoded public key.
+func (c *Credential) PublicKey() []byte {
+	return c.publicKey
+}
+
+func (c *Credential) SignCount() uint32 {
+	return c.signCount
+}
+
+func (c *Credential) CreatedAt() time.Time {
+	return c.createdAt
+}
+
+func (c *Credential) LastUsedAt() time.Time {
+	return c.lastUsedAt
+}
+
+// CheckSignCount detects cloned authenticators, whose counter falls behind the
+// one of the original. Authenticators without a counter always report zero.
+func (c *Credential) CheckSignCount(signCount uint32) error {
+	if signCount == 0 && c.signCount == 0 {
+		return nil
+	}
+
+	if signCount <= c.signCount {
+		return ErrSignCountRegressed
+	}
+
+	return nil
+}
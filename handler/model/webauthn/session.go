@@ -0,0 +1,93 @@
+package webauthn
+
+import (
+	"crypto/rand"
+	"errors"
+	"time"
+)
+
+// Ceremony tells what a challenge is issued for.
+type Ceremony string
+
+const (
+	CeremonyRegistration Ceremony = "registration"
+	CeremonyLogin        Ceremony = "login"
+)
+
+const (
+	DefaultTimeout = 5 * time.Minute
+
+	challengeLength = 32
+)
+
+// Session is a pending ceremony, identified by the random challenge the
+// authenticator signs. Registrations are bound to the authenticated user,
+// logins are not since the user is only known from the credential.
+type Session struct {
+	challenge []byte
+	ceremony  Ceremony
+	userID    string
+	issuedAt  time.Time
+	expiresAt time.Time
+}
+
+func NewSession(challenge []byte, ceremony Ceremony, userID string, issuedAt, expiresAt time.Time) (*Session, error) {
+	if len(challenge) < 16 {
+		return nil, errors.New("challenge too short")
+	}
+
+	if ceremony == "" {
+		return nil, errors.New("empty ceremony")
+	}
+
+	if ceremony == CeremonyRegistration && userID == "" {
+		return nil, errors.New("empty user id")
+	}
+
+	if !expiresAt.After(issuedAt) {
+		return nil, errors.New("expiry should be after issued time")
+	}
+
+	return &Session{
+		challenge: challenge,
+		ceremony:  ceremony,
+		userID:    userID,
+		issuedAt:  issuedAt,
+		expiresAt: expiresAt,
+	}, nil
+}
+
+// BeginSession starts a ceremony with a new challenge.
+func BeginSession(ceremony Ceremony, userID string, now time.Time, timeout time.Duration) (*Session, error) {
+	challenge := make([]byte, challengeLength)
+	_, err := rand.Read(challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewSession(challenge, ceremony, userID, now, now.Add(timeout))
+}
+
+func (s *Session) Challenge() []byte {
+	return s.challenge
+}
+
+func (s *Session) Ceremony() Ceremony {
+	return s.ceremony
+}
+
+func (s *Session) UserID() string {
+	return s.userID
+}
+
+func (s *Session) IssuedAt() time.Time {
+	return s.issuedAt
+}
+
+func (s *Session) ExpiresAt() time.Time {
+	return s.expiresAt
+}
+
+func (s *Session) Expired(now time.Time) bool {
+	return !now.Before(s.expiresAt)
+}
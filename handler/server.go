@@ -1,24 +1,164 @@
 package handler
 
 import (
+	"errors"
+	"fmt"
+	"time"
+
 	"github.com/SawitProRecruitment/UserService/handler/app"
+	"github.com/SawitProRecruitment/UserService/handler/model/lockout"
+	"github.com/SawitProRecruitment/UserService/handler/model/user"
+	"github.com/SawitProRecruitment/UserService/handler/model/webauthn"
+	"github.com/SawitProRecruitment/UserService/keyring"
 	"github.com/SawitProRecruitment/UserService/repository"
+	"github.com/SawitProRecruitment/UserService/sms"
 )
 
 type Server struct {
-	Repository  repository.RepositoryInterface
-	AuthService *app.AuthService
-	UserService *app.UserService
+	Repository           repository.RepositoryInterface
+	AuthService          *app.AuthService
+	UserService          *app.UserService
+	SessionService       *app.SessionService
+	OTPService           *app.OTPService
+	PasswordResetService *app.PasswordResetService
+	MFAService           *app.MFAService
+	PasskeyService       *app.PasskeyService
+	// KeyRing signs and verifies the access tokens.
+	KeyRing *keyring.Ring
+	// TokenIssuer, TokenAudience and TokenLeeway set the iss and aud claims
+	// of the access tokens and how they are verified, see TokenCreator and
+	// TokenVerifier.
+	TokenIssuer   string
+	TokenAudience []string
+	TokenLeeway   time.Duration
+	// TokenRoles returns the roles claim of the access tokens of a user.
+	TokenRoles func(usr *user.User) []string
+
+	// AdminKey grants access to the admin endpoints, which are disabled when
+	// empty.
+	AdminKey string
+	// OAuthClients maps the IDs of the services allowed to introspect and
+	// revoke tokens to their secret. The OAuth endpoints are disabled when
+	// empty.
+	OAuthClients map[string]string
 }
 
 type NewServerOptions struct {
-	Repository repository.RepositoryInterface
+	Repository                repository.RepositoryInterface
+	RefreshTokenRepository    repository.RefreshTokenRepositoryInterface
+	TokenRevocationRepository repository.TokenRevocationRepositoryInterface
+	OTPRepository             repository.OTPRepositoryInterface
+	LoginFailureRepository    repository.LoginFailureRepositoryInterface
+	PasswordHistoryRepository repository.PasswordHistoryRepositoryInterface
+	TOTPRepository            repository.TOTPRepositoryInterface
+	MFAChallengeRepository    repository.MFAChallengeRepositoryInterface
+	PasskeyRepository         repository.PasskeyRepositoryInterface
+	WebAuthnSessionRepository repository.WebAuthnSessionRepositoryInterface
+	// SMSSender delivers one-time passwords.
+	SMSSender sms.Sender
+	// Lockout policies for failed logins, the defaults are used when zero.
+	UserLockoutPolicy lockout.Policy
+	IPLockoutPolicy   lockout.Policy
+	// TOTPIssuer is the name shown by authenticator apps, the default is used
+	// when empty.
+	TOTPIssuer string
+	// RelyingParty scopes the passkeys, the default is used when its ID is
+	// empty.
+	RelyingParty webauthn.RelyingParty
+	// KeySource loads the keys signing the access tokens.
+	KeySource keyring.Source
+	// SigningAlgorithm applies to the keys not naming their algorithm, the
+	// one of the key type is used when empty: RS256 for RSA keys.
+	SigningAlgorithm keyring.Algorithm
+	// TokenIssuer is the iss claim of the access tokens, DefaultTokenIssuer
+	// when empty.
+	TokenIssuer string
+	// TokenAudience is the aud claim of the access tokens, only the tokens
+	// intended for one of them are accepted. Any token is accepted when
+	// empty.
+	TokenAudience []string
+	// TokenLeeway tolerates clock skew when checking the time claims of the
+	// access tokens.
+	TokenLeeway time.Duration
+	// TokenRoles returns the roles claim of the access tokens of a user, the
+	// claim is left out when nil. The roles are looked up again on refresh.
+	TokenRoles   func(usr *user.User) []string
+	AdminKey     string
+	OAuthClients map[string]string
 }
 
-func NewServer(opts NewServerOptions) *Server {
+func NewServer(opts NewServerOptions) (*Server, error) {
+	if opts.KeySource == nil {
+		return nil, errors.New("no token signing key source")
+	}
+
+	if opts.SMSSender == nil {
+		return nil, errors.New("no SMS sender")
+	}
+
+	if opts.TokenLeeway < 0 {
+		return nil, errors.New("token leeway should not be negative")
+	}
+
+	// Tokens are accepted until they expire plus the leeway
+	keyRing, err := keyring.Load(opts.KeySource, opts.SigningAlgorithm, defaultAccessTokenExpiry+opts.TokenLeeway)
+	if err != nil {
+		return nil, fmt.Errorf("loading token signing keys: %w", err)
+	}
+
+	otpService := app.NewOTPService(opts.OTPRepository, opts.SMSSender)
+	authService := app.NewAuthService(opts.Repository, opts.LoginFailureRepository, otpService)
+	if opts.UserLockoutPolicy != (lockout.Policy{}) {
+		authService.UserLockoutPolicy = opts.UserLockoutPolicy
+	}
+
+	if opts.IPLockoutPolicy != (lockout.Policy{}) {
+		authService.IPLockoutPolicy = opts.IPLockoutPolicy
+	}
+
+	mfaService := app.NewMFAService(opts.Repository, opts.TOTPRepository, opts.MFAChallengeRepository, authService)
+	if opts.TOTPIssuer != "" {
+		mfaService.Issuer = opts.TOTPIssuer
+	}
+
+	passkeyService := app.NewPasskeyService(opts.Repository, opts.PasskeyRepository, opts.WebAuthnSessionRepository, authService)
+	if opts.RelyingParty.ID != "" {
+		passkeyService.RelyingParty = opts.RelyingParty
+	}
+
 	return &Server{
-		Repository:  opts.Repository,
-		AuthService: app.NewAuthService(opts.Repository),
-		UserService: app.NewUserService(opts.Repository),
+		Repository:           opts.Repository,
+		AuthService:          authService,
+		UserService:          app.NewUserService(opts.Repository, opts.PasswordHistoryRepository, otpService, authService),
+		SessionService:       app.NewSessionService(opts.Repository, opts.RefreshTokenRepository, opts.TokenRevocationRepository),
+		OTPService:           otpService,
+		PasswordResetService: app.NewPasswordResetService(opts.Repository, opts.PasswordHistoryRepository, otpService),
+		MFAService:           mfaService,
+		PasskeyService:       passkeyService,
+		KeyRing:              keyRing,
+		TokenIssuer:          opts.TokenIssuer,
+		TokenAudience:        opts.TokenAudience,
+		TokenLeeway:          opts.TokenLeeway,
+		TokenRoles:           opts.TokenRoles,
+		AdminKey:             opts.AdminKey,
+		OAuthClients:         opts.OAuthClients,
+	}, nil
+}
+
+func (s *Server) tokenCreator() *TokenCreator {
+	return &TokenCreator{
+		KeyRing:  s.KeyRing,
+		Issuer:   s.TokenIssuer,
+		Audience: s.TokenAudience,
+		Roles:    s.TokenRoles,
+	}
+}
+
+func (s *Server) tokenVerifier() *TokenVerifier {
+	return &TokenVerifier{
+		KeyRing:  s.KeyRing,
+		Issuer:   s.TokenIssuer,
+		Audience: s.TokenAudience,
+		Leeway:   s.TokenLeeway,
 	}
 }
@@ -0,0 +1,36 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// DefaultRequestTimeout bounds the database calls of a request.
+const DefaultRequestTimeout = 5 * time.Second
+
+// RequestTimeout sets a deadline on the request context, which is passed down
+// to every repository call, so queries stop when it passes as well as when the
+// client disconnects. Requests failing past the deadline get 503 Service
+// Unavailable, whatever error the database returned.
+func RequestTimeout(timeout time.Duration) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			ctx, cancel := context.WithTimeout(c.Request().Context(), timeout)
+			defer cancel()
+
+			c.SetRequest(c.Request().WithContext(ctx))
+			err := next(c)
+			if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) && !c.Response().Committed {
+				log.Printf("request timed out: %v", err)
+				return c.NoContent(http.StatusServiceUnavailable)
+			}
+
+			return err
+		}
+	}
+}
@@ -1,57 +1,276 @@
 package handler
 
 import (
-	"crypto/rsa"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/SawitProRecruitment/UserService/handler/model/user"
+	"github.com/SawitProRecruitment/UserService/keyring"
 	"github.com/golang-jwt/jwt"
+	"github.com/rs/xid"
 )
 
+const (
+	defaultAccessTokenExpiry = 1 * time.Hour
+	DefaultTokenIssuer       = "UserService"
+	// DefaultTokenScope grants access to the profile of the user.
+	DefaultTokenScope = "profile"
+)
+
+// Authentication method references of the amr claim, see RFC 8176.
+const (
+	amrPassword          = "pwd"
+	amrOneTimePassword   = "otp"
+	amrSMS               = "sms"
+	amrMultiFactor       = "mfa"
+	amrProofOfPossession = "pop"
+)
+
+// validMethods are the algorithms of the keyring, tokens with another one are
+// rejected before looking at their key.
+var validMethods = func() []string {
+	var methods []string
+	for _, alg := range keyring.Algorithms {
+		methods = append(methods, string(alg))
+	}
+
+	return methods
+}()
+
+// AccessTokenClaims are the claims of the access tokens.
+type AccessTokenClaims struct {
+	ID        string   `json:"jti"`
+	Subject   string   `json:"sub"`
+	Issuer    string   `json:"iss"`
+	Audience  audience `json:"aud,omitempty"`
+	IssuedAt  int64    `json:"iat"`
+	NotBefore int64    `json:"nbf"`
+	ExpiresAt int64    `json:"exp"`
+	// Scope lists the granted scopes separated by spaces.
+	Scope string `json:"scope,omitempty"`
+	CustomClaims
+}
+
+// CustomClaims describe the session of an access token, they are left out
+// when empty.
+type CustomClaims struct {
+	// Roles are the roles of the user, see TokenCreator.
+	Roles []string `json:"roles,omitempty"`
+	// SessionID identifies the login, it is kept by the tokens obtained with
+	// its refresh tokens.
+	SessionID string `json:"sid,omitempty"`
+	// AuthMethods tell how the user authenticated, only the tokens issued
+	// on login have them.
+	AuthMethods []string `json:"amr,omitempty"`
+}
+
+// Valid checks the time claims without leeway, TokenVerifier checks the
+// claims with its own settings.
+func (c *AccessTokenClaims) Valid() error {
+	return c.validate(time.Now(), 0)
+}
+
+func (c *AccessTokenClaims) validate(now time.Time, leeway time.Duration) error {
+	if c.ID == "" {
+		return errors.New("missing token id")
+	}
+
+	if c.Subject == "" {
+		return errors.New("missing subject")
+	}
+
+	if c.ExpiresAt == 0 || !now.Add(-leeway).Before(time.Unix(c.ExpiresAt, 0)) {
+		return errors.New("token expired")
+	}
+
+	if now.Add(leeway).Before(time.Unix(c.NotBefore, 0)) {
+		return errors.New("token not valid yet")
+	}
+
+	if now.Add(leeway).Before(time.Unix(c.IssuedAt, 0)) {
+		return errors.New("token issued in the future")
+	}
+
+	return nil
+}
+
+// audience is a single string when there is one, as most verifiers expect.
+type audience []string
+
+func (a audience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+
+	return json.Marshal([]string(a))
+}
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*a = audience{s}
+		return nil
+	}
+
+	return json.Unmarshal(data, (*[]string)(a))
+}
+
+func (a audience) contains(aud string) bool {
+	for _, v := range a {
+		if v == aud {
+			return true
+		}
+	}
+
+	return false
+}
+
 type TokenCreator struct {
-	PrivateKey *rsa.PrivateKey
-	Expiry     time.Duration
+	KeyRing *keyring.Ring
+	Expiry  time.Duration
+	// Issuer is the iss claim, DefaultTokenIssuer when empty.
+	Issuer string
+	// Audience lists the services the tokens are intended for, the aud claim
+	// is left out when empty.
+	Audience []string
+	// Scope is the scope claim, DefaultTokenScope when empty.
+	Scope string
+	// Roles returns the roles claim of a user, the claim is left out when
+	// nil or when it returns none.
+	Roles func(usr *user.User) []string
 }
 
 func (tc *TokenCreator) CreateAccessToken(usr *user.User) (string, error) {
+	return tc.CreateAccessTokenWithClaims(usr, CustomClaims{})
+}
+
+// CreateAccessTokenWithClaims signs a token for the user with the current key
+// of the ring and its algorithm, the key is identified by the kid header.
+func (tc *TokenCreator) CreateAccessTokenWithClaims(usr *user.User, custom CustomClaims) (string, error) {
 	now := time.Now()
-	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.StandardClaims{
-		Subject:   usr.ID(),
-		IssuedAt:  now.Unix(),
-		ExpiresAt: now.Add(tc.expiry()).Unix(),
+	key, err := tc.KeyRing.SigningKey(now)
+	if err != nil {
+		return "", err
+	}
+
+	if custom.Roles == nil && tc.Roles != nil {
+		custom.Roles = tc.Roles(usr)
+	}
+
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(string(key.Algorithm)), &AccessTokenClaims{
+		ID:           xid.New().String(),
+		Subject:      usr.ID(),
+		Issuer:       issuerOrDefault(tc.Issuer),
+		Audience:     tc.Audience,
+		IssuedAt:     now.Unix(),
+		NotBefore:    now.Unix(),
+		ExpiresAt:    now.Add(tc.expiry()).Unix(),
+		Scope:        tc.scope(),
+		CustomClaims: custom,
 	})
+	token.Header["kid"] = key.ID
 
-	return token.SignedString(tc.PrivateKey)
+	return token.SignedString(key.PrivateKey)
 }
 
 func (tc *TokenCreator) expiry() time.Duration {
 	if tc.Expiry <= 0 {
-		return 1 * time.Hour
+		return defaultAccessTokenExpiry
 	}
 
 	return tc.Expiry
 
 }
 
+func (tc *TokenCreator) scope() string {
+	if tc.Scope == "" {
+		return DefaultTokenScope
+	}
+
+	return tc.Scope
+}
+
 type TokenVerifier struct {
-	PrivateKey *rsa.PrivateKey
-	PublicKey  *rsa.PublicKey
-	Expiry     time.Duration
+	KeyRing *keyring.Ring
+	// Issuer is the expected iss claim, DefaultTokenIssuer when empty.
+	Issuer string
+	// Audience accepts the tokens intended for one of the services, any
+	// token is accepted when empty.
+	Audience []string
+	// Leeway tolerates clock skew between the issuer and the verifier when
+	// checking the time claims.
+	Leeway time.Duration
 }
 
 func (tv *TokenVerifier) VerifyIdentify(tokenString string) (string, error) {
-	var claim jwt.StandardClaims
-	token, err := jwt.ParseWithClaims(tokenString, &claim, func(token *jwt.Token) (interface{}, error) {
-		return tv.PublicKey, nil
-	})
+	claim, err := tv.Verify(tokenString)
 	if err != nil {
 		return "", err
 	}
 
+	return claim.Subject, nil
+}
+
+// Verify checks the token signature, with the key of the ring named by the kid
+// header, and claims, then returns them. The alg header has to be the
+// algorithm of the key, so a token can not pick how its signature is checked.
+func (tv *TokenVerifier) Verify(tokenString string) (*AccessTokenClaims, error) {
+	now := time.Now()
+	var claim AccessTokenClaims
+	parser := &jwt.Parser{ValidMethods: validMethods, SkipClaimsValidation: true}
+	token, err := parser.ParseWithClaims(tokenString, &claim, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, err := tv.KeyRing.VerificationKey(kid, now)
+		if err != nil {
+			return nil, err
+		}
+
+		if token.Method.Alg() != string(key.Algorithm) {
+			return nil, fmt.Errorf("unexpected algorithm %s for key %s", token.Method.Alg(), kid)
+		}
+
+		return key.PublicKey(), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
 	if !token.Valid {
-		return "", errors.New("invalid token")
+		return nil, errors.New("invalid token")
 	}
 
-	return claim.Subject, nil
+	err = claim.validate(now, tv.Leeway)
+	if err != nil {
+		return nil, err
+	}
+
+	if claim.Issuer != issuerOrDefault(tv.Issuer) {
+		return nil, fmt.Errorf("unexpected issuer %q", claim.Issuer)
+	}
+
+	if len(tv.Audience) > 0 && !tv.intendedFor(claim.Audience) {
+		return nil, errors.New("token intended for another audience")
+	}
+
+	return &claim, nil
+}
+
+func (tv *TokenVerifier) intendedFor(aud audience) bool {
+	for _, a := range tv.Audience {
+		if aud.contains(a) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func issuerOrDefault(issuer string) string {
+	if issuer == "" {
+		return DefaultTokenIssuer
+	}
+
+	return issuer
 }
@@ -0,0 +1,144 @@
+package keyring
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// Algorithm is the JWS algorithm a key signs with.
+type Algorithm string
+
+const (
+	RS256 Algorithm = "RS256"
+	PS256 Algorithm = "PS256"
+	// ES256 and EdDSA keep tokens small, their signatures are 64 bytes
+	// against 256 for a 2048 bits RSA key.
+	ES256 Algorithm = "ES256"
+	EdDSA Algorithm = "EdDSA"
+)
+
+var Algorithms = []Algorithm{RS256, PS256, ES256, EdDSA}
+
+func ParseAlgorithm(s string) (Algorithm, error) {
+	for _, alg := range Algorithms {
+		if string(alg) == s {
+			return alg, nil
+		}
+	}
+
+	return "", fmt.Errorf("unsupported algorithm %q", s)
+}
+
+// defaultAlgorithm returns the algorithm of the key type, RS256 for RSA keys.
+func defaultAlgorithm(signer crypto.Signer) Algorithm {
+	switch signer.Public().(type) {
+	case *ecdsa.PublicKey:
+		return ES256
+	case ed25519.PublicKey:
+		return EdDSA
+	default:
+		return RS256
+	}
+}
+
+// checkKey verifies the key can sign with the algorithm.
+func checkKey(alg Algorithm, signer crypto.Signer) error {
+	switch pub := signer.Public().(type) {
+	case *rsa.PublicKey:
+		if alg != RS256 && alg != PS256 {
+			return fmt.Errorf("RSA key can not sign with %s", alg)
+		}
+
+		if pub.N.BitLen() < minRSABits {
+			return fmt.Errorf("RSA key of %d bits, want at least %d", pub.N.BitLen(), minRSABits)
+		}
+	case *ecdsa.PublicKey:
+		if alg != ES256 {
+			return fmt.Errorf("EC key can not sign with %s", alg)
+		}
+
+		if pub.Curve != elliptic.P256() {
+			return fmt.Errorf("EC key on %s, ES256 needs P-256", pub.Curve.Params().Name)
+		}
+	case ed25519.PublicKey:
+		if alg != EdDSA {
+			return fmt.Errorf("Ed25519 key can not sign with %s", alg)
+		}
+	default:
+		return fmt.Errorf("unsupported key type %T", pub)
+	}
+
+	return nil
+}
+
+// JWK holds the public key members of a JSON Web Key, base64url encoded.
+type JWK struct {
+	Kty string
+	Crv string
+	N   string
+	E   string
+	X   string
+	Y   string
+}
+
+func publicJWK(pub crypto.PublicKey) (JWK, error) {
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		return JWK{
+			Kty: "RSA",
+			N:   encode(pub.N.Bytes()),
+			E:   encode(big.NewInt(int64(pub.E)).Bytes()),
+		}, nil
+	case *ecdsa.PublicKey:
+		// Coordinates have the full length of the curve size
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		return JWK{
+			Kty: "EC",
+			Crv: pub.Curve.Params().Name,
+			X:   encode(pub.X.FillBytes(make([]byte, size))),
+			Y:   encode(pub.Y.FillBytes(make([]byte, size))),
+		}, nil
+	case ed25519.PublicKey:
+		return JWK{
+			Kty: "OKP",
+			Crv: "Ed25519",
+			X:   encode(pub),
+		}, nil
+	default:
+		return JWK{}, errors.New("unsupported key type")
+	}
+}
+
+// Thumbprint returns the RFC 7638 thumbprint of the public key.
+func Thumbprint(pub crypto.PublicKey) (string, error) {
+	jwk, err := publicJWK(pub)
+	if err != nil {
+		return "", err
+	}
+
+	// Required members in lexicographic order, without whitespace
+	var s string
+	switch jwk.Kty {
+	case "RSA":
+		s = fmt.Sprintf(`{"e":"%s","kty":"RSA","n":"%s"}`, jwk.E, jwk.N)
+	case "EC":
+		s = fmt.Sprintf(`{"crv":"%s","kty":"EC","x":"%s","y":"%s"}`, jwk.Crv, jwk.X, jwk.Y)
+	default:
+		s = fmt.Sprintf(`{"crv":"%s","kty":"%s","x":"%s"}`, jwk.Crv, jwk.Kty, jwk.X)
+	}
+
+	sum := sha256.Sum256([]byte(s))
+	return encode(sum[:]), nil
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,162 @@
+// Package keyring holds the keys signing access tokens. Keys are rotated on a
+// schedule: every key signs the new tokens from its activation time until the
+// next key is activated, then keeps verifying the tokens it signed until they
+// expire.
+package keyring
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+var (
+	ErrKeyNotFound  = errors.New("signing key not found")
+	ErrNoSigningKey = errors.New("no active signing key")
+)
+
+const minRSABits = 2048
+
+type Key struct {
+	// ID is sent as the kid header of the tokens, the thumbprint of the
+	// public key is used when empty.
+	ID string
+	// PrivateKey is an *rsa.PrivateKey, *ecdsa.PrivateKey on P-256 or
+	// ed25519.PrivateKey.
+	PrivateKey crypto.Signer
+	// Algorithm is the only one the key signs and verifies with, the one of
+	// the key type when empty: RS256 for RSA keys.
+	Algorithm Algorithm
+	// ActiveFrom is when the key starts signing tokens.
+	ActiveFrom time.Time
+}
+
+func (k *Key) PublicKey() crypto.PublicKey {
+	return k.PrivateKey.Public()
+}
+
+// JWK returns the public key as JWK members.
+func (k *Key) JWK() JWK {
+	// Key types are checked when the ring is made
+	jwk, _ := publicJWK(k.PublicKey())
+	return jwk
+}
+
+type Ring struct {
+	// keys are sorted by activation time
+	keys          []*Key
+	tokenLifetime time.Duration
+}
+
+// New makes a ring of the keys. The token lifetime is how long a key keeps
+// verifying tokens once replaced, it should not be shorter than the expiry
+// of the tokens.
+func New(keys []*Key, tokenLifetime time.Duration) (*Ring, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("no key")
+	}
+
+	if tokenLifetime <= 0 {
+		return nil, errors.New("token lifetime should be positive")
+	}
+
+	r := &Ring{tokenLifetime: tokenLifetime}
+	ids := make(map[string]bool)
+	for _, k := range keys {
+		if k.PrivateKey == nil {
+			return nil, errors.New("missing private key")
+		}
+
+		key := *k
+		if key.Algorithm == "" {
+			key.Algorithm = defaultAlgorithm(key.PrivateKey)
+		}
+
+		err := checkKey(key.Algorithm, key.PrivateKey)
+		if err != nil && key.ID != "" {
+			return nil, fmt.Errorf("key %s: %w", key.ID, err)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if key.ID == "" {
+			key.ID, err = Thumbprint(key.PublicKey())
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if ids[key.ID] {
+			return nil, fmt.Errorf("duplicate key %q", key.ID)
+		}
+
+		ids[key.ID] = true
+		r.keys = append(r.keys, &key)
+	}
+
+	sort.SliceStable(r.keys, func(i, j int) bool {
+		return r.keys[i].ActiveFrom.Before(r.keys[j].ActiveFrom)
+	})
+
+	// Otherwise which key signs would depend on the order they are listed
+	for i := 1; i < len(r.keys); i++ {
+		if r.keys[i].ActiveFrom.Equal(r.keys[i-1].ActiveFrom) {
+			return nil, fmt.Errorf("keys %q and %q have the same activation time", r.keys[i-1].ID, r.keys[i].ID)
+		}
+	}
+
+	return r, nil
+}
+
+// SigningKey returns the key signing new tokens, the last one activated.
+func (r *Ring) SigningKey(now time.Time) (*Key, error) {
+	var current *Key
+	for _, k := range r.keys {
+		if k.ActiveFrom.After(now) {
+			break
+		}
+
+		current = k
+	}
+
+	if current == nil {
+		return nil, ErrNoSigningKey
+	}
+
+	return current, nil
+}
+
+// VerificationKey returns the key of the id if it may still have signed
+// unexpired tokens.
+func (r *Ring) VerificationKey(id string, now time.Time) (*Key, error) {
+	for _, k := range r.PublishedKeys(now) {
+		if k.ID == id {
+			return k, nil
+		}
+	}
+
+	return nil, ErrKeyNotFound
+}
+
+// PublishedKeys returns the keys verifiers should trust: the signing key, the
+// replaced keys whose tokens did not expire yet and the keys scheduled next,
+// so verifiers know about them before the first token they sign.
+func (r *Ring) PublishedKeys(now time.Time) []*Key {
+	var keys []*Key
+	for i, k := range r.keys {
+		if i+1 < len(r.keys) {
+			replacedAt := r.keys[i+1].ActiveFrom
+			if !now.Before(replacedAt.Add(r.tokenLifetime)) {
+				continue
+			}
+		}
+
+		keys = append(keys, k)
+	}
+
+	return keys
+}
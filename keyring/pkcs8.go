@@ -0,0 +1,193 @@
+package keyring
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"hash"
+
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Encrypted PKCS#8 keys, as written by `openssl pkcs8 -topk8`, use the PBES2
+// scheme of RFC 8018 with a PBKDF2 or scrypt derived AES-CBC key.
+var (
+	oidPBES2  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidScrypt = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11591, 4, 11}
+
+	oidHMACWithSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidHMACWithSHA384 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 10}
+	oidHMACWithSHA512 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 11}
+
+	oidAES128CBC = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+var errDecryption = errors.New("wrong passphrase or corrupted key")
+
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	KeyLength      int                      `asn1:"optional"`
+	PRF            pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+type scryptParams struct {
+	Salt                     []byte
+	CostParameter            int
+	BlockSize                int
+	ParallelizationParameter int
+	KeyLength                int `asn1:"optional"`
+}
+
+// decryptPKCS8 returns the PKCS#8 encoded private key of an encrypted one.
+func decryptPKCS8(der, passphrase []byte) ([]byte, error) {
+	var info encryptedPrivateKeyInfo
+	rest, err := asn1.Unmarshal(der, &info)
+	if err != nil || len(rest) > 0 {
+		return nil, errors.New("invalid encrypted private key")
+	}
+
+	if !info.Algorithm.Algorithm.Equal(oidPBES2) {
+		return nil, fmt.Errorf("unsupported key encryption %s, only PBES2 is supported", info.Algorithm.Algorithm)
+	}
+
+	var params pbes2Params
+	_, err = asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &params)
+	if err != nil {
+		return nil, errors.New("invalid PBES2 parameters")
+	}
+
+	keyLength, err := aesKeyLength(params.EncryptionScheme.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	var iv []byte
+	_, err = asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv)
+	if err != nil || len(iv) != aes.BlockSize {
+		return nil, errors.New("invalid AES-CBC parameters")
+	}
+
+	key, err := deriveKey(params.KeyDerivationFunc, passphrase, keyLength)
+	if err != nil {
+		return nil, err
+	}
+
+	data := info.EncryptedData
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, errDecryption
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	plain := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, data)
+
+	return unpad(plain)
+}
+
+func aesKeyLength(oid asn1.ObjectIdentifier) (int, error) {
+	switch {
+	case oid.Equal(oidAES128CBC):
+		return 16, nil
+	case oid.Equal(oidAES192CBC):
+		return 24, nil
+	case oid.Equal(oidAES256CBC):
+		return 32, nil
+	default:
+		return 0, fmt.Errorf("unsupported key cipher %s, only AES-CBC is supported", oid)
+	}
+}
+
+func deriveKey(kdf pkix.AlgorithmIdentifier, passphrase []byte, keyLength int) ([]byte, error) {
+	switch {
+	case kdf.Algorithm.Equal(oidPBKDF2):
+		var params pbkdf2Params
+		_, err := asn1.Unmarshal(kdf.Parameters.FullBytes, &params)
+		if err != nil || params.IterationCount <= 0 {
+			return nil, errors.New("invalid PBKDF2 parameters")
+		}
+
+		if params.KeyLength != 0 && params.KeyLength != keyLength {
+			return nil, errors.New("PBKDF2 key length does not match the cipher")
+		}
+
+		h, err := prfHash(params.PRF.Algorithm)
+		if err != nil {
+			return nil, err
+		}
+
+		return pbkdf2.Key(passphrase, params.Salt, params.IterationCount, keyLength, h), nil
+	case kdf.Algorithm.Equal(oidScrypt):
+		var params scryptParams
+		_, err := asn1.Unmarshal(kdf.Parameters.FullBytes, &params)
+		if err != nil {
+			return nil, errors.New("invalid scrypt parameters")
+		}
+
+		if params.KeyLength != 0 && params.KeyLength != keyLength {
+			return nil, errors.New("scrypt key length does not match the cipher")
+		}
+
+		return scrypt.Key(passphrase, params.Salt, params.CostParameter, params.BlockSize, params.ParallelizationParameter, keyLength)
+	default:
+		return nil, fmt.Errorf("unsupported key derivation %s, only PBKDF2 and scrypt are supported", kdf.Algorithm)
+	}
+}
+
+func prfHash(oid asn1.ObjectIdentifier) (func() hash.Hash, error) {
+	switch {
+	// HMAC-SHA1 is the default when the PRF is omitted
+	case len(oid) == 0, oid.Equal(oidHMACWithSHA1):
+		return sha1.New, nil
+	case oid.Equal(oidHMACWithSHA256):
+		return sha256.New, nil
+	case oid.Equal(oidHMACWithSHA384):
+		return sha512.New384, nil
+	case oid.Equal(oidHMACWithSHA512):
+		return sha512.New, nil
+	default:
+		return nil, fmt.Errorf("unsupported PBKDF2 function %s", oid)
+	}
+}
+
+// unpad removes the PKCS#7 padding. A bad padding almost always means the
+// passphrase is wrong.
+func unpad(data []byte) ([]byte, error) {
+	n := int(data[len(data)-1])
+	if n == 0 || n > aes.BlockSize || n > len(data) {
+		return nil, errDecryption
+	}
+
+	for _, b := range data[len(data)-n:] {
+		if int(b) != n {
+			return nil, errDecryption
+		}
+	}
+
+	return data[:len(data)-n], nil
+}
@@ -0,0 +1,169 @@
+// This file contains the providers of the server-side secret mixed into
+// password hashes. Keys are written as "id:base64key" entries, the first one
+// is used for new hashes and the others are kept to verify older ones until
+// they are rehashed.
+package pepper
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+var ErrKeyNotFound = errors.New("pepper key not found")
+
+const maxKeyIDLength = 32
+
+type KeyProvider interface {
+	// CurrentKeyID returns the ID of the key used for new hashes.
+	CurrentKeyID() (string, error)
+	Key(id string) ([]byte, error)
+}
+
+// EnvKeyProvider reads the keys from an environment variable, entries are
+// separated by commas.
+type EnvKeyProvider struct {
+	Name string
+}
+
+func (ekp *EnvKeyProvider) CurrentKeyID() (string, error) {
+	ks, err := ekp.keys()
+	if err != nil {
+		return "", err
+	}
+
+	return ks.currentID, nil
+}
+
+func (ekp *EnvKeyProvider) Key(id string) ([]byte, error) {
+	ks, err := ekp.keys()
+	if err != nil {
+		return nil, err
+	}
+
+	return ks.key(id)
+}
+
+func (ekp *EnvKeyProvider) keys() (*keySet, error) {
+	return parseKeys(os.Getenv(ekp.Name))
+}
+
+// FileKeyProvider reads the keys from a file, one entry per line. The file is
+// read on first use and again by Reload, so keys can be rotated without a
+// restart.
+type FileKeyProvider struct {
+	Path string
+
+	mu sync.RWMutex
+	ks *keySet
+}
+
+func (fkp *FileKeyProvider) CurrentKeyID() (string, error) {
+	ks, err := fkp.keys()
+	if err != nil {
+		return "", err
+	}
+
+	return ks.currentID, nil
+}
+
+func (fkp *FileKeyProvider) Key(id string) ([]byte, error) {
+	ks, err := fkp.keys()
+	if err != nil {
+		return nil, err
+	}
+
+	return ks.key(id)
+}
+
+// Reload reads the file again. The keys read before are kept when it fails.
+func (fkp *FileKeyProvider) Reload() error {
+	_, err := fkp.reload()
+	return err
+}
+
+func (fkp *FileKeyProvider) keys() (*keySet, error) {
+	fkp.mu.RLock()
+	ks := fkp.ks
+	fkp.mu.RUnlock()
+
+	if ks != nil {
+		return ks, nil
+	}
+
+	return fkp.reload()
+}
+
+func (fkp *FileKeyProvider) reload() (*keySet, error) {
+	b, err := os.ReadFile(fkp.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	ks, err := parseKeys(string(b))
+	if err != nil {
+		return nil, err
+	}
+
+	fkp.mu.Lock()
+	fkp.ks = ks
+	fkp.mu.Unlock()
+
+	return ks, nil
+}
+
+type keySet struct {
+	currentID string
+	keys      map[string][]byte
+}
+
+func (ks *keySet) key(id string) ([]byte, error) {
+	key, ok := ks.keys[id]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+
+	return key, nil
+}
+
+func parseKeys(s string) (*keySet, error) {
+	ks := &keySet{keys: make(map[string][]byte)}
+	entries := strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == '\n'
+	})
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+
+		id, encoded, ok := strings.Cut(entry, ":")
+		if !ok || id == "" || len(id) > maxKeyIDLength {
+			return nil, errors.New("invalid pepper key entry")
+		}
+
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) == 0 {
+			return nil, fmt.Errorf("invalid pepper key %q", id)
+		}
+
+		if _, ok := ks.keys[id]; ok {
+			return nil, fmt.Errorf("duplicate pepper key %q", id)
+		}
+
+		if ks.currentID == "" {
+			ks.currentID = id
+		}
+
+		ks.keys[id] = key
+	}
+
+	if ks.currentID == "" {
+		return nil, errors.New("no pepper key")
+	}
+
+	return ks, nil
+}
@@ -0,0 +1,62 @@
+// This file contains the sources of breached password hashes. They follow
+// the k-anonymity range API of Pwned Passwords: a source is only given the
+// first 5 hex characters of the SHA-1 hash and returns every suffix sharing
+// it, so a remote source never learns the password.
+package pwned
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const prefixLength = 5
+
+// FileSource serves ranges from a local file of uppercase SHA-1 hashes, one
+// "HASH:COUNT" per line as in the Pwned Passwords downloads. The whole file is
+// kept in memory, it is meant for a curated list rather than the full corpus.
+type FileSource struct {
+	ranges map[string][]string
+}
+
+func LoadFile(path string) (*FileSource, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fs := &FileSource{ranges: make(map[string][]string)}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+
+		hash, _, _ := strings.Cut(entry, ":")
+		if len(hash) != 40 {
+			return nil, fmt.Errorf("invalid hash on line %d", line)
+		}
+
+		entry = strings.ToUpper(entry)
+		prefix := entry[:prefixLength]
+		fs.ranges[prefix] = append(fs.ranges[prefix], entry[prefixLength:])
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return fs, nil
+}
+
+// Range returns the "SUFFIX:COUNT" entries of the hashes with the prefix.
+func (fs *FileSource) Range(prefix string) ([]string, error) {
+	if len(prefix) != prefixLength {
+		return nil, fmt.Errorf("prefix should have %d characters", prefixLength)
+	}
+
+	return fs.ranges[strings.ToUpper(prefix)], nil
+}
@@ -1,31 +1,50 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"time"
 
+	"github.com/SawitProRecruitment/UserService/handler/model/lockout"
+	"github.com/SawitProRecruitment/UserService/handler/model/mfa"
+	"github.com/SawitProRecruitment/UserService/handler/model/otp"
+	"github.com/SawitProRecruitment/UserService/handler/model/token"
 	"github.com/SawitProRecruitment/UserService/handler/model/user"
-	"github.com/lib/pq"
+	"github.com/SawitProRecruitment/UserService/handler/model/webauthn"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/rs/xid"
 )
 
 const (
-	errCodeUniqueViolation = pq.ErrorCode("23505")
+	errCodeUniqueViolation = "23505"
+
+	// expiredTokenRetention is how long the expired tokens are kept before
+	// being deleted, longer than any leeway accepting an expired token.
+	expiredTokenRetention = 24 * time.Hour
 )
 
 var ErrUniqueViolation = errors.New("unique violation")
 
-func (r *Repository) Store(u *user.User) error {
-	_id, err := xid.FromString(u.ID())
+func (r *Repository) Store(ctx context.Context, u *user.User) error {
+	_id, err := idValue(u.ID())
 	if err != nil {
 		return err
 	}
 
 	pwdHash, pwdSalt := u.Password()
-	_, err = r.Db.Exec("INSERT INTO users (id, phone_number, full_name, password_hash, password_salt) VALUES ($1, $2, $3, $4, $5)", _id, u.PhoneNumber(), u.FullName(), pwdHash, pwdSalt)
+	_, err = r.Db.ExecContext(ctx, "INSERT INTO users (id, phone_number, full_name, password_hash, password_salt, password_pepper_id, phone_verified_at, pending_phone_number) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)",
+		_id,
+		u.PhoneNumber(),
+		u.FullName(),
+		pwdHash,
+		nullBytes(pwdSalt),
+		nullString(u.PasswordPepperID()),
+		nullTime(u.PhoneVerifiedAt()),
+		nullString(u.PendingPhoneNumber()))
 
 	// Detect unique constraint violation!
-	var pgerr *pq.Error
+	var pgerr *pgconn.PgError
 	if errors.As(err, &pgerr) {
 		if pgerr.Code == errCodeUniqueViolation {
 			return ErrUniqueViolation
@@ -39,24 +58,30 @@ func (r *Repository) Store(u *user.User) error {
 	return nil
 }
 
-func (r *Repository) GetByID(id string) (*user.User, error) {
+func (r *Repository) GetByID(ctx context.Context, id string) (*user.User, error) {
 	var (
-		phoneNumber string
-		fullName    string
-		pwdHash     []byte
-		pwdSalt     []byte
+		phoneNumber        string
+		fullName           string
+		pwdHash            []byte
+		pwdSalt            []byte
+		pwdPepperID        sql.NullString
+		phoneVerifiedAt    sql.NullTime
+		pendingPhoneNumber sql.NullString
 	)
 
-	_id, err := xid.FromString(id)
+	_id, err := idValue(id)
 	if err != nil {
 		return nil, err
 	}
 
-	err = r.Db.QueryRow("SELECT phone_number, full_name, password_hash, password_salt FROM users WHERE id = $1", _id).Scan(
+	err = r.Db.QueryRowContext(ctx, "SELECT phone_number, full_name, password_hash, password_salt, password_pepper_id, phone_verified_at, pending_phone_number FROM users WHERE id = $1", _id).Scan(
 		&phoneNumber,
 		&fullName,
 		&pwdHash,
-		&pwdSalt)
+		&pwdSalt,
+		&pwdPepperID,
+		&phoneVerifiedAt,
+		&pendingPhoneNumber)
 
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -66,21 +91,27 @@ func (r *Repository) GetByID(id string) (*user.User, error) {
 		return nil, err
 	}
 
-	return user.New(id, phoneNumber, fullName, pwdHash, pwdSalt)
+	return user.New(id, phoneNumber, fullName, pwdHash, pwdSalt, pwdPepperID.String, phoneVerifiedAt.Time, pendingPhoneNumber.String)
 }
 
-func (r *Repository) GetByPhoneNumber(phoneNumber string) (*user.User, error) {
+func (r *Repository) GetByPhoneNumber(ctx context.Context, phoneNumber string) (*user.User, error) {
 	var (
-		_id      xid.ID
-		fullName string
-		pwdHash  []byte
-		pwdSalt  []byte
+		_id                xid.ID
+		fullName           string
+		pwdHash            []byte
+		pwdSalt            []byte
+		pwdPepperID        sql.NullString
+		phoneVerifiedAt    sql.NullTime
+		pendingPhoneNumber sql.NullString
 	)
-	err := r.Db.QueryRow("SELECT id, full_name, password_hash, password_salt FROM users WHERE phone_number = $1", phoneNumber).Scan(
+	err := r.Db.QueryRowContext(ctx, "SELECT id, full_name, password_hash, password_salt, password_pepper_id, phone_verified_at, pending_phone_number FROM users WHERE phone_number = $1", phoneNumber).Scan(
 		&_id,
 		&fullName,
 		&pwdHash,
-		&pwdSalt)
+		&pwdSalt,
+		&pwdPepperID,
+		&phoneVerifiedAt,
+		&pendingPhoneNumber)
 
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -90,25 +121,33 @@ func (r *Repository) GetByPhoneNumber(phoneNumber string) (*user.User, error) {
 		return nil, err
 	}
 
-	return user.New(_id.String(), phoneNumber, fullName, pwdHash, pwdSalt)
+	return user.New(_id.String(), phoneNumber, fullName, pwdHash, pwdSalt, pwdPepperID.String, phoneVerifiedAt.Time, pendingPhoneNumber.String)
+}
+
+func (r *Repository) Update(ctx context.Context, u *user.User) error {
+	return updateUser(ctx, r.Db, u)
 }
 
-func (r *Repository) Update(u *user.User) error {
-	_id, err := xid.FromString(u.ID())
+// updateUser runs the update of Update on the database or in a transaction.
+func updateUser(ctx context.Context, q queryer, u *user.User) error {
+	_id, err := idValue(u.ID())
 	if err != nil {
 		return err
 	}
 
 	pwdHash, pwdSalt := u.Password()
-	res, err := r.Db.Exec("UPDATE users SET phone_number = $1, full_name = $2, password_hash = $3, password_salt = $4 WHERE id = $5",
+	res, err := q.ExecContext(ctx, "UPDATE users SET phone_number = $1, full_name = $2, password_hash = $3, password_salt = $4, password_pepper_id = $5, phone_verified_at = $6, pending_phone_number = $7 WHERE id = $8",
 		u.PhoneNumber(),
 		u.FullName(),
 		pwdHash,
-		pwdSalt,
+		nullBytes(pwdSalt),
+		nullString(u.PasswordPepperID()),
+		nullTime(u.PhoneVerifiedAt()),
+		nullString(u.PendingPhoneNumber()),
 		_id)
 
 	// Detect unique constraint violation!
-	var pgerr *pq.Error
+	var pgerr *pgconn.PgError
 	if errors.As(err, &pgerr) {
 		if pgerr.Code == errCodeUniqueViolation {
 			return ErrUniqueViolation
@@ -130,3 +169,872 @@ func (r *Repository) Update(u *user.User) error {
 
 	return nil
 }
+
+func (r *Repository) StoreRefreshToken(ctx context.Context, rt *token.RefreshToken) error {
+	_id, err := idValue(rt.ID())
+	if err != nil {
+		return err
+	}
+
+	_familyID, err := idValue(rt.FamilyID())
+	if err != nil {
+		return err
+	}
+
+	_userID, err := idValue(rt.UserID())
+	if err != nil {
+		return err
+	}
+
+	// Tokens are never updated once expired, they are deleted a while later
+	_, err = r.Db.ExecContext(ctx, "DELETE FROM refresh_tokens WHERE expires_at < $1", rt.IssuedAt().Add(-expiredTokenRetention))
+	if err != nil {
+		return err
+	}
+
+	_, err = r.Db.ExecContext(ctx, "INSERT INTO refresh_tokens (id, family_id, user_id, token_hash, issued_at, expires_at) VALUES ($1, $2, $3, $4, $5, $6)",
+		_id,
+		_familyID,
+		_userID,
+		rt.Hash(),
+		rt.IssuedAt(),
+		rt.ExpiresAt())
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *Repository) GetRefreshTokenByHash(ctx context.Context, hash []byte) (*token.RefreshToken, error) {
+	var (
+		_id       xid.ID
+		_familyID xid.ID
+		_userID   xid.ID
+		issuedAt  time.Time
+		expiresAt time.Time
+		usedAt    sql.NullTime
+		revokedAt sql.NullTime
+	)
+
+	err := r.Db.QueryRowContext(ctx, "SELECT id, family_id, user_id, issued_at, expires_at, used_at, revoked_at FROM refresh_tokens WHERE token_hash = $1", hash).Scan(
+		&_id,
+		&_familyID,
+		&_userID,
+		&issuedAt,
+		&expiresAt,
+		&usedAt,
+		&revokedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return token.New(_id.String(), _familyID.String(), _userID.String(), hash, issuedAt, expiresAt, usedAt.Time, revokedAt.Time)
+}
+
+func (r *Repository) MarkRefreshTokenUsed(ctx context.Context, id string, usedAt time.Time) (bool, error) {
+	_id, err := idValue(id)
+	if err != nil {
+		return false, err
+	}
+
+	res, err := r.Db.ExecContext(ctx, "UPDATE refresh_tokens SET used_at = $1 WHERE id = $2 AND used_at IS NULL", usedAt, _id)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected > 0, nil
+}
+
+func (r *Repository) RevokeRefreshTokenFamily(ctx context.Context, familyID string, revokedAt time.Time) error {
+	_familyID, err := idValue(familyID)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.Db.ExecContext(ctx, "UPDATE refresh_tokens SET revoked_at = $1 WHERE family_id = $2 AND revoked_at IS NULL", revokedAt, _familyID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *Repository) RevokeUserRefreshTokens(ctx context.Context, userID string, revokedAt time.Time) error {
+	_userID, err := idValue(userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.Db.ExecContext(ctx, "UPDATE refresh_tokens SET revoked_at = $1 WHERE user_id = $2 AND revoked_at IS NULL", revokedAt, _userID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *Repository) RevokeAccessToken(ctx context.Context, jti, userID string, expiresAt time.Time) error {
+	_userID, err := idValue(userID)
+	if err != nil {
+		return err
+	}
+
+	// The revocation is useless once the token expired
+	_, err = r.Db.ExecContext(ctx, "DELETE FROM revoked_access_tokens WHERE expires_at < $1", time.Now().Add(-expiredTokenRetention))
+	if err != nil {
+		return err
+	}
+
+	_, err = r.Db.ExecContext(ctx, "INSERT INTO revoked_access_tokens (jti, user_id, expires_at) VALUES ($1, $2, $3) ON CONFLICT (jti) DO NOTHING", jti, _userID, expiresAt)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *Repository) RevokeUserAccessTokens(ctx context.Context, userID string, issuedBefore time.Time) error {
+	_userID, err := idValue(userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.Db.ExecContext(ctx, `INSERT INTO user_token_revocations (user_id, issued_before) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET issued_before = GREATEST(user_token_revocations.issued_before, EXCLUDED.issued_before)`,
+		_userID,
+		issuedBefore)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *Repository) AccessTokenRevoked(ctx context.Context, jti, userID string, issuedAt time.Time) (bool, error) {
+	_userID, err := idValue(userID)
+	if err != nil {
+		return false, err
+	}
+
+	var revoked bool
+	err = r.Db.QueryRowContext(ctx, `SELECT
+		EXISTS (SELECT 1 FROM revoked_access_tokens WHERE jti = $1) OR
+		EXISTS (SELECT 1 FROM user_token_revocations WHERE user_id = $2 AND issued_before > $3)`,
+		jti,
+		_userID,
+		issuedAt).Scan(&revoked)
+	if err != nil {
+		return false, err
+	}
+
+	return revoked, nil
+}
+
+func (r *Repository) StoreOTP(ctx context.Context, o *otp.OTP) error {
+	_id, err := idValue(o.ID())
+	if err != nil {
+		return err
+	}
+
+	_, err = r.Db.ExecContext(ctx, `INSERT INTO one_time_passwords (id, purpose, phone_number, code_hash, issued_at, expires_at, attempts, send_count, send_window_start) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (purpose, phone_number) DO UPDATE SET
+			id = EXCLUDED.id,
+			code_hash = EXCLUDED.code_hash,
+			issued_at = EXCLUDED.issued_at,
+			expires_at = EXCLUDED.expires_at,
+			attempts = EXCLUDED.attempts,
+			consumed_at = NULL,
+			send_count = EXCLUDED.send_count,
+			send_window_start = EXCLUDED.send_window_start`,
+		_id,
+		o.Purpose(),
+		o.PhoneNumber(),
+		o.CodeHash(),
+		o.IssuedAt(),
+		o.ExpiresAt(),
+		o.Attempts(),
+		o.SendCount(),
+		o.SendWindowStart())
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *Repository) GetOTP(ctx context.Context, purpose otp.Purpose, phoneNumber string) (*otp.OTP, error) {
+	var (
+		_id             xid.ID
+		codeHash        []byte
+		issuedAt        time.Time
+		expiresAt       time.Time
+		attempts        int
+		consumedAt      sql.NullTime
+		sendCount       int
+		sendWindowStart time.Time
+	)
+
+	err := r.Db.QueryRowContext(ctx, "SELECT id, code_hash, issued_at, expires_at, attempts, consumed_at, send_count, send_window_start FROM one_time_passwords WHERE purpose = $1 AND phone_number = $2", purpose, phoneNumber).Scan(
+		&_id,
+		&codeHash,
+		&issuedAt,
+		&expiresAt,
+		&attempts,
+		&consumedAt,
+		&sendCount,
+		&sendWindowStart)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return otp.New(_id.String(), purpose, phoneNumber, codeHash, issuedAt, expiresAt, attempts, consumedAt.Time, sendCount, sendWindowStart)
+}
+
+func (r *Repository) IncrementOTPAttempts(ctx context.Context, id string) (int, error) {
+	_id, err := idValue(id)
+	if err != nil {
+		return 0, err
+	}
+
+	var attempts int
+	err = r.Db.QueryRowContext(ctx, "UPDATE one_time_passwords SET attempts = attempts + 1 WHERE id = $1 RETURNING attempts", _id).Scan(&attempts)
+	if err == sql.ErrNoRows {
+		return 0, errors.New("no rows affected")
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	return attempts, nil
+}
+
+func (r *Repository) ConsumeOTP(ctx context.Context, id string, consumedAt time.Time) (bool, error) {
+	_id, err := idValue(id)
+	if err != nil {
+		return false, err
+	}
+
+	res, err := r.Db.ExecContext(ctx, "UPDATE one_time_passwords SET consumed_at = $1 WHERE id = $2 AND consumed_at IS NULL", consumedAt, _id)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected > 0, nil
+}
+
+func (r *Repository) GetLoginFailures(ctx context.Context, key string) (*lockout.Counter, error) {
+	var (
+		failures      int
+		lastFailureAt time.Time
+		lockedUntil   sql.NullTime
+	)
+
+	err := r.Db.QueryRowContext(ctx, "SELECT failures, last_failure_at, locked_until FROM login_failures WHERE key = $1", key).Scan(
+		&failures,
+		&lastFailureAt,
+		&lockedUntil)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return lockout.New(key, failures, lastFailureAt, lockedUntil.Time)
+}
+
+func (r *Repository) IncrementLoginFailures(ctx context.Context, key string, failedAt, resetBefore time.Time) (*lockout.Counter, error) {
+	var (
+		failures    int
+		lockedUntil sql.NullTime
+	)
+
+	err := r.Db.QueryRowContext(ctx, `INSERT INTO login_failures (key, failures, last_failure_at) VALUES ($1, 1, $2)
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE WHEN login_failures.last_failure_at < $3 THEN 1 ELSE login_failures.failures + 1 END,
+			locked_until = CASE WHEN login_failures.last_failure_at < $3 THEN NULL ELSE login_failures.locked_until END,
+			last_failure_at = EXCLUDED.last_failure_at
+		RETURNING failures, locked_until`,
+		key,
+		failedAt,
+		resetBefore).Scan(&failures, &lockedUntil)
+	if err != nil {
+		return nil, err
+	}
+
+	return lockout.New(key, failures, failedAt, lockedUntil.Time)
+}
+
+func (r *Repository) LockLogin(ctx context.Context, key string, lockedUntil time.Time) error {
+	_, err := r.Db.ExecContext(ctx, "UPDATE login_failures SET locked_until = $1 WHERE key = $2", lockedUntil, key)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *Repository) ResetLoginFailures(ctx context.Context, key string) error {
+	_, err := r.Db.ExecContext(ctx, "DELETE FROM login_failures WHERE key = $1", key)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *Repository) GetPasswordHistory(ctx context.Context, userID string, limit int) ([]*user.PasswordRecord, error) {
+	_userID, err := idValue(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := r.Db.QueryContext(ctx, "SELECT password_hash, password_salt, password_pepper_id, created_at FROM password_history WHERE user_id = $1 ORDER BY created_at DESC, id DESC LIMIT $2", _userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var history []*user.PasswordRecord
+	for rows.Next() {
+		var (
+			pwdHash     []byte
+			pwdSalt     []byte
+			pwdPepperID sql.NullString
+			createdAt   time.Time
+		)
+
+		err = rows.Scan(&pwdHash, &pwdSalt, &pwdPepperID, &createdAt)
+		if err != nil {
+			return nil, err
+		}
+
+		pr, err := user.NewPasswordRecord(pwdHash, pwdSalt, pwdPepperID.String, createdAt)
+		if err != nil {
+			return nil, err
+		}
+
+		history = append(history, pr)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return history, nil
+}
+
+func (r *Repository) UpdatePassword(ctx context.Context, u *user.User, previous *user.PasswordRecord, keep int) error {
+	_userID, err := idValue(u.ID())
+	if err != nil {
+		return err
+	}
+
+	tx, err := r.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = updateUser(ctx, tx, u)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO password_history (user_id, password_hash, password_salt, password_pepper_id, created_at) VALUES ($1, $2, $3, $4, $5)",
+		_userID,
+		previous.Hash(),
+		nullBytes(previous.Salt()),
+		nullString(previous.PepperID()),
+		previous.CreatedAt())
+	if err != nil {
+		return err
+	}
+
+	// The id breaks the ties of passwords changed at the same time
+	_, err = tx.ExecContext(ctx, "DELETE FROM password_history WHERE user_id = $1 AND id NOT IN (SELECT id FROM password_history WHERE user_id = $1 ORDER BY created_at DESC, id DESC LIMIT $2)", _userID, keep)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *Repository) StoreTOTP(ctx context.Context, t *mfa.TOTP) error {
+	_userID, err := idValue(t.UserID())
+	if err != nil {
+		return err
+	}
+
+	_, err = r.Db.ExecContext(ctx, `INSERT INTO user_totp (user_id, secret, created_at, confirmed_at, last_used_step) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (user_id) DO UPDATE SET
+			secret = EXCLUDED.secret,
+			created_at = EXCLUDED.created_at,
+			confirmed_at = EXCLUDED.confirmed_at,
+			last_used_step = EXCLUDED.last_used_step`,
+		_userID,
+		t.Secret(),
+		t.CreatedAt(),
+		nullTime(t.ConfirmedAt()),
+		t.LastUsedStep())
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *Repository) GetTOTP(ctx context.Context, userID string) (*mfa.TOTP, error) {
+	_userID, err := idValue(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		secret       []byte
+		createdAt    time.Time
+		confirmedAt  sql.NullTime
+		lastUsedStep int64
+	)
+
+	err = r.Db.QueryRowContext(ctx, "SELECT secret, created_at, confirmed_at, last_used_step FROM user_totp WHERE user_id = $1", _userID).Scan(
+		&secret,
+		&createdAt,
+		&confirmedAt,
+		&lastUsedStep)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return mfa.NewTOTP(userID, secret, createdAt, confirmedAt.Time, lastUsedStep)
+}
+
+func (r *Repository) ConfirmTOTP(ctx context.Context, userID string, confirmedAt time.Time, step int64, recoveryCodeHashes [][]byte) (bool, error) {
+	_userID, err := idValue(userID)
+	if err != nil {
+		return false, err
+	}
+
+	tx, err := r.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, "UPDATE user_totp SET confirmed_at = $1, last_used_step = $2 WHERE user_id = $3 AND confirmed_at IS NULL", confirmedAt, step, _userID)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	if affected == 0 {
+		return false, nil
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM totp_recovery_codes WHERE user_id = $1", _userID)
+	if err != nil {
+		return false, err
+	}
+
+	for _, codeHash := range recoveryCodeHashes {
+		_, err = tx.ExecContext(ctx, "INSERT INTO totp_recovery_codes (user_id, code_hash) VALUES ($1, $2)", _userID, codeHash)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (r *Repository) UseTOTPStep(ctx context.Context, userID string, step int64) (bool, error) {
+	_userID, err := idValue(userID)
+	if err != nil {
+		return false, err
+	}
+
+	res, err := r.Db.ExecContext(ctx, "UPDATE user_totp SET last_used_step = $1 WHERE user_id = $2 AND last_used_step < $1", step, _userID)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected > 0, nil
+}
+
+func (r *Repository) DeleteTOTP(ctx context.Context, userID string) error {
+	_userID, err := idValue(userID)
+	if err != nil {
+		return err
+	}
+
+	tx, err := r.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM totp_recovery_codes WHERE user_id = $1", _userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM user_totp WHERE user_id = $1", _userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *Repository) UseRecoveryCode(ctx context.Context, userID string, codeHash []byte, usedAt time.Time) (bool, error) {
+	_userID, err := idValue(userID)
+	if err != nil {
+		return false, err
+	}
+
+	res, err := r.Db.ExecContext(ctx, "UPDATE totp_recovery_codes SET used_at = $1 WHERE user_id = $2 AND code_hash = $3 AND used_at IS NULL", usedAt, _userID, codeHash)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected > 0, nil
+}
+
+func (r *Repository) StoreMFAChallenge(ctx context.Context, c *mfa.Challenge) error {
+	_id, err := idValue(c.ID())
+	if err != nil {
+		return err
+	}
+
+	_userID, err := idValue(c.UserID())
+	if err != nil {
+		return err
+	}
+
+	// A challenge is useless once expired, the expired ones are deleted so
+	// they do not pile up
+	_, err = r.Db.ExecContext(ctx, "DELETE FROM mfa_challenges WHERE expires_at <= $1", c.IssuedAt())
+	if err != nil {
+		return err
+	}
+
+	_, err = r.Db.ExecContext(ctx, "INSERT INTO mfa_challenges (id, user_id, token_hash, issued_at, expires_at, attempts, consumed_at) VALUES ($1, $2, $3, $4, $5, $6, $7)",
+		_id,
+		_userID,
+		c.TokenHash(),
+		c.IssuedAt(),
+		c.ExpiresAt(),
+		c.Attempts(),
+		nullTime(c.ConsumedAt()))
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *Repository) GetMFAChallengeByHash(ctx context.Context, hash []byte) (*mfa.Challenge, error) {
+	var (
+		_id        xid.ID
+		_userID    xid.ID
+		issuedAt   time.Time
+		expiresAt  time.Time
+		attempts   int
+		consumedAt sql.NullTime
+	)
+
+	err := r.Db.QueryRowContext(ctx, "SELECT id, user_id, issued_at, expires_at, attempts, consumed_at FROM mfa_challenges WHERE token_hash = $1", hash).Scan(
+		&_id,
+		&_userID,
+		&issuedAt,
+		&expiresAt,
+		&attempts,
+		&consumedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return mfa.NewChallenge(_id.String(), _userID.String(), hash, issuedAt, expiresAt, attempts, consumedAt.Time)
+}
+
+func (r *Repository) IncrementMFAChallengeAttempts(ctx context.Context, id string) (int, error) {
+	_id, err := idValue(id)
+	if err != nil {
+		return 0, err
+	}
+
+	var attempts int
+	err = r.Db.QueryRowContext(ctx, "UPDATE mfa_challenges SET attempts = attempts + 1 WHERE id = $1 RETURNING attempts", _id).Scan(&attempts)
+	if err == sql.ErrNoRows {
+		return 0, errors.New("no rows affected")
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	return attempts, nil
+}
+
+func (r *Repository) ConsumeMFAChallenge(ctx context.Context, id string, consumedAt time.Time) (bool, error) {
+	_id, err := idValue(id)
+	if err != nil {
+		return false, err
+	}
+
+	res, err := r.Db.ExecContext(ctx, "UPDATE mfa_challenges SET consumed_at = $1 WHERE id = $2 AND consumed_at IS NULL", consumedAt, _id)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected > 0, nil
+}
+
+func (r *Repository) StorePasskey(ctx context.Context, c *webauthn.Credential) error {
+	_userID, err := idValue(c.UserID())
+	if err != nil {
+		return err
+	}
+
+	_, err = r.Db.ExecContext(ctx, "INSERT INTO passkeys (id, user_id, public_key, sign_count, created_at, last_used_at) VALUES ($1, $2, $3, $4, $5, $6)",
+		c.ID(),
+		_userID,
+		c.PublicKey(),
+		int64(c.SignCount()),
+		c.CreatedAt(),
+		nullTime(c.LastUsedAt()))
+
+	var pgerr *pgconn.PgError
+	if errors.As(err, &pgerr) {
+		if pgerr.Code == errCodeUniqueViolation {
+			return ErrUniqueViolation
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *Repository) GetPasskey(ctx context.Context, id []byte) (*webauthn.Credential, error) {
+	var (
+		_userID    xid.ID
+		publicKey  []byte
+		signCount  int64
+		createdAt  time.Time
+		lastUsedAt sql.NullTime
+	)
+
+	err := r.Db.QueryRowContext(ctx, "SELECT user_id, public_key, sign_count, created_at, last_used_at FROM passkeys WHERE id = $1", id).Scan(
+		&_userID,
+		&publicKey,
+		&signCount,
+		&createdAt,
+		&lastUsedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return webauthn.NewCredential(id, _userID.String(), publicKey, uint32(signCount), createdAt, lastUsedAt.Time)
+}
+
+func (r *Repository) GetUserPasskeys(ctx context.Context, userID string) ([]*webauthn.Credential, error) {
+	_userID, err := idValue(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := r.Db.QueryContext(ctx, "SELECT id, public_key, sign_count, created_at, last_used_at FROM passkeys WHERE user_id = $1 ORDER BY created_at", _userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var creds []*webauthn.Credential
+	for rows.Next() {
+		var (
+			id         []byte
+			publicKey  []byte
+			signCount  int64
+			createdAt  time.Time
+			lastUsedAt sql.NullTime
+		)
+
+		err = rows.Scan(&id, &publicKey, &signCount, &createdAt, &lastUsedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		c, err := webauthn.NewCredential(id, userID, publicKey, uint32(signCount), createdAt, lastUsedAt.Time)
+		if err != nil {
+			return nil, err
+		}
+
+		creds = append(creds, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return creds, nil
+}
+
+func (r *Repository) UpdatePasskeySignCount(ctx context.Context, id []byte, signCount uint32, usedAt time.Time) (bool, error) {
+	res, err := r.Db.ExecContext(ctx, "UPDATE passkeys SET sign_count = $1, last_used_at = $2 WHERE id = $3 AND (sign_count < $1 OR sign_count = 0)", int64(signCount), usedAt, id)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected > 0, nil
+}
+
+func (r *Repository) StoreWebAuthnSession(ctx context.Context, s *webauthn.Session) error {
+	// Login sessions have no user, stored as NULL
+	var _userID []byte
+	if s.UserID() != "" {
+		var err error
+		_userID, err = idValue(s.UserID())
+		if err != nil {
+			return err
+		}
+	}
+
+	// Anyone can begin a passkey login, the expired ceremonies are deleted
+	// so they do not pile up
+	_, err := r.Db.ExecContext(ctx, "DELETE FROM webauthn_sessions WHERE expires_at <= $1", s.IssuedAt())
+	if err != nil {
+		return err
+	}
+
+	_, err = r.Db.ExecContext(ctx, "INSERT INTO webauthn_sessions (challenge, ceremony, user_id, issued_at, expires_at) VALUES ($1, $2, $3, $4, $5)",
+		s.Challenge(),
+		s.Ceremony(),
+		_userID,
+		s.IssuedAt(),
+		s.ExpiresAt())
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *Repository) TakeWebAuthnSession(ctx context.Context, challenge []byte) (*webauthn.Session, error) {
+	var (
+		ceremony  webauthn.Ceremony
+		_userID   xid.ID
+		issuedAt  time.Time
+		expiresAt time.Time
+	)
+
+	err := r.Db.QueryRowContext(ctx, "DELETE FROM webauthn_sessions WHERE challenge = $1 RETURNING ceremony, user_id, issued_at, expires_at", challenge).Scan(
+		&ceremony,
+		&_userID,
+		&issuedAt,
+		&expiresAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var userID string
+	if !_userID.IsNil() {
+		userID = _userID.String()
+	}
+
+	return webauthn.NewSession(challenge, ceremony, userID, issuedAt, expiresAt)
+}
+
+// idValue checks the id is an xid and returns the value of the BYTEA id
+// columns, the bytes of its text.
+func idValue(id string) ([]byte, error) {
+	_id, err := xid.FromString(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(_id.String()), nil
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+func nullBytes(b []byte) interface{} {
+	if len(b) == 0 {
+		return nil
+	}
+
+	return b
+}
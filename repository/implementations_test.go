@@ -0,0 +1,96 @@
+package repository_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/SawitProRecruitment/UserService/repository"
+	"github.com/SawitProRecruitment/UserService/repository/repositorytest"
+)
+
+// TestRepository runs the conformance suite on the PostgreSQL database of
+// TEST_DATABASE_URL, whose tables are dropped and created again by every test.
+func TestRepository(t *testing.T) {
+	repo := newTestRepository(t)
+	repositorytest.Run(t, func(t *testing.T) repository.Storage {
+		ctx := context.Background()
+		if err := repo.MigrateTo(ctx, 0); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := repo.MigrateUp(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		return repo
+	})
+}
+
+// newTestRepository connects to the PostgreSQL database of TEST_DATABASE_URL,
+// skipping the test when there is none.
+func newTestRepository(t *testing.T) *repository.Repository {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" || testing.Short() {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	repo, err := repository.NewRepository(context.Background(), repository.NewRepositoryOptions{
+		Dsn:            dsn,
+		MaxConns:       4,
+		MinConns:       1,
+		ConnectTimeout: 5 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { repo.Close() })
+	return repo
+}
+
+func TestNewRepository(t *testing.T) {
+	tests := []struct {
+		name        string
+		opts        repository.NewRepositoryOptions
+		wantElapsed time.Duration
+	}{
+		{
+			name: "invalid dsn",
+			opts: repository.NewRepositoryOptions{Dsn: "postgres://localhost:port/users"},
+		},
+		{
+			name: "min connections above max",
+			opts: repository.NewRepositoryOptions{Dsn: "postgres://localhost/users", MaxConns: 2, MinConns: 4},
+		},
+		{
+			name: "database not ready",
+			opts: repository.NewRepositoryOptions{
+				Dsn:            "postgres://postgres@127.0.0.1:1/users?connect_timeout=1",
+				ConnectTimeout: 500 * time.Millisecond,
+			},
+			wantElapsed: 500 * time.Millisecond,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Given
+			start := time.Now()
+
+			// When
+			repo, err := repository.NewRepository(context.Background(), tt.opts)
+
+			// Then
+			if err == nil {
+				repo.Close()
+				t.Fatal("NewRepository succeeded")
+			}
+
+			if elapsed := time.Since(start); elapsed < tt.wantElapsed {
+				t.Errorf("gave up after %v, want retries for %v", elapsed, tt.wantElapsed)
+			}
+		})
+	}
+}
@@ -5,12 +5,120 @@
 package repository
 
 import (
+	"context"
+	"time"
+
+	"github.com/SawitProRecruitment/UserService/handler/model/lockout"
+	"github.com/SawitProRecruitment/UserService/handler/model/mfa"
+	"github.com/SawitProRecruitment/UserService/handler/model/otp"
+	"github.com/SawitProRecruitment/UserService/handler/model/token"
 	"github.com/SawitProRecruitment/UserService/handler/model/user"
+	"github.com/SawitProRecruitment/UserService/handler/model/webauthn"
 )
 
 type RepositoryInterface interface {
-	Store(*user.User) error
-	GetByID(id string) (*user.User, error)
-	GetByPhoneNumber(phoneNumber string) (*user.User, error)
-	Update(*user.User) error
+	Store(context.Context, *user.User) error
+	GetByID(ctx context.Context, id string) (*user.User, error)
+	GetByPhoneNumber(ctx context.Context, phoneNumber string) (*user.User, error)
+	Update(context.Context, *user.User) error
+}
+
+type RefreshTokenRepositoryInterface interface {
+	StoreRefreshToken(context.Context, *token.RefreshToken) error
+	GetRefreshTokenByHash(ctx context.Context, hash []byte) (*token.RefreshToken, error)
+	// MarkRefreshTokenUsed flags the token as used. It returns false when the
+	// token was already used, so concurrent rotations can be detected.
+	MarkRefreshTokenUsed(ctx context.Context, id string, usedAt time.Time) (bool, error)
+	RevokeRefreshTokenFamily(ctx context.Context, familyID string, revokedAt time.Time) error
+	RevokeUserRefreshTokens(ctx context.Context, userID string, revokedAt time.Time) error
+}
+
+type TokenRevocationRepositoryInterface interface {
+	// RevokeAccessToken denies the access token with the given jti until it
+	// expires.
+	RevokeAccessToken(ctx context.Context, jti, userID string, expiresAt time.Time) error
+	// RevokeUserAccessTokens denies every access token of the user issued
+	// before the given time, a whole second like the token issue times.
+	RevokeUserAccessTokens(ctx context.Context, userID string, issuedBefore time.Time) error
+	AccessTokenRevoked(ctx context.Context, jti, userID string, issuedAt time.Time) (bool, error)
+}
+
+type OTPRepositoryInterface interface {
+	// StoreOTP saves the code, replacing the previous one issued for the same
+	// purpose and phone number.
+	StoreOTP(context.Context, *otp.OTP) error
+	GetOTP(ctx context.Context, purpose otp.Purpose, phoneNumber string) (*otp.OTP, error)
+	// IncrementOTPAttempts atomically counts a verification attempt and
+	// returns the number of attempts made so far.
+	IncrementOTPAttempts(ctx context.Context, id string) (int, error)
+	// ConsumeOTP flags the code as used. It returns false when the code was
+	// already used.
+	ConsumeOTP(ctx context.Context, id string, consumedAt time.Time) (bool, error)
+}
+
+type LoginFailureRepositoryInterface interface {
+	GetLoginFailures(ctx context.Context, key string) (*lockout.Counter, error)
+	// IncrementLoginFailures atomically counts a failed login. Failures
+	// recorded before resetBefore are forgotten first.
+	IncrementLoginFailures(ctx context.Context, key string, failedAt, resetBefore time.Time) (*lockout.Counter, error)
+	LockLogin(ctx context.Context, key string, lockedUntil time.Time) error
+	ResetLoginFailures(ctx context.Context, key string) error
+}
+
+type PasswordHistoryRepositoryInterface interface {
+	// GetPasswordHistory returns the most recent previous passwords of the
+	// user, newest first.
+	GetPasswordHistory(ctx context.Context, userID string, limit int) ([]*user.PasswordRecord, error)
+	// UpdatePassword saves the user with its new password and the previous
+	// one in the history at once, then forgets the history beyond the most
+	// recent keep. It fails like Update when the user does not exist.
+	UpdatePassword(ctx context.Context, u *user.User, previous *user.PasswordRecord, keep int) error
+}
+
+type TOTPRepositoryInterface interface {
+	// StoreTOTP saves the secret, replacing the previous one of the user.
+	StoreTOTP(context.Context, *mfa.TOTP) error
+	GetTOTP(ctx context.Context, userID string) (*mfa.TOTP, error)
+	// ConfirmTOTP enables the secret of the user at the time step of its
+	// first code and replaces the recovery codes. It returns false when the
+	// secret was already confirmed.
+	ConfirmTOTP(ctx context.Context, userID string, confirmedAt time.Time, step int64, recoveryCodeHashes [][]byte) (bool, error)
+	// UseTOTPStep records the time step of a verified code. It returns false
+	// when the step, or a later one, was already used.
+	UseTOTPStep(ctx context.Context, userID string, step int64) (bool, error)
+	// DeleteTOTP removes the secret and the recovery codes of the user.
+	DeleteTOTP(ctx context.Context, userID string) error
+	// UseRecoveryCode flags the recovery code as used. It returns false when
+	// the code does not exist or was already used.
+	UseRecoveryCode(ctx context.Context, userID string, codeHash []byte, usedAt time.Time) (bool, error)
+}
+
+type MFAChallengeRepositoryInterface interface {
+	StoreMFAChallenge(context.Context, *mfa.Challenge) error
+	GetMFAChallengeByHash(ctx context.Context, hash []byte) (*mfa.Challenge, error)
+	// IncrementMFAChallengeAttempts atomically counts an answer and returns
+	// the number of attempts made so far.
+	IncrementMFAChallengeAttempts(ctx context.Context, id string) (int, error)
+	// ConsumeMFAChallenge flags the challenge as answered. It returns false
+	// when the challenge was already answered.
+	ConsumeMFAChallenge(ctx context.Context, id string, consumedAt time.Time) (bool, error)
+}
+
+type PasskeyRepositoryInterface interface {
+	// StorePasskey saves a new credential. It returns ErrUniqueViolation when
+	// the credential id is already registered.
+	StorePasskey(context.Context, *webauthn.Credential) error
+	GetPasskey(ctx context.Context, id []byte) (*webauthn.Credential, error)
+	GetUserPasskeys(ctx context.Context, userID string) ([]*webauthn.Credential, error)
+	// UpdatePasskeySignCount records a use of the credential. It returns
+	// false when a concurrent use already recorded the same or a higher
+	// count.
+	UpdatePasskeySignCount(ctx context.Context, id []byte, signCount uint32, usedAt time.Time) (bool, error)
+}
+
+type WebAuthnSessionRepositoryInterface interface {
+	StoreWebAuthnSession(context.Context, *webauthn.Session) error
+	// TakeWebAuthnSession removes and returns the session of the challenge,
+	// so every challenge can be answered once.
+	TakeWebAuthnSession(ctx context.Context, challenge []byte) (*webauthn.Session, error)
 }
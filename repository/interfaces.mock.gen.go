@@ -5,9 +5,16 @@
 package repository
 
 import (
+	context "context"
 	reflect "reflect"
+	time "time"
 
+	lockout "github.com/SawitProRecruitment/UserService/handler/model/lockout"
+	mfa "github.com/SawitProRecruitment/UserService/handler/model/mfa"
+	otp "github.com/SawitProRecruitment/UserService/handler/model/otp"
+	token "github.com/SawitProRecruitment/UserService/handler/model/token"
 	user "github.com/SawitProRecruitment/UserService/handler/model/user"
+	webauthn "github.com/SawitProRecruitment/UserService/handler/model/webauthn"
 	gomock "github.com/golang/mock/gomock"
 )
 
@@ -35,59 +42,762 @@ func (m *MockRepositoryInterface) EXPECT() *MockRepositoryInterfaceMockRecorder
 }
 
 // GetByID mocks base method.
-func (m *MockRepositoryInterface) GetByID(id string) (*user.User, error) {
+func (m *MockRepositoryInterface) GetByID(ctx context.Context, id string) (*user.User, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetByID", id)
+	ret := m.ctrl.Call(m, "GetByID", ctx, id)
 	ret0, _ := ret[0].(*user.User)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetByID indicates an expected call of GetByID.
-func (mr *MockRepositoryInterfaceMockRecorder) GetByID(id interface{}) *gomock.Call {
+func (mr *MockRepositoryInterfaceMockRecorder) GetByID(ctx, id interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetByID", reflect.TypeOf((*MockRepositoryInterface)(nil).GetByID), id)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetByID", reflect.TypeOf((*MockRepositoryInterface)(nil).GetByID), ctx, id)
 }
 
 // GetByPhoneNumber mocks base method.
-func (m *MockRepositoryInterface) GetByPhoneNumber(phoneNumber string) (*user.User, error) {
+func (m *MockRepositoryInterface) GetByPhoneNumber(ctx context.Context, phoneNumber string) (*user.User, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetByPhoneNumber", phoneNumber)
+	ret := m.ctrl.Call(m, "GetByPhoneNumber", ctx, phoneNumber)
 	ret0, _ := ret[0].(*user.User)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetByPhoneNumber indicates an expected call of GetByPhoneNumber.
-func (mr *MockRepositoryInterfaceMockRecorder) GetByPhoneNumber(phoneNumber interface{}) *gomock.Call {
+func (mr *MockRepositoryInterfaceMockRecorder) GetByPhoneNumber(ctx, phoneNumber interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetByPhoneNumber", reflect.TypeOf((*MockRepositoryInterface)(nil).GetByPhoneNumber), phoneNumber)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetByPhoneNumber", reflect.TypeOf((*MockRepositoryInterface)(nil).GetByPhoneNumber), ctx, phoneNumber)
 }
 
 // Store mocks base method.
-func (m *MockRepositoryInterface) Store(arg0 *user.User) error {
+func (m *MockRepositoryInterface) Store(arg0 context.Context, arg1 *user.User) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Store", arg0)
+	ret := m.ctrl.Call(m, "Store", arg0, arg1)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // Store indicates an expected call of Store.
-func (mr *MockRepositoryInterfaceMockRecorder) Store(arg0 interface{}) *gomock.Call {
+func (mr *MockRepositoryInterfaceMockRecorder) Store(arg0, arg1 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Store", reflect.TypeOf((*MockRepositoryInterface)(nil).Store), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Store", reflect.TypeOf((*MockRepositoryInterface)(nil).Store), arg0, arg1)
 }
 
 // Update mocks base method.
-func (m *MockRepositoryInterface) Update(arg0 *user.User) error {
+func (m *MockRepositoryInterface) Update(arg0 context.Context, arg1 *user.User) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Update", arg0)
+	ret := m.ctrl.Call(m, "Update", arg0, arg1)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // Update indicates an expected call of Update.
-func (mr *MockRepositoryInterfaceMockRecorder) Update(arg0 interface{}) *gomock.Call {
+func (mr *MockRepositoryInterfaceMockRecorder) Update(arg0, arg1 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Update", reflect.TypeOf((*MockRepositoryInterface)(nil).Update), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Update", reflect.TypeOf((*MockRepositoryInterface)(nil).Update), arg0, arg1)
+}
+
+// MockRefreshTokenRepositoryInterface is a mock of RefreshTokenRepositoryInterface interface.
+type MockRefreshTokenRepositoryInterface struct {
+	ctrl     *gomock.Controller
+	recorder *MockRefreshTokenRepositoryInterfaceMockRecorder
+}
+
+// MockRefreshTokenRepositoryInterfaceMockRecorder is the mock recorder for MockRefreshTokenRepositoryInterface.
+type MockRefreshTokenRepositoryInterfaceMockRecorder struct {
+	mock *MockRefreshTokenRepositoryInterface
+}
+
+// NewMockRefreshTokenRepositoryInterface creates a new mock instance.
+func NewMockRefreshTokenRepositoryInterface(ctrl *gomock.Controller) *MockRefreshTokenRepositoryInterface {
+	mock := &MockRefreshTokenRepositoryInterface{ctrl: ctrl}
+	mock.recorder = &MockRefreshTokenRepositoryInterfaceMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockRefreshTokenRepositoryInterface) EXPECT() *MockRefreshTokenRepositoryInterfaceMockRecorder {
+	return m.recorder
+}
+
+// GetRefreshTokenByHash mocks base method.
+func (m *MockRefreshTokenRepositoryInterface) GetRefreshTokenByHash(ctx context.Context, hash []byte) (*token.RefreshToken, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetRefreshTokenByHash", ctx, hash)
+	ret0, _ := ret[0].(*token.RefreshToken)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetRefreshTokenByHash indicates an expected call of GetRefreshTokenByHash.
+func (mr *MockRefreshTokenRepositoryInterfaceMockRecorder) GetRefreshTokenByHash(ctx, hash interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRefreshTokenByHash", reflect.TypeOf((*MockRefreshTokenRepositoryInterface)(nil).GetRefreshTokenByHash), ctx, hash)
+}
+
+// MarkRefreshTokenUsed mocks base method.
+func (m *MockRefreshTokenRepositoryInterface) MarkRefreshTokenUsed(ctx context.Context, id string, usedAt time.Time) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MarkRefreshTokenUsed", ctx, id, usedAt)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// MarkRefreshTokenUsed indicates an expected call of MarkRefreshTokenUsed.
+func (mr *MockRefreshTokenRepositoryInterfaceMockRecorder) MarkRefreshTokenUsed(ctx, id, usedAt interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MarkRefreshTokenUsed", reflect.TypeOf((*MockRefreshTokenRepositoryInterface)(nil).MarkRefreshTokenUsed), ctx, id, usedAt)
+}
+
+// RevokeRefreshTokenFamily mocks base method.
+func (m *MockRefreshTokenRepositoryInterface) RevokeRefreshTokenFamily(ctx context.Context, familyID string, revokedAt time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RevokeRefreshTokenFamily", ctx, familyID, revokedAt)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RevokeRefreshTokenFamily indicates an expected call of RevokeRefreshTokenFamily.
+func (mr *MockRefreshTokenRepositoryInterfaceMockRecorder) RevokeRefreshTokenFamily(ctx, familyID, revokedAt interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevokeRefreshTokenFamily", reflect.TypeOf((*MockRefreshTokenRepositoryInterface)(nil).RevokeRefreshTokenFamily), ctx, familyID, revokedAt)
+}
+
+// RevokeUserRefreshTokens mocks base method.
+func (m *MockRefreshTokenRepositoryInterface) RevokeUserRefreshTokens(ctx context.Context, userID string, revokedAt time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RevokeUserRefreshTokens", ctx, userID, revokedAt)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RevokeUserRefreshTokens indicates an expected call of RevokeUserRefreshTokens.
+func (mr *MockRefreshTokenRepositoryInterfaceMockRecorder) RevokeUserRefreshTokens(ctx, userID, revokedAt interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevokeUserRefreshTokens", reflect.TypeOf((*MockRefreshTokenRepositoryInterface)(nil).RevokeUserRefreshTokens), ctx, userID, revokedAt)
+}
+
+// StoreRefreshToken mocks base method.
+func (m *MockRefreshTokenRepositoryInterface) StoreRefreshToken(arg0 context.Context, arg1 *token.RefreshToken) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "StoreRefreshToken", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// StoreRefreshToken indicates an expected call of StoreRefreshToken.
+func (mr *MockRefreshTokenRepositoryInterfaceMockRecorder) StoreRefreshToken(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "StoreRefreshToken", reflect.TypeOf((*MockRefreshTokenRepositoryInterface)(nil).StoreRefreshToken), arg0, arg1)
+}
+
+// MockTokenRevocationRepositoryInterface is a mock of TokenRevocationRepositoryInterface interface.
+type MockTokenRevocationRepositoryInterface struct {
+	ctrl     *gomock.Controller
+	recorder *MockTokenRevocationRepositoryInterfaceMockRecorder
+}
+
+// MockTokenRevocationRepositoryInterfaceMockRecorder is the mock recorder for MockTokenRevocationRepositoryInterface.
+type MockTokenRevocationRepositoryInterfaceMockRecorder struct {
+	mock *MockTokenRevocationRepositoryInterface
+}
+
+// NewMockTokenRevocationRepositoryInterface creates a new mock instance.
+func NewMockTokenRevocationRepositoryInterface(ctrl *gomock.Controller) *MockTokenRevocationRepositoryInterface {
+	mock := &MockTokenRevocationRepositoryInterface{ctrl: ctrl}
+	mock.recorder = &MockTokenRevocationRepositoryInterfaceMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockTokenRevocationRepositoryInterface) EXPECT() *MockTokenRevocationRepositoryInterfaceMockRecorder {
+	return m.recorder
+}
+
+// AccessTokenRevoked mocks base method.
+func (m *MockTokenRevocationRepositoryInterface) AccessTokenRevoked(ctx context.Context, jti, userID string, issuedAt time.Time) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AccessTokenRevoked", ctx, jti, userID, issuedAt)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// AccessTokenRevoked indicates an expected call of AccessTokenRevoked.
+func (mr *MockTokenRevocationRepositoryInterfaceMockRecorder) AccessTokenRevoked(ctx, jti, userID, issuedAt interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AccessTokenRevoked", reflect.TypeOf((*MockTokenRevocationRepositoryInterface)(nil).AccessTokenRevoked), ctx, jti, userID, issuedAt)
+}
+
+// RevokeAccessToken mocks base method.
+func (m *MockTokenRevocationRepositoryInterface) RevokeAccessToken(ctx context.Context, jti, userID string, expiresAt time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RevokeAccessToken", ctx, jti, userID, expiresAt)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RevokeAccessToken indicates an expected call of RevokeAccessToken.
+func (mr *MockTokenRevocationRepositoryInterfaceMockRecorder) RevokeAccessToken(ctx, jti, userID, expiresAt interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevokeAccessToken", reflect.TypeOf((*MockTokenRevocationRepositoryInterface)(nil).RevokeAccessToken), ctx, jti, userID, expiresAt)
+}
+
+// RevokeUserAccessTokens mocks base method.
+func (m *MockTokenRevocationRepositoryInterface) RevokeUserAccessTokens(ctx context.Context, userID string, issuedBefore time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RevokeUserAccessTokens", ctx, userID, issuedBefore)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RevokeUserAccessTokens indicates an expected call of RevokeUserAccessTokens.
+func (mr *MockTokenRevocationRepositoryInterfaceMockRecorder) RevokeUserAccessTokens(ctx, userID, issuedBefore interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevokeUserAccessTokens", reflect.TypeOf((*MockTokenRevocationRepositoryInterface)(nil).RevokeUserAccessTokens), ctx, userID, issuedBefore)
+}
+
+// MockOTPRepositoryInterface is a mock of OTPRepositoryInterface interface.
+type MockOTPRepositoryInterface struct {
+	ctrl     *gomock.Controller
+	recorder *MockOTPRepositoryInterfaceMockRecorder
+}
+
+// MockOTPRepositoryInterfaceMockRecorder is the mock recorder for MockOTPRepositoryInterface.
+type MockOTPRepositoryInterfaceMockRecorder struct {
+	mock *MockOTPRepositoryInterface
+}
+
+// NewMockOTPRepositoryInterface creates a new mock instance.
+func NewMockOTPRepositoryInterface(ctrl *gomock.Controller) *MockOTPRepositoryInterface {
+	mock := &MockOTPRepositoryInterface{ctrl: ctrl}
+	mock.recorder = &MockOTPRepositoryInterfaceMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockOTPRepositoryInterface) EXPECT() *MockOTPRepositoryInterfaceMockRecorder {
+	return m.recorder
+}
+
+// ConsumeOTP mocks base method.
+func (m *MockOTPRepositoryInterface) ConsumeOTP(ctx context.Context, id string, consumedAt time.Time) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ConsumeOTP", ctx, id, consumedAt)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ConsumeOTP indicates an expected call of ConsumeOTP.
+func (mr *MockOTPRepositoryInterfaceMockRecorder) ConsumeOTP(ctx, id, consumedAt interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ConsumeOTP", reflect.TypeOf((*MockOTPRepositoryInterface)(nil).ConsumeOTP), ctx, id, consumedAt)
+}
+
+// GetOTP mocks base method.
+func (m *MockOTPRepositoryInterface) GetOTP(ctx context.Context, purpose otp.Purpose, phoneNumber string) (*otp.OTP, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetOTP", ctx, purpose, phoneNumber)
+	ret0, _ := ret[0].(*otp.OTP)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetOTP indicates an expected call of GetOTP.
+func (mr *MockOTPRepositoryInterfaceMockRecorder) GetOTP(ctx, purpose, phoneNumber interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOTP", reflect.TypeOf((*MockOTPRepositoryInterface)(nil).GetOTP), ctx, purpose, phoneNumber)
+}
+
+// IncrementOTPAttempts mocks base method.
+func (m *MockOTPRepositoryInterface) IncrementOTPAttempts(ctx context.Context, id string) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "IncrementOTPAttempts", ctx, id)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// IncrementOTPAttempts indicates an expected call of IncrementOTPAttempts.
+func (mr *MockOTPRepositoryInterfaceMockRecorder) IncrementOTPAttempts(ctx, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IncrementOTPAttempts", reflect.TypeOf((*MockOTPRepositoryInterface)(nil).IncrementOTPAttempts), ctx, id)
+}
+
+// StoreOTP mocks base method.
+func (m *MockOTPRepositoryInterface) StoreOTP(arg0 context.Context, arg1 *otp.OTP) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "StoreOTP", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// StoreOTP indicates an expected call of StoreOTP.
+func (mr *MockOTPRepositoryInterfaceMockRecorder) StoreOTP(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "StoreOTP", reflect.TypeOf((*MockOTPRepositoryInterface)(nil).StoreOTP), arg0, arg1)
+}
+
+// MockLoginFailureRepositoryInterface is a mock of LoginFailureRepositoryInterface interface.
+type MockLoginFailureRepositoryInterface struct {
+	ctrl     *gomock.Controller
+	recorder *MockLoginFailureRepositoryInterfaceMockRecorder
+}
+
+// MockLoginFailureRepositoryInterfaceMockRecorder is the mock recorder for MockLoginFailureRepositoryInterface.
+type MockLoginFailureRepositoryInterfaceMockRecorder struct {
+	mock *MockLoginFailureRepositoryInterface
+}
+
+// NewMockLoginFailureRepositoryInterface creates a new mock instance.
+func NewMockLoginFailureRepositoryInterface(ctrl *gomock.Controller) *MockLoginFailureRepositoryInterface {
+	mock := &MockLoginFailureRepositoryInterface{ctrl: ctrl}
+	mock.recorder = &MockLoginFailureRepositoryInterfaceMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockLoginFailureRepositoryInterface) EXPECT() *MockLoginFailureRepositoryInterfaceMockRecorder {
+	return m.recorder
+}
+
+// GetLoginFailures mocks base method.
+func (m *MockLoginFailureRepositoryInterface) GetLoginFailures(ctx context.Context, key string) (*lockout.Counter, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetLoginFailures", ctx, key)
+	ret0, _ := ret[0].(*lockout.Counter)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetLoginFailures indicates an expected call of GetLoginFailures.
+func (mr *MockLoginFailureRepositoryInterfaceMockRecorder) GetLoginFailures(ctx, key interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetLoginFailures", reflect.TypeOf((*MockLoginFailureRepositoryInterface)(nil).GetLoginFailures), ctx, key)
+}
+
+// IncrementLoginFailures mocks base method.
+func (m *MockLoginFailureRepositoryInterface) IncrementLoginFailures(ctx context.Context, key string, failedAt, resetBefore time.Time) (*lockout.Counter, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "IncrementLoginFailures", ctx, key, failedAt, resetBefore)
+	ret0, _ := ret[0].(*lockout.Counter)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// IncrementLoginFailures indicates an expected call of IncrementLoginFailures.
+func (mr *MockLoginFailureRepositoryInterfaceMockRecorder) IncrementLoginFailures(ctx, key, failedAt, resetBefore interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IncrementLoginFailures", reflect.TypeOf((*MockLoginFailureRepositoryInterface)(nil).IncrementLoginFailures), ctx, key, failedAt, resetBefore)
+}
+
+// LockLogin mocks base method.
+func (m *MockLoginFailureRepositoryInterface) LockLogin(ctx context.Context, key string, lockedUntil time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LockLogin", ctx, key, lockedUntil)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// LockLogin indicates an expected call of LockLogin.
+func (mr *MockLoginFailureRepositoryInterfaceMockRecorder) LockLogin(ctx, key, lockedUntil interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LockLogin", reflect.TypeOf((*MockLoginFailureRepositoryInterface)(nil).LockLogin), ctx, key, lockedUntil)
+}
+
+// ResetLoginFailures mocks base method.
+func (m *MockLoginFailureRepositoryInterface) ResetLoginFailures(ctx context.Context, key string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ResetLoginFailures", ctx, key)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ResetLoginFailures indicates an expected call of ResetLoginFailures.
+func (mr *MockLoginFailureRepositoryInterfaceMockRecorder) ResetLoginFailures(ctx, key interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResetLoginFailures", reflect.TypeOf((*MockLoginFailureRepositoryInterface)(nil).ResetLoginFailures), ctx, key)
+}
+
+// MockPasswordHistoryRepositoryInterface is a mock of PasswordHistoryRepositoryInterface interface.
+type MockPasswordHistoryRepositoryInterface struct {
+	ctrl     *gomock.Controller
+	recorder *MockPasswordHistoryRepositoryInterfaceMockRecorder
+}
+
+// MockPasswordHistoryRepositoryInterfaceMockRecorder is the mock recorder for MockPasswordHistoryRepositoryInterface.
+type MockPasswordHistoryRepositoryInterfaceMockRecorder struct {
+	mock *MockPasswordHistoryRepositoryInterface
+}
+
+// NewMockPasswordHistoryRepositoryInterface creates a new mock instance.
+func NewMockPasswordHistoryRepositoryInterface(ctrl *gomock.Controller) *MockPasswordHistoryRepositoryInterface {
+	mock := &MockPasswordHistoryRepositoryInterface{ctrl: ctrl}
+	mock.recorder = &MockPasswordHistoryRepositoryInterfaceMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockPasswordHistoryRepositoryInterface) EXPECT() *MockPasswordHistoryRepositoryInterfaceMockRecorder {
+	return m.recorder
+}
+
+// GetPasswordHistory mocks base method.
+func (m *MockPasswordHistoryRepositoryInterface) GetPasswordHistory(ctx context.Context, userID string, limit int) ([]*user.PasswordRecord, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetPasswordHistory", ctx, userID, limit)
+	ret0, _ := ret[0].([]*user.PasswordRecord)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetPasswordHistory indicates an expected call of GetPasswordHistory.
+func (mr *MockPasswordHistoryRepositoryInterfaceMockRecorder) GetPasswordHistory(ctx, userID, limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPasswordHistory", reflect.TypeOf((*MockPasswordHistoryRepositoryInterface)(nil).GetPasswordHistory), ctx, userID, limit)
+}
+
+// UpdatePassword mocks base method.
+func (m *MockPasswordHistoryRepositoryInterface) UpdatePassword(ctx context.Context, u *user.User, previous *user.PasswordRecord, keep int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdatePassword", ctx, u, previous, keep)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdatePassword indicates an expected call of UpdatePassword.
+func (mr *MockPasswordHistoryRepositoryInterfaceMockRecorder) UpdatePassword(ctx, u, previous, keep interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdatePassword", reflect.TypeOf((*MockPasswordHistoryRepositoryInterface)(nil).UpdatePassword), ctx, u, previous, keep)
+}
+
+// MockTOTPRepositoryInterface is a mock of TOTPRepositoryInterface interface.
+type MockTOTPRepositoryInterface struct {
+	ctrl     *gomock.Controller
+	recorder *MockTOTPRepositoryInterfaceMockRecorder
+}
+
+// MockTOTPRepositoryInterfaceMockRecorder is the mock recorder for MockTOTPRepositoryInterface.
+type MockTOTPRepositoryInterfaceMockRecorder struct {
+	mock *MockTOTPRepositoryInterface
+}
+
+// NewMockTOTPRepositoryInterface creates a new mock instance.
+func NewMockTOTPRepositoryInterface(ctrl *gomock.Controller) *MockTOTPRepositoryInterface {
+	mock := &MockTOTPRepositoryInterface{ctrl: ctrl}
+	mock.recorder = &MockTOTPRepositoryInterfaceMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockTOTPRepositoryInterface) EXPECT() *MockTOTPRepositoryInterfaceMockRecorder {
+	return m.recorder
+}
+
+// ConfirmTOTP mocks base method.
+func (m *MockTOTPRepositoryInterface) ConfirmTOTP(ctx context.Context, userID string, confirmedAt time.Time, step int64, recoveryCodeHashes [][]byte) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ConfirmTOTP", ctx, userID, confirmedAt, step, recoveryCodeHashes)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ConfirmTOTP indicates an expected call of ConfirmTOTP.
+func (mr *MockTOTPRepositoryInterfaceMockRecorder) ConfirmTOTP(ctx, userID, confirmedAt, step, recoveryCodeHashes interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ConfirmTOTP", reflect.TypeOf((*MockTOTPRepositoryInterface)(nil).ConfirmTOTP), ctx, userID, confirmedAt, step, recoveryCodeHashes)
+}
+
+// DeleteTOTP mocks base method.
+func (m *MockTOTPRepositoryInterface) DeleteTOTP(ctx context.Context, userID string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteTOTP", ctx, userID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteTOTP indicates an expected call of DeleteTOTP.
+func (mr *MockTOTPRepositoryInterfaceMockRecorder) DeleteTOTP(ctx, userID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteTOTP", reflect.TypeOf((*MockTOTPRepositoryInterface)(nil).DeleteTOTP), ctx, userID)
+}
+
+// GetTOTP mocks base method.
+func (m *MockTOTPRepositoryInterface) GetTOTP(ctx context.Context, userID string) (*mfa.TOTP, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetTOTP", ctx, userID)
+	ret0, _ := ret[0].(*mfa.TOTP)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetTOTP indicates an expected call of GetTOTP.
+func (mr *MockTOTPRepositoryInterfaceMockRecorder) GetTOTP(ctx, userID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetTOTP", reflect.TypeOf((*MockTOTPRepositoryInterface)(nil).GetTOTP), ctx, userID)
+}
+
+// StoreTOTP mocks base method.
+func (m *MockTOTPRepositoryInterface) StoreTOTP(arg0 context.Context, arg1 *mfa.TOTP) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "StoreTOTP", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// StoreTOTP indicates an expected call of StoreTOTP.
+func (mr *MockTOTPRepositoryInterfaceMockRecorder) StoreTOTP(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "StoreTOTP", reflect.TypeOf((*MockTOTPRepositoryInterface)(nil).StoreTOTP), arg0, arg1)
+}
+
+// UseRecoveryCode mocks base method.
+func (m *MockTOTPRepositoryInterface) UseRecoveryCode(ctx context.Context, userID string, codeHash []byte, usedAt time.Time) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UseRecoveryCode", ctx, userID, codeHash, usedAt)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UseRecoveryCode indicates an expected call of UseRecoveryCode.
+func (mr *MockTOTPRepositoryInterfaceMockRecorder) UseRecoveryCode(ctx, userID, codeHash, usedAt interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UseRecoveryCode", reflect.TypeOf((*MockTOTPRepositoryInterface)(nil).UseRecoveryCode), ctx, userID, codeHash, usedAt)
+}
+
+// UseTOTPStep mocks base method.
+func (m *MockTOTPRepositoryInterface) UseTOTPStep(ctx context.Context, userID string, step int64) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UseTOTPStep", ctx, userID, step)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UseTOTPStep indicates an expected call of UseTOTPStep.
+func (mr *MockTOTPRepositoryInterfaceMockRecorder) UseTOTPStep(ctx, userID, step interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UseTOTPStep", reflect.TypeOf((*MockTOTPRepositoryInterface)(nil).UseTOTPStep), ctx, userID, step)
+}
+
+// MockMFAChallengeRepositoryInterface is a mock of MFAChallengeRepositoryInterface interface.
+type MockMFAChallengeRepositoryInterface struct {
+	ctrl     *gomock.Controller
+	recorder *MockMFAChallengeRepositoryInterfaceMockRecorder
+}
+
+// MockMFAChallengeRepositoryInterfaceMockRecorder is the mock recorder for MockMFAChallengeRepositoryInterface.
+type MockMFAChallengeRepositoryInterfaceMockRecorder struct {
+	mock *MockMFAChallengeRepositoryInterface
+}
+
+// NewMockMFAChallengeRepositoryInterface creates a new mock instance.
+func NewMockMFAChallengeRepositoryInterface(ctrl *gomock.Controller) *MockMFAChallengeRepositoryInterface {
+	mock := &MockMFAChallengeRepositoryInterface{ctrl: ctrl}
+	mock.recorder = &MockMFAChallengeRepositoryInterfaceMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockMFAChallengeRepositoryInterface) EXPECT() *MockMFAChallengeRepositoryInterfaceMockRecorder {
+	return m.recorder
+}
+
+// ConsumeMFAChallenge mocks base method.
+func (m *MockMFAChallengeRepositoryInterface) ConsumeMFAChallenge(ctx context.Context, id string, consumedAt time.Time) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ConsumeMFAChallenge", ctx, id, consumedAt)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ConsumeMFAChallenge indicates an expected call of ConsumeMFAChallenge.
+func (mr *MockMFAChallengeRepositoryInterfaceMockRecorder) ConsumeMFAChallenge(ctx, id, consumedAt interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ConsumeMFAChallenge", reflect.TypeOf((*MockMFAChallengeRepositoryInterface)(nil).ConsumeMFAChallenge), ctx, id, consumedAt)
+}
+
+// GetMFAChallengeByHash mocks base method.
+func (m *MockMFAChallengeRepositoryInterface) GetMFAChallengeByHash(ctx context.Context, hash []byte) (*mfa.Challenge, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetMFAChallengeByHash", ctx, hash)
+	ret0, _ := ret[0].(*mfa.Challenge)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetMFAChallengeByHash indicates an expected call of GetMFAChallengeByHash.
+func (mr *MockMFAChallengeRepositoryInterfaceMockRecorder) GetMFAChallengeByHash(ctx, hash interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetMFAChallengeByHash", reflect.TypeOf((*MockMFAChallengeRepositoryInterface)(nil).GetMFAChallengeByHash), ctx, hash)
+}
+
+// IncrementMFAChallengeAttempts mocks base method.
+func (m *MockMFAChallengeRepositoryInterface) IncrementMFAChallengeAttempts(ctx context.Context, id string) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "IncrementMFAChallengeAttempts", ctx, id)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// IncrementMFAChallengeAttempts indicates an expected call of IncrementMFAChallengeAttempts.
+func (mr *MockMFAChallengeRepositoryInterfaceMockRecorder) IncrementMFAChallengeAttempts(ctx, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IncrementMFAChallengeAttempts", reflect.TypeOf((*MockMFAChallengeRepositoryInterface)(nil).IncrementMFAChallengeAttempts), ctx, id)
+}
+
+// StoreMFAChallenge mocks base method.
+func (m *MockMFAChallengeRepositoryInterface) StoreMFAChallenge(arg0 context.Context, arg1 *mfa.Challenge) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "StoreMFAChallenge", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// StoreMFAChallenge indicates an expected call of StoreMFAChallenge.
+func (mr *MockMFAChallengeRepositoryInterfaceMockRecorder) StoreMFAChallenge(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "StoreMFAChallenge", reflect.TypeOf((*MockMFAChallengeRepositoryInterface)(nil).StoreMFAChallenge), arg0, arg1)
+}
+
+// MockPasskeyRepositoryInterface is a mock of PasskeyRepositoryInterface interface.
+type MockPasskeyRepositoryInterface struct {
+	ctrl     *gomock.Controller
+	recorder *MockPasskeyRepositoryInterfaceMockRecorder
+}
+
+// MockPasskeyRepositoryInterfaceMockRecorder is the mock recorder for MockPasskeyRepositoryInterface.
+type MockPasskeyRepositoryInterfaceMockRecorder struct {
+	mock *MockPasskeyRepositoryInterface
+}
+
+// NewMockPasskeyRepositoryInterface creates a new mock instance.
+func NewMockPasskeyRepositoryInterface(ctrl *gomock.Controller) *MockPasskeyRepositoryInterface {
+	mock := &MockPasskeyRepositoryInterface{ctrl: ctrl}
+	mock.recorder = &MockPasskeyRepositoryInterfaceMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockPasskeyRepositoryInterface) EXPECT() *MockPasskeyRepositoryInterfaceMockRecorder {
+	return m.recorder
+}
+
+// GetPasskey mocks base method.
+func (m *MockPasskeyRepositoryInterface) GetPasskey(ctx context.Context, id []byte) (*webauthn.Credential, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetPasskey", ctx, id)
+	ret0, _ := ret[0].(*webauthn.Credential)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetPasskey indicates an expected call of GetPasskey.
+func (mr *MockPasskeyRepositoryInterfaceMockRecorder) GetPasskey(ctx, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPasskey", reflect.TypeOf((*MockPasskeyRepositoryInterface)(nil).GetPasskey), ctx, id)
+}
+
+// GetUserPasskeys mocks base method.
+func (m *MockPasskeyRepositoryInterface) GetUserPasskeys(ctx context.Context, userID string) ([]*webauthn.Credential, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetUserPasskeys", ctx, userID)
+	ret0, _ := ret[0].([]*webauthn.Credential)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetUserPasskeys indicates an expected call of GetUserPasskeys.
+func (mr *MockPasskeyRepositoryInterfaceMockRecorder) GetUserPasskeys(ctx, userID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserPasskeys", reflect.TypeOf((*MockPasskeyRepositoryInterface)(nil).GetUserPasskeys), ctx, userID)
+}
+
+// StorePasskey mocks base method.
+func (m *MockPasskeyRepositoryInterface) StorePasskey(arg0 context.Context, arg1 *webauthn.Credential) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "StorePasskey", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// StorePasskey indicates an expected call of StorePasskey.
+func (mr *MockPasskeyRepositoryInterfaceMockRecorder) StorePasskey(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "StorePasskey", reflect.TypeOf((*MockPasskeyRepositoryInterface)(nil).StorePasskey), arg0, arg1)
+}
+
+// UpdatePasskeySignCount mocks base method.
+func (m *MockPasskeyRepositoryInterface) UpdatePasskeySignCount(ctx context.Context, id []byte, signCount uint32, usedAt time.Time) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdatePasskeySignCount", ctx, id, signCount, usedAt)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UpdatePasskeySignCount indicates an expected call of UpdatePasskeySignCount.
+func (mr *MockPasskeyRepositoryInterfaceMockRecorder) UpdatePasskeySignCount(ctx, id, signCount, usedAt interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdatePasskeySignCount", reflect.TypeOf((*MockPasskeyRepositoryInterface)(nil).UpdatePasskeySignCount), ctx, id, signCount, usedAt)
+}
+
+// MockWebAuthnSessionRepositoryInterface is a mock of WebAuthnSessionRepositoryInterface interface.
+type MockWebAuthnSessionRepositoryInterface struct {
+	ctrl     *gomock.Controller
+	recorder *MockWebAuthnSessionRepositoryInterfaceMockRecorder
+}
+
+// MockWebAuthnSessionRepositoryInterfaceMockRecorder is the mock recorder for MockWebAuthnSessionRepositoryInterface.
+type MockWebAuthnSessionRepositoryInterfaceMockRecorder struct {
+	mock *MockWebAuthnSessionRepositoryInterface
+}
+
+// NewMockWebAuthnSessionRepositoryInterface creates a new mock instance.
+func NewMockWebAuthnSessionRepositoryInterface(ctrl *gomock.Controller) *MockWebAuthnSessionRepositoryInterface {
+	mock := &MockWebAuthnSessionRepositoryInterface{ctrl: ctrl}
+	mock.recorder = &MockWebAuthnSessionRepositoryInterfaceMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockWebAuthnSessionRepositoryInterface) EXPECT() *MockWebAuthnSessionRepositoryInterfaceMockRecorder {
+	return m.recorder
+}
+
+// StoreWebAuthnSession mocks base method.
+func (m *MockWebAuthnSessionRepositoryInterface) StoreWebAuthnSession(arg0 context.Context, arg1 *webauthn.Session) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "StoreWebAuthnSession", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// StoreWebAuthnSession indicates an expected call of StoreWebAuthnSession.
+func (mr *MockWebAuthnSessionRepositoryInterfaceMockRecorder) StoreWebAuthnSession(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "StoreWebAuthnSession", reflect.TypeOf((*MockWebAuthnSessionRepositoryInterface)(nil).StoreWebAuthnSession), arg0, arg1)
+}
+
+// TakeWebAuthnSession mocks base method.
+func (m *MockWebAuthnSessionRepositoryInterface) TakeWebAuthnSession(ctx context.Context, challenge []byte) (*webauthn.Session, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "TakeWebAuthnSession", ctx, challenge)
+	ret0, _ := ret[0].(*webauthn.Session)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// TakeWebAuthnSession indicates an expected call of TakeWebAuthnSession.
+func (mr *MockWebAuthnSessionRepositoryInterfaceMockRecorder) TakeWebAuthnSession(ctx, challenge interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TakeWebAuthnSession", reflect.TypeOf((*MockWebAuthnSessionRepositoryInterface)(nil).TakeWebAuthnSession), ctx, challenge)
 }
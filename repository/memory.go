@@ -0,0 +1,837 @@
+// This file contains the in-memory implementation of the repository layer,
+// running the service without a database. It follows the semantics of the
+// PostgreSQL implementation, which the conformance suite of repositorytest
+// checks for both.
+package repository
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/SawitProRecruitment/UserService/handler/model/lockout"
+	"github.com/SawitProRecruitment/UserService/handler/model/mfa"
+	"github.com/SawitProRecruitment/UserService/handler/model/otp"
+	"github.com/SawitProRecruitment/UserService/handler/model/token"
+	"github.com/SawitProRecruitment/UserService/handler/model/user"
+	"github.com/SawitProRecruitment/UserService/handler/model/webauthn"
+)
+
+// DefaultMemoryRetention is how long the memory repository keeps the records
+// once they expired.
+const DefaultMemoryRetention = 24 * time.Hour
+
+// MemoryRepository keeps everything in maps guarded by a single mutex, so every
+// method is atomic. Data is lost when the process exits.
+type MemoryRepository struct {
+	// Retention is how long expired records are kept before a write prunes
+	// them. The send limits of one-time passwords and the login lockouts look
+	// back at expired records, so it should be longer than their windows.
+	Retention time.Duration
+
+	mu       sync.Mutex
+	prunedAt time.Time
+
+	users                map[string]*user.User
+	refreshTokens        map[string]*token.RefreshToken
+	revokedAccessTokens  map[string]time.Time
+	userTokenRevocations map[string]time.Time
+	otps                 map[otpKey]*otp.OTP
+	loginFailures        map[string]*lockout.Counter
+	// passwordHistory is newest first
+	passwordHistory  map[string][]*user.PasswordRecord
+	totps            map[string]*mfa.TOTP
+	recoveryCodes    map[string][]*recoveryCode
+	mfaChallenges    map[string]*mfa.Challenge
+	passkeys         map[string]*webauthn.Credential
+	webAuthnSessions map[string]*webauthn.Session
+
+	// Indexes of the records by their other keys, the slices hold IDs
+	userIDsByPhoneNumber  map[string]string
+	refreshTokenIDsByHash map[string]string
+	refreshTokenFamilies  map[string][]string
+	userRefreshTokens     map[string][]string
+	otpKeysByID           map[string]otpKey
+	mfaChallengeIDsByHash map[string]string
+	userPasskeyIDs        map[string][]string
+}
+
+type otpKey struct {
+	purpose     otp.Purpose
+	phoneNumber string
+}
+
+type recoveryCode struct {
+	hash   []byte
+	usedAt time.Time
+}
+
+func NewMemoryRepository() *MemoryRepository {
+	return &MemoryRepository{
+		Retention:             DefaultMemoryRetention,
+		users:                 make(map[string]*user.User),
+		refreshTokens:         make(map[string]*token.RefreshToken),
+		revokedAccessTokens:   make(map[string]time.Time),
+		userTokenRevocations:  make(map[string]time.Time),
+		otps:                  make(map[otpKey]*otp.OTP),
+		loginFailures:         make(map[string]*lockout.Counter),
+		passwordHistory:       make(map[string][]*user.PasswordRecord),
+		totps:                 make(map[string]*mfa.TOTP),
+		recoveryCodes:         make(map[string][]*recoveryCode),
+		mfaChallenges:         make(map[string]*mfa.Challenge),
+		passkeys:              make(map[string]*webauthn.Credential),
+		webAuthnSessions:      make(map[string]*webauthn.Session),
+		userIDsByPhoneNumber:  make(map[string]string),
+		refreshTokenIDsByHash: make(map[string]string),
+		refreshTokenFamilies:  make(map[string][]string),
+		userRefreshTokens:     make(map[string][]string),
+		otpKeysByID:           make(map[string]otpKey),
+		mfaChallengeIDsByHash: make(map[string]string),
+		userPasskeyIDs:        make(map[string][]string),
+	}
+}
+
+func (r *MemoryRepository) Store(_ context.Context, u *user.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.users[u.ID()]; ok {
+		return ErrUniqueViolation
+	}
+
+	if r.phoneNumberTaken(u.PhoneNumber(), u.ID()) {
+		return ErrUniqueViolation
+	}
+
+	stored, err := copyUser(u)
+	if err != nil {
+		return err
+	}
+
+	r.users[u.ID()] = stored
+	r.userIDsByPhoneNumber[u.PhoneNumber()] = u.ID()
+	return nil
+}
+
+func (r *MemoryRepository) GetByID(_ context.Context, id string) (*user.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	u, ok := r.users[id]
+	if !ok {
+		return nil, nil
+	}
+
+	return copyUser(u)
+}
+
+func (r *MemoryRepository) GetByPhoneNumber(_ context.Context, phoneNumber string) (*user.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	id, ok := r.userIDsByPhoneNumber[phoneNumber]
+	if !ok {
+		return nil, nil
+	}
+
+	return copyUser(r.users[id])
+}
+
+func (r *MemoryRepository) Update(_ context.Context, u *user.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.update(u)
+}
+
+func (r *MemoryRepository) update(u *user.User) error {
+	prev, ok := r.users[u.ID()]
+	if !ok {
+		return errors.New("no rows affected")
+	}
+
+	if r.phoneNumberTaken(u.PhoneNumber(), u.ID()) {
+		return ErrUniqueViolation
+	}
+
+	stored, err := copyUser(u)
+	if err != nil {
+		return err
+	}
+
+	delete(r.userIDsByPhoneNumber, prev.PhoneNumber())
+	r.users[u.ID()] = stored
+	r.userIDsByPhoneNumber[u.PhoneNumber()] = u.ID()
+	return nil
+}
+
+func (r *MemoryRepository) phoneNumberTaken(phoneNumber, exceptID string) bool {
+	id, ok := r.userIDsByPhoneNumber[phoneNumber]
+	return ok && id != exceptID
+}
+
+func (r *MemoryRepository) StoreRefreshToken(_ context.Context, rt *token.RefreshToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.prune(time.Now())
+	if _, ok := r.refreshTokens[rt.ID()]; ok {
+		return ErrUniqueViolation
+	}
+
+	if _, ok := r.refreshTokenIDsByHash[string(rt.Hash())]; ok {
+		return ErrUniqueViolation
+	}
+
+	// Like the INSERT, only the use and revocation of a new token are ignored
+	stored, err := token.New(rt.ID(), rt.FamilyID(), rt.UserID(), cloneBytes(rt.Hash()), rt.IssuedAt(), rt.ExpiresAt(), time.Time{}, time.Time{})
+	if err != nil {
+		return err
+	}
+
+	r.refreshTokens[rt.ID()] = stored
+	r.refreshTokenIDsByHash[string(rt.Hash())] = rt.ID()
+	r.refreshTokenFamilies[rt.FamilyID()] = append(r.refreshTokenFamilies[rt.FamilyID()], rt.ID())
+	r.userRefreshTokens[rt.UserID()] = append(r.userRefreshTokens[rt.UserID()], rt.ID())
+	return nil
+}
+
+func (r *MemoryRepository) GetRefreshTokenByHash(_ context.Context, hash []byte) (*token.RefreshToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	id, ok := r.refreshTokenIDsByHash[string(hash)]
+	if !ok {
+		return nil, nil
+	}
+
+	return r.refreshTokens[id], nil
+}
+
+func (r *MemoryRepository) MarkRefreshTokenUsed(_ context.Context, id string, usedAt time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rt, ok := r.refreshTokens[id]
+	if !ok || !rt.UsedAt().IsZero() {
+		return false, nil
+	}
+
+	used, err := token.New(rt.ID(), rt.FamilyID(), rt.UserID(), rt.Hash(), rt.IssuedAt(), rt.ExpiresAt(), usedAt, rt.RevokedAt())
+	if err != nil {
+		return false, err
+	}
+
+	r.refreshTokens[id] = used
+	return true, nil
+}
+
+func (r *MemoryRepository) RevokeRefreshTokenFamily(_ context.Context, familyID string, revokedAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.revokeRefreshTokens(r.refreshTokenFamilies[familyID], revokedAt)
+}
+
+func (r *MemoryRepository) RevokeUserRefreshTokens(_ context.Context, userID string, revokedAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.revokeRefreshTokens(r.userRefreshTokens[userID], revokedAt)
+}
+
+func (r *MemoryRepository) revokeRefreshTokens(ids []string, revokedAt time.Time) error {
+	for _, id := range ids {
+		rt := r.refreshTokens[id]
+		if !rt.RevokedAt().IsZero() {
+			continue
+		}
+
+		revoked, err := token.New(rt.ID(), rt.FamilyID(), rt.UserID(), rt.Hash(), rt.IssuedAt(), rt.ExpiresAt(), rt.UsedAt(), revokedAt)
+		if err != nil {
+			return err
+		}
+
+		r.refreshTokens[id] = revoked
+	}
+
+	return nil
+}
+
+func (r *MemoryRepository) RevokeAccessToken(_ context.Context, jti, userID string, expiresAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.prune(time.Now())
+	if _, ok := r.revokedAccessTokens[jti]; !ok {
+		r.revokedAccessTokens[jti] = expiresAt
+	}
+
+	return nil
+}
+
+func (r *MemoryRepository) RevokeUserAccessTokens(_ context.Context, userID string, issuedBefore time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if prev, ok := r.userTokenRevocations[userID]; !ok || issuedBefore.After(prev) {
+		r.userTokenRevocations[userID] = issuedBefore
+	}
+
+	return nil
+}
+
+func (r *MemoryRepository) AccessTokenRevoked(_ context.Context, jti, userID string, issuedAt time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.revokedAccessTokens[jti]; ok {
+		return true, nil
+	}
+
+	issuedBefore, ok := r.userTokenRevocations[userID]
+	return ok && issuedBefore.After(issuedAt), nil
+}
+
+func (r *MemoryRepository) StoreOTP(_ context.Context, o *otp.OTP) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.prune(time.Now())
+	key := otpKey{purpose: o.Purpose(), phoneNumber: o.PhoneNumber()}
+	if k, ok := r.otpKeysByID[o.ID()]; ok && k != key {
+		return ErrUniqueViolation
+	}
+
+	// Replacing the code of the purpose and phone number resets its use
+	stored, err := otp.New(o.ID(), o.Purpose(), o.PhoneNumber(), cloneBytes(o.CodeHash()), o.IssuedAt(), o.ExpiresAt(), o.Attempts(), time.Time{}, o.SendCount(), o.SendWindowStart())
+	if err != nil {
+		return err
+	}
+
+	if prev, ok := r.otps[key]; ok {
+		delete(r.otpKeysByID, prev.ID())
+	}
+
+	r.otps[key] = stored
+	r.otpKeysByID[o.ID()] = key
+	return nil
+}
+
+func (r *MemoryRepository) GetOTP(_ context.Context, purpose otp.Purpose, phoneNumber string) (*otp.OTP, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	o, ok := r.otps[otpKey{purpose: purpose, phoneNumber: phoneNumber}]
+	if !ok {
+		return nil, nil
+	}
+
+	// ContinueSendWindow changes the code, keep the stored one apart
+	return otp.New(o.ID(), o.Purpose(), o.PhoneNumber(), o.CodeHash(), o.IssuedAt(), o.ExpiresAt(), o.Attempts(), o.ConsumedAt(), o.SendCount(), o.SendWindowStart())
+}
+
+func (r *MemoryRepository) IncrementOTPAttempts(_ context.Context, id string) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key, o := r.otpByID(id)
+	if o == nil {
+		return 0, errors.New("no rows affected")
+	}
+
+	updated, err := otp.New(o.ID(), o.Purpose(), o.PhoneNumber(), o.CodeHash(), o.IssuedAt(), o.ExpiresAt(), o.Attempts()+1, o.ConsumedAt(), o.SendCount(), o.SendWindowStart())
+	if err != nil {
+		return 0, err
+	}
+
+	r.otps[key] = updated
+	return updated.Attempts(), nil
+}
+
+func (r *MemoryRepository) ConsumeOTP(_ context.Context, id string, consumedAt time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key, o := r.otpByID(id)
+	if o == nil || o.Consumed() {
+		return false, nil
+	}
+
+	consumed, err := otp.New(o.ID(), o.Purpose(), o.PhoneNumber(), o.CodeHash(), o.IssuedAt(), o.ExpiresAt(), o.Attempts(), consumedAt, o.SendCount(), o.SendWindowStart())
+	if err != nil {
+		return false, err
+	}
+
+	r.otps[key] = consumed
+	return true, nil
+}
+
+func (r *MemoryRepository) otpByID(id string) (otpKey, *otp.OTP) {
+	key, ok := r.otpKeysByID[id]
+	if !ok {
+		return otpKey{}, nil
+	}
+
+	return key, r.otps[key]
+}
+
+func (r *MemoryRepository) GetLoginFailures(_ context.Context, key string) (*lockout.Counter, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c, ok := r.loginFailures[key]
+	if !ok {
+		return nil, nil
+	}
+
+	return c, nil
+}
+
+func (r *MemoryRepository) IncrementLoginFailures(_ context.Context, key string, failedAt, resetBefore time.Time) (*lockout.Counter, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.prune(time.Now())
+	failures := 1
+	var lockedUntil time.Time
+	if c, ok := r.loginFailures[key]; ok && !c.LastFailureAt().Before(resetBefore) {
+		failures = c.Failures() + 1
+		lockedUntil = c.LockedUntil()
+	}
+
+	c, err := lockout.New(key, failures, failedAt, lockedUntil)
+	if err != nil {
+		return nil, err
+	}
+
+	r.loginFailures[key] = c
+	return c, nil
+}
+
+func (r *MemoryRepository) LockLogin(_ context.Context, key string, lockedUntil time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c, ok := r.loginFailures[key]
+	if !ok {
+		return nil
+	}
+
+	locked, err := lockout.New(key, c.Failures(), c.LastFailureAt(), lockedUntil)
+	if err != nil {
+		return err
+	}
+
+	r.loginFailures[key] = locked
+	return nil
+}
+
+func (r *MemoryRepository) ResetLoginFailures(_ context.Context, key string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.loginFailures, key)
+	return nil
+}
+
+func (r *MemoryRepository) GetPasswordHistory(_ context.Context, userID string, limit int) ([]*user.PasswordRecord, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if limit < 0 {
+		return nil, errors.New("negative limit")
+	}
+
+	history := r.passwordHistory[userID]
+	if len(history) > limit {
+		history = history[:limit]
+	}
+
+	if len(history) == 0 {
+		return nil, nil
+	}
+
+	return append([]*user.PasswordRecord(nil), history...), nil
+}
+
+func (r *MemoryRepository) UpdatePassword(_ context.Context, u *user.User, previous *user.PasswordRecord, keep int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if keep < 0 {
+		return errors.New("negative keep")
+	}
+
+	stored, err := user.NewPasswordRecord(cloneBytes(previous.Hash()), cloneBytes(previous.Salt()), previous.PepperID(), previous.CreatedAt())
+	if err != nil {
+		return err
+	}
+
+	err = r.update(u)
+	if err != nil {
+		return err
+	}
+
+	// Prepended so it stays ahead of the passwords changed at the same time
+	history := append([]*user.PasswordRecord{stored}, r.passwordHistory[u.ID()]...)
+	sort.SliceStable(history, func(i, j int) bool {
+		return history[i].CreatedAt().After(history[j].CreatedAt())
+	})
+
+	if len(history) > keep {
+		history = history[:keep]
+	}
+
+	r.passwordHistory[u.ID()] = history
+	return nil
+}
+
+func (r *MemoryRepository) StoreTOTP(_ context.Context, t *mfa.TOTP) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, err := mfa.NewTOTP(t.UserID(), cloneBytes(t.Secret()), t.CreatedAt(), t.ConfirmedAt(), t.LastUsedStep())
+	if err != nil {
+		return err
+	}
+
+	r.totps[t.UserID()] = stored
+	return nil
+}
+
+func (r *MemoryRepository) GetTOTP(_ context.Context, userID string) (*mfa.TOTP, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t, ok := r.totps[userID]
+	if !ok {
+		return nil, nil
+	}
+
+	return t, nil
+}
+
+func (r *MemoryRepository) ConfirmTOTP(_ context.Context, userID string, confirmedAt time.Time, step int64, recoveryCodeHashes [][]byte) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t, ok := r.totps[userID]
+	if !ok || t.Confirmed() {
+		return false, nil
+	}
+
+	confirmed, err := mfa.NewTOTP(userID, t.Secret(), t.CreatedAt(), confirmedAt, step)
+	if err != nil {
+		return false, err
+	}
+
+	codes := make([]*recoveryCode, 0, len(recoveryCodeHashes))
+	for _, codeHash := range recoveryCodeHashes {
+		for _, c := range codes {
+			if bytes.Equal(c.hash, codeHash) {
+				return false, ErrUniqueViolation
+			}
+		}
+
+		codes = append(codes, &recoveryCode{hash: cloneBytes(codeHash)})
+	}
+
+	r.totps[userID] = confirmed
+	r.recoveryCodes[userID] = codes
+	return true, nil
+}
+
+func (r *MemoryRepository) UseTOTPStep(_ context.Context, userID string, step int64) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t, ok := r.totps[userID]
+	if !ok || t.LastUsedStep() >= step {
+		return false, nil
+	}
+
+	used, err := mfa.NewTOTP(userID, t.Secret(), t.CreatedAt(), t.ConfirmedAt(), step)
+	if err != nil {
+		return false, err
+	}
+
+	r.totps[userID] = used
+	return true, nil
+}
+
+func (r *MemoryRepository) DeleteTOTP(_ context.Context, userID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.recoveryCodes, userID)
+	delete(r.totps, userID)
+	return nil
+}
+
+func (r *MemoryRepository) UseRecoveryCode(_ context.Context, userID string, codeHash []byte, usedAt time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, c := range r.recoveryCodes[userID] {
+		if bytes.Equal(c.hash, codeHash) && c.usedAt.IsZero() {
+			c.usedAt = usedAt
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (r *MemoryRepository) StoreMFAChallenge(_ context.Context, c *mfa.Challenge) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.prune(time.Now())
+	if _, ok := r.mfaChallenges[c.ID()]; ok {
+		return ErrUniqueViolation
+	}
+
+	if _, ok := r.mfaChallengeIDsByHash[string(c.TokenHash())]; ok {
+		return ErrUniqueViolation
+	}
+
+	stored, err := mfa.NewChallenge(c.ID(), c.UserID(), cloneBytes(c.TokenHash()), c.IssuedAt(), c.ExpiresAt(), c.Attempts(), c.ConsumedAt())
+	if err != nil {
+		return err
+	}
+
+	r.mfaChallenges[c.ID()] = stored
+	r.mfaChallengeIDsByHash[string(c.TokenHash())] = c.ID()
+	return nil
+}
+
+func (r *MemoryRepository) GetMFAChallengeByHash(_ context.Context, hash []byte) (*mfa.Challenge, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	id, ok := r.mfaChallengeIDsByHash[string(hash)]
+	if !ok {
+		return nil, nil
+	}
+
+	return r.mfaChallenges[id], nil
+}
+
+func (r *MemoryRepository) IncrementMFAChallengeAttempts(_ context.Context, id string) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c, ok := r.mfaChallenges[id]
+	if !ok {
+		return 0, errors.New("no rows affected")
+	}
+
+	updated, err := mfa.NewChallenge(c.ID(), c.UserID(), c.TokenHash(), c.IssuedAt(), c.ExpiresAt(), c.Attempts()+1, c.ConsumedAt())
+	if err != nil {
+		return 0, err
+	}
+
+	r.mfaChallenges[id] = updated
+	return updated.Attempts(), nil
+}
+
+func (r *MemoryRepository) ConsumeMFAChallenge(_ context.Context, id string, consumedAt time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c, ok := r.mfaChallenges[id]
+	if !ok || c.Consumed() {
+		return false, nil
+	}
+
+	consumed, err := mfa.NewChallenge(c.ID(), c.UserID(), c.TokenHash(), c.IssuedAt(), c.ExpiresAt(), c.Attempts(), consumedAt)
+	if err != nil {
+		return false, err
+	}
+
+	r.mfaChallenges[id] = consumed
+	return true, nil
+}
+
+func (r *MemoryRepository) StorePasskey(_ context.Context, c *webauthn.Credential) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.passkeys[string(c.ID())]; ok {
+		return ErrUniqueViolation
+	}
+
+	stored, err := webauthn.NewCredential(cloneBytes(c.ID()), c.UserID(), cloneBytes(c.PublicKey()), c.SignCount(), c.CreatedAt(), c.LastUsedAt())
+	if err != nil {
+		return err
+	}
+
+	r.passkeys[string(c.ID())] = stored
+	r.userPasskeyIDs[c.UserID()] = append(r.userPasskeyIDs[c.UserID()], string(c.ID()))
+	return nil
+}
+
+func (r *MemoryRepository) GetPasskey(_ context.Context, id []byte) (*webauthn.Credential, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c, ok := r.passkeys[string(id)]
+	if !ok {
+		return nil, nil
+	}
+
+	return c, nil
+}
+
+func (r *MemoryRepository) GetUserPasskeys(_ context.Context, userID string) ([]*webauthn.Credential, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var creds []*webauthn.Credential
+	for _, id := range r.userPasskeyIDs[userID] {
+		creds = append(creds, r.passkeys[id])
+	}
+
+	sort.Slice(creds, func(i, j int) bool {
+		return creds[i].CreatedAt().Before(creds[j].CreatedAt())
+	})
+
+	return creds, nil
+}
+
+func (r *MemoryRepository) UpdatePasskeySignCount(_ context.Context, id []byte, signCount uint32, usedAt time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c, ok := r.passkeys[string(id)]
+	if !ok || (c.SignCount() >= signCount && c.SignCount() != 0) {
+		return false, nil
+	}
+
+	used, err := webauthn.NewCredential(c.ID(), c.UserID(), c.PublicKey(), signCount, c.CreatedAt(), usedAt)
+	if err != nil {
+		return false, err
+	}
+
+	r.passkeys[string(id)] = used
+	return true, nil
+}
+
+func (r *MemoryRepository) StoreWebAuthnSession(_ context.Context, s *webauthn.Session) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.prune(time.Now())
+	if _, ok := r.webAuthnSessions[string(s.Challenge())]; ok {
+		return ErrUniqueViolation
+	}
+
+	stored, err := webauthn.NewSession(cloneBytes(s.Challenge()), s.Ceremony(), s.UserID(), s.IssuedAt(), s.ExpiresAt())
+	if err != nil {
+		return err
+	}
+
+	r.webAuthnSessions[string(s.Challenge())] = stored
+	return nil
+}
+
+func (r *MemoryRepository) TakeWebAuthnSession(_ context.Context, challenge []byte) (*webauthn.Session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, ok := r.webAuthnSessions[string(challenge)]
+	if !ok {
+		return nil, nil
+	}
+
+	delete(r.webAuthnSessions, string(challenge))
+	return s, nil
+}
+
+// prune deletes the records expired for longer than the retention. It goes
+// through every record, so it only runs once a tenth of the retention passed
+// since the last time.
+func (r *MemoryRepository) prune(now time.Time) {
+	if now.Before(r.prunedAt.Add(r.Retention / 10)) {
+		return
+	}
+
+	r.prunedAt = now
+	expiredBefore := now.Add(-r.Retention)
+	for id, rt := range r.refreshTokens {
+		if rt.ExpiresAt().Before(expiredBefore) {
+			delete(r.refreshTokens, id)
+			delete(r.refreshTokenIDsByHash, string(rt.Hash()))
+			removeID(r.refreshTokenFamilies, rt.FamilyID(), id)
+			removeID(r.userRefreshTokens, rt.UserID(), id)
+		}
+	}
+
+	for jti, expiresAt := range r.revokedAccessTokens {
+		if expiresAt.Before(expiredBefore) {
+			delete(r.revokedAccessTokens, jti)
+		}
+	}
+
+	for key, o := range r.otps {
+		if o.ExpiresAt().Before(expiredBefore) {
+			delete(r.otps, key)
+			delete(r.otpKeysByID, o.ID())
+		}
+	}
+
+	for key, c := range r.loginFailures {
+		if c.LastFailureAt().Before(expiredBefore) && c.LockedUntil().Before(expiredBefore) {
+			delete(r.loginFailures, key)
+		}
+	}
+
+	for id, c := range r.mfaChallenges {
+		if c.ExpiresAt().Before(expiredBefore) {
+			delete(r.mfaChallenges, id)
+			delete(r.mfaChallengeIDsByHash, string(c.TokenHash()))
+		}
+	}
+
+	for challenge, s := range r.webAuthnSessions {
+		if s.ExpiresAt().Before(expiredBefore) {
+			delete(r.webAuthnSessions, challenge)
+		}
+	}
+}
+
+// removeID removes the ID from the entry of the index, which is deleted along
+// with its last ID.
+func removeID(index map[string][]string, key, id string) {
+	var ids []string
+	for _, v := range index[key] {
+		if v != id {
+			ids = append(ids, v)
+		}
+	}
+
+	if len(ids) == 0 {
+		delete(index, key)
+	} else {
+		index[key] = ids
+	}
+}
+
+// copyUser keeps the stored users apart from the ones of the callers, which
+// change them before calling Update.
+func copyUser(u *user.User) (*user.User, error) {
+	pwdHash, pwdSalt := u.Password()
+	return user.New(u.ID(), u.PhoneNumber(), u.FullName(), cloneBytes(pwdHash), cloneBytes(pwdSalt), u.PasswordPepperID(), u.PhoneVerifiedAt(), u.PendingPhoneNumber())
+}
+
+func cloneBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+
+	return append([]byte{}, b...)
+}
@@ -0,0 +1,153 @@
+package repository_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/SawitProRecruitment/UserService/handler/model/lockout"
+	"github.com/SawitProRecruitment/UserService/handler/model/otp"
+	"github.com/SawitProRecruitment/UserService/handler/model/token"
+	"github.com/SawitProRecruitment/UserService/handler/model/webauthn"
+	"github.com/SawitProRecruitment/UserService/repository"
+	"github.com/SawitProRecruitment/UserService/repository/repositorytest"
+	"github.com/rs/xid"
+)
+
+func TestMemoryRepository(t *testing.T) {
+	repositorytest.Run(t, func(t *testing.T) repository.Storage {
+		return repository.NewMemoryRepository()
+	})
+}
+
+func TestMemoryRepositoryPrunesExpiredRecords(t *testing.T) {
+	testCases := map[string]struct {
+		expiresIn    time.Duration
+		expectPruned bool
+	}{
+		"expired": {
+			expiresIn:    -time.Minute,
+			expectPruned: true,
+		},
+		"not expired": {
+			expiresIn:    time.Minute,
+			expectPruned: false,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			// Given
+			ctx := context.Background()
+			repo := repository.NewMemoryRepository()
+			repo.Retention = 0
+
+			issuedAt := time.Now().Add(-time.Hour)
+			expiresAt := time.Now().Add(tc.expiresIn)
+
+			rt, err := token.New(xid.New().String(), xid.New().String(), "user", token.Hash("refresh-token"), issuedAt, expiresAt, time.Time{}, time.Time{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			o, err := otp.New(xid.New().String(), otp.PurposeLogin, "+628123456789", []byte("code"), issuedAt, expiresAt, 0, time.Time{}, 1, issuedAt)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			session, err := webauthn.NewSession([]byte("login-challenge-00"), webauthn.CeremonyLogin, "", issuedAt, expiresAt)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := repo.StoreRefreshToken(ctx, rt); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := repo.RevokeAccessToken(ctx, "jti", "user", expiresAt); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := repo.StoreOTP(ctx, o); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := repo.IncrementLoginFailures(ctx, lockout.IPKey("192.0.2.1"), issuedAt, issuedAt.Add(-time.Hour)); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := repo.LockLogin(ctx, lockout.IPKey("192.0.2.1"), expiresAt); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := repo.StoreWebAuthnSession(ctx, session); err != nil {
+				t.Fatal(err)
+			}
+
+			// When
+			_, err = repo.IncrementLoginFailures(ctx, lockout.IPKey("192.0.2.2"), time.Now(), time.Now().Add(-time.Hour))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Then
+			gotToken, err := repo.GetRefreshTokenByHash(ctx, rt.Hash())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := gotToken == nil, tc.expectPruned; got != want {
+				t.Fatalf("refresh token pruned got %v, want %v", got, want)
+			}
+
+			revoked, err := repo.AccessTokenRevoked(ctx, "jti", "user", issuedAt)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := !revoked, tc.expectPruned; got != want {
+				t.Fatalf("access token revocation pruned got %v, want %v", got, want)
+			}
+
+			gotOTP, err := repo.GetOTP(ctx, otp.PurposeLogin, "+628123456789")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := gotOTP == nil, tc.expectPruned; got != want {
+				t.Fatalf("otp pruned got %v, want %v", got, want)
+			}
+
+			// The OTP is not found by its ID either once pruned
+			_, err = repo.IncrementOTPAttempts(ctx, o.ID())
+			if got, want := err != nil, tc.expectPruned; got != want {
+				t.Fatalf("otp attempts err got %v, want pruned %v", err, want)
+			}
+
+			gotCounter, err := repo.GetLoginFailures(ctx, lockout.IPKey("192.0.2.1"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := gotCounter == nil, tc.expectPruned; got != want {
+				t.Fatalf("login failures pruned got %v, want %v", got, want)
+			}
+
+			gotSession, err := repo.TakeWebAuthnSession(ctx, session.Challenge())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := gotSession == nil, tc.expectPruned; got != want {
+				t.Fatalf("webauthn session pruned got %v, want %v", got, want)
+			}
+
+			// A pruned record no longer takes its unique keys
+			if tc.expectPruned {
+				if err := repo.StoreRefreshToken(ctx, rt); err != nil {
+					t.Fatalf("storing the pruned refresh token again got %v, want nil", err)
+				}
+			}
+		})
+	}
+}
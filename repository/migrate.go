@@ -0,0 +1,323 @@
+// This file contains the schema migrations. They are numbered pairs of up and
+// down SQL scripts embedded in the binary, the applied ones being recorded in
+// the schema_migrations table.
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+var (
+	ErrSchemaAhead  = errors.New("database schema is ahead of this binary")
+	ErrSchemaBehind = errors.New("database schema is behind this binary, run the migrations")
+)
+
+// migrationLockKey identifies the advisory lock serializing the migrations
+// of concurrently starting PostgreSQL instances.
+const migrationLockKey = 0x55736572536572 // "UserSer"
+
+//go:embed migrations/postgres/*.sql migrations/sqlite/*.sql
+var migrationFiles embed.FS
+
+var migrationFilePattern = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// schema holds the migrations of a database and the statements applying them.
+type schema struct {
+	dir string
+	// tableExists tells whether the table named $1 exists.
+	tableExists string
+	// lock and unlock serialize the migrations run on different connections,
+	// nothing does when empty.
+	lock   string
+	unlock string
+	// adopt records the databases created before migrations existed, if any.
+	adopt  string
+	record string
+	forget string
+}
+
+var postgresSchema = &schema{
+	dir:         "migrations/postgres",
+	tableExists: "SELECT to_regclass($1) IS NOT NULL",
+	lock:        "SELECT pg_advisory_lock(" + strconv.Itoa(migrationLockKey) + ")",
+	unlock:      "SELECT pg_advisory_unlock(" + strconv.Itoa(migrationLockKey) + ")",
+	adopt: `INSERT INTO schema_migrations (version, name, applied_at)
+SELECT 1, 'initial', now()
+WHERE to_regclass('users') IS NOT NULL AND NOT EXISTS (SELECT 1 FROM schema_migrations)`,
+	record: "INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, now())",
+	forget: "DELETE FROM schema_migrations WHERE version = $1",
+}
+
+// The database file is locked by every write, and recording a migration
+// fails once another process applied it.
+var sqliteSchema = &schema{
+	dir:         "migrations/sqlite",
+	tableExists: "SELECT EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = $1)",
+	record:      "INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, strftime('%Y-%m-%dT%H:%M:%fZ', 'now'))",
+	forget:      "DELETE FROM schema_migrations WHERE version = $1",
+}
+
+// migrations returns the embedded migrations ordered by version, which run
+// from 1 without gaps.
+func (s *schema) migrations() ([]Migration, error) {
+	return readMigrations(migrationFiles, s.dir)
+}
+
+func readMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, e := range entries {
+		m := migrationFilePattern.FindStringSubmatch(e.Name())
+		if m == nil {
+			return nil, fmt.Errorf("unexpected migration file %s", e.Name())
+		}
+
+		version, err := strconv.Atoi(m[1])
+		if err != nil {
+			return nil, err
+		}
+
+		data, err := fs.ReadFile(fsys, path.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = mig
+		}
+
+		if mig.Name != m[2] {
+			return nil, fmt.Errorf("migration %d is named both %s and %s", version, mig.Name, m[2])
+		}
+
+		if m[3] == "up" {
+			mig.Up = string(data)
+		} else {
+			mig.Down = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		migrations = append(migrations, *mig)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	for i, mig := range migrations {
+		if mig.Version != i+1 {
+			return nil, fmt.Errorf("missing migration %d", i+1)
+		}
+
+		if mig.Up == "" || mig.Down == "" {
+			return nil, fmt.Errorf("migration %d needs both an up and a down script", mig.Version)
+		}
+	}
+
+	return migrations, nil
+}
+
+// Migrations returns the migrations of the PostgreSQL schema.
+func (r *Repository) Migrations() ([]Migration, error) {
+	return postgresSchema.migrations()
+}
+
+func (r *Repository) SchemaVersion(ctx context.Context) (int, error) {
+	return postgresSchema.version(ctx, r.Db)
+}
+
+func (r *Repository) CheckSchema(ctx context.Context) error {
+	return postgresSchema.check(ctx, r.Db)
+}
+
+func (r *Repository) MigrateUp(ctx context.Context) error {
+	return postgresSchema.migrateUp(ctx, r.Db)
+}
+
+func (r *Repository) MigrateTo(ctx context.Context, version int) error {
+	return postgresSchema.migrateTo(ctx, r.Db, version)
+}
+
+// version returns the version of the last applied migration, 0 when none
+// was.
+func (s *schema) version(ctx context.Context, db *sql.DB) (int, error) {
+	var exists bool
+	err := db.QueryRowContext(ctx, s.tableExists, "schema_migrations").Scan(&exists)
+	if err != nil || !exists {
+		return 0, err
+	}
+
+	return schemaVersion(ctx, db)
+}
+
+// check fails unless every migration of the binary, and no other, is
+// applied.
+func (s *schema) check(ctx context.Context, db *sql.DB) error {
+	migrations, err := s.migrations()
+	if err != nil {
+		return err
+	}
+
+	version, err := s.version(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	return compareSchema(version, len(migrations))
+}
+
+// migrateUp applies the pending migrations.
+func (s *schema) migrateUp(ctx context.Context, db *sql.DB) error {
+	migrations, err := s.migrations()
+	if err != nil {
+		return err
+	}
+
+	return s.migrateTo(ctx, db, len(migrations))
+}
+
+// migrateTo applies the up or down migrations bringing the schema to the
+// version. Every migration runs in its own transaction, along with recording
+// it, and concurrent calls wait for each other.
+func (s *schema) migrateTo(ctx context.Context, db *sql.DB, version int) error {
+	migrations, err := s.migrations()
+	if err != nil {
+		return err
+	}
+
+	if version < 0 || version > len(migrations) {
+		return fmt.Errorf("unknown schema version %d, this binary knows up to %d", version, len(migrations))
+	}
+
+	// Session advisory locks belong to a connection, so keep one for the run
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	if s.lock != "" {
+		_, err = conn.ExecContext(ctx, s.lock)
+		if err != nil {
+			return err
+		}
+
+		defer conn.ExecContext(context.Background(), s.unlock)
+	}
+
+	err = s.initMigrationsTable(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	current, err := schemaVersion(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	// The down scripts of unknown migrations are not available
+	if current > len(migrations) {
+		return compareSchema(current, len(migrations))
+	}
+
+	for ; current < version; current++ {
+		mig := migrations[current]
+		err = applyMigration(ctx, conn, mig.Up, s.record, mig.Version, mig.Name)
+		if err != nil {
+			return fmt.Errorf("migration %d_%s: %w", mig.Version, mig.Name, err)
+		}
+	}
+
+	for ; current > version; current-- {
+		mig := migrations[current-1]
+		err = applyMigration(ctx, conn, mig.Down, s.forget, mig.Version)
+		if err != nil {
+			return fmt.Errorf("reverting migration %d_%s: %w", mig.Version, mig.Name, err)
+		}
+	}
+
+	return nil
+}
+
+type queryer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// initMigrationsTable creates the schema_migrations table. Databases created
+// from the schema before migrations existed are recorded at version 1.
+func (s *schema) initMigrationsTable(ctx context.Context, q queryer) error {
+	_, err := q.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+  version INTEGER PRIMARY KEY,
+  name VARCHAR(64) NOT NULL,
+  applied_at TIMESTAMPTZ NOT NULL
+)`)
+	if err != nil || s.adopt == "" {
+		return err
+	}
+
+	_, err = q.ExecContext(ctx, s.adopt)
+	return err
+}
+func schemaVersion(ctx context.Context, q queryer) (int, error) {
+	var version int
+	err := q.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version)
+	return version, err
+}
+
+func applyMigration(ctx context.Context, conn *sql.Conn, script, record string, args ...interface{}) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, script)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, record, args...)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func compareSchema(version, latest int) error {
+	switch {
+	case version > latest:
+		return fmt.Errorf("%w: database at version %d, binary at %d", ErrSchemaAhead, version, latest)
+	case version < latest:
+		return fmt.Errorf("%w: database at version %d, binary at %d", ErrSchemaBehind, version, latest)
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,209 @@
+package repository_test
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/SawitProRecruitment/UserService/repository"
+	"github.com/rs/xid"
+)
+
+// migratable is a backend with a schema.
+type migratable interface {
+	repository.Migrator
+	Migrations() ([]repository.Migration, error)
+}
+
+func TestSQLiteMigrations(t *testing.T) {
+	testMigrations(t, func(t *testing.T) (migratable, *sql.DB) {
+		repo, err := repository.NewSQLiteRepository(repository.NewRepositoryOptions{
+			Dsn: repository.SQLiteScheme + filepath.Join(t.TempDir(), "users.db"),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		t.Cleanup(func() { repo.Db.Close() })
+		return repo, repo.Db
+	})
+}
+
+// TestPostgresMigrations runs on the PostgreSQL database of
+// TEST_DATABASE_URL, whose tables are dropped by every test.
+func TestPostgresMigrations(t *testing.T) {
+	repo := newTestRepository(t)
+	open := func(t *testing.T) (migratable, *sql.DB) {
+		ctx := context.Background()
+		if err := repo.MigrateTo(ctx, 0); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := repo.Db.ExecContext(ctx, "DROP TABLE schema_migrations"); err != nil {
+			t.Fatal(err)
+		}
+
+		return repo, repo.Db
+	}
+
+	testMigrations(t, open)
+
+	t.Run("adopts a database created before the migrations", func(t *testing.T) {
+		// Given
+		ctx := context.Background()
+		_, db := open(t)
+
+		// The schema of the former database.sql
+		_, err := db.ExecContext(ctx, `CREATE TABLE users (
+  id BYTEA PRIMARY KEY,
+  phone_number VARCHAR(13) UNIQUE NOT NULL,
+  full_name VARCHAR(60) NOT NULL,
+  password_hash BYTEA NOT NULL,
+  password_salt BYTEA NOT NULL
+)`)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		id := xid.New()
+		_, err = db.ExecContext(ctx, "INSERT INTO users (id, phone_number, full_name, password_hash, password_salt) VALUES ($1, $2, $3, $4, $5)",
+			id.Bytes(), "+628123456789", "Legacy User", bytes.Repeat([]byte{0x24}, 32), []byte("salt"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// When
+		err = repo.MigrateUp(ctx)
+
+		// Then
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := repo.CheckSchema(ctx); err != nil {
+			t.Fatalf("CheckSchema got %v, want nil", err)
+		}
+
+		u, err := repo.GetByPhoneNumber(ctx, "+628123456789")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if u == nil || u.ID() != id.String() {
+			t.Fatalf("user got %+v, want the legacy user", u)
+		}
+
+		if _, salt := u.Password(); !bytes.Equal(salt, []byte("salt")) {
+			t.Fatalf("salt got %q, want the legacy salt", salt)
+		}
+	})
+}
+
+// testMigrations checks the migrations of a backend, open returning it on a
+// database without tables.
+func testMigrations(t *testing.T, open func(t *testing.T) (migratable, *sql.DB)) {
+	t.Run("down and up", func(t *testing.T) {
+		// Given
+		ctx := context.Background()
+		m, _ := open(t)
+
+		migrations, err := m.Migrations()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := m.MigrateUp(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		// When / Then every down script reverts its up script
+		for version := len(migrations) - 1; version >= 0; version-- {
+			if err := m.MigrateTo(ctx, version); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := m.MigrateTo(ctx, version+1); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := m.MigrateTo(ctx, version); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := m.SchemaVersion(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got != version {
+				t.Fatalf("version got %d, want %d", got, version)
+			}
+		}
+
+		if err := m.MigrateUp(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := m.CheckSchema(ctx); err != nil {
+			t.Fatalf("CheckSchema got %v, want nil", err)
+		}
+	})
+
+	t.Run("behind", func(t *testing.T) {
+		// Given
+		ctx := context.Background()
+		m, _ := open(t)
+
+		if err := m.MigrateTo(ctx, 1); err != nil {
+			t.Fatal(err)
+		}
+
+		// When
+		err := m.CheckSchema(ctx)
+
+		// Then
+		if !errors.Is(err, repository.ErrSchemaBehind) {
+			t.Fatalf("err got %v, want %v", err, repository.ErrSchemaBehind)
+		}
+	})
+
+	t.Run("ahead", func(t *testing.T) {
+		// Given
+		ctx := context.Background()
+		m, db := open(t)
+
+		migrations, err := m.Migrations()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := m.MigrateUp(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = db.ExecContext(ctx, "INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, 'newer', CURRENT_TIMESTAMP)", len(migrations)+1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		t.Cleanup(func() {
+			db.ExecContext(context.Background(), "DELETE FROM schema_migrations WHERE version = $1", len(migrations)+1)
+		})
+
+		// When / Then
+		if err := m.CheckSchema(ctx); !errors.Is(err, repository.ErrSchemaAhead) {
+			t.Fatalf("CheckSchema got %v, want %v", err, repository.ErrSchemaAhead)
+		}
+
+		if err := m.MigrateUp(ctx); !errors.Is(err, repository.ErrSchemaAhead) {
+			t.Fatalf("MigrateUp got %v, want %v", err, repository.ErrSchemaAhead)
+		}
+
+		if err := m.MigrateTo(ctx, 0); !errors.Is(err, repository.ErrSchemaAhead) {
+			t.Fatalf("MigrateTo got %v, want %v", err, repository.ErrSchemaAhead)
+		}
+	})
+}
@@ -0,0 +1,7 @@
+CREATE TABLE users (
+  id BYTEA PRIMARY KEY,
+  phone_number VARCHAR(13) UNIQUE NOT NULL,
+  full_name VARCHAR(60) NOT NULL,
+  password_hash BYTEA NOT NULL,
+  password_salt BYTEA NOT NULL
+);
@@ -0,0 +1,12 @@
+CREATE TABLE refresh_tokens (
+  id BYTEA PRIMARY KEY,
+  family_id BYTEA NOT NULL,
+  user_id BYTEA NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  token_hash BYTEA UNIQUE NOT NULL,
+  issued_at TIMESTAMPTZ NOT NULL,
+  expires_at TIMESTAMPTZ NOT NULL,
+  used_at TIMESTAMPTZ,
+  revoked_at TIMESTAMPTZ
+);
+
+CREATE INDEX refresh_tokens_family_id_idx ON refresh_tokens (family_id);
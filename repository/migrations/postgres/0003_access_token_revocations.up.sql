@@ -0,0 +1,10 @@
+CREATE TABLE revoked_access_tokens (
+  jti VARCHAR(20) PRIMARY KEY,
+  user_id BYTEA NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE user_token_revocations (
+  user_id BYTEA PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+  issued_before TIMESTAMPTZ NOT NULL
+);
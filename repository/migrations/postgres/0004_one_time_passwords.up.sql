@@ -0,0 +1,13 @@
+CREATE TABLE one_time_passwords (
+  id BYTEA PRIMARY KEY,
+  purpose VARCHAR(32) NOT NULL,
+  phone_number VARCHAR(13) NOT NULL,
+  code_hash BYTEA NOT NULL,
+  issued_at TIMESTAMPTZ NOT NULL,
+  expires_at TIMESTAMPTZ NOT NULL,
+  attempts INTEGER NOT NULL DEFAULT 0,
+  consumed_at TIMESTAMPTZ,
+  send_count INTEGER NOT NULL DEFAULT 1,
+  send_window_start TIMESTAMPTZ NOT NULL,
+  UNIQUE (purpose, phone_number)
+);
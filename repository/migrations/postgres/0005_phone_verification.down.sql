@@ -0,0 +1,2 @@
+ALTER TABLE users DROP COLUMN pending_phone_number;
+ALTER TABLE users DROP COLUMN phone_verified_at;
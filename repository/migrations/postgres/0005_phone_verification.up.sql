@@ -0,0 +1,3 @@
+-- The phone numbers of the existing users are unverified
+ALTER TABLE users ADD COLUMN phone_verified_at TIMESTAMPTZ;
+ALTER TABLE users ADD COLUMN pending_phone_number VARCHAR(13);
@@ -0,0 +1,7 @@
+-- Consecutive failed logins, keyed by "user:<id>" or "ip:<address>"
+CREATE TABLE login_failures (
+  key VARCHAR(64) PRIMARY KEY,
+  failures INTEGER NOT NULL,
+  last_failure_at TIMESTAMPTZ NOT NULL,
+  locked_until TIMESTAMPTZ
+);
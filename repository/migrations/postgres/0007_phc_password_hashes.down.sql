@@ -0,0 +1,2 @@
+-- Fails while users have PHC hashes, which the former binaries cannot verify
+ALTER TABLE users ALTER COLUMN password_salt SET NOT NULL;
@@ -0,0 +1,2 @@
+-- PHC strings carry their salt, the column is only set for legacy hashes
+ALTER TABLE users ALTER COLUMN password_salt DROP NOT NULL;
@@ -0,0 +1 @@
+ALTER TABLE users ADD COLUMN password_pepper_id VARCHAR(32);
@@ -0,0 +1,13 @@
+-- Previous passwords of the users, which cannot be reused. They are
+-- identified so the ones changed at the same time are kept in order and
+-- pruned one by one.
+CREATE TABLE password_history (
+  id BIGSERIAL PRIMARY KEY,
+  user_id BYTEA NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  password_hash BYTEA NOT NULL,
+  password_salt BYTEA,
+  password_pepper_id VARCHAR(32),
+  created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX password_history_user_id_created_at_idx ON password_history (user_id, created_at);
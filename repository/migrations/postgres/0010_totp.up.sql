@@ -0,0 +1,26 @@
+-- TOTP secrets, enforced at login once confirmed
+CREATE TABLE user_totp (
+  user_id BYTEA PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+  secret BYTEA NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL,
+  confirmed_at TIMESTAMPTZ,
+  last_used_step BIGINT NOT NULL DEFAULT 0
+);
+
+CREATE TABLE totp_recovery_codes (
+  user_id BYTEA NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  code_hash BYTEA NOT NULL,
+  used_at TIMESTAMPTZ,
+  PRIMARY KEY (user_id, code_hash)
+);
+
+-- Pending second steps of logins
+CREATE TABLE mfa_challenges (
+  id BYTEA PRIMARY KEY,
+  user_id BYTEA NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  token_hash BYTEA UNIQUE NOT NULL,
+  issued_at TIMESTAMPTZ NOT NULL,
+  expires_at TIMESTAMPTZ NOT NULL,
+  attempts INTEGER NOT NULL DEFAULT 0,
+  consumed_at TIMESTAMPTZ
+);
@@ -0,0 +1,20 @@
+-- WebAuthn credentials allowing passwordless logins
+CREATE TABLE passkeys (
+  id BYTEA PRIMARY KEY,
+  user_id BYTEA NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  public_key BYTEA NOT NULL,
+  sign_count BIGINT NOT NULL DEFAULT 0,
+  created_at TIMESTAMPTZ NOT NULL,
+  last_used_at TIMESTAMPTZ
+);
+
+CREATE INDEX passkeys_user_id_idx ON passkeys (user_id);
+
+-- Pending WebAuthn ceremonies, keyed by their challenge
+CREATE TABLE webauthn_sessions (
+  challenge BYTEA PRIMARY KEY,
+  ceremony VARCHAR(16) NOT NULL,
+  user_id BYTEA REFERENCES users (id) ON DELETE CASCADE,
+  issued_at TIMESTAMPTZ NOT NULL,
+  expires_at TIMESTAMPTZ NOT NULL
+);
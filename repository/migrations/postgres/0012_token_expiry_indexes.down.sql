@@ -0,0 +1,2 @@
+DROP INDEX revoked_access_tokens_expires_at_idx;
+DROP INDEX refresh_tokens_expires_at_idx;
@@ -0,0 +1,12 @@
+DROP TABLE webauthn_sessions;
+DROP TABLE passkeys;
+DROP TABLE mfa_challenges;
+DROP TABLE totp_recovery_codes;
+DROP TABLE user_totp;
+DROP TABLE password_history;
+DROP TABLE login_failures;
+DROP TABLE one_time_passwords;
+DROP TABLE user_token_revocations;
+DROP TABLE revoked_access_tokens;
+DROP TABLE refresh_tokens;
+DROP TABLE users;
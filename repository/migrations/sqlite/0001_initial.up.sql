@@ -0,0 +1,114 @@
+CREATE TABLE users (
+  id BLOB PRIMARY KEY,
+  phone_number VARCHAR(13) UNIQUE NOT NULL,
+  full_name VARCHAR(60) NOT NULL,
+  password_hash BLOB NOT NULL,
+  password_salt BLOB,
+  password_pepper_id VARCHAR(32),
+  phone_verified_at TIMESTAMP,
+  pending_phone_number VARCHAR(13)
+);
+
+CREATE TABLE refresh_tokens (
+  id BLOB PRIMARY KEY,
+  family_id BLOB NOT NULL,
+  user_id BLOB NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  token_hash BLOB UNIQUE NOT NULL,
+  issued_at TIMESTAMP NOT NULL,
+  expires_at TIMESTAMP NOT NULL,
+  used_at TIMESTAMP,
+  revoked_at TIMESTAMP
+);
+
+CREATE INDEX refresh_tokens_family_id_idx ON refresh_tokens (family_id);
+
+CREATE TABLE revoked_access_tokens (
+  jti VARCHAR(20) PRIMARY KEY,
+  user_id BLOB NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  expires_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE user_token_revocations (
+  user_id BLOB PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+  issued_before TIMESTAMP NOT NULL
+);
+
+CREATE TABLE one_time_passwords (
+  id BLOB PRIMARY KEY,
+  purpose VARCHAR(32) NOT NULL,
+  phone_number VARCHAR(13) NOT NULL,
+  code_hash BLOB NOT NULL,
+  issued_at TIMESTAMP NOT NULL,
+  expires_at TIMESTAMP NOT NULL,
+  attempts INTEGER NOT NULL DEFAULT 0,
+  consumed_at TIMESTAMP,
+  send_count INTEGER NOT NULL DEFAULT 1,
+  send_window_start TIMESTAMP NOT NULL,
+  UNIQUE (purpose, phone_number)
+);
+
+-- Consecutive failed logins, keyed by "user:<id>" or "ip:<address>"
+CREATE TABLE login_failures (
+  key VARCHAR(64) PRIMARY KEY,
+  failures INTEGER NOT NULL,
+  last_failure_at TIMESTAMP NOT NULL,
+  locked_until TIMESTAMP
+);
+
+-- Previous passwords of the users, which cannot be reused
+CREATE TABLE password_history (
+  user_id BLOB NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  password_hash BLOB NOT NULL,
+  password_salt BLOB,
+  password_pepper_id VARCHAR(32),
+  created_at TIMESTAMP NOT NULL,
+  PRIMARY KEY (user_id, created_at)
+);
+
+-- TOTP secrets, enforced at login once confirmed
+CREATE TABLE user_totp (
+  user_id BLOB PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+  secret BLOB NOT NULL,
+  created_at TIMESTAMP NOT NULL,
+  confirmed_at TIMESTAMP,
+  last_used_step INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE totp_recovery_codes (
+  user_id BLOB NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  code_hash BLOB NOT NULL,
+  used_at TIMESTAMP,
+  PRIMARY KEY (user_id, code_hash)
+);
+
+-- Pending second steps of logins
+CREATE TABLE mfa_challenges (
+  id BLOB PRIMARY KEY,
+  user_id BLOB NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  token_hash BLOB UNIQUE NOT NULL,
+  issued_at TIMESTAMP NOT NULL,
+  expires_at TIMESTAMP NOT NULL,
+  attempts INTEGER NOT NULL DEFAULT 0,
+  consumed_at TIMESTAMP
+);
+
+-- WebAuthn credentials allowing passwordless logins
+CREATE TABLE passkeys (
+  id BLOB PRIMARY KEY,
+  user_id BLOB NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  public_key BLOB NOT NULL,
+  sign_count INTEGER NOT NULL DEFAULT 0,
+  created_at TIMESTAMP NOT NULL,
+  last_used_at TIMESTAMP
+);
+
+CREATE INDEX passkeys_user_id_idx ON passkeys (user_id);
+
+-- Pending WebAuthn ceremonies, keyed by their challenge
+CREATE TABLE webauthn_sessions (
+  challenge BLOB PRIMARY KEY,
+  ceremony VARCHAR(16) NOT NULL,
+  user_id BLOB REFERENCES users (id) ON DELETE CASCADE,
+  issued_at TIMESTAMP NOT NULL,
+  expires_at TIMESTAMP NOT NULL
+);
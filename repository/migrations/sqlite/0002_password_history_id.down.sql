@@ -0,0 +1,15 @@
+CREATE TABLE password_history_old (
+  user_id BLOB NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  password_hash BLOB NOT NULL,
+  password_salt BLOB,
+  password_pepper_id VARCHAR(32),
+  created_at TIMESTAMP NOT NULL,
+  PRIMARY KEY (user_id, created_at)
+);
+
+-- Keep the most recent of the passwords changed at the same time
+INSERT OR REPLACE INTO password_history_old (user_id, password_hash, password_salt, password_pepper_id, created_at)
+  SELECT user_id, password_hash, password_salt, password_pepper_id, created_at FROM password_history ORDER BY id;
+
+DROP TABLE password_history;
+ALTER TABLE password_history_old RENAME TO password_history;
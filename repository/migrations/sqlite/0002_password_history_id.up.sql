@@ -0,0 +1,18 @@
+-- Identify the previous passwords, so the ones changed at the same time are
+-- kept in order and pruned one by one. SQLite cannot change a primary key,
+-- the table is rebuilt.
+CREATE TABLE password_history_new (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  user_id BLOB NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  password_hash BLOB NOT NULL,
+  password_salt BLOB,
+  password_pepper_id VARCHAR(32),
+  created_at TIMESTAMP NOT NULL
+);
+
+INSERT INTO password_history_new (user_id, password_hash, password_salt, password_pepper_id, created_at)
+  SELECT user_id, password_hash, password_salt, password_pepper_id, created_at FROM password_history ORDER BY created_at;
+
+DROP TABLE password_history;
+ALTER TABLE password_history_new RENAME TO password_history;
+CREATE INDEX password_history_user_id_created_at_idx ON password_history (user_id, created_at);
@@ -0,0 +1,3 @@
+-- Expired tokens are deleted on every new one
+CREATE INDEX refresh_tokens_expires_at_idx ON refresh_tokens (expires_at);
+CREATE INDEX revoked_access_tokens_expires_at_idx ON revoked_access_tokens (expires_at);
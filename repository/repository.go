@@ -2,25 +2,167 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
 )
 
+// MemoryDsn selects the in-memory storage, which needs no database but keeps
+// nothing across restarts.
+const MemoryDsn = "memory:"
+
+const (
+	// DefaultConnectTimeout is how long a starting instance waits for the
+	// database.
+	DefaultConnectTimeout = 30 * time.Second
+	minConnectBackoff     = 100 * time.Millisecond
+	maxConnectBackoff     = 5 * time.Second
+)
+
+// Repository queries PostgreSQL through database/sql on top of the pgx pool,
+// which still applies the settings of the pool and maps the errors of pgconn.
+// The queries, migrations and transactions are thus written the same way as
+// the ones of SQLiteRepository.
 type Repository struct {
-	Db *sql.DB
+	Db   *sql.DB
+	pool *pgxpool.Pool
 }
 
+// NewRepositoryOptions configure the storage. The settings of the PostgreSQL
+// pool keep the pgx defaults, or the ones of the DSN such as pool_max_conns,
+// when zero.
 type NewRepositoryOptions struct {
 	Dsn string
+	// MaxConns bounds the connections of the pool.
+	MaxConns int32
+	// MinConns are kept open even when idle.
+	MinConns int32
+	// MaxConnLifetime is how long a connection is used before being replaced,
+	// e.g. so the connections spread over the replicas behind a load balancer.
+	MaxConnLifetime time.Duration
+	// StatementCacheCapacity is how many prepared statements every connection
+	// keeps, none are when negative, as some connection poolers require.
+	StatementCacheCapacity int
+	// ConnectTimeout is how long the database is waited for,
+	// DefaultConnectTimeout when zero.
+	ConnectTimeout time.Duration
 }
 
-func NewRepository(opts NewRepositoryOptions) *Repository {
-	db, err := sql.Open("postgres", opts.Dsn)
+// NewRepository connects to the PostgreSQL database of the DSN. The database
+// is pinged with exponential back-off until it answers, so the service can
+// start along with it.
+func NewRepository(ctx context.Context, opts NewRepositoryOptions) (*Repository, error) {
+	config, err := poolConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	pool, err := pgxpool.NewWithConfig(ctx, config)
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+
+	connectTimeout := opts.ConnectTimeout
+	if connectTimeout <= 0 {
+		connectTimeout = DefaultConnectTimeout
 	}
+
+	err = ping(ctx, pool, connectTimeout)
+	if err != nil {
+		pool.Close()
+		return nil, err
+	}
+
 	return &Repository{
-		Db: db,
+		Db:   stdlib.OpenDBFromPool(pool),
+		pool: pool,
+	}, nil
+}
+
+func poolConfig(opts NewRepositoryOptions) (*pgxpool.Config, error) {
+	config, err := pgxpool.ParseConfig(opts.Dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.MaxConns > 0 {
+		config.MaxConns = opts.MaxConns
+	}
+
+	if opts.MinConns > 0 {
+		config.MinConns = opts.MinConns
+	}
+
+	if config.MinConns > config.MaxConns {
+		return nil, fmt.Errorf("%d min connections exceed the %d max connections", config.MinConns, config.MaxConns)
+	}
+
+	if opts.MaxConnLifetime > 0 {
+		config.MaxConnLifetime = opts.MaxConnLifetime
+	}
+
+	switch {
+	case opts.StatementCacheCapacity < 0:
+		config.ConnConfig.DefaultQueryExecMode = pgx.QueryExecModeDescribeExec
+	case opts.StatementCacheCapacity > 0:
+		config.ConnConfig.StatementCacheCapacity = opts.StatementCacheCapacity
+	}
+
+	return config, nil
+}
+
+func ping(ctx context.Context, pool *pgxpool.Pool, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	backoff := minConnectBackoff
+	for {
+		err := pool.Ping(ctx)
+		if err == nil {
+			return nil
+		}
+
+		if ctx.Err() != nil {
+			return fmt.Errorf("database not ready after %v: %w", timeout, err)
+		}
+
+		log.Printf("database not ready, retrying in %v: %v", backoff, err)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("database not ready after %v: %w", timeout, err)
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > maxConnectBackoff {
+			backoff = maxConnectBackoff
+		}
+	}
+}
+
+// Close closes the connections to the database.
+func (r *Repository) Close() error {
+	err := r.Db.Close()
+	r.pool.Close()
+	return err
+}
+
+// Open returns the storage of the DSN: the in-memory one for MemoryDsn, SQLite
+// for the DSN starting with SQLiteScheme and PostgreSQL otherwise.
+func Open(ctx context.Context, opts NewRepositoryOptions) (Storage, error) {
+	switch {
+	case opts.Dsn == MemoryDsn:
+		return NewMemoryRepository(), nil
+	case strings.HasPrefix(opts.Dsn, SQLiteScheme):
+		return NewSQLiteRepository(opts)
+	default:
+		return NewRepository(ctx, opts)
 	}
 }
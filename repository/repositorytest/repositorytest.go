@@ -0,0 +1,678 @@
+// Package repositorytest is the conformance suite of the storage backends,
+// checking they behave the way the services expect, whatever the database.
+package repositorytest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/SawitProRecruitment/UserService/handler/model/mfa"
+	"github.com/SawitProRecruitment/UserService/handler/model/otp"
+	"github.com/SawitProRecruitment/UserService/handler/model/token"
+	"github.com/SawitProRecruitment/UserService/handler/model/user"
+	"github.com/SawitProRecruitment/UserService/handler/model/webauthn"
+	"github.com/SawitProRecruitment/UserService/repository"
+	"github.com/rs/xid"
+)
+
+// Run runs the suite, newStorage returns an empty storage for every test.
+func Run(t *testing.T, newStorage func(t *testing.T) repository.Storage) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, s repository.Storage)
+	}{
+		{"Users", testUsers},
+		{"RefreshTokens", testRefreshTokens},
+		{"ConcurrentRefreshTokenRotation", testConcurrentRefreshTokenRotation},
+		{"TokenRevocations", testTokenRevocations},
+		{"OTPs", testOTPs},
+		{"LoginFailures", testLoginFailures},
+		{"PasswordHistory", testPasswordHistory},
+		{"TOTP", testTOTP},
+		{"MFAChallenges", testMFAChallenges},
+		{"Passkeys", testPasskeys},
+		{"WebAuthnSessions", testWebAuthnSessions},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newStorage(t))
+		})
+	}
+}
+
+// now is truncated to the precision of the databases.
+func now() time.Time {
+	return time.Now().Truncate(time.Microsecond)
+}
+
+func newUser(t *testing.T, phoneNumber string) *user.User {
+	t.Helper()
+
+	u, err := user.New(xid.New().String(), phoneNumber, "Jane Doe", []byte("$2a$04$2p2Yz0CDyVhN0dNgJd6Tq.eXy4n1xOoVgn2JgVb0mGZ8k5JrPFvXO"), nil, "", time.Time{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return u
+}
+
+func storeUser(t *testing.T, s repository.Storage, phoneNumber string) *user.User {
+	t.Helper()
+
+	u := newUser(t, phoneNumber)
+	if err := s.Store(context.Background(), u); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+
+	return u
+}
+
+func check(t *testing.T, err error) {
+	t.Helper()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkApplied(t *testing.T, method string, ok bool, err error, want bool) {
+	t.Helper()
+
+	if err != nil {
+		t.Fatalf("%s: %v", method, err)
+	}
+
+	if ok != want {
+		t.Fatalf("%s = %v, want %v", method, ok, want)
+	}
+}
+
+func testUsers(t *testing.T, s repository.Storage) {
+	ctx := context.Background()
+
+	// Given
+	u := storeUser(t, s, "+628123456789")
+
+	// When / Then
+	got, err := s.GetByID(ctx, u.ID())
+	check(t, err)
+	if got == nil || got.PhoneNumber() != u.PhoneNumber() || got.FullName() != u.FullName() {
+		t.Fatalf("GetByID = %+v, want the stored user", got)
+	}
+
+	got, err = s.GetByPhoneNumber(ctx, u.PhoneNumber())
+	check(t, err)
+	if got == nil || got.ID() != u.ID() {
+		t.Fatalf("GetByPhoneNumber = %+v, want the stored user", got)
+	}
+
+	got, err = s.GetByID(ctx, xid.New().String())
+	if got != nil || err != nil {
+		t.Fatalf("GetByID of an unknown id = %v, %v, want nil, nil", got, err)
+	}
+
+	got, err = s.GetByPhoneNumber(ctx, "+628000000000")
+	if got != nil || err != nil {
+		t.Fatalf("GetByPhoneNumber of an unknown number = %v, %v, want nil, nil", got, err)
+	}
+
+	err = s.Store(ctx, newUser(t, u.PhoneNumber()))
+	if !errors.Is(err, repository.ErrUniqueViolation) {
+		t.Fatalf("Store of a taken phone number = %v, want ErrUniqueViolation", err)
+	}
+
+	err = s.Store(ctx, u)
+	if !errors.Is(err, repository.ErrUniqueViolation) {
+		t.Fatalf("Store of a stored user = %v, want ErrUniqueViolation", err)
+	}
+
+	// Changes are kept once updated only
+	check(t, u.ChangeFullName("John Doe"))
+	got, err = s.GetByID(ctx, u.ID())
+	check(t, err)
+	if got.FullName() != "Jane Doe" {
+		t.Fatalf("full name = %q before Update, want %q", got.FullName(), "Jane Doe")
+	}
+
+	verifiedAt := now()
+	check(t, u.ConfirmPhoneNumber(verifiedAt))
+	check(t, s.Update(ctx, u))
+	got, err = s.GetByID(ctx, u.ID())
+	check(t, err)
+	if got.FullName() != "John Doe" || !got.PhoneVerifiedAt().Equal(verifiedAt) {
+		t.Fatalf("GetByID = %q verified at %v, want %q verified at %v", got.FullName(), got.PhoneVerifiedAt(), "John Doe", verifiedAt)
+	}
+
+	other := storeUser(t, s, "+628987654321")
+	check(t, other.ChangePhoneNumber(u.PhoneNumber()))
+	err = s.Update(ctx, other)
+	if !errors.Is(err, repository.ErrUniqueViolation) {
+		t.Fatalf("Update to a taken phone number = %v, want ErrUniqueViolation", err)
+	}
+
+	err = s.Update(ctx, newUser(t, "+628111111111"))
+	if err == nil {
+		t.Fatal("Update of an unknown user succeeded")
+	}
+}
+
+func newRefreshToken(t *testing.T, familyID, userID string, hash string, issuedAt time.Time) *token.RefreshToken {
+	t.Helper()
+
+	rt, err := token.New(xid.New().String(), familyID, userID, []byte(hash), issuedAt, issuedAt.Add(time.Hour), time.Time{}, time.Time{})
+	check(t, err)
+	return rt
+}
+
+func testRefreshTokens(t *testing.T, s repository.Storage) {
+	ctx := context.Background()
+	issuedAt := now()
+
+	// Given
+	u := storeUser(t, s, "+628123456789")
+	family := xid.New().String()
+	first := newRefreshToken(t, family, u.ID(), "first", issuedAt)
+	second := newRefreshToken(t, family, u.ID(), "second", issuedAt)
+	other := newRefreshToken(t, xid.New().String(), u.ID(), "other", issuedAt)
+	for _, rt := range []*token.RefreshToken{first, second, other} {
+		check(t, s.StoreRefreshToken(ctx, rt))
+	}
+
+	// When / Then
+	got, err := s.GetRefreshTokenByHash(ctx, []byte("first"))
+	check(t, err)
+	if got == nil || got.ID() != first.ID() || got.FamilyID() != family || got.UserID() != u.ID() || !got.ExpiresAt().Equal(first.ExpiresAt()) {
+		t.Fatalf("GetRefreshTokenByHash = %+v, want the first token", got)
+	}
+
+	got, err = s.GetRefreshTokenByHash(ctx, []byte("unknown"))
+	if got != nil || err != nil {
+		t.Fatalf("GetRefreshTokenByHash of an unknown hash = %v, %v, want nil, nil", got, err)
+	}
+
+	usedAt := issuedAt.Add(time.Minute)
+	ok, err := s.MarkRefreshTokenUsed(ctx, first.ID(), usedAt)
+	checkApplied(t, "MarkRefreshTokenUsed", ok, err, true)
+
+	ok, err = s.MarkRefreshTokenUsed(ctx, first.ID(), usedAt.Add(time.Minute))
+	checkApplied(t, "MarkRefreshTokenUsed of a used token", ok, err, false)
+
+	got, err = s.GetRefreshTokenByHash(ctx, []byte("first"))
+	check(t, err)
+	if !got.UsedAt().Equal(usedAt) {
+		t.Fatalf("used at %v, want %v", got.UsedAt(), usedAt)
+	}
+
+	revokedAt := issuedAt.Add(2 * time.Minute)
+	check(t, s.RevokeRefreshTokenFamily(ctx, family, revokedAt))
+	for hash, want := range map[string]time.Time{"first": revokedAt, "second": revokedAt, "other": {}} {
+		got, err = s.GetRefreshTokenByHash(ctx, []byte(hash))
+		check(t, err)
+		if !got.RevokedAt().Equal(want) {
+			t.Fatalf("%s token revoked at %v, want %v", hash, got.RevokedAt(), want)
+		}
+	}
+
+	// Revoked tokens keep their revocation time
+	check(t, s.RevokeUserRefreshTokens(ctx, u.ID(), revokedAt.Add(time.Minute)))
+	for hash, want := range map[string]time.Time{"first": revokedAt, "other": revokedAt.Add(time.Minute)} {
+		got, err = s.GetRefreshTokenByHash(ctx, []byte(hash))
+		check(t, err)
+		if !got.RevokedAt().Equal(want) {
+			t.Fatalf("%s token revoked at %v, want %v", hash, got.RevokedAt(), want)
+		}
+	}
+}
+
+func testConcurrentRefreshTokenRotation(t *testing.T, s repository.Storage) {
+	ctx := context.Background()
+
+	// Given
+	u := storeUser(t, s, "+628123456789")
+	rt := newRefreshToken(t, xid.New().String(), u.ID(), "token", now())
+	check(t, s.StoreRefreshToken(ctx, rt))
+
+	// When
+	const rotations = 8
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		used int
+	)
+
+	for i := 0; i < rotations; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			ok, err := s.MarkRefreshTokenUsed(ctx, rt.ID(), now())
+			if err != nil {
+				t.Error(err)
+			}
+
+			if ok {
+				mu.Lock()
+				used++
+				mu.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	// Then
+	if used != 1 {
+		t.Fatalf("token marked used %d times, want once", used)
+	}
+}
+
+func testTokenRevocations(t *testing.T, s repository.Storage) {
+	ctx := context.Background()
+	issuedAt := now()
+
+	// Given
+	u := storeUser(t, s, "+628123456789")
+
+	// When / Then
+	revoked, err := s.AccessTokenRevoked(ctx, "jti", u.ID(), issuedAt)
+	checkApplied(t, "AccessTokenRevoked", revoked, err, false)
+
+	check(t, s.RevokeAccessToken(ctx, "jti", u.ID(), issuedAt.Add(time.Hour)))
+	check(t, s.RevokeAccessToken(ctx, "jti", u.ID(), issuedAt.Add(time.Hour)))
+	revoked, err = s.AccessTokenRevoked(ctx, "jti", u.ID(), issuedAt)
+	checkApplied(t, "AccessTokenRevoked of a revoked token", revoked, err, true)
+
+	revoked, err = s.AccessTokenRevoked(ctx, "other", u.ID(), issuedAt)
+	checkApplied(t, "AccessTokenRevoked of another token", revoked, err, false)
+
+	// The latest revocation of the user wins
+	check(t, s.RevokeUserAccessTokens(ctx, u.ID(), issuedAt.Add(time.Minute)))
+	check(t, s.RevokeUserAccessTokens(ctx, u.ID(), issuedAt))
+	revoked, err = s.AccessTokenRevoked(ctx, "other", u.ID(), issuedAt)
+	checkApplied(t, "AccessTokenRevoked of a token issued before", revoked, err, true)
+
+	revoked, err = s.AccessTokenRevoked(ctx, "other", u.ID(), issuedAt.Add(time.Minute))
+	checkApplied(t, "AccessTokenRevoked of a token issued after", revoked, err, false)
+
+	// Tokens issued within the second before the revocation are revoked
+	second := issuedAt.Truncate(time.Second).Add(time.Hour)
+	check(t, s.RevokeUserAccessTokens(ctx, u.ID(), second.Add(time.Second)))
+	revoked, err = s.AccessTokenRevoked(ctx, "other", u.ID(), second)
+	checkApplied(t, "AccessTokenRevoked of a token issued the same second", revoked, err, true)
+
+	revoked, err = s.AccessTokenRevoked(ctx, "other", u.ID(), second.Add(time.Second))
+	checkApplied(t, "AccessTokenRevoked of a token issued the next second", revoked, err, false)
+}
+
+func newOTP(t *testing.T, phoneNumber, codeHash string, issuedAt time.Time) *otp.OTP {
+	t.Helper()
+
+	o, err := otp.New(xid.New().String(), otp.PurposeLogin, phoneNumber, []byte(codeHash), issuedAt, issuedAt.Add(5*time.Minute), 0, time.Time{}, 1, issuedAt)
+	check(t, err)
+	return o
+}
+
+func testOTPs(t *testing.T, s repository.Storage) {
+	ctx := context.Background()
+	issuedAt := now()
+
+	// Given
+	first := newOTP(t, "+628123456789", "first", issuedAt)
+	check(t, s.StoreOTP(ctx, first))
+
+	// When / Then
+	got, err := s.GetOTP(ctx, otp.PurposeLogin, "+628123456789")
+	check(t, err)
+	if got == nil || got.ID() != first.ID() || !bytes.Equal(got.CodeHash(), []byte("first")) || got.SendCount() != 1 || !got.SendWindowStart().Equal(issuedAt) {
+		t.Fatalf("GetOTP = %+v, want the stored code", got)
+	}
+
+	got, err = s.GetOTP(ctx, otp.PurposePasswordReset, "+628123456789")
+	if got != nil || err != nil {
+		t.Fatalf("GetOTP of another purpose = %v, %v, want nil, nil", got, err)
+	}
+
+	for want := 1; want <= 2; want++ {
+		attempts, err := s.IncrementOTPAttempts(ctx, first.ID())
+		check(t, err)
+		if attempts != want {
+			t.Fatalf("IncrementOTPAttempts = %d, want %d", attempts, want)
+		}
+	}
+
+	_, err = s.IncrementOTPAttempts(ctx, xid.New().String())
+	if err == nil {
+		t.Fatal("IncrementOTPAttempts of an unknown code succeeded")
+	}
+
+	ok, err := s.ConsumeOTP(ctx, first.ID(), issuedAt.Add(time.Minute))
+	checkApplied(t, "ConsumeOTP", ok, err, true)
+
+	ok, err = s.ConsumeOTP(ctx, first.ID(), issuedAt.Add(time.Minute))
+	checkApplied(t, "ConsumeOTP of a consumed code", ok, err, false)
+
+	// A new code replaces the consumed one
+	second := newOTP(t, "+628123456789", "second", issuedAt.Add(time.Minute))
+	check(t, s.StoreOTP(ctx, second))
+	got, err = s.GetOTP(ctx, otp.PurposeLogin, "+628123456789")
+	check(t, err)
+	if got.ID() != second.ID() || got.Attempts() != 0 || got.Consumed() {
+		t.Fatalf("GetOTP = %+v, want the unused second code", got)
+	}
+}
+
+func testLoginFailures(t *testing.T, s repository.Storage) {
+	ctx := context.Background()
+	failedAt := now()
+
+	// Given
+	const key = "ip:192.0.2.1"
+
+	// When / Then
+	c, err := s.GetLoginFailures(ctx, key)
+	if c != nil || err != nil {
+		t.Fatalf("GetLoginFailures of an unknown key = %v, %v, want nil, nil", c, err)
+	}
+
+	for want := 1; want <= 2; want++ {
+		c, err = s.IncrementLoginFailures(ctx, key, failedAt, failedAt.Add(-time.Hour))
+		check(t, err)
+		if c.Failures() != want {
+			t.Fatalf("IncrementLoginFailures = %d failures, want %d", c.Failures(), want)
+		}
+	}
+
+	lockedUntil := failedAt.Add(time.Minute)
+	check(t, s.LockLogin(ctx, key, lockedUntil))
+	c, err = s.IncrementLoginFailures(ctx, key, failedAt, failedAt.Add(-time.Hour))
+	check(t, err)
+	if c.Failures() != 3 || !c.LockedUntil().Equal(lockedUntil) {
+		t.Fatalf("IncrementLoginFailures = %d failures locked until %v, want 3 locked until %v", c.Failures(), c.LockedUntil(), lockedUntil)
+	}
+
+	c, err = s.GetLoginFailures(ctx, key)
+	check(t, err)
+	if c.Failures() != 3 || !c.LastFailureAt().Equal(failedAt) || !c.LockedUntil().Equal(lockedUntil) {
+		t.Fatalf("GetLoginFailures = %+v, want 3 failures locked until %v", c, lockedUntil)
+	}
+
+	// Failures before resetBefore are forgotten along with the lock
+	later := failedAt.Add(time.Hour)
+	c, err = s.IncrementLoginFailures(ctx, key, later, later.Add(-time.Minute))
+	check(t, err)
+	if c.Failures() != 1 || !c.LockedUntil().IsZero() {
+		t.Fatalf("IncrementLoginFailures = %d failures locked until %v, want 1 unlocked", c.Failures(), c.LockedUntil())
+	}
+
+	check(t, s.ResetLoginFailures(ctx, key))
+	c, err = s.GetLoginFailures(ctx, key)
+	if c != nil || err != nil {
+		t.Fatalf("GetLoginFailures after reset = %v, %v, want nil, nil", c, err)
+	}
+}
+
+func testPasswordHistory(t *testing.T, s repository.Storage) {
+	ctx := context.Background()
+	createdAt := now()
+
+	// Given
+	u := storeUser(t, s, "+628123456789")
+	var changed *user.User
+	// The last two passwords were changed at the same time
+	for i, hash := range []string{"$first", "$second", "$third"} {
+		at := createdAt
+		if i > 0 {
+			at = createdAt.Add(time.Minute)
+		}
+
+		pr, err := user.NewPasswordRecord([]byte(hash), nil, "", at)
+		check(t, err)
+
+		changed, err = user.New(u.ID(), u.PhoneNumber(), u.FullName(), []byte(hash+"-next"), nil, "", time.Time{}, "")
+		check(t, err)
+		check(t, s.UpdatePassword(ctx, changed, pr, 2))
+	}
+
+	// When
+	history, err := s.GetPasswordHistory(ctx, u.ID(), 5)
+	check(t, err)
+
+	// Then
+	if len(history) != 2 || string(history[0].Hash()) != "$third" || string(history[1].Hash()) != "$second" {
+		t.Fatalf("GetPasswordHistory = %d records, want the last 2 newest first", len(history))
+	}
+
+	if !history[0].CreatedAt().Equal(createdAt.Add(time.Minute)) {
+		t.Fatalf("created at %v, want %v", history[0].CreatedAt(), createdAt.Add(time.Minute))
+	}
+
+	got, err := s.GetByID(ctx, u.ID())
+	check(t, err)
+	if pwdHash, _ := got.Password(); string(pwdHash) != "$third-next" {
+		t.Fatalf("GetByID = password %q, want the updated one", pwdHash)
+	}
+
+	history, err = s.GetPasswordHistory(ctx, u.ID(), 1)
+	check(t, err)
+	if len(history) != 1 || string(history[0].Hash()) != "$third" {
+		t.Fatalf("GetPasswordHistory limited to 1 = %d records, want the newest", len(history))
+	}
+
+	history, err = s.GetPasswordHistory(ctx, xid.New().String(), 5)
+	if len(history) != 0 || err != nil {
+		t.Fatalf("GetPasswordHistory of another user = %d records, %v, want none", len(history), err)
+	}
+
+	// A failed update leaves the history as is
+	other := storeUser(t, s, "+628123456780")
+	taken, err := user.New(other.ID(), u.PhoneNumber(), other.FullName(), []byte("$other-next"), nil, "", time.Time{}, "")
+	check(t, err)
+	pr, err := user.NewPasswordRecord([]byte("$other"), nil, "", createdAt)
+	check(t, err)
+	if err := s.UpdatePassword(ctx, taken, pr, 2); !errors.Is(err, repository.ErrUniqueViolation) {
+		t.Fatalf("UpdatePassword to a taken phone number = %v, want ErrUniqueViolation", err)
+	}
+
+	history, err = s.GetPasswordHistory(ctx, other.ID(), 5)
+	if len(history) != 0 || err != nil {
+		t.Fatalf("GetPasswordHistory after a failed update = %d records, %v, want none", len(history), err)
+	}
+
+	if err := s.UpdatePassword(ctx, newUser(t, "+628123456781"), pr, 2); err == nil {
+		t.Fatal("UpdatePassword of an unknown user = nil, want an error")
+	}
+}
+
+func testTOTP(t *testing.T, s repository.Storage) {
+	ctx := context.Background()
+	createdAt := now()
+
+	// Given
+	u := storeUser(t, s, "+628123456789")
+
+	// When / Then
+	got, err := s.GetTOTP(ctx, u.ID())
+	if got != nil || err != nil {
+		t.Fatalf("GetTOTP without secret = %v, %v, want nil, nil", got, err)
+	}
+
+	secret, err := mfa.NewTOTP(u.ID(), []byte("12345678901234567890"), createdAt, time.Time{}, 0)
+	check(t, err)
+	check(t, s.StoreTOTP(ctx, secret))
+
+	got, err = s.GetTOTP(ctx, u.ID())
+	check(t, err)
+	if got == nil || !bytes.Equal(got.Secret(), secret.Secret()) || got.Confirmed() {
+		t.Fatalf("GetTOTP = %+v, want the unconfirmed secret", got)
+	}
+
+	confirmedAt := createdAt.Add(time.Minute)
+	ok, err := s.ConfirmTOTP(ctx, u.ID(), confirmedAt, 100, [][]byte{[]byte("code-1"), []byte("code-2")})
+	checkApplied(t, "ConfirmTOTP", ok, err, true)
+
+	ok, err = s.ConfirmTOTP(ctx, u.ID(), confirmedAt, 101, [][]byte{[]byte("code-3")})
+	checkApplied(t, "ConfirmTOTP of a confirmed secret", ok, err, false)
+
+	got, err = s.GetTOTP(ctx, u.ID())
+	check(t, err)
+	if !got.ConfirmedAt().Equal(confirmedAt) || got.LastUsedStep() != 100 {
+		t.Fatalf("GetTOTP = confirmed at %v step %d, want %v step 100", got.ConfirmedAt(), got.LastUsedStep(), confirmedAt)
+	}
+
+	ok, err = s.UseTOTPStep(ctx, u.ID(), 100)
+	checkApplied(t, "UseTOTPStep of the used step", ok, err, false)
+
+	ok, err = s.UseTOTPStep(ctx, u.ID(), 101)
+	checkApplied(t, "UseTOTPStep of the next step", ok, err, true)
+
+	ok, err = s.UseRecoveryCode(ctx, u.ID(), []byte("code-1"), confirmedAt)
+	checkApplied(t, "UseRecoveryCode", ok, err, true)
+
+	ok, err = s.UseRecoveryCode(ctx, u.ID(), []byte("code-1"), confirmedAt)
+	checkApplied(t, "UseRecoveryCode of a used code", ok, err, false)
+
+	ok, err = s.UseRecoveryCode(ctx, u.ID(), []byte("code-3"), confirmedAt)
+	checkApplied(t, "UseRecoveryCode of an unknown code", ok, err, false)
+
+	check(t, s.DeleteTOTP(ctx, u.ID()))
+	got, err = s.GetTOTP(ctx, u.ID())
+	if got != nil || err != nil {
+		t.Fatalf("GetTOTP after delete = %v, %v, want nil, nil", got, err)
+	}
+
+	ok, err = s.UseRecoveryCode(ctx, u.ID(), []byte("code-2"), confirmedAt)
+	checkApplied(t, "UseRecoveryCode after delete", ok, err, false)
+}
+
+func testMFAChallenges(t *testing.T, s repository.Storage) {
+	ctx := context.Background()
+	issuedAt := now()
+
+	// Given
+	u := storeUser(t, s, "+628123456789")
+	c, err := mfa.NewChallenge(xid.New().String(), u.ID(), []byte("challenge"), issuedAt, issuedAt.Add(5*time.Minute), 0, time.Time{})
+	check(t, err)
+	check(t, s.StoreMFAChallenge(ctx, c))
+
+	// When / Then
+	got, err := s.GetMFAChallengeByHash(ctx, []byte("challenge"))
+	check(t, err)
+	if got == nil || got.ID() != c.ID() || got.UserID() != u.ID() || !got.ExpiresAt().Equal(c.ExpiresAt()) {
+		t.Fatalf("GetMFAChallengeByHash = %+v, want the stored challenge", got)
+	}
+
+	got, err = s.GetMFAChallengeByHash(ctx, []byte("unknown"))
+	if got != nil || err != nil {
+		t.Fatalf("GetMFAChallengeByHash of an unknown hash = %v, %v, want nil, nil", got, err)
+	}
+
+	for want := 1; want <= 2; want++ {
+		attempts, err := s.IncrementMFAChallengeAttempts(ctx, c.ID())
+		check(t, err)
+		if attempts != want {
+			t.Fatalf("IncrementMFAChallengeAttempts = %d, want %d", attempts, want)
+		}
+	}
+
+	_, err = s.IncrementMFAChallengeAttempts(ctx, xid.New().String())
+	if err == nil {
+		t.Fatal("IncrementMFAChallengeAttempts of an unknown challenge succeeded")
+	}
+
+	ok, err := s.ConsumeMFAChallenge(ctx, c.ID(), issuedAt.Add(time.Minute))
+	checkApplied(t, "ConsumeMFAChallenge", ok, err, true)
+
+	ok, err = s.ConsumeMFAChallenge(ctx, c.ID(), issuedAt.Add(time.Minute))
+	checkApplied(t, "ConsumeMFAChallenge of an answered challenge", ok, err, false)
+}
+
+func testPasskeys(t *testing.T, s repository.Storage) {
+	ctx := context.Background()
+	createdAt := now()
+
+	// Given
+	u := storeUser(t, s, "+628123456789")
+	second, err := webauthn.NewCredential([]byte("second"), u.ID(), []byte("public-key"), 0, createdAt.Add(time.Minute), time.Time{})
+	check(t, err)
+	first, err := webauthn.NewCredential([]byte("first"), u.ID(), []byte("public-key"), 5, createdAt, time.Time{})
+	check(t, err)
+	check(t, s.StorePasskey(ctx, second))
+	check(t, s.StorePasskey(ctx, first))
+
+	// When / Then
+	err = s.StorePasskey(ctx, first)
+	if !errors.Is(err, repository.ErrUniqueViolation) {
+		t.Fatalf("StorePasskey of a registered credential = %v, want ErrUniqueViolation", err)
+	}
+
+	got, err := s.GetPasskey(ctx, []byte("first"))
+	check(t, err)
+	if got == nil || got.UserID() != u.ID() || got.SignCount() != 5 || !got.CreatedAt().Equal(createdAt) {
+		t.Fatalf("GetPasskey = %+v, want the first credential", got)
+	}
+
+	got, err = s.GetPasskey(ctx, []byte("unknown"))
+	if got != nil || err != nil {
+		t.Fatalf("GetPasskey of an unknown id = %v, %v, want nil, nil", got, err)
+	}
+
+	creds, err := s.GetUserPasskeys(ctx, u.ID())
+	check(t, err)
+	if len(creds) != 2 || string(creds[0].ID()) != "first" || string(creds[1].ID()) != "second" {
+		t.Fatalf("GetUserPasskeys = %d credentials, want first and second by creation", len(creds))
+	}
+
+	usedAt := createdAt.Add(time.Hour)
+	ok, err := s.UpdatePasskeySignCount(ctx, []byte("first"), 5, usedAt)
+	checkApplied(t, "UpdatePasskeySignCount of the same count", ok, err, false)
+
+	ok, err = s.UpdatePasskeySignCount(ctx, []byte("first"), 6, usedAt)
+	checkApplied(t, "UpdatePasskeySignCount of a higher count", ok, err, true)
+
+	// Authenticators without counter always send 0
+	ok, err = s.UpdatePasskeySignCount(ctx, []byte("second"), 0, usedAt)
+	checkApplied(t, "UpdatePasskeySignCount without counter", ok, err, true)
+
+	got, err = s.GetPasskey(ctx, []byte("first"))
+	check(t, err)
+	if got.SignCount() != 6 || !got.LastUsedAt().Equal(usedAt) {
+		t.Fatalf("GetPasskey = count %d used at %v, want 6 used at %v", got.SignCount(), got.LastUsedAt(), usedAt)
+	}
+}
+
+func testWebAuthnSessions(t *testing.T, s repository.Storage) {
+	ctx := context.Background()
+	issuedAt := now()
+
+	// Given
+	u := storeUser(t, s, "+628123456789")
+	registration, err := webauthn.NewSession([]byte("registration-challenge"), webauthn.CeremonyRegistration, u.ID(), issuedAt, issuedAt.Add(time.Minute))
+	check(t, err)
+	login, err := webauthn.NewSession([]byte("login-challenge-00"), webauthn.CeremonyLogin, "", issuedAt, issuedAt.Add(time.Minute))
+	check(t, err)
+	check(t, s.StoreWebAuthnSession(ctx, registration))
+	check(t, s.StoreWebAuthnSession(ctx, login))
+
+	// When / Then
+	got, err := s.TakeWebAuthnSession(ctx, registration.Challenge())
+	check(t, err)
+	if got == nil || got.Ceremony() != webauthn.CeremonyRegistration || got.UserID() != u.ID() || !got.ExpiresAt().Equal(registration.ExpiresAt()) {
+		t.Fatalf("TakeWebAuthnSession = %+v, want the registration session", got)
+	}
+
+	got, err = s.TakeWebAuthnSession(ctx, registration.Challenge())
+	if got != nil || err != nil {
+		t.Fatalf("TakeWebAuthnSession of a taken challenge = %v, %v, want nil, nil", got, err)
+	}
+
+	got, err = s.TakeWebAuthnSession(ctx, login.Challenge())
+	check(t, err)
+	if got == nil || got.Ceremony() != webauthn.CeremonyLogin || got.UserID() != "" {
+		t.Fatalf("TakeWebAuthnSession = %+v, want the login session without user", got)
+	}
+}
@@ -0,0 +1,1062 @@
+// This file contains the SQLite implementation of the repository layer, for
+// deployments without PostgreSQL. The queries follow the PostgreSQL ones,
+// times being stored as UTC text so they compare in order.
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/SawitProRecruitment/UserService/handler/model/lockout"
+	"github.com/SawitProRecruitment/UserService/handler/model/mfa"
+	"github.com/SawitProRecruitment/UserService/handler/model/otp"
+	"github.com/SawitProRecruitment/UserService/handler/model/token"
+	"github.com/SawitProRecruitment/UserService/handler/model/user"
+	"github.com/SawitProRecruitment/UserService/handler/model/webauthn"
+	"github.com/rs/xid"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// SQLiteScheme prefixes the DSN of SQLite databases, followed by the path of
+// the file, e.g. sqlite:///var/lib/userservice/users.db.
+const SQLiteScheme = "sqlite:"
+
+// sqliteTimeFormat has a fixed width, so the text of the times sorts in
+// order, and the precision of PostgreSQL.
+const sqliteTimeFormat = "2006-01-02T15:04:05.000000Z"
+
+type SQLiteRepository struct {
+	Db *sql.DB
+}
+
+func NewSQLiteRepository(opts NewRepositoryOptions) (*SQLiteRepository, error) {
+	path := strings.TrimPrefix(strings.TrimPrefix(opts.Dsn, SQLiteScheme), "//")
+
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+
+	db, err := sql.Open("sqlite", "file:"+path+sep+"_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, err
+	}
+
+	// SQLite runs one write at a time, a single connection also keeps the
+	// same database for :memory:
+	db.SetMaxOpenConns(1)
+	return &SQLiteRepository{
+		Db: db,
+	}, nil
+}
+
+// Migrations returns the migrations of the SQLite schema.
+func (r *SQLiteRepository) Migrations() ([]Migration, error) {
+	return sqliteSchema.migrations()
+}
+
+func (r *SQLiteRepository) SchemaVersion(ctx context.Context) (int, error) {
+	return sqliteSchema.version(ctx, r.Db)
+}
+
+func (r *SQLiteRepository) CheckSchema(ctx context.Context) error {
+	return sqliteSchema.check(ctx, r.Db)
+}
+
+func (r *SQLiteRepository) MigrateUp(ctx context.Context) error {
+	return sqliteSchema.migrateUp(ctx, r.Db)
+}
+
+func (r *SQLiteRepository) MigrateTo(ctx context.Context, version int) error {
+	return sqliteSchema.migrateTo(ctx, r.Db, version)
+}
+
+func (r *SQLiteRepository) Store(ctx context.Context, u *user.User) error {
+	_id, err := xid.FromString(u.ID())
+	if err != nil {
+		return err
+	}
+
+	pwdHash, pwdSalt := u.Password()
+	_, err = r.Db.ExecContext(ctx, "INSERT INTO users (id, phone_number, full_name, password_hash, password_salt, password_pepper_id, phone_verified_at, pending_phone_number) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)",
+		_id,
+		u.PhoneNumber(),
+		u.FullName(),
+		pwdHash,
+		nullBytes(pwdSalt),
+		nullString(u.PasswordPepperID()),
+		sqliteTime(u.PhoneVerifiedAt()),
+		nullString(u.PendingPhoneNumber()))
+
+	return sqliteError(err)
+}
+
+func (r *SQLiteRepository) GetByID(ctx context.Context, id string) (*user.User, error) {
+	var (
+		phoneNumber        string
+		fullName           string
+		pwdHash            []byte
+		pwdSalt            []byte
+		pwdPepperID        sql.NullString
+		phoneVerifiedAt    sql.NullTime
+		pendingPhoneNumber sql.NullString
+	)
+
+	_id, err := xid.FromString(id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.Db.QueryRowContext(ctx, "SELECT phone_number, full_name, password_hash, password_salt, password_pepper_id, phone_verified_at, pending_phone_number FROM users WHERE id = $1", _id).Scan(
+		&phoneNumber,
+		&fullName,
+		&pwdHash,
+		&pwdSalt,
+		&pwdPepperID,
+		&phoneVerifiedAt,
+		&pendingPhoneNumber)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return user.New(id, phoneNumber, fullName, pwdHash, pwdSalt, pwdPepperID.String, phoneVerifiedAt.Time, pendingPhoneNumber.String)
+}
+
+func (r *SQLiteRepository) GetByPhoneNumber(ctx context.Context, phoneNumber string) (*user.User, error) {
+	var (
+		_id                xid.ID
+		fullName           string
+		pwdHash            []byte
+		pwdSalt            []byte
+		pwdPepperID        sql.NullString
+		phoneVerifiedAt    sql.NullTime
+		pendingPhoneNumber sql.NullString
+	)
+	err := r.Db.QueryRowContext(ctx, "SELECT id, full_name, password_hash, password_salt, password_pepper_id, phone_verified_at, pending_phone_number FROM users WHERE phone_number = $1", phoneNumber).Scan(
+		&_id,
+		&fullName,
+		&pwdHash,
+		&pwdSalt,
+		&pwdPepperID,
+		&phoneVerifiedAt,
+		&pendingPhoneNumber)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return user.New(_id.String(), phoneNumber, fullName, pwdHash, pwdSalt, pwdPepperID.String, phoneVerifiedAt.Time, pendingPhoneNumber.String)
+}
+
+func (r *SQLiteRepository) Update(ctx context.Context, u *user.User) error {
+	return sqliteUpdateUser(ctx, r.Db, u)
+}
+
+// sqliteUpdateUser runs the update of Update on the database or in a
+// transaction.
+func sqliteUpdateUser(ctx context.Context, q queryer, u *user.User) error {
+	_id, err := xid.FromString(u.ID())
+	if err != nil {
+		return err
+	}
+
+	pwdHash, pwdSalt := u.Password()
+	res, err := q.ExecContext(ctx, "UPDATE users SET phone_number = $1, full_name = $2, password_hash = $3, password_salt = $4, password_pepper_id = $5, phone_verified_at = $6, pending_phone_number = $7 WHERE id = $8",
+		u.PhoneNumber(),
+		u.FullName(),
+		pwdHash,
+		nullBytes(pwdSalt),
+		nullString(u.PasswordPepperID()),
+		sqliteTime(u.PhoneVerifiedAt()),
+		nullString(u.PendingPhoneNumber()),
+		_id)
+	if err != nil {
+		return sqliteError(err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return errors.New("no rows affected")
+	}
+
+	return nil
+}
+
+func (r *SQLiteRepository) StoreRefreshToken(ctx context.Context, rt *token.RefreshToken) error {
+	_id, err := xid.FromString(rt.ID())
+	if err != nil {
+		return err
+	}
+
+	_familyID, err := xid.FromString(rt.FamilyID())
+	if err != nil {
+		return err
+	}
+
+	_userID, err := xid.FromString(rt.UserID())
+	if err != nil {
+		return err
+	}
+
+	// Tokens are never updated once expired, they are deleted a while later
+	_, err = r.Db.ExecContext(ctx, "DELETE FROM refresh_tokens WHERE expires_at < $1", sqliteTime(rt.IssuedAt().Add(-expiredTokenRetention)))
+	if err != nil {
+		return err
+	}
+
+	_, err = r.Db.ExecContext(ctx, "INSERT INTO refresh_tokens (id, family_id, user_id, token_hash, issued_at, expires_at) VALUES ($1, $2, $3, $4, $5, $6)",
+		_id,
+		_familyID,
+		_userID,
+		rt.Hash(),
+		sqliteTime(rt.IssuedAt()),
+		sqliteTime(rt.ExpiresAt()))
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *SQLiteRepository) GetRefreshTokenByHash(ctx context.Context, hash []byte) (*token.RefreshToken, error) {
+	var (
+		_id       xid.ID
+		_familyID xid.ID
+		_userID   xid.ID
+		issuedAt  time.Time
+		expiresAt time.Time
+		usedAt    sql.NullTime
+		revokedAt sql.NullTime
+	)
+
+	err := r.Db.QueryRowContext(ctx, "SELECT id, family_id, user_id, issued_at, expires_at, used_at, revoked_at FROM refresh_tokens WHERE token_hash = $1", hash).Scan(
+		&_id,
+		&_familyID,
+		&_userID,
+		&issuedAt,
+		&expiresAt,
+		&usedAt,
+		&revokedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return token.New(_id.String(), _familyID.String(), _userID.String(), hash, issuedAt, expiresAt, usedAt.Time, revokedAt.Time)
+}
+
+func (r *SQLiteRepository) MarkRefreshTokenUsed(ctx context.Context, id string, usedAt time.Time) (bool, error) {
+	_id, err := xid.FromString(id)
+	if err != nil {
+		return false, err
+	}
+
+	res, err := r.Db.ExecContext(ctx, "UPDATE refresh_tokens SET used_at = $1 WHERE id = $2 AND used_at IS NULL", sqliteTime(usedAt), _id)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected > 0, nil
+}
+
+func (r *SQLiteRepository) RevokeRefreshTokenFamily(ctx context.Context, familyID string, revokedAt time.Time) error {
+	_familyID, err := xid.FromString(familyID)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.Db.ExecContext(ctx, "UPDATE refresh_tokens SET revoked_at = $1 WHERE family_id = $2 AND revoked_at IS NULL", sqliteTime(revokedAt), _familyID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *SQLiteRepository) RevokeUserRefreshTokens(ctx context.Context, userID string, revokedAt time.Time) error {
+	_userID, err := xid.FromString(userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.Db.ExecContext(ctx, "UPDATE refresh_tokens SET revoked_at = $1 WHERE user_id = $2 AND revoked_at IS NULL", sqliteTime(revokedAt), _userID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *SQLiteRepository) RevokeAccessToken(ctx context.Context, jti, userID string, expiresAt time.Time) error {
+	_userID, err := xid.FromString(userID)
+	if err != nil {
+		return err
+	}
+
+	// The revocation is useless once the token expired
+	_, err = r.Db.ExecContext(ctx, "DELETE FROM revoked_access_tokens WHERE expires_at < $1", sqliteTime(time.Now().Add(-expiredTokenRetention)))
+	if err != nil {
+		return err
+	}
+
+	_, err = r.Db.ExecContext(ctx, "INSERT INTO revoked_access_tokens (jti, user_id, expires_at) VALUES ($1, $2, $3) ON CONFLICT (jti) DO NOTHING", jti, _userID, sqliteTime(expiresAt))
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *SQLiteRepository) RevokeUserAccessTokens(ctx context.Context, userID string, issuedBefore time.Time) error {
+	_userID, err := xid.FromString(userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.Db.ExecContext(ctx, `INSERT INTO user_token_revocations (user_id, issued_before) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET issued_before = MAX(user_token_revocations.issued_before, excluded.issued_before)`,
+		_userID,
+		sqliteTime(issuedBefore))
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *SQLiteRepository) AccessTokenRevoked(ctx context.Context, jti, userID string, issuedAt time.Time) (bool, error) {
+	_userID, err := xid.FromString(userID)
+	if err != nil {
+		return false, err
+	}
+
+	var revoked bool
+	err = r.Db.QueryRowContext(ctx, `SELECT
+		EXISTS (SELECT 1 FROM revoked_access_tokens WHERE jti = $1) OR
+		EXISTS (SELECT 1 FROM user_token_revocations WHERE user_id = $2 AND issued_before > $3)`,
+		jti,
+		_userID,
+		sqliteTime(issuedAt)).Scan(&revoked)
+	if err != nil {
+		return false, err
+	}
+
+	return revoked, nil
+}
+
+func (r *SQLiteRepository) StoreOTP(ctx context.Context, o *otp.OTP) error {
+	_id, err := xid.FromString(o.ID())
+	if err != nil {
+		return err
+	}
+
+	_, err = r.Db.ExecContext(ctx, `INSERT INTO one_time_passwords (id, purpose, phone_number, code_hash, issued_at, expires_at, attempts, send_count, send_window_start) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (purpose, phone_number) DO UPDATE SET
+			id = excluded.id,
+			code_hash = excluded.code_hash,
+			issued_at = excluded.issued_at,
+			expires_at = excluded.expires_at,
+			attempts = excluded.attempts,
+			consumed_at = NULL,
+			send_count = excluded.send_count,
+			send_window_start = excluded.send_window_start`,
+		_id,
+		o.Purpose(),
+		o.PhoneNumber(),
+		o.CodeHash(),
+		sqliteTime(o.IssuedAt()),
+		sqliteTime(o.ExpiresAt()),
+		o.Attempts(),
+		o.SendCount(),
+		sqliteTime(o.SendWindowStart()))
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *SQLiteRepository) GetOTP(ctx context.Context, purpose otp.Purpose, phoneNumber string) (*otp.OTP, error) {
+	var (
+		_id             xid.ID
+		codeHash        []byte
+		issuedAt        time.Time
+		expiresAt       time.Time
+		attempts        int
+		consumedAt      sql.NullTime
+		sendCount       int
+		sendWindowStart time.Time
+	)
+
+	err := r.Db.QueryRowContext(ctx, "SELECT id, code_hash, issued_at, expires_at, attempts, consumed_at, send_count, send_window_start FROM one_time_passwords WHERE purpose = $1 AND phone_number = $2", purpose, phoneNumber).Scan(
+		&_id,
+		&codeHash,
+		&issuedAt,
+		&expiresAt,
+		&attempts,
+		&consumedAt,
+		&sendCount,
+		&sendWindowStart)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return otp.New(_id.String(), purpose, phoneNumber, codeHash, issuedAt, expiresAt, attempts, consumedAt.Time, sendCount, sendWindowStart)
+}
+
+func (r *SQLiteRepository) IncrementOTPAttempts(ctx context.Context, id string) (int, error) {
+	_id, err := xid.FromString(id)
+	if err != nil {
+		return 0, err
+	}
+
+	var attempts int
+	err = r.Db.QueryRowContext(ctx, "UPDATE one_time_passwords SET attempts = attempts + 1 WHERE id = $1 RETURNING attempts", _id).Scan(&attempts)
+	if err == sql.ErrNoRows {
+		return 0, errors.New("no rows affected")
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	return attempts, nil
+}
+
+func (r *SQLiteRepository) ConsumeOTP(ctx context.Context, id string, consumedAt time.Time) (bool, error) {
+	_id, err := xid.FromString(id)
+	if err != nil {
+		return false, err
+	}
+
+	res, err := r.Db.ExecContext(ctx, "UPDATE one_time_passwords SET consumed_at = $1 WHERE id = $2 AND consumed_at IS NULL", sqliteTime(consumedAt), _id)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected > 0, nil
+}
+
+func (r *SQLiteRepository) GetLoginFailures(ctx context.Context, key string) (*lockout.Counter, error) {
+	var (
+		failures      int
+		lastFailureAt time.Time
+		lockedUntil   sql.NullTime
+	)
+
+	err := r.Db.QueryRowContext(ctx, "SELECT failures, last_failure_at, locked_until FROM login_failures WHERE key = $1", key).Scan(
+		&failures,
+		&lastFailureAt,
+		&lockedUntil)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return lockout.New(key, failures, lastFailureAt, lockedUntil.Time)
+}
+
+func (r *SQLiteRepository) IncrementLoginFailures(ctx context.Context, key string, failedAt, resetBefore time.Time) (*lockout.Counter, error) {
+	var (
+		failures    int
+		lockedUntil sql.NullTime
+	)
+
+	err := r.Db.QueryRowContext(ctx, `INSERT INTO login_failures (key, failures, last_failure_at) VALUES ($1, 1, $2)
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE WHEN login_failures.last_failure_at < $3 THEN 1 ELSE login_failures.failures + 1 END,
+			locked_until = CASE WHEN login_failures.last_failure_at < $3 THEN NULL ELSE login_failures.locked_until END,
+			last_failure_at = excluded.last_failure_at
+		RETURNING failures, locked_until`,
+		key,
+		sqliteTime(failedAt),
+		sqliteTime(resetBefore)).Scan(&failures, &lockedUntil)
+	if err != nil {
+		return nil, err
+	}
+
+	return lockout.New(key, failures, failedAt, lockedUntil.Time)
+}
+
+func (r *SQLiteRepository) LockLogin(ctx context.Context, key string, lockedUntil time.Time) error {
+	_, err := r.Db.ExecContext(ctx, "UPDATE login_failures SET locked_until = $1 WHERE key = $2", sqliteTime(lockedUntil), key)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *SQLiteRepository) ResetLoginFailures(ctx context.Context, key string) error {
+	_, err := r.Db.ExecContext(ctx, "DELETE FROM login_failures WHERE key = $1", key)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *SQLiteRepository) GetPasswordHistory(ctx context.Context, userID string, limit int) ([]*user.PasswordRecord, error) {
+	_userID, err := xid.FromString(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Unlike PostgreSQL, SQLite reads a negative limit as no limit
+	if limit < 0 {
+		return nil, errors.New("negative limit")
+	}
+
+	rows, err := r.Db.QueryContext(ctx, "SELECT password_hash, password_salt, password_pepper_id, created_at FROM password_history WHERE user_id = $1 ORDER BY created_at DESC, id DESC LIMIT $2", _userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var history []*user.PasswordRecord
+	for rows.Next() {
+		var (
+			pwdHash     []byte
+			pwdSalt     []byte
+			pwdPepperID sql.NullString
+			createdAt   time.Time
+		)
+
+		err = rows.Scan(&pwdHash, &pwdSalt, &pwdPepperID, &createdAt)
+		if err != nil {
+			return nil, err
+		}
+
+		pr, err := user.NewPasswordRecord(pwdHash, pwdSalt, pwdPepperID.String, createdAt)
+		if err != nil {
+			return nil, err
+		}
+
+		history = append(history, pr)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return history, nil
+}
+
+func (r *SQLiteRepository) UpdatePassword(ctx context.Context, u *user.User, previous *user.PasswordRecord, keep int) error {
+	_userID, err := xid.FromString(u.ID())
+	if err != nil {
+		return err
+	}
+
+	if keep < 0 {
+		return errors.New("negative keep")
+	}
+
+	tx, err := r.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = sqliteUpdateUser(ctx, tx, u)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO password_history (user_id, password_hash, password_salt, password_pepper_id, created_at) VALUES ($1, $2, $3, $4, $5)",
+		_userID,
+		previous.Hash(),
+		nullBytes(previous.Salt()),
+		nullString(previous.PepperID()),
+		sqliteTime(previous.CreatedAt()))
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM password_history WHERE user_id = $1 AND id NOT IN (SELECT id FROM password_history WHERE user_id = $1 ORDER BY created_at DESC, id DESC LIMIT $2)", _userID, keep)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *SQLiteRepository) StoreTOTP(ctx context.Context, t *mfa.TOTP) error {
+	_userID, err := xid.FromString(t.UserID())
+	if err != nil {
+		return err
+	}
+
+	_, err = r.Db.ExecContext(ctx, `INSERT INTO user_totp (user_id, secret, created_at, confirmed_at, last_used_step) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (user_id) DO UPDATE SET
+			secret = excluded.secret,
+			created_at = excluded.created_at,
+			confirmed_at = excluded.confirmed_at,
+			last_used_step = excluded.last_used_step`,
+		_userID,
+		t.Secret(),
+		sqliteTime(t.CreatedAt()),
+		sqliteTime(t.ConfirmedAt()),
+		t.LastUsedStep())
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *SQLiteRepository) GetTOTP(ctx context.Context, userID string) (*mfa.TOTP, error) {
+	_userID, err := xid.FromString(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		secret       []byte
+		createdAt    time.Time
+		confirmedAt  sql.NullTime
+		lastUsedStep int64
+	)
+
+	err = r.Db.QueryRowContext(ctx, "SELECT secret, created_at, confirmed_at, last_used_step FROM user_totp WHERE user_id = $1", _userID).Scan(
+		&secret,
+		&createdAt,
+		&confirmedAt,
+		&lastUsedStep)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return mfa.NewTOTP(userID, secret, createdAt, confirmedAt.Time, lastUsedStep)
+}
+
+func (r *SQLiteRepository) ConfirmTOTP(ctx context.Context, userID string, confirmedAt time.Time, step int64, recoveryCodeHashes [][]byte) (bool, error) {
+	_userID, err := xid.FromString(userID)
+	if err != nil {
+		return false, err
+	}
+
+	tx, err := r.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, "UPDATE user_totp SET confirmed_at = $1, last_used_step = $2 WHERE user_id = $3 AND confirmed_at IS NULL", sqliteTime(confirmedAt), step, _userID)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	if affected == 0 {
+		return false, nil
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM totp_recovery_codes WHERE user_id = $1", _userID)
+	if err != nil {
+		return false, err
+	}
+
+	for _, codeHash := range recoveryCodeHashes {
+		_, err = tx.ExecContext(ctx, "INSERT INTO totp_recovery_codes (user_id, code_hash) VALUES ($1, $2)", _userID, codeHash)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (r *SQLiteRepository) UseTOTPStep(ctx context.Context, userID string, step int64) (bool, error) {
+	_userID, err := xid.FromString(userID)
+	if err != nil {
+		return false, err
+	}
+
+	res, err := r.Db.ExecContext(ctx, "UPDATE user_totp SET last_used_step = $1 WHERE user_id = $2 AND last_used_step < $1", step, _userID)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected > 0, nil
+}
+
+func (r *SQLiteRepository) DeleteTOTP(ctx context.Context, userID string) error {
+	_userID, err := xid.FromString(userID)
+	if err != nil {
+		return err
+	}
+
+	tx, err := r.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM totp_recovery_codes WHERE user_id = $1", _userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM user_totp WHERE user_id = $1", _userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *SQLiteRepository) UseRecoveryCode(ctx context.Context, userID string, codeHash []byte, usedAt time.Time) (bool, error) {
+	_userID, err := xid.FromString(userID)
+	if err != nil {
+		return false, err
+	}
+
+	res, err := r.Db.ExecContext(ctx, "UPDATE totp_recovery_codes SET used_at = $1 WHERE user_id = $2 AND code_hash = $3 AND used_at IS NULL", sqliteTime(usedAt), _userID, codeHash)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected > 0, nil
+}
+
+func (r *SQLiteRepository) StoreMFAChallenge(ctx context.Context, c *mfa.Challenge) error {
+	_id, err := xid.FromString(c.ID())
+	if err != nil {
+		return err
+	}
+
+	_userID, err := xid.FromString(c.UserID())
+	if err != nil {
+		return err
+	}
+
+	// A challenge is useless once expired, the expired ones are deleted so
+	// they do not pile up
+	_, err = r.Db.ExecContext(ctx, "DELETE FROM mfa_challenges WHERE expires_at <= $1", sqliteTime(c.IssuedAt()))
+	if err != nil {
+		return err
+	}
+
+	_, err = r.Db.ExecContext(ctx, "INSERT INTO mfa_challenges (id, user_id, token_hash, issued_at, expires_at, attempts, consumed_at) VALUES ($1, $2, $3, $4, $5, $6, $7)",
+		_id,
+		_userID,
+		c.TokenHash(),
+		sqliteTime(c.IssuedAt()),
+		sqliteTime(c.ExpiresAt()),
+		c.Attempts(),
+		sqliteTime(c.ConsumedAt()))
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *SQLiteRepository) GetMFAChallengeByHash(ctx context.Context, hash []byte) (*mfa.Challenge, error) {
+	var (
+		_id        xid.ID
+		_userID    xid.ID
+		issuedAt   time.Time
+		expiresAt  time.Time
+		attempts   int
+		consumedAt sql.NullTime
+	)
+
+	err := r.Db.QueryRowContext(ctx, "SELECT id, user_id, issued_at, expires_at, attempts, consumed_at FROM mfa_challenges WHERE token_hash = $1", hash).Scan(
+		&_id,
+		&_userID,
+		&issuedAt,
+		&expiresAt,
+		&attempts,
+		&consumedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return mfa.NewChallenge(_id.String(), _userID.String(), hash, issuedAt, expiresAt, attempts, consumedAt.Time)
+}
+
+func (r *SQLiteRepository) IncrementMFAChallengeAttempts(ctx context.Context, id string) (int, error) {
+	_id, err := xid.FromString(id)
+	if err != nil {
+		return 0, err
+	}
+
+	var attempts int
+	err = r.Db.QueryRowContext(ctx, "UPDATE mfa_challenges SET attempts = attempts + 1 WHERE id = $1 RETURNING attempts", _id).Scan(&attempts)
+	if err == sql.ErrNoRows {
+		return 0, errors.New("no rows affected")
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	return attempts, nil
+}
+
+func (r *SQLiteRepository) ConsumeMFAChallenge(ctx context.Context, id string, consumedAt time.Time) (bool, error) {
+	_id, err := xid.FromString(id)
+	if err != nil {
+		return false, err
+	}
+
+	res, err := r.Db.ExecContext(ctx, "UPDATE mfa_challenges SET consumed_at = $1 WHERE id = $2 AND consumed_at IS NULL", sqliteTime(consumedAt), _id)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected > 0, nil
+}
+
+func (r *SQLiteRepository) StorePasskey(ctx context.Context, c *webauthn.Credential) error {
+	_userID, err := xid.FromString(c.UserID())
+	if err != nil {
+		return err
+	}
+
+	_, err = r.Db.ExecContext(ctx, "INSERT INTO passkeys (id, user_id, public_key, sign_count, created_at, last_used_at) VALUES ($1, $2, $3, $4, $5, $6)",
+		c.ID(),
+		_userID,
+		c.PublicKey(),
+		int64(c.SignCount()),
+		sqliteTime(c.CreatedAt()),
+		sqliteTime(c.LastUsedAt()))
+
+	return sqliteError(err)
+}
+
+func (r *SQLiteRepository) GetPasskey(ctx context.Context, id []byte) (*webauthn.Credential, error) {
+	var (
+		_userID    xid.ID
+		publicKey  []byte
+		signCount  int64
+		createdAt  time.Time
+		lastUsedAt sql.NullTime
+	)
+
+	err := r.Db.QueryRowContext(ctx, "SELECT user_id, public_key, sign_count, created_at, last_used_at FROM passkeys WHERE id = $1", id).Scan(
+		&_userID,
+		&publicKey,
+		&signCount,
+		&createdAt,
+		&lastUsedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return webauthn.NewCredential(id, _userID.String(), publicKey, uint32(signCount), createdAt, lastUsedAt.Time)
+}
+
+func (r *SQLiteRepository) GetUserPasskeys(ctx context.Context, userID string) ([]*webauthn.Credential, error) {
+	_userID, err := xid.FromString(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := r.Db.QueryContext(ctx, "SELECT id, public_key, sign_count, created_at, last_used_at FROM passkeys WHERE user_id = $1 ORDER BY created_at", _userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var creds []*webauthn.Credential
+	for rows.Next() {
+		var (
+			id         []byte
+			publicKey  []byte
+			signCount  int64
+			createdAt  time.Time
+			lastUsedAt sql.NullTime
+		)
+
+		err = rows.Scan(&id, &publicKey, &signCount, &createdAt, &lastUsedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		c, err := webauthn.NewCredential(id, userID, publicKey, uint32(signCount), createdAt, lastUsedAt.Time)
+		if err != nil {
+			return nil, err
+		}
+
+		creds = append(creds, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return creds, nil
+}
+
+func (r *SQLiteRepository) UpdatePasskeySignCount(ctx context.Context, id []byte, signCount uint32, usedAt time.Time) (bool, error) {
+	res, err := r.Db.ExecContext(ctx, "UPDATE passkeys SET sign_count = $1, last_used_at = $2 WHERE id = $3 AND (sign_count < $1 OR sign_count = 0)", int64(signCount), sqliteTime(usedAt), id)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected > 0, nil
+}
+
+func (r *SQLiteRepository) StoreWebAuthnSession(ctx context.Context, s *webauthn.Session) error {
+	// Login sessions have no user, the nil id is stored as NULL
+	var _userID xid.ID
+	if s.UserID() != "" {
+		var err error
+		_userID, err = xid.FromString(s.UserID())
+		if err != nil {
+			return err
+		}
+	}
+
+	// Anyone can begin a passkey login, the expired ceremonies are deleted
+	// so they do not pile up
+	_, err := r.Db.ExecContext(ctx, "DELETE FROM webauthn_sessions WHERE expires_at <= $1", sqliteTime(s.IssuedAt()))
+	if err != nil {
+		return err
+	}
+
+	_, err = r.Db.ExecContext(ctx, "INSERT INTO webauthn_sessions (challenge, ceremony, user_id, issued_at, expires_at) VALUES ($1, $2, $3, $4, $5)",
+		s.Challenge(),
+		s.Ceremony(),
+		_userID,
+		sqliteTime(s.IssuedAt()),
+		sqliteTime(s.ExpiresAt()))
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *SQLiteRepository) TakeWebAuthnSession(ctx context.Context, challenge []byte) (*webauthn.Session, error) {
+	var (
+		ceremony  webauthn.Ceremony
+		_userID   xid.ID
+		issuedAt  time.Time
+		expiresAt time.Time
+	)
+
+	err := r.Db.QueryRowContext(ctx, "DELETE FROM webauthn_sessions WHERE challenge = $1 RETURNING ceremony, user_id, issued_at, expires_at", challenge).Scan(
+		&ceremony,
+		&_userID,
+		&issuedAt,
+		&expiresAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var userID string
+	if !_userID.IsNil() {
+		userID = _userID.String()
+	}
+
+	return webauthn.NewSession(challenge, ceremony, userID, issuedAt, expiresAt)
+}
+
+// sqliteTime formats the time in UTC, the zero time as NULL.
+func sqliteTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+
+	return t.UTC().Format(sqliteTimeFormat)
+}
+
+// sqliteError maps the violations of primary keys and unique constraints to
+// ErrUniqueViolation, like the 23505 error code of PostgreSQL.
+func sqliteError(err error) error {
+	var liteErr *sqlite.Error
+	if errors.As(err, &liteErr) {
+		switch liteErr.Code() {
+		case sqlite3.SQLITE_CONSTRAINT_UNIQUE, sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY:
+			return ErrUniqueViolation
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,126 @@
+package repository_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/SawitProRecruitment/UserService/handler/model/mfa"
+	"github.com/SawitProRecruitment/UserService/handler/model/token"
+	"github.com/SawitProRecruitment/UserService/handler/model/user"
+	"github.com/SawitProRecruitment/UserService/handler/model/webauthn"
+	"github.com/SawitProRecruitment/UserService/repository"
+	"github.com/SawitProRecruitment/UserService/repository/repositorytest"
+	"github.com/rs/xid"
+)
+
+func TestSQLiteRepository(t *testing.T) {
+	repositorytest.Run(t, func(t *testing.T) repository.Storage {
+		return newSQLiteRepository(t)
+	})
+}
+
+// newSQLiteRepository returns a repository on a new migrated database.
+func newSQLiteRepository(t *testing.T) *repository.SQLiteRepository {
+	repo, err := repository.NewSQLiteRepository(repository.NewRepositoryOptions{
+		Dsn: repository.SQLiteScheme + filepath.Join(t.TempDir(), "users.db"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { repo.Db.Close() })
+
+	if err := repo.MigrateUp(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	return repo
+}
+
+func TestSQLiteRepositoryDeletesExpiredRecords(t *testing.T) {
+	testCases := map[string]struct {
+		table     string
+		retention time.Duration
+		store     func(ctx context.Context, repo *repository.SQLiteRepository, userID, name string, issuedAt, expiresAt time.Time) error
+	}{
+		"mfa challenges": {
+			table: "mfa_challenges",
+			store: func(ctx context.Context, repo *repository.SQLiteRepository, userID, name string, issuedAt, expiresAt time.Time) error {
+				c, err := mfa.NewChallenge(xid.New().String(), userID, token.Hash("challenge-of-"+name), issuedAt, expiresAt, 0, time.Time{})
+				if err != nil {
+					return err
+				}
+
+				return repo.StoreMFAChallenge(ctx, c)
+			},
+		},
+		"refresh tokens": {
+			table:     "refresh_tokens",
+			retention: 24 * time.Hour,
+			store: func(ctx context.Context, repo *repository.SQLiteRepository, userID, name string, issuedAt, expiresAt time.Time) error {
+				rt, err := token.New(xid.New().String(), xid.New().String(), userID, token.Hash("refresh-token-of-"+name), issuedAt, expiresAt, time.Time{}, time.Time{})
+				if err != nil {
+					return err
+				}
+
+				return repo.StoreRefreshToken(ctx, rt)
+			},
+		},
+		"revoked access tokens": {
+			table:     "revoked_access_tokens",
+			retention: 24 * time.Hour,
+			store: func(ctx context.Context, repo *repository.SQLiteRepository, userID, name string, issuedAt, expiresAt time.Time) error {
+				return repo.RevokeAccessToken(ctx, "jti-of-"+name, userID, expiresAt)
+			},
+		},
+		"webauthn sessions": {
+			table: "webauthn_sessions",
+			store: func(ctx context.Context, repo *repository.SQLiteRepository, userID, name string, issuedAt, expiresAt time.Time) error {
+				s, err := webauthn.NewSession([]byte("challenge-of-"+name), webauthn.CeremonyLogin, "", issuedAt, expiresAt)
+				if err != nil {
+					return err
+				}
+
+				return repo.StoreWebAuthnSession(ctx, s)
+			},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			// Given
+			ctx := context.Background()
+			repo := newSQLiteRepository(t)
+			u, err := user.New(xid.New().String(), "+628123456789", "Jane Doe", []byte("$2a$04$2p2Yz0CDyVhN0dNgJd6Tq.eXy4n1xOoVgn2JgVb0mGZ8k5JrPFvXO"), nil, "", time.Time{}, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := repo.Store(ctx, u); err != nil {
+				t.Fatal(err)
+			}
+
+			now := time.Now()
+			if err := tc.store(ctx, repo, u.ID(), "expired", now.Add(-tc.retention-time.Hour), now.Add(-tc.retention-time.Minute)); err != nil {
+				t.Fatal(err)
+			}
+
+			// When
+			if err := tc.store(ctx, repo, u.ID(), "valid", now, now.Add(time.Minute)); err != nil {
+				t.Fatal(err)
+			}
+
+			// Then
+			var count int
+			if err := repo.Db.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+tc.table).Scan(&count); err != nil {
+				t.Fatal(err)
+			}
+
+			if count != 1 {
+				t.Fatalf("rows got %d, want only the valid one", count)
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+// This file contains the interfaces of the storage backends, each implementing
+// every repository interface.
+package repository
+
+import "context"
+
+// Storage is a backend of all the repositories.
+type Storage interface {
+	RepositoryInterface
+	RefreshTokenRepositoryInterface
+	TokenRevocationRepositoryInterface
+	OTPRepositoryInterface
+	LoginFailureRepositoryInterface
+	PasswordHistoryRepositoryInterface
+	TOTPRepositoryInterface
+	MFAChallengeRepositoryInterface
+	PasskeyRepositoryInterface
+	WebAuthnSessionRepositoryInterface
+}
+
+// Migrator is implemented by the backends with a schema.
+type Migrator interface {
+	// SchemaVersion returns the version of the last applied migration, 0
+	// when none was.
+	SchemaVersion(ctx context.Context) (int, error)
+	// CheckSchema fails unless every migration of the binary, and no other,
+	// is applied.
+	CheckSchema(ctx context.Context) error
+	// MigrateUp applies the pending migrations.
+	MigrateUp(ctx context.Context) error
+	// MigrateTo applies the up or down migrations bringing the schema to the
+	// version.
+	MigrateTo(ctx context.Context, version int) error
+}
+
+var (
+	_ Storage  = (*Repository)(nil)
+	_ Migrator = (*Repository)(nil)
+	_ Storage  = (*MemoryRepository)(nil)
+	_ Storage  = (*SQLiteRepository)(nil)
+	_ Migrator = (*SQLiteRepository)(nil)
+)
@@ -0,0 +1,68 @@
+// This file contains the SMS senders used to deliver one-time passwords.
+// Only local implementations exist here, a real gateway only needs to
+// satisfy the Sender interface.
+package sms
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+type Sender interface {
+	Send(phoneNumber, message string) error
+}
+
+// LogSender logs that messages were sent instead of sending them, for
+// development only. Their text, holding the one-time passwords, is left out.
+type LogSender struct {
+	Logger *log.Logger
+}
+
+func (ls *LogSender) Send(phoneNumber, message string) error {
+	logger := ls.Logger
+	if logger == nil {
+		logger = log.Default()
+	}
+
+	logger.Printf("sms to=%s length=%d", phoneNumber, len(message))
+	return nil
+}
+
+// FileSender appends messages as JSON lines to a file, acting as an outbox
+// that can be inspected during development.
+type FileSender struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+type fileMessage struct {
+	To      string    `json:"to"`
+	Message string    `json:"message"`
+	SentAt  time.Time `json:"sentAt"`
+}
+
+func (fs *FileSender) Send(phoneNumber, message string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	f, err := os.OpenFile(fs.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+
+	err = json.NewEncoder(f).Encode(fileMessage{
+		To:      phoneNumber,
+		Message: message,
+		SentAt:  time.Now(),
+	})
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,26 @@
+package sms
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestLogSender(t *testing.T) {
+	// Given
+	var buf bytes.Buffer
+	sender := &LogSender{Logger: log.New(&buf, "", 0)}
+
+	// When
+	err := sender.Send("+628174546647", "Your code is 123456")
+
+	// Then
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := buf.String(); !strings.Contains(got, "+628174546647") || strings.Contains(got, "123456") {
+		t.Fatalf("log got %q, want the recipient without the message", got)
+	}
+}